@@ -14,6 +14,7 @@
 
 //+build appengine
 
+// govanityurls serves Go vanity URLs.
 package main
 
 import (
@@ -21,18 +22,20 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
 	"google.golang.org/appengine"
 )
 
 func main() {
-	vanity, err := ioutil.ReadFile("./vanity.yaml")
+	config, err := ioutil.ReadFile("./vanity.yaml")
 	if err != nil {
 		log.Fatal(err)
 	}
-	h, err := newHandler(vanity)
+	h, err := vanity.NewHandler(config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	h.DefaultHost = defaultHost
 	http.Handle("/", h)
 	appengine.Main()
 }
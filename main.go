@@ -14,16 +14,82 @@
 
 //+build !appengine
 
+// govanityurls serves Go vanity URLs.
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	_ "expvar"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	_ "net/http/pprof"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+	"github.com/GoogleCloudPlatform/govanityurls/vanity/kube"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 func main() {
+	var err error
+	if vault, err = newVault(); err != nil {
+		log.Fatal(err)
+	}
+	metrics = newMetrics()
+	// Flags go before the mode and apply to all of them.
+	for len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "-") {
+		switch arg := strings.TrimPrefix(os.Args[1], "-"); {
+		case arg == "lenient" || arg == "-lenient":
+			lenient = true
+		case strings.HasPrefix(arg, "format=") || strings.HasPrefix(arg, "-format="):
+			format = arg[strings.IndexByte(arg, '=')+1:]
+		case strings.HasPrefix(arg, "listen=") || strings.HasPrefix(arg, "-listen="):
+			listen = arg[strings.IndexByte(arg, '=')+1:]
+		case strings.HasPrefix(arg, "debug-addr=") || strings.HasPrefix(arg, "-debug-addr="):
+			debugAddr = arg[strings.IndexByte(arg, '=')+1:]
+		case strings.HasPrefix(arg, "autocert=") || strings.HasPrefix(arg, "-autocert="):
+			autocertDir = arg[strings.IndexByte(arg, '=')+1:]
+		default:
+			log.Fatalf("unknown flag %s", os.Args[1])
+		}
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	if err := startDebug(); err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(check(os.Args[2:]))
+		case "doctor":
+			os.Exit(doctor(os.Args[2:]))
+		case "export":
+			os.Exit(export(os.Args[2:]))
+		case "operator":
+			os.Exit(operator(os.Args[2:]))
+		case "render":
+			os.Exit(render(os.Args[2:]))
+		case "replica":
+			os.Exit(replica(os.Args[2:]))
+		case "verify":
+			os.Exit(verify(os.Args[2:]))
+		}
+	}
 	var configPath string
 	switch len(os.Args) {
 	case 1:
@@ -31,22 +97,1291 @@ func main() {
 	case 2:
 		configPath = os.Args[1]
 	default:
-		log.Fatal("usage: govanityurls [CONFIG]")
+		log.Fatal("usage: govanityurls [-lenient] [-format=FORMAT] [-listen=ADDR] [-debug-addr=ADDR] [-autocert=DIR] [check [-live] [-warnings-as-errors]|doctor|operator|verify] [CONFIG]\n       govanityurls export [-out DIR] [-hosting netlify|cloudflare] [CONFIG]\n       govanityurls render [-html] CONFIG IMPORTPATH\n       govanityurls replica PRIMARY [CONFIG]")
+	}
+	mw, err := middleware(metricsMiddleware, accessLog, shadow)
+	if err != nil {
+		log.Fatal(err)
 	}
-	vanity, err := ioutil.ReadFile(configPath)
+	gs, err := newGitHubSync()
 	if err != nil {
 		log.Fatal(err)
 	}
-	h, err := newHandler(vanity)
+	gl, err := newGitLabSync()
 	if err != nil {
 		log.Fatal(err)
 	}
-	http.Handle("/", h)
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	kv, err := newKVSync()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rl := &vanity.Reloader{
+		Path: configPath,
+		Read: configReader(configPath),
+		Load: func(config []byte) (*vanity.Handler, error) {
+			h, err := loadHandler(config, vanity.WithMiddleware(mw...))
+			if err == nil && gs != nil {
+				if err := gs.Apply(h); err != nil {
+					log.Printf("github sync: %v", err)
+				}
+			}
+			if err == nil && gl != nil {
+				if err := gl.Apply(h); err != nil {
+					log.Printf("gitlab sync: %v", err)
+				}
+			}
+			if err == nil && kv != nil {
+				if err := kv.Apply(h); err != nil {
+					log.Printf("kv sync: %v", err)
+				}
+			}
+			return h, err
+		},
+	}
+	if isURL(configPath) {
+		rl.Interval = time.Minute
+		if v := os.Getenv("VANITY_CONFIG_REFRESH"); v != "" {
+			if rl.Interval, err = time.ParseDuration(v); err != nil || rl.Interval <= 0 {
+				log.Fatalf("VANITY_CONFIG_REFRESH: want a positive duration, got %q", v)
+			}
+		}
+	}
+	if metrics != nil {
+		metrics.Reloader = rl
+	}
+	watch := true
+	if v := os.Getenv("VANITY_WATCH"); v != "" {
+		if watch, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("VANITY_WATCH: %v", err)
+		}
+	}
+	var startupTimeout time.Duration
+	if v := os.Getenv("VANITY_STARTUP_TIMEOUT"); v != "" {
+		if startupTimeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("VANITY_STARTUP_TIMEOUT: %v", err)
+		}
+	}
+	// The probes are served while the configuration is first loaded, so
+	// that the server is unready rather than exiting while, for example,
+	// the configuration server cannot be reached.
+	health := newHealth(nil)
+	health.SetReady(errors.New("configuration not loaded"))
+	health.Register(mux)
+	mux.Handle("/", rl)
+	start := func() error {
+		if err := loadFirst(rl, health, startupTimeout); err != nil {
+			return err
+		}
+		health.Notifier = rl.Handler().Notifier
+		startVault(health)
+		if watch {
+			rl.Health = health
+			go func() {
+				log.Printf("not watching %s: %v", configPath, rl.Run(context.Background()))
+			}()
+		}
+		if err := startLinkChecker(&vanity.LinkChecker{Reloader: rl}, health, nil); err != nil {
+			return err
+		}
+		if err := startPathAPI(&vanity.PathAPI{Reloader: rl}, configPath); err != nil {
+			return err
+		}
+		if gs != nil {
+			gs.Reloader, gs.Health = rl, health
+			if gs.Secret != "" {
+				mux.Handle("/webhooks/github", gs)
+			}
+			health.Pending("github")
+			go gs.Run(context.Background())
+		}
+		if gl != nil {
+			gl.Reloader, gl.Health = rl, health
+			if gl.Secret != "" {
+				mux.Handle("/webhooks/gitlab", gl)
+			}
+			health.Pending("gitlab")
+			go gl.Run(context.Background())
+		}
+		if kv != nil {
+			kv.Reloader, kv.Health = rl, health
+			health.Pending("kv")
+			go kv.Run(context.Background())
+		}
+		return nil
+	}
+	if err := serve(rl.Handler, health, start); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func defaultHost(r *http.Request) string {
-	return r.Host
+const (
+	// startupRetry and startupMaxRetry are the initial and the longest
+	// delay between attempts to load the configuration at startup.
+	startupRetry    = time.Second
+	startupMaxRetry = 2 * time.Minute
+)
+
+// loadFirst loads the configuration of rl for the first time, retrying
+// with exponential backoff while it does not load and reporting the server
+// unready meanwhile.  If timeout is positive, it gives up once the next
+// attempt would be later than timeout after the first.
+func loadFirst(rl *vanity.Reloader, health *vanity.Health, timeout time.Duration) error {
+	started := time.Now()
+	delay := startupRetry
+	for {
+		_, err := rl.Reload()
+		health.SetReady(err)
+		if err == nil {
+			return nil
+		}
+		if timeout > 0 && time.Since(started)+delay > timeout {
+			return fmt.Errorf("configuration not loaded after %v: %v", time.Since(started).Round(time.Second), err)
+		}
+		log.Printf("%v; retrying in %v", err, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > startupMaxRetry {
+			delay = startupMaxRetry
+		}
+	}
+}
+
+// mux serves the public endpoints.  It is not http.DefaultServeMux, where
+// net/http/pprof and expvar register themselves, so that those are only
+// served at the debug address.
+var mux = http.NewServeMux()
+
+// debugAddr, if set, is the address to serve the profiles of net/http/pprof
+// and the variables of expvar at.
+var debugAddr string
+
+// startDebug serves http.DefaultServeMux at debugAddr in the background, if
+// it is set.
+func startDebug() error {
+	if debugAddr == "" {
+		return nil
+	}
+	l, err := net.Listen("tcp", debugAddr)
+	if err != nil {
+		return fmt.Errorf("-debug-addr: %v", err)
+	}
+	go func() {
+		log.Printf("debug server: %v", http.Serve(l, http.DefaultServeMux))
+	}()
+	return nil
+}
+
+// vault reads the secrets that settings refer to, if VAULT_ADDR is set.
+var vault *vanity.Vault
+
+// newVault returns a Vault client for the server at VAULT_ADDR, or nil if
+// it is not set.  The client authenticates with VAULT_TOKEN, the token in
+// the file VANITY_VAULT_TOKEN_FILE or, if VANITY_VAULT_ROLE is set, the
+// Kubernetes service account, logging in at VANITY_VAULT_AUTH_MOUNT.
+func newVault() (*vanity.Vault, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+	v := &vanity.Vault{
+		Address:   addr,
+		Token:     os.Getenv("VAULT_TOKEN"),
+		TokenFile: os.Getenv("VANITY_VAULT_TOKEN_FILE"),
+		Role:      os.Getenv("VANITY_VAULT_ROLE"),
+		AuthMount: os.Getenv("VANITY_VAULT_AUTH_MOUNT"),
+	}
+	if v.Token == "" && v.TokenFile == "" && v.Role == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is set, but none of VAULT_TOKEN, VANITY_VAULT_TOKEN_FILE and VANITY_VAULT_ROLE is")
+	}
+	return v, nil
+}
+
+// newHealth returns the probes for a server notifying n.  They are answered
+// at VANITY_HEALTH_PATH and VANITY_READY_PATH, if set, and the server is
+// only ready while the comma-separated components in
+// VANITY_READY_COMPONENTS are ok.
+func newHealth(n vanity.Notifier) *vanity.Health {
+	health := &vanity.Health{
+		Notifier:   n,
+		HealthPath: os.Getenv("VANITY_HEALTH_PATH"),
+		ReadyPath:  os.Getenv("VANITY_READY_PATH"),
+	}
+	for _, name := range strings.Split(os.Getenv("VANITY_READY_COMPONENTS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			health.ReadyComponents = append(health.ReadyComponents, name)
+		}
+	}
+	return health
+}
+
+// startVault keeps the Vault token and leases alive in the background.
+func startVault(health *vanity.Health) {
+	if vault == nil {
+		return
+	}
+	vault.Health = health
+	go vault.Run(context.Background())
+}
+
+// defaultDrainTimeout is how long in-flight requests may take to finish
+// after a signal to stop, unless VANITY_DRAIN_TIMEOUT says otherwise.
+const defaultDrainTimeout = 10 * time.Second
+
+// defaultListen is the address to serve plain HTTP on without -listen.
+const defaultListen = ":8080"
+
+// listen, if set, are the addresses to serve plain HTTP on, separated by
+// commas: host:port or unix: followed by the path of a socket.
+var listen string
+
+// plainListeners returns the listeners to serve plain HTTP on: the sockets
+// passed by systemd socket activation, if any, or else those at listen.
+func plainListeners() ([]net.Listener, error) {
+	ls, err := systemdListeners()
+	if err != nil || len(ls) > 0 {
+		return ls, err
+	}
+	addrs := listen
+	if addrs == "" {
+		addrs = defaultListen
+	}
+	if ls, err = listenAll(addrs); err != nil {
+		return nil, fmt.Errorf("-listen: %v", err)
+	}
+	return ls, nil
+}
+
+// listenAll listens on each of the comma-separated addresses, host:port or
+// unix: followed by the path of a socket.
+func listenAll(addrs string) ([]net.Listener, error) {
+	var ls []net.Listener
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		network := "tcp"
+		if path := strings.TrimPrefix(addr, "unix:"); path != addr {
+			// A socket left behind by an earlier run would make Listen
+			// fail.
+			if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+				os.Remove(path)
+			}
+			network, addr = "unix", path
+		}
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// systemdListeners returns the sockets that systemd passed to the process
+// as described in sd_listen_fds(3), starting at file descriptor 3.
+func systemdListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("LISTEN_FDS: %v", err)
+	}
+	// The sockets are not for the programs this one may start.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	var ls []net.Listener
+	for fd := 3; fd < 3+n; fd++ {
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket activation: file descriptor %d: %v", fd, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// serve serves mux over HTTP on the plain listeners and, if the
+// configuration of the handler enables TLS or -autocert is set, over
+// HTTPS too, warning through health about certificates that are about to
+// expire.  If start is not nil, it is called once the plain listeners are
+// served and before the handler is first used, e.g. to load it.  With
+// VANITY_PROXY_PROTOCOL set, every connection must start with a PROXY
+// protocol header.  On SIGTERM or SIGINT it reports itself not ready, stops
+// accepting connections and waits up to VANITY_DRAIN_TIMEOUT for in-flight
+// requests, then returns nil.  Otherwise it only returns on failure.
+func serve(handler func() *vanity.Handler, health *vanity.Health, start func() error) error {
+	drain := defaultDrainTimeout
+	if v := os.Getenv("VANITY_DRAIN_TIMEOUT"); v != "" {
+		var err error
+		if drain, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("VANITY_DRAIN_TIMEOUT: %v", err)
+		}
+	}
+	var proxyProtocol bool
+	if v := os.Getenv("VANITY_PROXY_PROTOCOL"); v != "" {
+		var err error
+		if proxyProtocol, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("VANITY_PROXY_PROTOCOL: %v", err)
+		}
+	}
+	m := newAutocert(handler)
+	ls, err := plainListeners()
+	if err != nil {
+		return err
+	}
+	// errc gets the first error of any server.
+	errc := make(chan error, 1)
+	run := func(srv *http.Server, l net.Listener, secure bool) {
+		if proxyProtocol {
+			l = &vanity.ProxyProtocolListener{Listener: l}
+		}
+		go func() {
+			var err error
+			if secure {
+				err = srv.ServeTLS(l, "", "")
+			} else {
+				err = srv.Serve(l)
+			}
+			select {
+			case errc <- err:
+			default:
+			}
+		}()
+	}
+	plain := &http.Server{Handler: mux}
+	if m != nil {
+		plain.Handler = m.HTTPHandler(mux)
+	}
+	servers := []*http.Server{plain}
+	for _, l := range ls {
+		run(plain, l, false)
+	}
+	if start != nil {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+	c, ok := handler().TLS()
+	switch {
+	case m != nil && len(handler().Hosts()) == 0:
+		return errors.New("-autocert: the configuration names no host")
+	case m != nil && ok:
+		return errors.New("-autocert: the configuration has a certificate already")
+	}
+	if m != nil || ok {
+		var source func() ([]*x509.Certificate, error)
+		secure := &http.Server{Addr: c.Addr, Handler: mux}
+		if m != nil {
+			secure.TLSConfig = m.TLSConfig()
+			// Certificates are cached in files named by host.
+			var paths []string
+			for _, host := range handler().Hosts() {
+				paths = append(paths, filepath.Join(string(m.Cache.(autocert.DirCache)), host))
+			}
+			source = vanity.CertFiles(paths...)
+		} else {
+			if secure.TLSConfig, err = vanity.NewTLSConfig(c); err != nil {
+				return err
+			}
+			source = vanity.CertFiles(c.CertFile)
+		}
+		cm := &vanity.CertMonitor{Source: source, Health: health}
+		if metrics != nil {
+			metrics.CertMonitor = cm
+		}
+		go cm.Run(context.Background())
+		if secure.Addr == "" {
+			secure.Addr = ":https"
+		}
+		sls, err := listenAll(secure.Addr)
+		if err != nil {
+			return fmt.Errorf("tls.addr: %v", err)
+		}
+		servers = append(servers, secure)
+		for _, l := range sls {
+			run(secure, l, true)
+		}
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-sigc:
+		log.Printf("%v: draining connections for up to %v", sig, drain)
+	}
+	signal.Stop(sigc)
+	health.SetReady(errors.New("shutting down"))
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	shutdown := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			shutdown <- srv.Shutdown(ctx)
+		}(srv)
+	}
+	for range servers {
+		if err := <-shutdown; err != nil {
+			return fmt.Errorf("draining connections: %v", err)
+		}
+	}
+	return nil
+}
+
+// autocertDir, if set, is the directory to cache certificates from Let's
+// Encrypt in.  It defaults to VANITY_AUTOCERT.
+var autocertDir string
+
+// newAutocert returns a manager that obtains certificates from Let's Encrypt
+// for the hosts of the handler, caching them in autocertDir, or nil if it
+// is not set.  VANITY_AUTOCERT_EMAIL is the contact address for expiry
+// notices.  The Let's Encrypt terms of service are accepted.
+func newAutocert(handler func() *vanity.Handler) *autocert.Manager {
+	dir := autocertDir
+	if dir == "" {
+		dir = os.Getenv("VANITY_AUTOCERT")
+	}
+	if dir == "" {
+		return nil
+	}
+	return &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(dir),
+		Email:  os.Getenv("VANITY_AUTOCERT_EMAIL"),
+		HostPolicy: func(ctx context.Context, host string) error {
+			// The hosts may change when the configuration is reloaded.
+			for _, h := range handler().Hosts() {
+				if strings.EqualFold(host, h) {
+					return nil
+				}
+			}
+			return fmt.Errorf("host %q not configured", host)
+		},
+	}
+}
+
+// lenient makes configuration files parse with unknown keys ignored instead
+// of rejected.
+var lenient bool
+
+// format, if set, is the format of all configuration files regardless of
+// their extensions.
+var format string
+
+// configFormat returns the format of the configuration file at path.
+func configFormat(path string) string {
+	if format != "" {
+		return format
+	}
+	if isURL(path) {
+		if u, err := url.Parse(path); err == nil {
+			path = u.Path
+		}
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	}
+	return "yaml"
+}
+
+// isDir reports whether path is a directory.
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// isURL reports whether path is the URL of a remote configuration file.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// readConfig reads the configuration file at path and converts it to YAML.
+func readConfig(path string) ([]byte, error) {
+	return configReader(path)()
+}
+
+// configReader returns a function that reads the configuration file at path
+// and converts it to YAML.  If path is a directory, its configuration files
+// are merged, in the order of their names.  If it is a URL, the file is
+// fetched, but only transferred again once it changed.
+func configReader(path string) func() ([]byte, error) {
+	read := func() ([]byte, error) { return ioutil.ReadFile(path) }
+	switch {
+	case isURL(path):
+		read = (&vanity.RemoteConfig{URL: path}).Read
+	case isDir(path):
+		return func() ([]byte, error) { return readConfigDir(path) }
+	}
+	return func() ([]byte, error) {
+		config, err := read()
+		if err != nil {
+			return nil, err
+		}
+		if config, err = vanity.ConvertConfig(config, configFormat(path)); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return config, nil
+	}
+}
+
+func readConfigDir(dir string) ([]byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var configs [][]byte
+	for _, fi := range files {
+		switch strings.ToLower(filepath.Ext(fi.Name())) {
+		case ".json", ".toml", ".yaml", ".yml":
+		default:
+			continue
+		}
+		// Kubernetes mounts the files of ConfigMaps through hidden
+		// directories.
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		config, err := readConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		names, configs = append(names, path), append(configs, config)
+	}
+	return vanity.MergeConfigs(names, configs)
+}
+
+// loadHandler returns a handler for the YAML configuration config, with
+// references to Vault secrets replaced.  GITHUB_TOKEN and GITLAB_TOKEN
+// authenticate its API requests.
+func loadHandler(config []byte, opts ...vanity.Option) (*vanity.Handler, error) {
+	parse := vanity.ParseConfig
+	if lenient {
+		parse = vanity.ParseConfigLenient
+	}
+	c, err := parse(config)
+	if err != nil {
+		return nil, err
+	}
+	if vault != nil {
+		if err := vault.ExpandConfig(context.Background(), c); err != nil {
+			return nil, err
+		}
+	}
+	// The tokens raise the API rate limits for the releases of tools.
+	github, err := secretEnv("GITHUB_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	gitlab, err := secretEnv("GITLAB_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]vanity.Option{vanity.WithGitHubToken(github), vanity.WithGitLabToken(gitlab)}, opts...)
+	return vanity.New(c, opts...)
+}
+
+// secretEnv returns the environment variable name, or the Vault secret its
+// value refers to.
+func secretEnv(name string) (string, error) {
+	v := os.Getenv(name)
+	if !strings.HasPrefix(v, vanity.VaultPrefix) {
+		return v, nil
+	}
+	if vault == nil {
+		return "", fmt.Errorf("%s refers to Vault, but VAULT_ADDR is not set", name)
+	}
+	s, err := vault.Expand(context.Background(), v)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
+
+// middleware returns the middleware that the functions fs enable.
+func middleware(fs ...func() (vanity.Middleware, error)) ([]vanity.Middleware, error) {
+	var mw []vanity.Middleware
+	for _, f := range fs {
+		m, err := f()
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			mw = append(mw, m)
+		}
+	}
+	return mw, nil
+}
+
+// metrics counts requests and serves them at /metrics, if VANITY_METRICS is
+// set.
+var metrics *vanity.Metrics
+
+// newMetrics returns the metrics to collect, or nil if VANITY_METRICS is not
+// set.
+func newMetrics() *vanity.Metrics {
+	if os.Getenv("VANITY_METRICS") == "" {
+		return nil
+	}
+	m := new(vanity.Metrics)
+	mux.Handle("/metrics", m)
+	return m
+}
+
+// metricsMiddleware returns the middleware that counts requests for
+// metrics, if they are collected.
+func metricsMiddleware() (vanity.Middleware, error) {
+	if metrics == nil {
+		return nil, nil
+	}
+	return metrics.Middleware, nil
+}
+
+// accessLog returns middleware that logs every request if VANITY_ACCESS_LOG
+// is set, as JSON if it is "json", except for the comma-separated paths in
+// VANITY_ACCESS_LOG_EXCLUDE.  If VANITY_GEOIP names MaxMind databases,
+// separated by commas, log lines get the client's country and autonomous
+// system, and /admin/stats on the debug address reports request counts by
+// them.
+func accessLog() (vanity.Middleware, error) {
+	format, geoip := os.Getenv("VANITY_ACCESS_LOG"), os.Getenv("VANITY_GEOIP")
+	if format == "" && geoip == "" {
+		return nil, nil
+	}
+	al := &vanity.AccessLog{JSON: format == "json"}
+	for _, path := range strings.Split(os.Getenv("VANITY_ACCESS_LOG_EXCLUDE"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			al.Exclude = append(al.Exclude, path)
+		}
+	}
+	if geoip != "" {
+		m, err := vanity.OpenMaxMind(strings.Split(geoip, ",")...)
+		if err != nil {
+			return nil, fmt.Errorf("VANITY_GEOIP: %v", err)
+		}
+		al.Locator = m
+		http.Handle("/admin/stats", al)
+	}
+	return al.Middleware, nil
+}
+
+// shadow returns middleware that compares a sample of requests with the
+// candidate configuration named by the VANITY_SHADOW environment variable,
+// if set.  VANITY_SHADOW_SAMPLE is the fraction of requests to compare and
+// defaults to 0.1.
+func shadow() (vanity.Middleware, error) {
+	path := os.Getenv("VANITY_SHADOW")
+	if path == "" {
+		return nil, nil
+	}
+	sample := 0.1
+	if v := os.Getenv("VANITY_SHADOW_SAMPLE"); v != "" {
+		var err error
+		if sample, err = strconv.ParseFloat(v, 64); err != nil || sample < 0 || sample > 1 {
+			return nil, fmt.Errorf("VANITY_SHADOW_SAMPLE: want a fraction between 0 and 1, got %q", v)
+		}
+	}
+	config, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	candidate, err := loadHandler(config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	s := &vanity.Shadow{Candidate: candidate, Sample: sample}
+	if metrics != nil {
+		metrics.Shadow = s
+	}
+	return s.Middleware, nil
+}
+
+// startLinkChecker starts lc checking repositories in the background every
+// VANITY_LINK_CHECK, if set, and serves the results at /admin/links on the
+// debug address, since they list every repository.  If active is not nil,
+// checks are skipped while it returns false.
+func startLinkChecker(lc *vanity.LinkChecker, health *vanity.Health, active func() bool) error {
+	v := os.Getenv("VANITY_LINK_CHECK")
+	if v == "" {
+		return nil
+	}
+	var err error
+	if lc.Interval, err = time.ParseDuration(v); err != nil {
+		return fmt.Errorf("VANITY_LINK_CHECK: %v", err)
+	}
+	if lc.GitHubToken, err = secretEnv("GITHUB_TOKEN"); err != nil {
+		return err
+	}
+	lc.Active, lc.Health = active, health
+	if metrics != nil {
+		metrics.LinkChecker = lc
+	}
+	http.Handle("/admin/links", lc)
+	go lc.Run(context.Background())
+	return nil
+}
+
+// startPathAPI serves api at /api/v1/paths if VANITY_ADMIN_TOKEN is set to
+// the token clients must present.  If VANITY_ADMIN_SAVE is true, changes are
+// written back to the configuration file at configPath.
+func startPathAPI(api *vanity.PathAPI, configPath string) error {
+	var err error
+	if api.Token, err = secretEnv("VANITY_ADMIN_TOKEN"); err != nil || api.Token == "" {
+		return err
+	}
+	if v := os.Getenv("VANITY_ADMIN_SAVE"); v != "" {
+		save, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("VANITY_ADMIN_SAVE: %v", err)
+		}
+		if save {
+			if configFormat(configPath) != "yaml" || isDir(configPath) || isURL(configPath) {
+				return errors.New("VANITY_ADMIN_SAVE: can only write back a local YAML configuration file")
+			}
+			api.Save = func(paths []vanity.PathConfig) error {
+				return savePaths(configPath, paths)
+			}
+		}
+	}
+	mux.Handle("/api/v1/paths", api)
+	mux.Handle("/api/v1/paths/", api)
+	return nil
+}
+
+// newKVSync returns a KVSync for the backend VANITY_KV, consul or etcd, or
+// nil if it is not set.  VANITY_KV_ADDR is the URL of its API,
+// VANITY_KV_PREFIX the prefix of the entries, and VANITY_KV_TOKEN
+// authenticates the requests.
+func newKVSync() (*vanity.KVSync, error) {
+	backend := os.Getenv("VANITY_KV")
+	if backend == "" {
+		return nil, nil
+	}
+	kv := &vanity.KVSync{Backend: backend, Addr: os.Getenv("VANITY_KV_ADDR"), Prefix: os.Getenv("VANITY_KV_PREFIX")}
+	switch backend {
+	case "consul":
+		if kv.Addr == "" {
+			kv.Addr = "http://127.0.0.1:8500"
+		}
+	case "etcd":
+		if kv.Addr == "" {
+			kv.Addr = "http://127.0.0.1:2379"
+		}
+	default:
+		return nil, fmt.Errorf("VANITY_KV: want consul or etcd, got %q", backend)
+	}
+	if kv.Prefix == "" {
+		kv.Prefix = "vanity/paths/"
+	}
+	var err error
+	if kv.Token, err = secretEnv("VANITY_KV_TOKEN"); err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// newGitHubSync returns a GitHubSync for the organization VANITY_GITHUB_ORG,
+// or nil if it is not set.  VANITY_GITHUB_TOPIC restricts it to repositories
+// with that topic and VANITY_GITHUB_SYNC sets the interval.  GITHUB_TOKEN
+// authenticates the requests.  With VANITY_GITHUB_WEBHOOK_SECRET set, the
+// organization's webhook is served too.
+func newGitHubSync() (*vanity.GitHubSync, error) {
+	org := os.Getenv("VANITY_GITHUB_ORG")
+	if org == "" {
+		return nil, nil
+	}
+	gs := &vanity.GitHubSync{Org: org, Topic: os.Getenv("VANITY_GITHUB_TOPIC")}
+	var err error
+	if v := os.Getenv("VANITY_GITHUB_SYNC"); v != "" {
+		if gs.Interval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("VANITY_GITHUB_SYNC: %v", err)
+		}
+	}
+	if gs.Token, err = secretEnv("GITHUB_TOKEN"); err != nil {
+		return nil, err
+	}
+	if gs.Secret, err = secretEnv("VANITY_GITHUB_WEBHOOK_SECRET"); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// newGitLabSync returns a GitLabSync for the group VANITY_GITLAB_GROUP, or
+// nil if it is not set.  VANITY_GITLAB_URL is the GitLab instance, by
+// default gitlab.com, and VANITY_GITLAB_SYNC sets the interval.
+// GITLAB_TOKEN authenticates the requests.  With
+// VANITY_GITLAB_WEBHOOK_SECRET set, the group's webhook is served too.
+func newGitLabSync() (*vanity.GitLabSync, error) {
+	group := os.Getenv("VANITY_GITLAB_GROUP")
+	if group == "" {
+		return nil, nil
+	}
+	gl := &vanity.GitLabSync{Group: group, URL: os.Getenv("VANITY_GITLAB_URL")}
+	var err error
+	if v := os.Getenv("VANITY_GITLAB_SYNC"); v != "" {
+		if gl.Interval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("VANITY_GITLAB_SYNC: %v", err)
+		}
+	}
+	if gl.Token, err = secretEnv("GITLAB_TOKEN"); err != nil {
+		return nil, err
+	}
+	if gl.Secret, err = secretEnv("VANITY_GITLAB_WEBHOOK_SECRET"); err != nil {
+		return nil, err
+	}
+	return gl, nil
+}
+
+// savePaths replaces the paths in the configuration file at configPath,
+// replacing the file at once so that it is never seen half written.  The
+// paths hold the values of environment variables that the file refers to;
+// ReplacePaths keeps the references where the values are unchanged, and a
+// change that would still write a value to the file is refused.
+func savePaths(configPath string, paths []vanity.PathConfig) error {
+	config, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(configPath)
+	if err != nil {
+		return err
+	}
+	saved, err := vanity.ReplacePaths(config, paths)
+	if err != nil {
+		return fmt.Errorf("%s: %v", configPath, err)
+	}
+	for _, m := range envRef.FindAllSubmatch(config, -1) {
+		v := os.Getenv(string(m[1]))
+		if v != "" && bytes.Contains(saved, []byte(v)) && !bytes.Contains(config, []byte(v)) {
+			return fmt.Errorf("%s: not saving the paths, which would write the value of %s to the file", configPath, m[1])
+		}
+	}
+	config = saved
+	tmp := configPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, config, fi.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, configPath)
+}
+
+// check reports every problem with a configuration file, without serving
+// it, and warns about entries that are likely mistakes.  With -live, it
+// also checks that the repositories are reachable, and with
+// -warnings-as-errors, warnings fail the check too.
+func check(args []string) int {
+	const usage = "usage: govanityurls check [-live] [-warnings-as-errors] [CONFIG]"
+	live, strict := false, false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch strings.TrimPrefix(strings.TrimPrefix(args[0], "-"), "-") {
+		case "live":
+			live, args = true, args[1:]
+		case "warnings-as-errors":
+			strict, args = true, args[1:]
+		default:
+			log.Print(usage)
+			return 2
+		}
+	}
+	configPath := "vanity.yaml"
+	switch len(args) {
+	case 0:
+	case 1:
+		configPath = args[0]
+	default:
+		log.Print(usage)
+		return 2
+	}
+	config, err := readConfig(configPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	// Other files are converted or merged to YAML first, whose lines
+	// would mislead.
+	lines := configFormat(configPath) == "yaml" && !isDir(configPath)
+	report := func(errs []*vanity.ConfigError, kind string) {
+		for _, err := range errs {
+			if err.Line > 0 && lines {
+				fmt.Fprintf(os.Stderr, "%s:%d: %s%v\n", configPath, err.Line, kind, err.Err)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %s%v\n", configPath, kind, err.Err)
+			}
+		}
+	}
+	errs := vanity.CheckConfig(config)
+	report(errs, "")
+	if len(errs) > 0 {
+		return 1
+	}
+	warnings := vanity.LintConfig(config)
+	report(warnings, "warning: ")
+	if len(warnings) > 0 && strict {
+		return 1
+	}
+	if live {
+		return checkLive(config)
+	}
+	return 0
+}
+
+// checkLive checks the repositories of the configuration the way
+// VANITY_LINK_CHECK does, reports the broken ones and returns the exit
+// status.
+func checkLive(config []byte) int {
+	h, err := loadHandler(config)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	lc := &vanity.LinkChecker{Handler: h, Logger: log.New(ioutil.Discard, "", 0)}
+	if lc.GitHubToken, err = secretEnv("GITHUB_TOKEN"); err != nil {
+		log.Print(err)
+		return 1
+	}
+	status := 0
+	for _, c := range lc.CheckAll(context.Background()) {
+		if c.Broken() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", c.Path, c.Problem())
+			status = 1
+		}
+	}
+	return status
+}
+
+// envRef matches a reference to an environment variable in a configuration
+// file, as expanded by vanity.ParseConfig.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// goMetaTag matches the meta tags that the go command reads.
+var goMetaTag = regexp.MustCompile(`<meta name="go-(?:import|source)"[^>]*>`)
+
+// render prints the meta tags, or with -html the whole page, that go get
+// is served for an import path, without serving the configuration.
+func render(args []string) int {
+	page := len(args) > 0 && args[0] == "-html"
+	if page {
+		args = args[1:]
+	}
+	if len(args) != 2 {
+		log.Print("usage: govanityurls render [-html] CONFIG IMPORTPATH")
+		return 2
+	}
+	config, err := readConfig(args[0])
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	h, err := loadHandler(config)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	// Without a host, the request goes to the host of the configuration.
+	target := args[1]
+	if !strings.HasPrefix(target, "/") {
+		target = "http://" + target
+	}
+	target += "?go-get=1"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusOK {
+		fmt.Printf("%d %s\n", w.Code, http.StatusText(w.Code))
+		if loc := w.Header().Get("Location"); loc != "" {
+			fmt.Printf("Location: %s\n", loc)
+		}
+		return 1
+	}
+	if page {
+		os.Stdout.Write(w.Body.Bytes())
+		return 0
+	}
+	for _, tag := range goMetaTag.FindAll(w.Body.Bytes(), -1) {
+		fmt.Printf("%s\n", tag)
+	}
+	return 0
+}
+
+// export writes the pages of the configuration as a static site to the
+// directory given by -out, by default public, with the files of the hosting
+// service given by -hosting, if any.
+func export(args []string) int {
+	const usage = "usage: govanityurls export [-out DIR] [-hosting netlify|cloudflare] [CONFIG]"
+	out, hosting, configPath := "public", "", "vanity.yaml"
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch arg := strings.TrimPrefix(strings.TrimPrefix(args[0], "-"), "-"); {
+		case arg == "out" && len(args) > 1:
+			out, args = args[1], args[2:]
+		case strings.HasPrefix(arg, "out="):
+			out, args = strings.TrimPrefix(arg, "out="), args[1:]
+		case arg == "hosting" && len(args) > 1:
+			hosting, args = args[1], args[2:]
+		case strings.HasPrefix(arg, "hosting="):
+			hosting, args = strings.TrimPrefix(arg, "hosting="), args[1:]
+		default:
+			log.Print(usage)
+			return 2
+		}
+	}
+	switch len(args) {
+	case 0:
+	case 1:
+		configPath = args[0]
+	default:
+		log.Print(usage)
+		return 2
+	}
+	config, err := readConfig(configPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	h, err := loadHandler(config)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	files, err := h.StaticSite(hosting)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	for _, f := range files {
+		name := filepath.Join(out, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			log.Print(err)
+			return 1
+		}
+		if err := ioutil.WriteFile(name, f.Data, 0644); err != nil {
+			log.Print(err)
+			return 1
+		}
+	}
+	log.Printf("wrote %d files to %s", len(files), out)
+	return 0
+}
+
+// doctor checks that the repositories in the configuration declare the
+// module paths they are served under, and returns the exit status.
+func doctor(args []string) int {
+	configPath := "vanity.yaml"
+	switch len(args) {
+	case 0:
+	case 1:
+		configPath = args[0]
+	default:
+		log.Print("usage: govanityurls doctor [CONFIG]")
+		return 2
+	}
+	config, err := readConfig(configPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	h, err := loadHandler(config)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	checks, err := h.CheckModulePaths(context.Background(), http.DefaultClient)
+	if err != nil {
+		log.Printf("doctor: %v", err)
+		return 1
+	}
+	status := 0
+	for _, c := range checks {
+		fmt.Println(c)
+		if !c.OK() {
+			status = 1
+		}
+	}
+	return status
+}
+
+// verify has the go command download every configured module from the
+// handler, and returns the exit status.
+func verify(args []string) int {
+	configPath := "vanity.yaml"
+	switch len(args) {
+	case 0:
+	case 1:
+		configPath = args[0]
+	default:
+		log.Print("usage: govanityurls verify [CONFIG]")
+		return 2
+	}
+	config, err := readConfig(configPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	h, err := loadHandler(config)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	results, err := h.VerifyImportPaths(context.Background())
+	if err != nil {
+		log.Printf("verify: %v", err)
+		return 1
+	}
+	status := 0
+	for _, v := range results {
+		fmt.Println(v)
+		if !v.OK() {
+			status = 1
+		}
+	}
+	return status
+}
+
+// operator serves the configuration together with the VanityPath resources
+// of the cluster it runs in, until it fails or is told to stop.
+func operator(args []string) int {
+	configPath := "vanity.yaml"
+	switch len(args) {
+	case 0:
+	case 1:
+		configPath = args[0]
+	default:
+		log.Print("usage: govanityurls operator [CONFIG]")
+		return 2
+	}
+	config, err := readConfig(configPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	mw, err := middleware(metricsMiddleware, accessLog)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	h, err := loadHandler(config, vanity.WithMiddleware(mw...))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	client, err := kube.InCluster()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	podNS, err := kube.InClusterNamespace()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	ns := os.Getenv("VANITY_NAMESPACE")
+	switch ns {
+	case "":
+		ns = podNS
+	case "*":
+		ns = ""
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	leader := &kube.Elector{
+		Client:    client,
+		Namespace: podNS,
+		Name:      "govanityurls",
+		Identity:  identity,
+	}
+	if metrics != nil {
+		metrics.Handler = h
+	}
+	health := newHealth(h.Notifier)
+	c := &kube.Controller{Client: client, Handler: h, Namespace: ns, Health: health, Leader: leader}
+	if v := os.Getenv("VANITY_STARTUP_TIMEOUT"); v != "" {
+		if c.StartupTimeout, err = time.ParseDuration(v); err != nil {
+			log.Printf("VANITY_STARTUP_TIMEOUT: %v", err)
+			return 2
+		}
+	}
+	if err := startLinkChecker(&vanity.LinkChecker{Handler: h}, health, leader.IsLeader); err != nil {
+		log.Print(err)
+		return 2
+	}
+	startVault(health)
+	// On shutdown, the lease is given up so that another replica takes
+	// over without waiting for it to expire.
+	ctx, stop := context.WithCancel(context.Background())
+	released := make(chan struct{})
+	go func() {
+		if err := leader.Run(ctx, nil); ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		close(released)
+	}()
+	go func() {
+		log.Fatal(c.Run(context.Background()))
+	}()
+	health.Register(mux)
+	mux.Handle("/", h)
+	err = serve(func() *vanity.Handler { return h }, health, nil)
+	stop()
+	<-released
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// replica serves the paths of the primary server at the given URL, with the
+// rest of the configuration read from the optional configuration file, until
+// it fails or is told to stop.
+func replica(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		log.Print("usage: govanityurls replica PRIMARY [CONFIG]")
+		return 2
+	}
+	var config []byte
+	if len(args) == 2 {
+		var err error
+		if config, err = readConfig(args[1]); err != nil {
+			log.Print(err)
+			return 1
+		}
+	}
+	mw, err := middleware(metricsMiddleware, accessLog)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	h, err := loadHandler(config, vanity.WithMiddleware(mw...))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if metrics != nil {
+		metrics.Handler = h
+	}
+	health := newHealth(h.Notifier)
+	rp := &vanity.Replica{Handler: h, URL: args[0], Health: health}
+	if err := startLinkChecker(&vanity.LinkChecker{Handler: h}, health, nil); err != nil {
+		log.Print(err)
+		return 2
+	}
+	startVault(health)
+	go func() {
+		log.Fatal(rp.Run(context.Background()))
+	}()
+	health.Register(mux)
+	mux.Handle("/", h)
+	if err := serve(func() *vanity.Handler { return h }, health, nil); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,242 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AccessLog logs every request and counts where requests come from.  Its
+// Middleware does the logging; its ServeHTTP method reports the counts as
+// JSON.
+type AccessLog struct {
+	// Logger receives a line per request.  If nil, the standard logger is
+	// used.
+	Logger *log.Logger
+
+	// Locator, if not nil, adds the country and autonomous system of the
+	// client to log lines and counts.
+	Locator Locator
+
+	// JSON makes the log lines JSON objects, written to the writer of the
+	// logger without its prefix so that each line can be parsed.
+	JSON bool
+
+	// Exclude lists paths, such as those of health checks, whose requests
+	// are neither logged nor counted.
+	Exclude []string
+
+	mu        sync.Mutex
+	requests  int
+	countries map[string]int
+	networks  map[uint]*networkCount
+}
+
+type networkCount struct {
+	ASN      uint   `json:"asn"`
+	Org      string `json:"org"`
+	Requests int    `json:"requests"`
+}
+
+// Middleware is a Middleware that logs requests.
+func (al *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contains(al.Exclude, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		latency := time.Since(start)
+
+		var loc Location
+		if al.Locator != nil {
+			if ip := clientIP(r); ip != nil {
+				loc = al.Locator.Locate(ip)
+			}
+		}
+		al.count(loc)
+		if al.JSON {
+			al.logJSON(r, sw, latency, loc)
+			return
+		}
+		line := fmt.Sprintf("%s %s %s %d %d %v", r.RemoteAddr, r.Method, r.URL.RequestURI(), sw.code, sw.n, latency.Round(time.Microsecond))
+		if al.Locator != nil {
+			line += fmt.Sprintf(" country=%s asn=%d", orDash(loc.Country), loc.ASN)
+		}
+		if al.Logger != nil {
+			al.Logger.Print(line)
+		} else {
+			log.Print(line)
+		}
+	})
+}
+
+// accessLogEntry is a log line in JSON.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Query     string    `json:"query,omitempty"`
+	GoGet     bool      `json:"go_get"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Latency   float64   `json:"latency"` // in seconds
+	UserAgent string    `json:"user_agent,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	ASN       uint      `json:"asn,omitempty"`
+}
+
+func (al *AccessLog) logJSON(r *http.Request, sw *statusWriter, latency time.Duration, loc Location) {
+	line, err := json.Marshal(accessLogEntry{
+		Time:      time.Now().UTC(),
+		Remote:    r.RemoteAddr,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		GoGet:     r.URL.Query().Get("go-get") == "1",
+		Status:    sw.code,
+		Bytes:     sw.n,
+		Latency:   latency.Seconds(),
+		UserAgent: r.UserAgent(),
+		Country:   loc.Country,
+		ASN:       loc.ASN,
+	})
+	if err != nil {
+		return
+	}
+	out := log.Writer()
+	if al.Logger != nil {
+		out = al.Logger.Writer()
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	out.Write(append(line, '\n'))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clientIP returns the address of the client sending r.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func (al *AccessLog) count(loc Location) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.requests++
+	if al.Locator == nil {
+		return
+	}
+	if al.countries == nil {
+		al.countries = make(map[string]int)
+		al.networks = make(map[uint]*networkCount)
+	}
+	al.countries[orDash(loc.Country)]++
+	if loc.ASN != 0 {
+		n := al.networks[loc.ASN]
+		if n == nil {
+			n = &networkCount{ASN: loc.ASN, Org: loc.Org}
+			al.networks[loc.ASN] = n
+		}
+		n.Requests++
+	}
+}
+
+// ServeHTTP reports the number of requests, by country and by autonomous
+// system, busiest first.
+func (al *AccessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type countryCount struct {
+		Country  string `json:"country"`
+		Requests int    `json:"requests"`
+	}
+	report := struct {
+		Requests  int            `json:"requests"`
+		Countries []countryCount `json:"countries,omitempty"`
+		Networks  []networkCount `json:"networks,omitempty"`
+	}{}
+	al.mu.Lock()
+	report.Requests = al.requests
+	for c, n := range al.countries {
+		report.Countries = append(report.Countries, countryCount{c, n})
+	}
+	for _, n := range al.networks {
+		report.Networks = append(report.Networks, *n)
+	}
+	al.mu.Unlock()
+	sort.Slice(report.Countries, func(i, j int) bool {
+		a, b := report.Countries[i], report.Countries[j]
+		return a.Requests > b.Requests || a.Requests == b.Requests && a.Country < b.Country
+	})
+	sort.Slice(report.Networks, func(i, j int) bool {
+		a, b := report.Networks[i], report.Networks[j]
+		return a.Requests > b.Requests || a.Requests == b.Requests && a.ASN < b.ASN
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	n           int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if the underlying writer does.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
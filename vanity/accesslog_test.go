@@ -0,0 +1,135 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type mapLocator map[string]Location
+
+func (m mapLocator) Locate(ip net.IP) Location {
+	return m[ip.String()]
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	al := &AccessLog{
+		Logger: log.New(&buf, "", 0),
+		Locator: mapLocator{
+			"192.0.2.1":   {Country: "DE", ASN: 3320, Org: "Deutsche Telekom AG"},
+			"2001:db8::1": {Country: "JP", ASN: 2497, Org: "Internet Initiative Japan Inc."},
+			"192.0.2.2":   {Country: "DE", ASN: 3320, Org: "Deutsche Telekom AG"},
+		},
+	}
+	h, err := NewHandler([]byte("host: example.com\n"+
+		"paths:\n"+
+		"  /portmidi:\n"+
+		"    repo: https://github.com/rakyll/portmidi\n"),
+		WithMiddleware(al.Middleware))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"192.0.2.1:1234", "[2001:db8::1]:443", "192.0.2.2:80", "198.51.100.7:80"} {
+		r := httptest.NewRequest("GET", "/portmidi?go-get=1", nil)
+		r.RemoteAddr = addr
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("logged %d lines; want 4:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "192.0.2.1:1234 GET /portmidi?go-get=1 200 ") || !strings.HasSuffix(lines[0], " country=DE asn=3320") {
+		t.Errorf("line = %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[3], " country=- asn=0") {
+		t.Errorf("unknown address logged as %q", lines[3])
+	}
+
+	w := httptest.NewRecorder()
+	al.ServeHTTP(w, httptest.NewRequest("GET", "/admin/stats", nil))
+	var report struct {
+		Requests  int
+		Countries []struct {
+			Country  string
+			Requests int
+		}
+		Networks []networkCount
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 4 || len(report.Countries) != 3 || report.Countries[0].Country != "DE" || report.Countries[0].Requests != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.Networks) != 2 || report.Networks[0] != (networkCount{3320, "Deutsche Telekom AG", 2}) {
+		t.Errorf("networks = %+v", report.Networks)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	al := &AccessLog{
+		Logger:  log.New(&buf, "access: ", log.LstdFlags),
+		JSON:    true,
+		Exclude: []string{"/healthz"},
+	}
+	h, err := NewHandler([]byte("host: example.com\n"+
+		"paths:\n"+
+		"  /portmidi:\n"+
+		"    repo: https://github.com/rakyll/portmidi\n"),
+		WithMiddleware(al.Middleware))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/portmidi?go-get=1", "/healthz", "/portmidi"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("User-Agent", "Go-http-client/1.1")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines; want 2:\n%s", len(lines), buf.String())
+	}
+	var entries []accessLogEntry
+	for _, line := range lines {
+		var e accessLogEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if e := entries[0]; e.Method != "GET" || e.Host != "example.com" || e.Path != "/portmidi" || !e.GoGet || e.Status != 200 || e.UserAgent != "Go-http-client/1.1" || e.Bytes == 0 {
+		t.Errorf("entry = %+v", e)
+	}
+	if entries[1].GoGet {
+		t.Errorf("request without go-get=1 logged with go_get")
+	}
+	if al.requests != 2 {
+		t.Errorf("counted %d requests; want 2", al.requests)
+	}
+}
+
+func TestOpenMaxMindMissing(t *testing.T) {
+	if _, err := OpenMaxMind("testdata/missing.mmdb"); err == nil {
+		t.Error("opened missing database")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+const badgePrefix = "/badge/"
+
+func isBadgeRequest(p string) bool {
+	return strings.HasPrefix(p, badgePrefix) && strings.HasSuffix(p, ".svg")
+}
+
+// serveBadge serves /badge/{path}.svg, a badge with the import path of
+// {path} for the README of its repository.  With ?version=latest, the
+// badge also shows the latest version reported by the module proxy.
+func (h *Handler) serveBadge(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/badge"), ".svg")
+	pc, subpath := h.findPath(p)
+	if pc == nil || subpath != "" || pc.retired {
+		http.NotFound(w, r)
+		return
+	}
+	modPath := h.Host(r) + pc.path
+	message := modPath
+	switch r.URL.Query().Get("version") {
+	case "":
+		setCacheControl(w, pc.cacheMaxAge)
+	case "latest":
+		// Without a version, the badge is still worth showing.
+		if v, err := h.latest.lookup(r.Context(), modPath); err == nil {
+			message += "@" + v.Version
+		} else if err != errModuleNotFound {
+			h.logf("badge for %s: %v", modPath, err)
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(latestTTL.Seconds())))
+	default:
+		http.Error(w, "version must be latest", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(badgeSVG("go get", message))
+}
+
+// badgeSVG renders a badge in the style of shields.io, with label on the
+// left and message on the right.
+func badgeSVG(label, message string) []byte {
+	lw, mw := textWidth(label)+10, textWidth(message)+10
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">
+<title>%[2]s: %[3]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[4]d" height="20" fill="#555"/><rect x="%[4]d" width="%[5]d" height="20" fill="#007d9c"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[6]d" y="15" fill="#010101" fill-opacity=".3">%[2]s</text><text x="%[6]d" y="14">%[2]s</text>
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text><text x="%[7]d" y="14">%[3]s</text>
+</g>
+</svg>
+`, lw+mw, template.HTMLEscapeString(label), template.HTMLEscapeString(message), lw, mw, lw/2, lw+mw/2)
+	return buf.Bytes()
+}
+
+// textWidth estimates the width in pixels of s in 11px Verdana, which
+// renders most characters 7 pixels wide.
+func textWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		switch {
+		case strings.ContainsRune("fijlrtI.,:;!|'/()[] -", r):
+			w += 4
+		case strings.ContainsRune("mwMW@%", r):
+			w += 10
+		case 'A' <= r && r <= 'Z':
+			w += 8
+		default:
+			w += 7
+		}
+	}
+	return w
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeBadge(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/example.com/portmidi/@latest" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"Version":"v1.2.3","Time":"2019-01-02T03:04:05Z"}`)
+	}))
+	defer upstream.Close()
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"  /unreleased:\n" +
+		"    repo: https://github.com/example/unreleased\n" +
+		"proxy:\n" +
+		"  upstream: " + upstream.URL + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url    string
+		status int
+		text   string
+	}{
+		{"/badge/portmidi.svg", http.StatusOK, "example.com/portmidi"},
+		{"/badge/portmidi.svg?version=latest", http.StatusOK, "example.com/portmidi@v1.2.3"},
+		{"/badge/unreleased.svg?version=latest", http.StatusOK, "example.com/unreleased"},
+		{"/badge/portmidi.svg?version=v1", http.StatusBadRequest, ""},
+		{"/badge/portmidi/sub.svg", http.StatusNotFound, ""},
+		{"/badge/unknown.svg", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d; want %d", test.url, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != "image/svg+xml" {
+			t.Errorf("%s: Content-Type = %q; want image/svg+xml", test.url, got)
+		}
+		var svg struct {
+			Title string `xml:"title"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &svg); err != nil {
+			t.Errorf("%s: invalid SVG: %v\n%s", test.url, err, w.Body)
+			continue
+		}
+		if want := "go get: " + test.text; svg.Title != want {
+			t.Errorf("%s: title = %q; want %q", test.url, svg.Title, want)
+		}
+	}
+}
+
+func TestBadgeSVGEscapes(t *testing.T) {
+	svg := string(badgeSVG("go get", `a<b>&"c"`))
+	if strings.Contains(svg, "<b>") || !strings.Contains(svg, "a&lt;b&gt;&amp;") {
+		t.Errorf("badge does not escape its text:\n%s", svg)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// CachingResolver remembers the answers of another Resolver, so that a
+// dynamic backend is not asked on every request for the same path.  Errors
+// are not cached.
+type CachingResolver struct {
+	resolver    Resolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	path    string
+	res     *Resolution
+	expires time.Time
+}
+
+// CacheStats counts the lookups of a CachingResolver.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// NewCachingResolver returns a resolver that caches answers of r for ttl,
+// and answers that a path is unknown for negativeTTL.  At most maxEntries
+// paths are remembered; if maxEntries is zero, there is no limit.
+func NewCachingResolver(r Resolver, ttl, negativeTTL time.Duration, maxEntries int) *CachingResolver {
+	return &CachingResolver{
+		resolver:    r,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// Resolve implements Resolver.
+func (c *CachingResolver) Resolve(ctx context.Context, path string) (*Resolution, error) {
+	now := time.Now()
+	c.mu.Lock()
+	if el, ok := c.entries[path]; ok {
+		e := el.Value.(*cacheEntry)
+		if now.Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return e.res, nil
+		}
+		c.remove(el)
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	res, err := c.resolver.Resolve(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	ttl := c.ttl
+	if res == nil {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return res, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[path]; ok {
+		c.remove(el)
+	}
+	c.entries[path] = c.lru.PushFront(&cacheEntry{path: path, res: res, expires: now.Add(ttl)})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return res, nil
+}
+
+func (c *CachingResolver) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).path)
+}
+
+// Stats returns the lookup counts so far.
+func (c *CachingResolver) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return s
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type countingResolver struct {
+	Resolver
+	calls map[string]int
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, path string) (*Resolution, error) {
+	r.calls[path]++
+	return r.Resolver.Resolve(ctx, path)
+}
+
+func TestCachingResolver(t *testing.T) {
+	backend := &countingResolver{
+		Resolver: mapResolver{
+			"/a": {Repo: "https://github.com/example/a"},
+			"/b": {Repo: "https://github.com/example/b"},
+		},
+		calls: make(map[string]int),
+	}
+	c := NewCachingResolver(backend, time.Hour, time.Hour, 2)
+	ctx := context.Background()
+	for _, p := range []string{"/a", "/a", "/unknown", "/unknown", "/broken", "/broken"} {
+		c.Resolve(ctx, p)
+	}
+	if backend.calls["/a"] != 1 || backend.calls["/unknown"] != 1 {
+		t.Errorf("backend calls = %v; want one each for /a and /unknown", backend.calls)
+	}
+	if backend.calls["/broken"] != 2 {
+		t.Errorf("backend calls for /broken = %d; want 2 (errors are not cached)", backend.calls["/broken"])
+	}
+
+	// /b evicts the least recently used entry, /a.
+	c.Resolve(ctx, "/b")
+	c.Resolve(ctx, "/a")
+	if backend.calls["/a"] != 2 {
+		t.Errorf("backend calls for /a = %d; want 2 after eviction", backend.calls["/a"])
+	}
+	s := c.Stats()
+	if s.Hits != 2 || s.Evictions != 2 || s.Entries != 2 {
+		t.Errorf("Stats() = %+v; want 2 hits, 2 evictions, 2 entries", s)
+	}
+}
+
+func TestCachingResolverExpiry(t *testing.T) {
+	backend := &countingResolver{
+		Resolver: mapResolver{"/a": {Repo: "https://github.com/example/a"}},
+		calls:    make(map[string]int),
+	}
+	c := NewCachingResolver(backend, time.Nanosecond, 0, 0)
+	ctx := context.Background()
+	c.Resolve(ctx, "/a")
+	time.Sleep(time.Millisecond)
+	c.Resolve(ctx, "/a")
+	c.Resolve(ctx, "/unknown")
+	c.Resolve(ctx, "/unknown")
+	if backend.calls["/a"] != 2 || backend.calls["/unknown"] != 2 {
+		t.Errorf("backend calls = %v; want two each", backend.calls)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCertWindow   = 14 * 24 * time.Hour
+	defaultCertInterval = time.Hour
+)
+
+// A CertMonitor warns about serving certificates that are about to expire,
+// whether they are read from files or issued through ACME.
+type CertMonitor struct {
+	// Source returns the certificates being served.  See CertFiles.
+	Source func() ([]*x509.Certificate, error)
+
+	// Window is how long before expiry to start warning.  Defaults to 14
+	// days.
+	Window time.Duration
+
+	// Interval is how often to check.  Defaults to an hour.
+	Interval time.Duration
+
+	// Health, if not nil, gets the result of every check reported as its
+	// "tls" component, so that its Notifier hears about certificates
+	// entering the window.
+	Health *Health
+
+	// Logger receives warnings.  If nil, the standard logger is used.
+	Logger *log.Logger
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	notAfter time.Time
+}
+
+// Run checks the certificates every Interval until ctx is done.
+func (m *CertMonitor) Run(ctx context.Context) error {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = defaultCertInterval
+	}
+	for {
+		m.Check()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Check checks the certificates once.  It returns an error if they cannot
+// be loaded or the first of them expires within Window.
+func (m *CertMonitor) Check() error {
+	err := m.check()
+	if err != nil {
+		m.logf("tls: %v", err)
+	}
+	if m.Health != nil {
+		m.Health.Report("tls", err)
+	}
+	return err
+}
+
+func (m *CertMonitor) check() error {
+	certs, err := m.Source()
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return errors.New("no certificates")
+	}
+	first := certs[0]
+	for _, c := range certs[1:] {
+		if c.NotAfter.Before(first.NotAfter) {
+			first = c
+		}
+	}
+	m.mu.Lock()
+	m.notAfter = first.NotAfter
+	m.mu.Unlock()
+	window := m.Window
+	if window <= 0 {
+		window = defaultCertWindow
+	}
+	now := time.Now()
+	if m.now != nil {
+		now = m.now()
+	}
+	left := first.NotAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return fmt.Errorf("certificate for %s expired at %v", certName(first), first.NotAfter.UTC())
+	case left <= window:
+		return fmt.Errorf("certificate for %s expires in %v, at %v", certName(first), left.Round(time.Minute), first.NotAfter.UTC())
+	}
+	return nil
+}
+
+// NotAfter returns when the first of the certificates expires, as of the
+// last check, or the zero time before the first successful one.
+func (m *CertMonitor) NotAfter() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.notAfter
+}
+
+// CertFiles returns a source of the leaf certificates in the PEM files at
+// paths, for a CertMonitor.  The files are read on every check, so renewed
+// certificates are noticed.
+func CertFiles(paths ...string) func() ([]*x509.Certificate, error) {
+	return func() ([]*x509.Certificate, error) {
+		var certs []*x509.Certificate
+		for _, path := range paths {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			// Keys may come first in a combined file.
+			var block *pem.Block
+			for {
+				block, data = pem.Decode(data)
+				if block == nil || block.Type == "CERTIFICATE" {
+					break
+				}
+			}
+			if block == nil {
+				return nil, fmt.Errorf("%s: no certificate", path)
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			certs = append(certs, c)
+		}
+		return certs, nil
+	}
+}
+
+// certName names c by its DNS names, or its subject if it has none.
+func certName(c *x509.Certificate) string {
+	if len(c.DNSNames) > 0 {
+		return strings.Join(c.DNSNames, ", ")
+	}
+	return c.Subject.CommonName
+}
+
+func (m *CertMonitor) logf(format string, args ...interface{}) {
+	if m.Logger != nil {
+		m.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for name expiring at notAfter
+// to dir, preceded by its key, and returns the file's path.
+func writeCert(t *testing.T, dir, name string, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	path := filepath.Join(dir, name+".pem")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCertMonitor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		notAfter []time.Time
+		want     string // error substring, empty for none
+	}{
+		{name: "fresh", notAfter: []time.Time{now.Add(60 * 24 * time.Hour)}},
+		{name: "expiring", notAfter: []time.Time{now.Add(3 * 24 * time.Hour)}, want: "certificate for expiring-0.example.com expires in 72h0m0s"},
+		{name: "expired", notAfter: []time.Time{now.Add(-time.Hour)}, want: "expired at 2019-12-31 23:00:00"},
+		{name: "second-expiring", notAfter: []time.Time{now.Add(60 * 24 * time.Hour), now.Add(24 * time.Hour)}, want: "certificate for second-expiring-1.example.com expires in 24h"},
+	}
+	for _, test := range tests {
+		var paths []string
+		for i, na := range test.notAfter {
+			paths = append(paths, writeCert(t, dir, fmt.Sprintf("%s-%d.example.com", test.name, i), na))
+		}
+		var logs bytes.Buffer
+		health := new(Health)
+		m := &CertMonitor{
+			Source: CertFiles(paths...),
+			Health: health,
+			Logger: log.New(&logs, "", 0),
+			now:    func() time.Time { return now },
+		}
+		err := m.Check()
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: Check: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: Check = %v; want error containing %q", test.name, err, test.want)
+		}
+		if (err != nil) != (logs.Len() > 0) {
+			t.Errorf("%s: logged %q for error %v", test.name, logs.String(), err)
+		}
+		if s, ok := health.Components()["tls"]; !ok || s.OK != (err == nil) {
+			t.Errorf("%s: tls component = %+v; want OK = %v", test.name, s, err == nil)
+		}
+		earliest := test.notAfter[0]
+		for _, na := range test.notAfter {
+			if na.Before(earliest) {
+				earliest = na
+			}
+		}
+		if !m.NotAfter().Equal(earliest) {
+			t.Errorf("%s: NotAfter = %v; want %v", test.name, m.NotAfter(), earliest)
+		}
+	}
+
+	m := &CertMonitor{Source: CertFiles(filepath.Join(dir, "missing.pem")), Logger: log.New(ioutil.Discard, "", 0)}
+	if err := m.Check(); err == nil {
+		t.Error("Check with a missing file succeeded; want error")
+	}
+	if !m.NotAfter().IsZero() {
+		t.Errorf("NotAfter without a successful check = %v; want zero", m.NotAfter())
+	}
+}
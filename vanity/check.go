@@ -0,0 +1,194 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConfigError is a problem with a configuration file found by CheckConfig.
+type ConfigError struct {
+	// Line is the line of the path, path rule or host at fault, or 0 if the
+	// problem is not with one of them.
+	Line int
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// CheckConfig parses and validates a YAML configuration file like
+// NewHandler, but reports the problems of every path, path rule and host
+// instead of only the first problem.  Vault references are taken to be
+// valid, since they are expanded only by servers.
+func CheckConfig(data []byte) []*ConfigError {
+	c, err := ParseConfig(data)
+	if err != nil {
+		return []*ConfigError{{Err: err}}
+	}
+	for _, f := range secretFields(c) {
+		if strings.HasPrefix(*f.value, VaultPrefix) {
+			*f.value = ""
+		}
+	}
+	global := *c
+	global.Paths, global.PathRules, global.Hosts = nil, nil, nil
+	if err := global.Validate(); err != nil {
+		// Every path would fail the same way.
+		return []*ConfigError{{Err: err}}
+	}
+	var errs []*ConfigError
+	check := func(line int, hc HostConfig) bool {
+		sub := global
+		if hc.Host == "" {
+			sub.Paths, sub.PathRules = hc.Paths, hc.PathRules
+		} else {
+			sub.Hosts = []HostConfig{hc}
+		}
+		if err := sub.Validate(); err != nil {
+			errs = append(errs, &ConfigError{Line: line, Err: err})
+			return false
+		}
+		return true
+	}
+	hosts := append([]HostConfig{{Paths: c.Paths, PathRules: c.PathRules}}, c.Hosts...)
+	for _, hc := range hosts {
+		start := 0
+		if hc.Host != "" {
+			start = keyLine(data, 0, hc.Host)
+			if !check(start, HostConfig{Host: hc.Host}) {
+				continue
+			}
+		}
+		for _, p := range hc.Paths {
+			check(keyLine(data, start, p.Path), HostConfig{Host: hc.Host, Paths: []PathConfig{p}})
+		}
+		for _, r := range hc.PathRules {
+			check(keyLine(data, start, r.Pattern), HostConfig{Host: hc.Host, PathRules: []PathRule{r}})
+		}
+	}
+	if len(errs) == 0 {
+		// Left are the conflicts between paths, such as aliases of
+		// another path.
+		if err := c.Validate(); err != nil {
+			errs = append(errs, &ConfigError{Err: err})
+		}
+	}
+	return errs
+}
+
+// LintConfig reports entries of a valid YAML configuration file that are
+// served but likely mistakes: path rules that never match because a path
+// serves everything they would, path rules that also match paths or other
+// path rules, which take precedence, paths that differ only in case but
+// point at different repositories, and paths that share a repository,
+// whose go.mod can declare only one of them.  It reports nothing for files
+// that CheckConfig rejects.
+func LintConfig(data []byte) []*ConfigError {
+	c, err := ParseConfig(data)
+	if err != nil {
+		return nil
+	}
+	for _, f := range secretFields(c) {
+		if strings.HasPrefix(*f.value, VaultPrefix) {
+			*f.value = ""
+		}
+	}
+	h, err := New(c)
+	if err != nil {
+		return nil
+	}
+	var warnings []*ConfigError
+	for _, hh := range h.handlers() {
+		start := 0
+		if hh != h {
+			start = keyLine(data, 0, hh.host)
+		}
+		warn := func(key, format string, args ...interface{}) {
+			warnings = append(warnings, &ConfigError{Line: keyLine(data, start, key), Err: fmt.Errorf(format, args...)})
+		}
+		hh.lintRules(warn)
+		hh.lintPaths(warn)
+	}
+	return warnings
+}
+
+// lintRules reports the path rules of h that other entries get in the way
+// of, to warn about the rule's key.
+func (h *Handler) lintRules(warn func(key, format string, args ...interface{})) {
+	pset := h.pathSet()
+	for i := range h.rules {
+		pr := &h.rules[i]
+		if pc, _ := h.findPath(pr.fixedPrefix()); pc != nil {
+			warn(pr.pattern, "path rule %s never matches: path %s serves everything below it", pr.pattern, pc.path)
+			continue
+		}
+		for j := range pset {
+			if m, _ := pr.match(pset[j].path); m != nil {
+				warn(pr.pattern, "path rule %s also matches path %s, which takes precedence", pr.pattern, pset[j].path)
+			}
+		}
+		for j := 0; j < i; j++ {
+			if h.rules[j].overlaps(pr) {
+				warn(pr.pattern, "path rule %s overlaps path rule %s, which takes precedence", pr.pattern, h.rules[j].pattern)
+			}
+		}
+	}
+}
+
+// lintPaths reports the paths of h that conflict with earlier ones, to
+// warn about the later path's key.
+func (h *Handler) lintPaths(warn func(key, format string, args ...interface{})) {
+	byLower := make(map[string]pathConfig)
+	byRepo := make(map[string]pathConfig)
+	for _, pc := range h.pathSet().configured() {
+		if pc.retired || pc.repo == "" {
+			continue
+		}
+		lower := strings.ToLower(pc.path)
+		if other, ok := byLower[lower]; ok && other.repo != pc.repo {
+			warn(pc.path, "paths %s and %s differ only in case but point at different repositories", other.path, pc.path)
+		} else if !ok {
+			byLower[lower] = pc
+		}
+		repo := strings.TrimSuffix(pc.repo, ".git") + "\x00" + pc.subdir
+		if other, ok := byRepo[repo]; ok {
+			warn(pc.path, "paths %s and %s both point at %s, whose go.mod can declare only one of them; make one an alias of the other", other.path, pc.path, pc.repo)
+		} else {
+			byRepo[repo] = pc
+		}
+	}
+}
+
+// keyLine returns the line of the first mapping key key after line start,
+// or 0 if there is none.
+func keyLine(data []byte, start int, key string) int {
+	lines := strings.Split(string(data), "\n")
+	for i := start; i < len(lines); i++ {
+		l := strings.TrimSpace(lines[i])
+		for _, k := range []string{key, strconv.Quote(key), "'" + key + "'"} {
+			if strings.HasPrefix(l, k+":") {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
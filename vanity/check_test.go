@@ -0,0 +1,194 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import "testing"
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errs   []string
+	}{
+		{
+			name: "valid",
+			config: "paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n",
+		},
+		{
+			name:   "syntax",
+			config: "paths: [\n",
+			errs:   []string{"yaml: line 1: did not find expected node content"},
+		},
+		{
+			name:   "global",
+			config: "cache_max_age: -1\n",
+			errs:   []string{"configuration for cache_max_age: must not be negative"},
+		},
+		{
+			name: "every path",
+			config: "paths:\n" +
+				"  /missingvcs:\n" +
+				"    repo: https://bitbucket.org/zombiezen/gopdf\n" +
+				"  /ok:\n" +
+				"    repo: https://github.com/example/ok\n" +
+				"  /unknownvcs:\n" +
+				"    repo: https://bitbucket.org/zombiezen/gopdf\n" +
+				"    vcs: xyzzy\n" +
+				"pathrules:\n" +
+				"  \"^/x/(?P<name>[a-z]+)$\":\n" +
+				"    regex: true\n" +
+				"    repo: https://github.com/example/{nope}\n" +
+				"hosts:\n" +
+				"  go.corp-a.com:\n" +
+				"    paths:\n" +
+				"      /missingvcs:\n" +
+				"        repo: https://bitbucket.org/zombiezen/gopdf\n" +
+				"  \"bad host\":\n" +
+				"    paths:\n" +
+				"      /ok:\n" +
+				"        repo: https://github.com/example/ok\n",
+			errs: []string{
+				"line 2: configuration for /missingvcs: cannot infer VCS from https://bitbucket.org/zombiezen/gopdf",
+				"line 6: configuration for /unknownvcs: unknown VCS xyzzy",
+				"line 10: configuration for pathrule ^/x/(?P<name>[a-z]+)$: repo refers to unknown group {nope}",
+				"line 18: configuration for hosts: invalid host \"bad host\"",
+				"line 16: host go.corp-a.com: configuration for /missingvcs: cannot infer VCS from https://bitbucket.org/zombiezen/gopdf",
+			},
+		},
+		{
+			name: "conflict",
+			config: "paths:\n" +
+				"  /a:\n" +
+				"    repo: https://github.com/example/a\n" +
+				"    aliases: [/b]\n" +
+				"  /b:\n" +
+				"    repo: https://github.com/example/b\n",
+			errs: []string{"configuration for /b: duplicate path"},
+		},
+		{
+			name: "shortened import prefix",
+			config: "import_depth: 1\n" +
+				"paths:\n" +
+				"  /x/a:\n" +
+				"    repo: https://github.com/example/a\n" +
+				"  /x/b:\n" +
+				"    repo: https://github.com/example/b\n",
+			errs: []string{"configuration for /x/b: import prefix /x is served for another repository by /x/a"},
+		},
+	}
+	for _, test := range tests {
+		errs := CheckConfig([]byte(test.config))
+		if len(errs) != len(test.errs) {
+			t.Errorf("%s: CheckConfig(...) = %v; want %d errors", test.name, errs, len(test.errs))
+			continue
+		}
+		for i, err := range errs {
+			if err.Error() != test.errs[i] {
+				t.Errorf("%s: error %d = %q; want %q", test.name, i, err, test.errs[i])
+			}
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		warnings []string
+	}{
+		{
+			name: "clean",
+			config: "paths:\n" +
+				"  /a:\n" +
+				"    repo: https://github.com/example/a\n" +
+				"    aliases: [/b]\n" +
+				"pathrules:\n" +
+				"  /x/{name}:\n" +
+				"    repo: https://github.com/x/{name}\n",
+		},
+		{
+			name: "shadowed rules",
+			config: "paths:\n" +
+				"  /gopdf:\n" +
+				"    repo: https://github.com/zombiezen/gopdf\n" +
+				"  /x/y:\n" +
+				"    repo: https://github.com/x/y\n" +
+				"pathrules:\n" +
+				"  /gopdf/{name}:\n" +
+				"    repo: https://github.com/gopdf/{name}\n" +
+				"  ^/gopdf/(?P<name>[a-z]+)$:\n" +
+				"    regex: true\n" +
+				"    repo: https://github.com/gopdf/{name}\n" +
+				"  /x/{name}:\n" +
+				"    repo: https://github.com/x/{name}\n",
+			warnings: []string{
+				"line 7: path rule /gopdf/{name} never matches: path /gopdf serves everything below it",
+				"line 12: path rule /x/{name} also matches path /x/y, which takes precedence",
+				"line 9: path rule ^/gopdf/(?P<name>[a-z]+)$ never matches: path /gopdf serves everything below it",
+			},
+		},
+		{
+			name: "overlapping rules",
+			config: "pathrules:\n" +
+				"  /x/{name}:\n" +
+				"    repo: https://github.com/x/{name}\n" +
+				"  /{name}/y:\n" +
+				"    repo: https://github.com/{name}/y\n",
+			warnings: []string{
+				"line 4: path rule /{name}/y overlaps path rule /x/{name}, which takes precedence",
+			},
+		},
+		{
+			name: "paths",
+			config: "paths:\n" +
+				"  /Foo:\n" +
+				"    repo: https://github.com/example/Foo\n" +
+				"  /foo:\n" +
+				"    repo: https://github.com/example/foo\n" +
+				"  /bar:\n" +
+				"    repo: https://github.com/example/foo.git\n" +
+				"  /old:\n" +
+				"    repo: https://github.com/example/foo\n" +
+				"    retired: true\n" +
+				"hosts:\n" +
+				"  go.corp-a.com:\n" +
+				"    paths:\n" +
+				"      /foo:\n" +
+				"        repo: https://github.com/example/foo\n",
+			warnings: []string{
+				"line 4: paths /Foo and /foo differ only in case but point at different repositories",
+				"line 4: paths /bar and /foo both point at https://github.com/example/foo, whose go.mod can declare only one of them; make one an alias of the other",
+			},
+		},
+		{
+			name:   "invalid",
+			config: "paths:\n  /a:\n    repo: https://bitbucket.org/zombiezen/gopdf\n",
+		},
+	}
+	for _, test := range tests {
+		warnings := LintConfig([]byte(test.config))
+		if len(warnings) != len(test.warnings) {
+			t.Errorf("%s: LintConfig(...) = %v; want %d warnings", test.name, warnings, len(test.warnings))
+			continue
+		}
+		for i, w := range warnings {
+			if w.Error() != test.warnings[i] {
+				t.Errorf("%s: warning %d = %q; want %q", test.name, i, w, test.warnings[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,805 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of a Handler.  It can be parsed from YAML with
+// ParseConfig or built directly in Go code.
+type Config struct {
+	// Host is the host name to use in meta tags.  If empty, the handler's
+	// DefaultHost or the Host header of the request is used.
+	Host string
+
+	// Prefix is the path, such as /go, under which the paths are served
+	// and which is part of their import paths, for sharing a host with a
+	// web site.  Requests for anything else are not found.
+	Prefix string
+
+	// CanonicalRedirect makes Host the canonical host: browsers asking for
+	// any other host but those of Hosts are permanently redirected to it,
+	// while go-get requests are answered with meta tags for the host they
+	// asked for, so that import paths on an old host keep working.
+	CanonicalRedirect bool
+
+	// ImportDepth is the number of leading elements of the matched path to
+	// advertise as the import prefix.  If zero, or not fewer than the
+	// elements of the matched path, the matched path is used.
+	ImportDepth int
+
+	// Branch is the branch that inferred go-source meta tags link to, such
+	// as main.  If empty, it depends on the provider, e.g. master for
+	// GitHub.
+	Branch string
+
+	// NoSource omits the go-source meta tag, e.g. for private repositories
+	// whose browse URLs should not be published.  Paths may override it.
+	NoSource bool
+
+	// Redirect sends browsers, that is requests without go-get=1, elsewhere
+	// instead of serving them the page with the meta tags.  It is
+	// pkg.go.dev, repo for the repository's web page, or a URL in which
+	// {import} stands for the requested import path.  Paths may override
+	// it.
+	Redirect string
+
+	// CacheMaxAge, if not nil, is the number of seconds that clients and
+	// proxies may cache the pages of paths and the index, sent as the
+	// max-age of a Cache-Control header.  Paths may override it.
+	CacheMaxAge *int
+
+	Paths     []PathConfig
+	PathRules []PathRule
+
+	// Hosts are further hosts to serve, each with its own paths and path
+	// rules, chosen by the Host header of requests.  The other settings are
+	// shared.  Requests for any other host are served Paths and PathRules.
+	Hosts []HostConfig
+
+	// Providers declare self-hosted code hosting services, whose
+	// repositories get their display and VCS inferred.
+	Providers []ProviderConfig
+
+	Proxy    ProxyConfig
+	Resolver ResolverConfig
+	Fallback FallbackConfig
+	Notify   NotifyConfig
+	Theme    ThemeConfig
+
+	// Template is the file of an html/template that replaces the built-in
+	// page of paths.  WithTemplates takes precedence.
+	Template string
+
+	// NotFoundTemplate is the file of an html/template for the page of
+	// requests that match no path, instead of a plain 404 response.
+	// WithNotFoundTemplate takes precedence, and NotFoundHandler over both.
+	NotFoundTemplate string
+
+	// TLS configures serving HTTPS.  The handler only validates it;
+	// servers get it from the handler's TLS method.
+	TLS TLSConfig
+
+	// Headers are added to every response.
+	Headers map[string]string
+
+	// RateLimit limits how fast each client may make requests.
+	RateLimit RateLimitConfig
+
+	// TrustedProxies are the CIDR blocks or addresses of reverse proxies
+	// whose X-Forwarded-Host, X-Forwarded-Proto and X-Forwarded-For
+	// headers are believed.  Requests from them are handled as if sent for
+	// the forwarded host, which picks among Hosts and is used in meta tags
+	// unless Host is set, and rate limited by the forwarded client.
+	TrustedProxies []string
+
+	// Export enables /api/v1/export, from which replicas copy the paths.
+	Export bool
+
+	// UpstreamTimeout bounds each call to the module proxy, the checksum
+	// database and code hosting APIs.  Defaults to 10 seconds.
+	UpstreamTimeout time.Duration
+
+	// RequestTimeout, if positive, bounds the handling of each request
+	// other than module proxy, checksum database and export requests.
+	RequestTimeout time.Duration
+
+	// Locale is the language of pages for browsers that accept none of the
+	// available ones.  Defaults to en.
+	Locale string
+
+	// Messages adds to or overrides the message catalogs of the built-in
+	// pages, by locale.
+	Messages map[string]Messages
+
+	// Index configures the index page.
+	Index IndexConfig
+}
+
+// PathConfig is the configuration of a path that points to the root of a
+// repository hosted elsewhere.
+type PathConfig struct {
+	Path string
+	Repo string
+
+	// Display is the last three fields of the go-source meta tag.  If
+	// empty, it is inferred from the code hosting service if possible.
+	Display string
+
+	// Branch overrides Config.Branch for this path.
+	Branch string
+
+	// NoSource overrides Config.NoSource for this path if not nil.
+	NoSource *bool
+
+	// Redirect overrides Config.Redirect for this path.
+	Redirect string
+
+	// CacheMaxAge overrides Config.CacheMaxAge for this path if not nil.
+	CacheMaxAge *int
+
+	// VCS is the version control system of Repo.  It may be omitted if it
+	// can be inferred from the code hosting service.
+	VCS string
+
+	// Tool marks repositories of installable commands.
+	Tool bool
+
+	// Aliases are further paths, such as the old paths of a renamed
+	// module, that are served the same repository.
+	Aliases []string
+
+	// DeprecateAliases makes the pages of the aliases point browsers at
+	// Path instead of redirecting them to the documentation.
+	DeprecateAliases bool
+
+	// Retired makes the path answer 410 Gone, without meta tags, for a
+	// package that was removed deliberately.  Repo may be empty then.
+	Retired bool
+
+	// RetiredMessage is added to the answer for a retired path, e.g. to
+	// name a replacement.
+	RetiredMessage string
+
+	// Subdir is the directory of the module within Repo, for monorepos.
+	// The go-source meta tag links into it and the go-import meta tag
+	// names it, which requires Go 1.25 or later.
+	Subdir string
+
+	// ImportDepth overrides Config.ImportDepth for this path if not nil.
+	ImportDepth *int
+
+	// MajorSubdirs indicates that the repository keeps major versions v2
+	// and above in vN subdirectories.
+	MajorSubdirs bool
+
+	// AllowInsecure permits a plain HTTP repository URL.
+	AllowInsecure bool
+
+	// Description, Tags and Owner describe the module on the index page
+	// and in the path listings, e.g. to keep a catalog of an
+	// organization's modules.
+	Description string
+	Tags        []string
+	Owner       string
+}
+
+// PathRule serves every path that matches Pattern, in which {name} stands
+// for a single path element.  The Path field of the embedded PathConfig is
+// ignored; {name} in Repo and Display is replaced by the matched element.
+type PathRule struct {
+	Pattern string
+
+	// Regexp makes Pattern a regular expression matching the start of
+	// paths, beginning with ^/.  {group} in Repo, Display, Redirect and
+	// Subdir is replaced by what the named group matched.
+	Regexp bool
+
+	// Exact restricts the rule to paths matching the pattern exactly,
+	// excluding subpaths.
+	Exact bool
+
+	PathConfig
+}
+
+// HostConfig is the configuration of one of several hosts served by a
+// handler.
+type HostConfig struct {
+	Host      string
+	Paths     []PathConfig
+	PathRules []PathRule
+}
+
+// ProxyConfig configures forwarding of module proxy protocol requests.
+type ProxyConfig struct {
+	// Upstream is the base URL of the module proxy to forward to.  If empty,
+	// module proxy requests are not forwarded.
+	Upstream string
+
+	// CacheDir is the directory in which immutable responses are cached.
+	CacheDir string
+
+	// SumDB enables forwarding of checksum database requests to
+	// sum.golang.org.
+	SumDB bool
+
+	// VCS serves modules of configured git repositories from their tagged
+	// versions, cloning the repositories into CacheDir.  Other modules are
+	// forwarded to Upstream, if set.
+	VCS bool
+
+	// VCSTimeout bounds each clone or fetch of a repository for VCS.  It
+	// defaults to five minutes.
+	VCSTimeout time.Duration
+}
+
+// ResolverConfig configures a WebhookResolver for paths that are not
+// configured statically.
+type ResolverConfig struct {
+	// URL is the endpoint to call.  If empty, no resolver is installed.
+	URL string
+
+	// Timeout bounds each call to URL.
+	Timeout time.Duration
+
+	// CacheTTL and NegativeTTL are how long answers that a path exists or
+	// is unknown are remembered.  CacheSize limits the number of paths
+	// remembered.  If both TTLs are zero, answers are not cached.
+	CacheTTL    time.Duration
+	NegativeTTL time.Duration
+	CacheSize   int
+}
+
+// FallbackConfig configures what happens to requests that match no path.
+// At most one field may be set; if none is, such requests get a 404 Not
+// Found response.
+type FallbackConfig struct {
+	// Proxy is the base URL of another vanity server to forward requests
+	// to.
+	Proxy string
+
+	// Redirect is a base URL to redirect requests to.  The request path
+	// and query are appended to it.
+	Redirect string
+}
+
+// NotifyConfig configures where operational events are sent.
+type NotifyConfig struct {
+	// Webhook is a URL to POST events to as JSON.
+	Webhook string
+
+	// Slack is the URL of a Slack incoming webhook.
+	Slack string
+
+	// SMTP configures mailing events.
+	SMTP SMTPConfig
+}
+
+// SMTPConfig configures an SMTPNotifier.  Events are only mailed if Addr is
+// set.
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+// Validate reports the first problem with c, if any.
+func (c *Config) Validate() error {
+	_, err := New(c)
+	return err
+}
+
+// yamlConfig is the layout of the YAML configuration file.
+type yamlConfig struct {
+	Host              string                  `yaml:"host,omitempty"`
+	CanonicalRedirect bool                    `yaml:"canonical_redirect,omitempty"`
+	Prefix            string                  `yaml:"prefix,omitempty"`
+	ImportDepth       int                     `yaml:"import_depth,omitempty"`
+	Branch            string                  `yaml:"branch,omitempty"`
+	NoSource          bool                    `yaml:"no_source,omitempty"`
+	Redirect          string                  `yaml:"redirect,omitempty"`
+	CacheMaxAge       *int                    `yaml:"cache_max_age,omitempty"`
+	Paths             map[string]yamlPath     `yaml:"paths,omitempty"`
+	PathRules         map[string]yamlPathRule `yaml:"pathrules,omitempty"`
+	Hosts             map[string]struct {
+		Paths     map[string]yamlPath     `yaml:"paths,omitempty"`
+		PathRules map[string]yamlPathRule `yaml:"pathrules,omitempty"`
+	} `yaml:"hosts,omitempty"`
+	Providers map[string]string `yaml:"providers,omitempty"`
+	Proxy     struct {
+		Upstream string `yaml:"upstream,omitempty"`
+		CacheDir string `yaml:"cache_dir,omitempty"`
+		SumDB    bool   `yaml:"sumdb,omitempty"`
+		VCS      bool   `yaml:"vcs,omitempty"`
+
+		VCSTimeout time.Duration `yaml:"vcs_timeout,omitempty"`
+	} `yaml:"proxy,omitempty"`
+	Resolver struct {
+		URL         string        `yaml:"url,omitempty"`
+		Timeout     time.Duration `yaml:"timeout,omitempty"`
+		CacheTTL    time.Duration `yaml:"cache_ttl,omitempty"`
+		NegativeTTL time.Duration `yaml:"negative_ttl,omitempty"`
+		CacheSize   int           `yaml:"cache_size,omitempty"`
+	} `yaml:"resolver,omitempty"`
+	Fallback struct {
+		Proxy    string `yaml:"proxy,omitempty"`
+		Redirect string `yaml:"redirect,omitempty"`
+	} `yaml:"fallback,omitempty"`
+	Notify struct {
+		Webhook string `yaml:"webhook,omitempty"`
+		Slack   string `yaml:"slack,omitempty"`
+		SMTP    struct {
+			Addr     string   `yaml:"addr,omitempty"`
+			From     string   `yaml:"from,omitempty"`
+			To       []string `yaml:"to,omitempty"`
+			Username string   `yaml:"username,omitempty"`
+			Password string   `yaml:"password,omitempty"`
+		} `yaml:"smtp,omitempty"`
+	} `yaml:"notify,omitempty"`
+	Theme struct {
+		Mode      string            `yaml:"mode,omitempty"`
+		Variables map[string]string `yaml:"variables,omitempty"`
+	} `yaml:"theme,omitempty"`
+	Template         string `yaml:"template,omitempty"`
+	NotFoundTemplate string `yaml:"not_found_template,omitempty"`
+	TLS              struct {
+		Addr         string   `yaml:"addr,omitempty"`
+		CertFile     string   `yaml:"cert_file,omitempty"`
+		KeyFile      string   `yaml:"key_file,omitempty"`
+		MinVersion   string   `yaml:"min_version,omitempty"`
+		CipherSuites []string `yaml:"cipher_suites,omitempty"`
+	} `yaml:"tls,omitempty"`
+	Headers         map[string]string   `yaml:"headers,omitempty"`
+	TrustedProxies  []string            `yaml:"trusted_proxies,omitempty"`
+	Export          bool                `yaml:"export,omitempty"`
+	UpstreamTimeout time.Duration       `yaml:"upstream_timeout,omitempty"`
+	RequestTimeout  time.Duration       `yaml:"request_timeout,omitempty"`
+	Locale          string              `yaml:"locale,omitempty"`
+	Messages        map[string]Messages `yaml:"messages,omitempty"`
+	RateLimit       struct {
+		Rate  float64 `yaml:"rate,omitempty"`
+		Burst int     `yaml:"burst,omitempty"`
+	} `yaml:"rate_limit,omitempty"`
+	Index struct {
+		PageSize int    `yaml:"page_size,omitempty"`
+		GroupBy  string `yaml:"group_by,omitempty"`
+	} `yaml:"index,omitempty"`
+}
+
+type yamlPath struct {
+	Repo             string   `yaml:"repo,omitempty"`
+	Display          string   `yaml:"display,omitempty"`
+	Branch           string   `yaml:"branch,omitempty"`
+	NoSource         *bool    `yaml:"no_source,omitempty"`
+	Redirect         string   `yaml:"redirect,omitempty"`
+	CacheMaxAge      *int     `yaml:"cache_max_age,omitempty"`
+	VCS              string   `yaml:"vcs,omitempty"`
+	Tool             bool     `yaml:"tool,omitempty"`
+	Subdir           string   `yaml:"subdir,omitempty"`
+	ImportDepth      *int     `yaml:"import_depth,omitempty"`
+	MajorSubdirs     bool     `yaml:"major_subdirs,omitempty"`
+	AllowInsecure    bool     `yaml:"allow_insecure,omitempty"`
+	Aliases          []string `yaml:"aliases,omitempty"`
+	DeprecateAliases bool     `yaml:"deprecate_aliases,omitempty"`
+	Retired          bool     `yaml:"retired,omitempty"`
+	RetiredMessage   string   `yaml:"retired_message,omitempty"`
+	Description      string   `yaml:"description,omitempty"`
+	Tags             []string `yaml:"tags,omitempty"`
+	Owner            string   `yaml:"owner,omitempty"`
+}
+
+type yamlPathRule struct {
+	yamlPath `yaml:",inline"`
+
+	// Subpaths controls whether the rule also matches paths below the
+	// pattern.  Defaults to true, like paths.
+	Subpaths *bool `yaml:"subpaths,omitempty"`
+
+	// Regex makes the pattern a regular expression.
+	Regex bool `yaml:"regex,omitempty"`
+}
+
+func (e yamlPath) pathConfig(path string) PathConfig {
+	return PathConfig{
+		Path:             path,
+		Repo:             e.Repo,
+		Display:          e.Display,
+		Branch:           e.Branch,
+		NoSource:         e.NoSource,
+		Redirect:         e.Redirect,
+		CacheMaxAge:      e.CacheMaxAge,
+		VCS:              e.VCS,
+		Tool:             e.Tool,
+		Subdir:           e.Subdir,
+		ImportDepth:      e.ImportDepth,
+		MajorSubdirs:     e.MajorSubdirs,
+		AllowInsecure:    e.AllowInsecure,
+		Aliases:          e.Aliases,
+		DeprecateAliases: e.DeprecateAliases,
+		Retired:          e.Retired,
+		RetiredMessage:   e.RetiredMessage,
+		Description:      e.Description,
+		Tags:             e.Tags,
+		Owner:            e.Owner,
+	}
+}
+
+func yamlPathOf(p PathConfig) yamlPath {
+	return yamlPath{
+		Repo:             p.Repo,
+		Display:          p.Display,
+		Branch:           p.Branch,
+		NoSource:         p.NoSource,
+		Redirect:         p.Redirect,
+		CacheMaxAge:      p.CacheMaxAge,
+		VCS:              p.VCS,
+		Tool:             p.Tool,
+		Subdir:           p.Subdir,
+		ImportDepth:      p.ImportDepth,
+		MajorSubdirs:     p.MajorSubdirs,
+		AllowInsecure:    p.AllowInsecure,
+		Aliases:          p.Aliases,
+		DeprecateAliases: p.DeprecateAliases,
+		Retired:          p.Retired,
+		RetiredMessage:   p.RetiredMessage,
+		Description:      p.Description,
+		Tags:             p.Tags,
+		Owner:            p.Owner,
+	}
+}
+
+// ReplacePaths returns the YAML configuration file config with its paths
+// replaced by paths.  The other settings are kept, in their order, but
+// comments are lost.  Where the value of a path is what a reference to an
+// environment variable in config expands to, the reference is kept, so
+// that tokens injected at deploy time are not written to the file.
+func ReplacePaths(config []byte, paths []PathConfig) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(config, &doc); err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Paths map[string]yamlPath `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(config, &raw); err != nil {
+		return nil, err
+	}
+	var m yaml.MapSlice
+	for _, p := range paths {
+		e := yamlPathOf(p)
+		if old, ok := raw.Paths[p.Path]; ok {
+			keepEnvRef(&e.Repo, old.Repo)
+			keepEnvRef(&e.Display, old.Display)
+		}
+		m = append(m, yaml.MapItem{Key: p.Path, Value: e})
+	}
+	out := make(yaml.MapSlice, 0, len(doc)+1)
+	replaced := false
+	for _, item := range doc {
+		if item.Key != "paths" {
+			out = append(out, item)
+			continue
+		}
+		if len(m) > 0 {
+			out = append(out, yaml.MapItem{Key: "paths", Value: m})
+		}
+		replaced = true
+	}
+	if !replaced && len(m) > 0 {
+		out = append(out, yaml.MapItem{Key: "paths", Value: m})
+	}
+	return yaml.Marshal(out)
+}
+
+// MergeConfigs merges YAML configuration files into one, as if their paths,
+// path rules, hosts and providers had been written in a single file.  A path
+// or any other setting must not be set by more than one of them.  The files
+// are named by names in errors.
+func MergeConfigs(names []string, configs [][]byte) ([]byte, error) {
+	var merged yaml.MapSlice
+	owners := make(map[string]string)
+	for i, config := range configs {
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal(config, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", names[i], err)
+		}
+		var err error
+		if merged, err = configMerge.merge(merged, doc, "", names[i], owners); err != nil {
+			return nil, err
+		}
+	}
+	return yaml.Marshal(merged)
+}
+
+// mergeRules tells how the keys of a mapping merge: the values of keys with
+// rules, or any key if * has rules, are mappings merged by those, and the
+// others must be set only once.
+type mergeRules map[string]mergeRules
+
+var configMerge = mergeRules{
+	"paths":     {},
+	"pathrules": {},
+	"providers": {},
+	"hosts":     {"*": {"paths": {}, "pathrules": {}}},
+}
+
+// merge adds the items of src, from the file name, to dst.  owners tracks
+// the files that set the keys so far, by their paths from the top.
+func (rules mergeRules) merge(dst, src yaml.MapSlice, prefix, name string, owners map[string]string) (yaml.MapSlice, error) {
+	for _, item := range src {
+		key := fmt.Sprint(item.Key)
+		where := strings.TrimPrefix(prefix+" "+key, " ")
+		sub := rules[key]
+		if sub == nil {
+			sub = rules["*"]
+		}
+		if sub == nil {
+			if owner, ok := owners[where]; ok {
+				return nil, fmt.Errorf("%s: %s is also set in %s", name, where, owner)
+			}
+			owners[where] = name
+			dst = append(dst, item)
+			continue
+		}
+		m, ok := item.Value.(yaml.MapSlice)
+		if !ok && item.Value != nil {
+			return nil, fmt.Errorf("%s: %s is not a mapping", name, where)
+		}
+		i := 0
+		for i < len(dst) && fmt.Sprint(dst[i].Key) != key {
+			i++
+		}
+		if i == len(dst) {
+			dst = append(dst, yaml.MapItem{Key: item.Key, Value: yaml.MapSlice{}})
+		}
+		merged, err := sub.merge(dst[i].Value.(yaml.MapSlice), m, where, name, owners)
+		if err != nil {
+			return nil, err
+		}
+		dst[i].Value = merged
+	}
+	return dst, nil
+}
+
+// ConvertConfig converts a configuration file in format, one of yaml, json
+// and toml, to the YAML that ParseConfig parses.  The keys are the same in
+// every format.
+func ConvertConfig(data []byte, format string) ([]byte, error) {
+	var v interface{}
+	switch format {
+	case "yaml":
+		return data, nil
+	case "json":
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+	case "toml":
+		// A TOML document is always a table.
+		var m map[string]interface{}
+		if err := toml.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		v = m
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q", format)
+	}
+	return yaml.Marshal(v)
+}
+
+// ParseConfig parses a YAML configuration file.  Unknown keys are an error,
+// so that misspelled settings do not go unnoticed.  References to
+// environment variables, written ${VAR}, are expanded in the host and in
+// the repo and display of paths and path rules.  The configuration is not
+// validated.
+func ParseConfig(data []byte) (*Config, error) {
+	return parseConfig(data, yaml.UnmarshalStrict)
+}
+
+// ParseConfigLenient is like ParseConfig, but ignores unknown keys.
+func ParseConfigLenient(data []byte) (*Config, error) {
+	return parseConfig(data, yaml.Unmarshal)
+}
+
+func parseConfig(data []byte, unmarshal func([]byte, interface{}) error) (*Config, error) {
+	var parsed yamlConfig
+	if err := unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	c := &Config{
+		Host:              parsed.Host,
+		CanonicalRedirect: parsed.CanonicalRedirect,
+		Prefix:            parsed.Prefix,
+		ImportDepth:       parsed.ImportDepth,
+		Branch:            parsed.Branch,
+		NoSource:          parsed.NoSource,
+		Redirect:          parsed.Redirect,
+		CacheMaxAge:       parsed.CacheMaxAge,
+		Proxy: ProxyConfig{
+			Upstream: parsed.Proxy.Upstream,
+			CacheDir: parsed.Proxy.CacheDir,
+			SumDB:    parsed.Proxy.SumDB,
+			VCS:      parsed.Proxy.VCS,
+
+			VCSTimeout: parsed.Proxy.VCSTimeout,
+		},
+		Resolver: ResolverConfig{
+			URL:         parsed.Resolver.URL,
+			Timeout:     parsed.Resolver.Timeout,
+			CacheTTL:    parsed.Resolver.CacheTTL,
+			NegativeTTL: parsed.Resolver.NegativeTTL,
+			CacheSize:   parsed.Resolver.CacheSize,
+		},
+		Fallback: FallbackConfig{
+			Proxy:    parsed.Fallback.Proxy,
+			Redirect: parsed.Fallback.Redirect,
+		},
+		Notify: NotifyConfig{
+			Webhook: parsed.Notify.Webhook,
+			Slack:   parsed.Notify.Slack,
+			SMTP: SMTPConfig{
+				Addr:     parsed.Notify.SMTP.Addr,
+				From:     parsed.Notify.SMTP.From,
+				To:       parsed.Notify.SMTP.To,
+				Username: parsed.Notify.SMTP.Username,
+				Password: parsed.Notify.SMTP.Password,
+			},
+		},
+		Theme: ThemeConfig{
+			Mode:      parsed.Theme.Mode,
+			Variables: parsed.Theme.Variables,
+		},
+		Template:         parsed.Template,
+		NotFoundTemplate: parsed.NotFoundTemplate,
+		TLS: TLSConfig{
+			Addr:         parsed.TLS.Addr,
+			CertFile:     parsed.TLS.CertFile,
+			KeyFile:      parsed.TLS.KeyFile,
+			MinVersion:   parsed.TLS.MinVersion,
+			CipherSuites: parsed.TLS.CipherSuites,
+		},
+		Headers:         parsed.Headers,
+		TrustedProxies:  parsed.TrustedProxies,
+		Export:          parsed.Export,
+		UpstreamTimeout: parsed.UpstreamTimeout,
+		RequestTimeout:  parsed.RequestTimeout,
+		Locale:          parsed.Locale,
+		Messages:        parsed.Messages,
+		RateLimit: RateLimitConfig{
+			Rate:  parsed.RateLimit.Rate,
+			Burst: parsed.RateLimit.Burst,
+		},
+		Index: IndexConfig{
+			PageSize: parsed.Index.PageSize,
+			GroupBy:  parsed.Index.GroupBy,
+		},
+	}
+	c.Paths = parsePaths(parsed.Paths)
+	c.PathRules = parsePathRules(parsed.PathRules)
+	for host, e := range parsed.Hosts {
+		c.Hosts = append(c.Hosts, HostConfig{
+			Host:      host,
+			Paths:     parsePaths(e.Paths),
+			PathRules: parsePathRules(e.PathRules),
+		})
+	}
+	for base, typ := range parsed.Providers {
+		c.Providers = append(c.Providers, ProviderConfig{Base: base, Type: typ})
+	}
+	sort.Slice(c.Providers, func(i, j int) bool { return c.Providers[i].Base < c.Providers[j].Base })
+	for _, f := range envFields(c) {
+		var err error
+		if *f.value, err = expandEnv(*f.value); err != nil {
+			return nil, fmt.Errorf("configuration for %s: %v", f.name, err)
+		}
+	}
+	// Hosts are sorted by their expanded names.
+	sort.Slice(c.Hosts, func(i, j int) bool { return c.Hosts[i].Host < c.Hosts[j].Host })
+	return c, nil
+}
+
+// envRef matches a reference to an environment variable.  Unlike os.Expand,
+// a bare $VAR is left alone, since it may well be part of a URL.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the references to environment variables in s.
+func expandEnv(s string) (string, error) {
+	var err error
+	s = envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v
+	})
+	return s, err
+}
+
+// keepEnvRef sets *value back to raw if raw refers to environment variables
+// and expands to *value.
+func keepEnvRef(value *string, raw string) {
+	if !envRef.MatchString(raw) {
+		return
+	}
+	if expanded, err := expandEnv(raw); err == nil && expanded == *value {
+		*value = raw
+	}
+}
+
+// envFields returns the fields of c in which environment variables are
+// expanded.
+func envFields(c *Config) []secretField {
+	fields := []secretField{{"host", &c.Host}}
+	addPaths := func(paths []PathConfig, rules []PathRule) {
+		for i := range paths {
+			p := &paths[i]
+			fields = append(fields, secretField{p.Path, &p.Repo}, secretField{p.Path, &p.Display})
+		}
+		for i := range rules {
+			r := &rules[i]
+			name := "pathrule " + r.Pattern
+			fields = append(fields, secretField{name, &r.Repo}, secretField{name, &r.Display})
+		}
+	}
+	addPaths(c.Paths, c.PathRules)
+	for i := range c.Hosts {
+		fields = append(fields, secretField{"hosts", &c.Hosts[i].Host})
+		addPaths(c.Hosts[i].Paths, c.Hosts[i].PathRules)
+	}
+	return fields
+}
+
+func parsePaths(m map[string]yamlPath) []PathConfig {
+	var paths []PathConfig
+	for _, path := range sortedKeys(m) {
+		paths = append(paths, m[path].pathConfig(path))
+	}
+	return paths
+}
+
+func parsePathRules(m map[string]yamlPathRule) []PathRule {
+	var rules []PathRule
+	for pattern, e := range m {
+		rules = append(rules, PathRule{
+			Pattern:    pattern,
+			Regexp:     e.Regex,
+			Exact:      e.Subpaths != nil && !*e.Subpaths,
+			PathConfig: e.pathConfig(""),
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Pattern < rules[j].Pattern })
+	return rules
+}
+
+func sortedKeys(m map[string]yamlPath) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
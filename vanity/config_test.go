@@ -0,0 +1,374 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /b:\n" +
+		"    repo: https://github.com/example/b\n" +
+		"  /a:\n" +
+		"    repo: https://bitbucket.org/example/a\n" +
+		"    vcs: hg\n" +
+		"pathrules:\n" +
+		"  /x/{name}:\n" +
+		"    repo: https://github.com/x/{name}\n" +
+		"    subpaths: false\n" +
+		"hosts:\n" +
+		"  go.corp-a.com:\n" +
+		"    paths:\n" +
+		"      /tools:\n" +
+		"        repo: https://github.com/corp-a/tools\n" +
+		"resolver:\n" +
+		"  url: https://registry.example.com/resolve\n" +
+		"  timeout: 500ms\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Host: "example.com",
+		Paths: []PathConfig{
+			{Path: "/a", Repo: "https://bitbucket.org/example/a", VCS: "hg"},
+			{Path: "/b", Repo: "https://github.com/example/b"},
+		},
+		PathRules: []PathRule{
+			{Pattern: "/x/{name}", Exact: true, PathConfig: PathConfig{Repo: "https://github.com/x/{name}"}},
+		},
+		Hosts: []HostConfig{
+			{Host: "go.corp-a.com", Paths: []PathConfig{{Path: "/tools", Repo: "https://github.com/corp-a/tools"}}},
+		},
+		Resolver: ResolverConfig{URL: "https://registry.example.com/resolve", Timeout: 500 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("ParseConfig = %+v; want %+v", c, want)
+	}
+}
+
+func TestMergeConfigs(t *testing.T) {
+	names := []string{"base.yaml", "team-a.yaml", "team-b.yaml"}
+	configs := [][]byte{
+		[]byte("host: example.com\n" +
+			"paths:\n" +
+			"  /portmidi:\n" +
+			"    repo: https://github.com/rakyll/portmidi\n"),
+		[]byte("paths:\n" +
+			"  /a:\n" +
+			"    repo: https://github.com/team-a/a\n" +
+			"hosts:\n" +
+			"  go.corp-a.com:\n" +
+			"    paths:\n" +
+			"      /tools:\n" +
+			"        repo: https://github.com/corp-a/tools\n"),
+		[]byte("hosts:\n" +
+			"  go.corp-a.com:\n" +
+			"    paths:\n" +
+			"      /b:\n" +
+			"        repo: https://github.com/corp-a/b\n"),
+	}
+	merged, err := MergeConfigs(names, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseConfig(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Host: "example.com",
+		Paths: []PathConfig{
+			{Path: "/a", Repo: "https://github.com/team-a/a"},
+			{Path: "/portmidi", Repo: "https://github.com/rakyll/portmidi"},
+		},
+		Hosts: []HostConfig{{Host: "go.corp-a.com", Paths: []PathConfig{
+			{Path: "/b", Repo: "https://github.com/corp-a/b"},
+			{Path: "/tools", Repo: "https://github.com/corp-a/tools"},
+		}}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("MergeConfigs = %+v; want %+v", c, want)
+	}
+
+	conflicts := []struct {
+		config string
+		err    string
+	}{
+		{
+			config: "paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/team-b/portmidi\n",
+			err: "team-b.yaml: paths /portmidi is also set in base.yaml",
+		},
+		{
+			config: "host: example.org\n",
+			err:    "team-b.yaml: host is also set in base.yaml",
+		},
+		{
+			config: "hosts:\n" +
+				"  go.corp-a.com:\n" +
+				"    paths:\n" +
+				"      /tools:\n" +
+				"        repo: https://github.com/team-b/tools\n",
+			err: "team-b.yaml: hosts go.corp-a.com paths /tools is also set in team-a.yaml",
+		},
+	}
+	for _, test := range conflicts {
+		_, err := MergeConfigs(names, [][]byte{configs[0], configs[1], []byte(test.config)})
+		if err == nil || err.Error() != test.err {
+			t.Errorf("MergeConfigs with %q = _, %v; want %s", test.config, err, test.err)
+		}
+	}
+}
+
+func TestConvertConfig(t *testing.T) {
+	want := &Config{
+		Host:        "example.com",
+		CacheMaxAge: new(int),
+		Paths: []PathConfig{
+			{Path: "/portmidi", Repo: "https://github.com/rakyll/portmidi", Aliases: []string{"/pm"}},
+		},
+		Hosts: []HostConfig{
+			{Host: "go.corp-a.com", Paths: []PathConfig{{Path: "/tools", Repo: "https://github.com/corp-a/tools"}}},
+		},
+		Resolver: ResolverConfig{Timeout: 500 * time.Millisecond},
+	}
+	*want.CacheMaxAge = 300
+	tests := []struct {
+		format string
+		config string
+	}{
+		{
+			format: "json",
+			config: `{
+				"host": "example.com",
+				"cache_max_age": 300,
+				"paths": {"/portmidi": {"repo": "https://github.com/rakyll/portmidi", "aliases": ["/pm"]}},
+				"hosts": {"go.corp-a.com": {"paths": {"/tools": {"repo": "https://github.com/corp-a/tools"}}}},
+				"resolver": {"timeout": "500ms"}
+			}`,
+		},
+		{
+			format: "toml",
+			config: `host = "example.com"
+				cache_max_age = 300
+
+				[paths."/portmidi"]
+				repo = "https://github.com/rakyll/portmidi"
+				aliases = ["/pm"]
+
+				[hosts."go.corp-a.com".paths."/tools"]
+				repo = "https://github.com/corp-a/tools"
+
+				[resolver]
+				timeout = "500ms"
+			`,
+		},
+	}
+	for _, test := range tests {
+		data, err := ConvertConfig([]byte(test.config), test.format)
+		if err != nil {
+			t.Errorf("%s: ConvertConfig: %v", test.format, err)
+			continue
+		}
+		c, err := ParseConfig(data)
+		if err != nil {
+			t.Errorf("%s: ParseConfig: %v", test.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("%s: ParseConfig = %+v; want %+v", test.format, c, want)
+		}
+	}
+	if _, err := ConvertConfig(nil, "ini"); err == nil {
+		t.Error("ConvertConfig(nil, \"ini\") did not fail")
+	}
+}
+
+func TestParseConfigEnv(t *testing.T) {
+	os.Setenv("VANITY_TEST_HOST", "staging.example.com")
+	os.Setenv("VANITY_TEST_TOKEN", "s3cret")
+	defer os.Unsetenv("VANITY_TEST_HOST")
+	defer os.Unsetenv("VANITY_TEST_TOKEN")
+	c, err := ParseConfig([]byte("host: ${VANITY_TEST_HOST}\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://${VANITY_TEST_TOKEN}@git.example.com/portmidi\n" +
+		"    display: https://git.example.com/portmidi $dir ${VANITY_TEST_TOKEN}\n" +
+		"    vcs: git\n" +
+		"hosts:\n" +
+		"  go.${VANITY_TEST_HOST}:\n" +
+		"    paths:\n" +
+		"      /tools:\n" +
+		"        repo: https://github.com/example/tools\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Host: "staging.example.com",
+		Paths: []PathConfig{{
+			Path:    "/portmidi",
+			Repo:    "https://s3cret@git.example.com/portmidi",
+			Display: "https://git.example.com/portmidi $dir s3cret",
+			VCS:     "git",
+		}},
+		Hosts: []HostConfig{
+			{Host: "go.staging.example.com", Paths: []PathConfig{{Path: "/tools", Repo: "https://github.com/example/tools"}}},
+		},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("ParseConfig = %+v; want %+v", c, want)
+	}
+
+	_, err = ParseConfig([]byte("paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/${VANITY_TEST_UNSET}/portmidi\n"))
+	if want := "configuration for /portmidi: environment variable VANITY_TEST_UNSET is not set"; err == nil || err.Error() != want {
+		t.Errorf("ParseConfig with an unset variable = _, %v; want %s", err, want)
+	}
+	_, err = ParseConfig([]byte("hosts:\n" +
+		"  go.${VANITY_TEST_UNSET}:\n" +
+		"    paths:\n" +
+		"      /tools:\n" +
+		"        repo: https://github.com/example/tools\n"))
+	if want := "configuration for hosts: environment variable VANITY_TEST_UNSET is not set"; err == nil || err.Error() != want {
+		t.Errorf("ParseConfig with an unset variable in a host = _, %v; want %s", err, want)
+	}
+}
+
+func TestParseConfigUnknownKeys(t *testing.T) {
+	config := []byte("paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"    dispaly: https://github.com/rakyll/portmidi _ _\n")
+	if _, err := ParseConfig(config); err == nil || !strings.Contains(err.Error(), "line 4: field dispaly not found") {
+		t.Errorf("ParseConfig = _, %v; want an error about line 4", err)
+	}
+	c, err := ParseConfigLenient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PathConfig{{Path: "/portmidi", Repo: "https://github.com/rakyll/portmidi"}}
+	if !reflect.DeepEqual(c.Paths, want) {
+		t.Errorf("ParseConfigLenient(...).Paths = %+v; want %+v", c.Paths, want)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Config
+		ok   bool
+	}{
+		{
+			name: "valid",
+			c: Config{Paths: []PathConfig{
+				{Path: "/portmidi", Repo: "https://github.com/rakyll/portmidi"},
+			}},
+			ok: true,
+		},
+		{
+			name: "duplicate path",
+			c: Config{Paths: []PathConfig{
+				{Path: "/portmidi", Repo: "https://github.com/rakyll/portmidi"},
+				{Path: "/portmidi/", Repo: "https://github.com/rakyll/portmidi"},
+			}},
+		},
+		{
+			name: "missing VCS",
+			c: Config{Paths: []PathConfig{
+				{Path: "/gopdf", Repo: "https://bitbucket.org/zombiezen/gopdf"},
+			}},
+		},
+	}
+	for _, test := range tests {
+		if err := test.c.Validate(); (err == nil) != test.ok {
+			t.Errorf("%s: Validate() = %v; want ok = %t", test.name, err, test.ok)
+		}
+	}
+}
+
+func TestReplacePaths(t *testing.T) {
+	config := []byte("host: example.com\n" +
+		"paths:\n" +
+		"  /old:\n" +
+		"    repo: https://github.com/example/old\n" +
+		"export: true\n")
+	depth := 1
+	out, err := ReplacePaths(config, []PathConfig{
+		{Path: "/new", Repo: "https://github.com/example/new", ImportDepth: &depth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Host != "example.com" || !c.Export || len(c.Paths) != 1 || c.Paths[0].Path != "/new" || *c.Paths[0].ImportDepth != 1 {
+		t.Errorf("ReplacePaths =\n%s", out)
+	}
+	if !bytes.HasPrefix(out, []byte("host: example.com\npaths:")) {
+		t.Errorf("ReplacePaths reordered the settings:\n%s", out)
+	}
+
+	if out, err = ReplacePaths(config, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = ParseConfig(out); err != nil || len(c.Paths) != 0 {
+		t.Errorf("ReplacePaths with no paths = %s, %v", out, err)
+	}
+}
+
+func TestReplacePathsEnv(t *testing.T) {
+	os.Setenv("VANITY_TEST_TOKEN", "s3cret")
+	defer os.Unsetenv("VANITY_TEST_TOKEN")
+	config := []byte("paths:\n" +
+		"  /private:\n" +
+		"    repo: https://${VANITY_TEST_TOKEN}@git.example.com/private\n" +
+		"    vcs: git\n" +
+		"  /moved:\n" +
+		"    repo: https://${VANITY_TEST_TOKEN}@git.example.com/moved\n" +
+		"    vcs: git\n")
+	c, err := ParseConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The API saves the expanded paths, with one of them changed.
+	paths := c.Paths
+	for i := range paths {
+		if paths[i].Path == "/moved" {
+			paths[i].Repo = "https://git.example.com/public/moved"
+		}
+	}
+	paths = append(paths, PathConfig{Path: "/new", Repo: "https://github.com/example/new"})
+	out, err := ReplacePaths(config, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte("repo: https://${VANITY_TEST_TOKEN}@git.example.com/private\n")) || bytes.Contains(out, []byte("s3cret")) {
+		t.Errorf("ReplacePaths did not keep the reference:\n%s", out)
+	}
+	if !bytes.Contains(out, []byte("repo: https://git.example.com/public/moved\n")) {
+		t.Errorf("ReplacePaths did not save the changed path:\n%s", out)
+	}
+}
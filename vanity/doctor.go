@@ -0,0 +1,165 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// ModuleCheck is the result of comparing a path's import path with the
+// module path declared in its repository's go.mod file.
+type ModuleCheck struct {
+	ImportPath string
+	Module     string // as declared in go.mod
+	Err        error  // could not fetch or parse go.mod
+	Skipped    bool   // go.mod location unknown for this repository
+}
+
+// OK reports whether the check passed or was skipped.
+func (c ModuleCheck) OK() bool {
+	return c.Err == nil && (c.Skipped || c.matches())
+}
+
+// matches reports whether the declared module is the import path or a
+// major version of it, e.g. example.com/tools/v2 for example.com/tools.
+func (c ModuleCheck) matches() bool {
+	if c.Module == c.ImportPath {
+		return true
+	}
+	prefix, major, ok := module.SplitPathVersion(c.Module)
+	return ok && major != "" && prefix == c.ImportPath
+}
+
+func (c ModuleCheck) String() string {
+	switch {
+	case c.Skipped:
+		return c.ImportPath + ": skipped (cannot locate go.mod for this repository)"
+	case c.Err != nil:
+		return fmt.Sprintf("%s: %v", c.ImportPath, c.Err)
+	case !c.matches():
+		return fmt.Sprintf("%s: go.mod declares module %s", c.ImportPath, c.Module)
+	}
+	return c.ImportPath + ": ok"
+}
+
+// CheckModulePaths fetches the go.mod file of every configured repository
+// and reports whether it declares the module path being served.  Mismatches
+// make the go command fail with "unexpected module path".  The configuration
+// must set the host if it has paths outside of hosts.  Each fetch is
+// bounded by the configured upstream_timeout and all of them by ctx.
+func (h *Handler) CheckModulePaths(ctx context.Context, client *http.Client) ([]ModuleCheck, error) {
+	var checks []ModuleCheck
+	for _, hh := range h.handlers() {
+		paths := hh.pathSet()
+		if len(paths) == 0 {
+			continue
+		}
+		if hh.host == "" {
+			return nil, errors.New("configuration must set host")
+		}
+		// Aliases are not expected to match.
+		for _, pc := range paths.configured() {
+			if pc.retired {
+				continue
+			}
+			c := ModuleCheck{ImportPath: hh.host + hh.prefix + pc.path}
+			u := goModURL(pc.repo, pc.subdir)
+			if u == "" {
+				c.Skipped = true
+			} else {
+				c.Module, c.Err = h.fetchModulePath(ctx, client, u)
+			}
+			checks = append(checks, c)
+		}
+	}
+	return checks, nil
+}
+
+// goModURL returns the URL of the go.mod file in subdir, or at the root if
+// it is empty, of repo's default branch, or the empty string if the code
+// hosting service is not known.
+func goModURL(repo, subdir string) string {
+	repo = strings.TrimSuffix(repo, ".git")
+	file := "go.mod"
+	if subdir != "" {
+		file = subdir + "/go.mod"
+	}
+	switch {
+	case strings.HasPrefix(repo, "https://github.com/"):
+		return "https://raw.githubusercontent.com/" + strings.TrimPrefix(repo, "https://github.com/") + "/HEAD/" + file
+	case strings.HasPrefix(repo, "https://gitlab.com/"):
+		return repo + "/-/raw/HEAD/" + file
+	case strings.HasPrefix(repo, "https://bitbucket.org/"):
+		return repo + "/raw/HEAD/" + file
+	}
+	return ""
+}
+
+func (h *Handler) fetchModulePath(ctx context.Context, client *http.Client, u string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %v", u, err)
+	}
+	mod := modulePath(data)
+	if mod == "" {
+		return "", fmt.Errorf("%s: no module directive", u)
+	}
+	return mod, nil
+}
+
+// modulePath returns the module path declared in the go.mod contents gomod,
+// or the empty string if there is no module directive.
+func modulePath(gomod []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(gomod))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 || f[0] != "module" {
+			continue
+		}
+		if p, err := strconv.Unquote(f[1]); err == nil {
+			return p
+		}
+		return f[1]
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestModulePath(t *testing.T) {
+	tests := []struct {
+		gomod string
+		want  string
+	}{
+		{"module example.com/foo\n", "example.com/foo"},
+		{"// comment\nmodule \"example.com/foo\" // trailing\n\ngo 1.12\n", "example.com/foo"},
+		{"go 1.12\n", ""},
+	}
+	for _, test := range tests {
+		if got := modulePath([]byte(test.gomod)); got != test.want {
+			t.Errorf("modulePath(%q) = %q; want %q", test.gomod, got, test.want)
+		}
+	}
+}
+
+func TestGoModURL(t *testing.T) {
+	tests := []struct {
+		repo   string
+		subdir string
+		want   string
+	}{
+		{"https://github.com/rakyll/portmidi", "", "https://raw.githubusercontent.com/rakyll/portmidi/HEAD/go.mod"},
+		{"https://github.com/rakyll/portmidi.git", "", "https://raw.githubusercontent.com/rakyll/portmidi/HEAD/go.mod"},
+		{"https://github.com/example/tools", "foo", "https://raw.githubusercontent.com/example/tools/HEAD/foo/go.mod"},
+		{"https://gitlab.com/example/foo", "", "https://gitlab.com/example/foo/-/raw/HEAD/go.mod"},
+		{"https://bitbucket.org/zombiezen/gopdf", "", "https://bitbucket.org/zombiezen/gopdf/raw/HEAD/go.mod"},
+		{"https://git.example.com/foo", "", ""},
+	}
+	for _, test := range tests {
+		if got := goModURL(test.repo, test.subdir); got != test.want {
+			t.Errorf("goModURL(%q, %q) = %q; want %q", test.repo, test.subdir, got, test.want)
+		}
+	}
+}
+
+func TestModuleCheck(t *testing.T) {
+	tests := []struct {
+		check ModuleCheck
+		ok    bool
+		want  string
+	}{
+		{ModuleCheck{ImportPath: "example.com/tools", Module: "example.com/tools"}, true, "example.com/tools: ok"},
+		{ModuleCheck{ImportPath: "example.com/tools", Module: "example.com/tools/v2"}, true, "example.com/tools: ok"},
+		{ModuleCheck{ImportPath: "example.com/tools", Module: "example.com/tools/v1"}, false, "example.com/tools: go.mod declares module example.com/tools/v1"},
+		{ModuleCheck{ImportPath: "example.com/tools", Module: "example.com/tools/lib"}, false, "example.com/tools: go.mod declares module example.com/tools/lib"},
+		{ModuleCheck{ImportPath: "example.com/tools", Module: "github.com/example/tools/v2"}, false, "example.com/tools: go.mod declares module github.com/example/tools/v2"},
+		{ModuleCheck{ImportPath: "example.com/tools", Skipped: true}, true, "example.com/tools: skipped (cannot locate go.mod for this repository)"},
+		{ModuleCheck{ImportPath: "example.com/tools", Err: errors.New("404 Not Found")}, false, "example.com/tools: 404 Not Found"},
+	}
+	for _, test := range tests {
+		if got := test.check.OK(); got != test.ok {
+			t.Errorf("%+v: OK() = %v; want %v", test.check, got, test.ok)
+		}
+		if got := test.check.String(); got != test.want {
+			t.Errorf("%+v: String() = %q; want %q", test.check, got, test.want)
+		}
+	}
+}
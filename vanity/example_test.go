@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity_test
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+)
+
+// Vanity URLs can be mounted inside an existing web service.
+func ExampleNewHandler() {
+	h, err := vanity.NewHandler([]byte(`
+host: example.com
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/portmidi/", h)
+	mux.Handle("/portmidi", h)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/portmidi/sub?go-get=1", nil))
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.Contains(line, `name="go-import"`) {
+			fmt.Println(line)
+		}
+	}
+	// Output:
+	// <meta name="go-import" content="example.com/portmidi git https://github.com/rakyll/portmidi">
+}
+
+// The configuration can also be built in Go code.
+func ExampleNew() {
+	h, err := vanity.New(&vanity.Config{
+		Host: "example.com",
+		Paths: []vanity.PathConfig{
+			{Path: "/portmidi", Repo: "https://github.com/rakyll/portmidi"},
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := h.AddPath(vanity.PathConfig{Path: "/tools", Repo: "https://github.com/example/tools"}); err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range h.Paths() {
+		fmt.Println(p.Path, p.Repo)
+	}
+	// Output:
+	// /portmidi https://github.com/rakyll/portmidi
+	// /tools https://github.com/example/tools
+}
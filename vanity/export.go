@@ -0,0 +1,130 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const exportPath = "/api/v1/export"
+
+// exportWait is how long an export request waits for the paths to change
+// before answering with the unchanged paths.
+const exportWait = 50 * time.Second
+
+// pathExport is the body of an export response.
+type pathExport struct {
+	Version string         `json:"version"`
+	Paths   []exportedPath `json:"paths"`
+}
+
+// exportedPath is a path with all defaults filled in, so that a replica
+// serves it exactly as the primary does.
+type exportedPath struct {
+	Path             string   `json:"path"`
+	Repo             string   `json:"repo"`
+	Display          string   `json:"display,omitempty"`
+	NoSource         bool     `json:"no_source,omitempty"`
+	VCS              string   `json:"vcs"`
+	Tool             bool     `json:"tool,omitempty"`
+	ImportDepth      int      `json:"import_depth,omitempty"`
+	MajorSubdirs     bool     `json:"major_subdirs,omitempty"`
+	Subdir           string   `json:"subdir,omitempty"`
+	Redirect         string   `json:"redirect,omitempty"`
+	CacheMaxAge      *int     `json:"cache_max_age,omitempty"`
+	Aliases          []string `json:"aliases,omitempty"`
+	DeprecateAliases bool     `json:"deprecate_aliases,omitempty"`
+	Retired          bool     `json:"retired,omitempty"`
+	RetiredMessage   string   `json:"retired_message,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	Owner            string   `json:"owner,omitempty"`
+}
+
+func (p exportedPath) pathConfig() PathConfig {
+	depth, noSource := p.ImportDepth, p.NoSource
+	return PathConfig{
+		Path:             p.Path,
+		Repo:             p.Repo,
+		Display:          p.Display,
+		NoSource:         &noSource,
+		VCS:              p.VCS,
+		Tool:             p.Tool,
+		ImportDepth:      &depth,
+		MajorSubdirs:     p.MajorSubdirs,
+		Subdir:           p.Subdir,
+		Redirect:         p.Redirect,
+		CacheMaxAge:      p.CacheMaxAge,
+		Aliases:          p.Aliases,
+		DeprecateAliases: p.DeprecateAliases,
+		Retired:          p.Retired,
+		RetiredMessage:   p.RetiredMessage,
+		Description:      p.Description,
+		Tags:             p.Tags,
+		Owner:            p.Owner,
+		AllowInsecure:    strings.HasPrefix(p.Repo, "http://"),
+	}
+}
+
+// serveExport serves the paths currently served as JSON.  If the since
+// parameter names the current version, it waits for them to change first,
+// so that replicas learn about changes as soon as they happen.
+func (h *Handler) serveExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	paths, version, changed := h.watchPaths()
+	if since := r.URL.Query().Get("since"); since == version {
+		timer := time.NewTimer(exportWait)
+		defer timer.Stop()
+		select {
+		case <-changed:
+			paths, version, _ = h.watchPaths()
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	export := pathExport{Version: version, Paths: make([]exportedPath, 0, len(paths))}
+	for _, pc := range paths.configured() {
+		export.Paths = append(export.Paths, exportedPath{
+			Path:             pc.path,
+			Repo:             pc.repo,
+			Display:          pc.display,
+			NoSource:         pc.noSource,
+			VCS:              pc.vcs,
+			Tool:             pc.tool,
+			ImportDepth:      pc.importDepth,
+			MajorSubdirs:     pc.majorSubdirs,
+			Subdir:           pc.subdir,
+			Redirect:         pc.redirect,
+			CacheMaxAge:      pc.cacheMaxAge,
+			Aliases:          pc.aliases,
+			DeprecateAliases: pc.deprecateAliases,
+			Retired:          pc.retired,
+			RetiredMessage:   pc.retiredMessage,
+			Description:      pc.description,
+			Tags:             pc.tags,
+			Owner:            pc.owner,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(export)
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServeExport(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"export: true\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"    tags: [audio]\n" +
+		"    owner: team-sound\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(since string) pathExport {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/export?since="+since, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d; want 200", w.Code)
+		}
+		var e pathExport
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	first := get("")
+	want := exportedPath{
+		Path:    "/portmidi",
+		Repo:    "https://github.com/rakyll/portmidi",
+		Display: "https://github.com/rakyll/portmidi https://github.com/rakyll/portmidi/tree/master{/dir} https://github.com/rakyll/portmidi/blob/master{/dir}/{file}#L{line}",
+		VCS:     "git",
+		Tags:    []string{"audio"},
+		Owner:   "team-sound",
+	}
+	if len(first.Paths) != 1 || !reflect.DeepEqual(first.Paths[0], want) {
+		t.Errorf("paths = %+v; want [%+v]", first.Paths, want)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		h.AddPath(PathConfig{Path: "/foo", Repo: "https://github.com/example/foo"})
+	}()
+	second := get(first.Version)
+	if second.Version == first.Version || len(second.Paths) != 2 {
+		t.Errorf("after waiting for a change: %+v", second)
+	}
+}
+
+func TestServeExportDisabled(t *testing.T) {
+	h, err := NewHandler([]byte("paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/export", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d; want 404", w.Code)
+	}
+}
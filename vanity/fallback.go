@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// newFallback returns the handler for requests that match no path, as
+// configured by c, or nil if c is empty.
+func newFallback(c FallbackConfig, client *http.Client) (http.Handler, error) {
+	switch {
+	case c.Proxy != "" && c.Redirect != "":
+		return nil, errors.New("configuration for fallback: proxy and redirect are mutually exclusive")
+	case c.Proxy != "":
+		u, err := parseAbsURL(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("configuration for fallback: %v", err)
+		}
+		p := httputil.NewSingleHostReverseProxy(u)
+		p.Transport = client.Transport
+		director := p.Director
+		p.Director = func(r *http.Request) {
+			host := r.Host
+			director(r)
+			// Route to the upstream virtual host, but let it know which
+			// host the client asked for.
+			r.Host = u.Host
+			r.Header.Set("X-Forwarded-Host", host)
+		}
+		return p, nil
+	case c.Redirect != "":
+		u, err := parseAbsURL(c.Redirect)
+		if err != nil {
+			return nil, fmt.Errorf("configuration for fallback: %v", err)
+		}
+		base := strings.TrimSuffix(u.String(), "/")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, base+r.URL.RequestURI(), http.StatusFound)
+		}), nil
+	}
+	return nil, nil
+}
+
+func parseAbsURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("%s is not an absolute URL", s)
+	}
+	return u, nil
+}
+
+// notFound serves a request that matched no path.
+func (h *Handler) notFound(w http.ResponseWriter, r *http.Request) {
+	if h.NotFoundHandler != nil {
+		h.NotFoundHandler.ServeHTTP(w, r)
+		return
+	}
+	if h.notFoundTmpl != nil {
+		h.serveErrorPage(w, r, h.notFoundTmpl, http.StatusNotFound, "not_found")
+		return
+	}
+	h.error(w, r, http.StatusNotFound, "not_found")
+}
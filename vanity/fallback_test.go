@@ -0,0 +1,159 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFallback(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Forwarded-Host")+" "+r.URL.RequestURI())
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name     string
+		config   string
+		status   int
+		location string
+		body     string
+	}{
+		{
+			name:   "none",
+			config: "host: example.com\n",
+			status: http.StatusNotFound,
+		},
+		{
+			name: "redirect",
+			config: "fallback:\n" +
+				"  redirect: https://old.example.com/\n",
+			status:   http.StatusFound,
+			location: "https://old.example.com/other?go-get=1",
+		},
+		{
+			name: "proxy",
+			config: "fallback:\n" +
+				"  proxy: " + upstream.URL + "\n",
+			status: http.StatusOK,
+			body:   "vanity.example.com /other?go-get=1",
+		},
+	}
+	for _, test := range tests {
+		h, err := NewHandler([]byte(test.config))
+		if err != nil {
+			t.Errorf("%s: NewHandler: %v", test.name, err)
+			continue
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/other?go-get=1", nil)
+		r.Host = "vanity.example.com"
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d; want %d", test.name, w.Code, test.status)
+		}
+		if got := w.Header().Get("Location"); got != test.location {
+			t.Errorf("%s: Location = %q; want %q", test.name, got, test.location)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s: body = %q; want %q", test.name, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	h, err := New(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/about", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusTeapot)
+	}
+}
+
+func TestNotFoundTemplate(t *testing.T) {
+	f, err := ioutil.TempFile("", "notfound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{{.Code}} {{.Host}}{{.Path}}: {{.Message}}`)
+	f.Close()
+
+	config := []byte("not_found_template: " + f.Name() + "\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n")
+	h, err := NewHandler(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/about", nil)
+	r.Host = "example.com"
+	r.Header.Set("Accept-Language", "de")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusNotFound)
+	}
+	if got, want := w.Body.String(), "404 example.com/about: 404 Seite nicht gefunden"; got != want {
+		t.Errorf("body = %q; want %q", got, want)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type = %q; want text/html", got)
+	}
+
+	h, err = NewHandler(config, WithNotFoundTemplate(template.Must(template.New("").Parse("gone: {{.Path}}"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/about", nil))
+	if got, want := w.Body.String(), "gone: /about"; got != want {
+		t.Errorf("WithNotFoundTemplate: body = %q; want %q", got, want)
+	}
+
+	_, err = NewHandler([]byte("not_found_template: " + f.Name() + ".missing\n"))
+	if err == nil || !strings.Contains(err.Error(), "configuration for not_found_template") {
+		t.Errorf("missing template: err = %v; want configuration error", err)
+	}
+}
+
+func TestBadFallback(t *testing.T) {
+	badConfigs := []string{
+		"fallback:\n" +
+			"  proxy: https://a.example.com\n" +
+			"  redirect: https://b.example.com\n",
+		"fallback:\n" +
+			"  redirect: /relative\n",
+	}
+	for _, config := range badConfigs {
+		if _, err := NewHandler([]byte(config)); err == nil {
+			t.Errorf("expected config to produce an error, but did not:\n%s", config)
+		}
+	}
+}
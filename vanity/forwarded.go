@@ -0,0 +1,141 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies parses the trusted_proxies setting, CIDR blocks or
+// single addresses.
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("configuration for trusted_proxies: invalid address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("configuration for trusted_proxies: %v", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trusted reports whether r comes directly from one of the trusted proxies.
+func (h *Handler) trusted(r *http.Request) bool {
+	return h.trustedIP(net.ParseIP(remoteHost(r)))
+}
+
+// trustedIP reports whether ip is one of the trusted proxies.
+func (h *Handler) trustedIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns the address of the client r comes from, without the
+// port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the address of the client that sent r.  For requests
+// from trusted proxies, it is the last address in X-Forwarded-For that is
+// not one of them, since each proxy appends the address it got the request
+// from and anything before may be made up by the client.
+func (h *Handler) clientIP(r *http.Request) string {
+	client := remoteHost(r)
+	if !h.trusted(r) {
+		return client
+	}
+	var hops []string
+	for _, v := range r.Header["X-Forwarded-For"] {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !h.trustedIP(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// forwarded returns r as the client sent it to the proxy r came through:
+// with the Host from X-Forwarded-Host and the URL scheme from
+// X-Forwarded-Proto.  Unless r comes from a trusted proxy, r is returned
+// unchanged.  If these headers were appended to by a chain of proxies, the
+// first value is the one the client sent.
+func (h *Handler) forwarded(r *http.Request) *http.Request {
+	if len(h.trustedProxies) == 0 || !h.trusted(r) {
+		return r
+	}
+	host := firstValue(r.Header.Get("X-Forwarded-Host"))
+	proto := strings.ToLower(firstValue(r.Header.Get("X-Forwarded-Proto")))
+	if proto != "http" && proto != "https" {
+		proto = ""
+	}
+	if host == "" && proto == "" {
+		return r
+	}
+	fr := *r
+	u := *r.URL
+	fr.URL = &u
+	if host != "" {
+		fr.Host = host
+	}
+	if proto != "" {
+		fr.URL.Scheme = proto
+	}
+	return &fr
+}
+
+// firstValue returns the first of the comma-separated values of a header.
+func firstValue(v string) string {
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
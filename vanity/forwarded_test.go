@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwarded(t *testing.T) {
+	h, err := NewHandler([]byte("trusted_proxies: [10.0.0.0/8, 2001:db8::1]\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"hosts:\n" +
+		"  go.corp-a.com:\n" +
+		"    paths:\n" +
+		"      /tools:\n" +
+		"        repo: https://github.com/corp-a/tools\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote   string
+		xfh      string
+		path     string
+		goImport string
+	}{
+		{"10.1.2.3:4567", "go.example.com", "/portmidi", "go.example.com/portmidi git https://github.com/rakyll/portmidi"},
+		{"10.1.2.3:4567", "go.corp-a.com", "/tools", "go.corp-a.com/tools git https://github.com/corp-a/tools"},
+		{"10.1.2.3:4567", "go.corp-a.com, proxy.internal", "/tools", "go.corp-a.com/tools git https://github.com/corp-a/tools"},
+		{"[2001:db8::1]:4567", "go.corp-a.com", "/tools", "go.corp-a.com/tools git https://github.com/corp-a/tools"},
+		{"10.1.2.3:4567", "", "/portmidi", "vanity.internal/portmidi git https://github.com/rakyll/portmidi"},
+		{"192.0.2.1:4567", "go.example.com", "/portmidi", "vanity.internal/portmidi git https://github.com/rakyll/portmidi"},
+		{"192.0.2.1:4567", "go.corp-a.com", "/tools", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Host = "vanity.internal"
+		r.RemoteAddr = test.remote
+		if test.xfh != "" {
+			r.Header.Set("X-Forwarded-Host", test.xfh)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := findMeta(w.Body.Bytes(), "go-import"); got != test.goImport {
+			t.Errorf("%s from %s: go-import = %q; want %q", test.xfh, test.remote, got, test.goImport)
+		}
+	}
+}
+
+func TestForwardedProto(t *testing.T) {
+	h, err := NewHandler([]byte("trusted_proxies: [10.0.0.0/8]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote string
+		xfp    string
+		scheme string
+	}{
+		{"10.1.2.3:4567", "https", "https"},
+		{"10.1.2.3:4567", "HTTPS, http", "https"},
+		{"10.1.2.3:4567", "gopher", ""},
+		{"192.0.2.1:4567", "https", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		r.Header.Set("X-Forwarded-Proto", test.xfp)
+		if got := h.forwarded(r).URL.Scheme; got != test.scheme {
+			t.Errorf("%s from %s: scheme = %q; want %q", test.xfp, test.remote, got, test.scheme)
+		}
+	}
+}
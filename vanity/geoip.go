@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is where a client address is registered.
+type Location struct {
+	Country string // ISO 3166-1 code
+	ASN     uint   // autonomous system number
+	Org     string // autonomous system organization
+}
+
+// A Locator looks up client addresses, e.g. for AccessLog.
+type Locator interface {
+	Locate(ip net.IP) Location
+}
+
+// MaxMind is a Locator backed by MaxMind databases such as GeoLite2-Country
+// and GeoLite2-ASN.  Lookups are local; no addresses leave the server.
+type MaxMind struct {
+	dbs []*maxminddb.Reader
+}
+
+// OpenMaxMind opens the databases at paths.  Each may provide countries,
+// autonomous systems, or both.
+func OpenMaxMind(paths ...string) (*MaxMind, error) {
+	m := new(MaxMind)
+	for _, path := range paths {
+		db, err := maxminddb.Open(path)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.dbs = append(m.dbs, db)
+	}
+	return m, nil
+}
+
+// mmdbRecord holds the fields of country, city and ASN databases that
+// Locate uses.
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+// Locate implements Locator.  Fields that no database knows are left empty.
+func (m *MaxMind) Locate(ip net.IP) Location {
+	var loc Location
+	for _, db := range m.dbs {
+		var rec mmdbRecord
+		if err := db.Lookup(ip, &rec); err != nil {
+			continue
+		}
+		if loc.Country == "" {
+			loc.Country = rec.Country.ISOCode
+		}
+		if loc.ASN == 0 {
+			loc.ASN, loc.Org = rec.ASN, rec.Org
+		}
+	}
+	return loc
+}
+
+// Close closes the databases.
+func (m *MaxMind) Close() error {
+	var first error
+	for _, db := range m.dbs {
+		if err := db.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const defaultGitHubSyncInterval = 10 * time.Minute
+
+// githubPageSize is the number of repositories to ask for at once, the most
+// the GitHub API returns.
+const githubPageSize = 100
+
+// A GitHubSync serves a path for every repository of a GitHub organization,
+// so that new repositories can be fetched without changing the
+// configuration.  The path of a repository is its name, e.g. /tools for
+// github.com/example/tools.  Paths from the configuration take precedence,
+// and paths are removed again once their repository is gone or loses Topic.
+// Served as the organization's webhook, it also applies repository events
+// as they happen instead of at the next sync.
+type GitHubSync struct {
+	Handler *Handler
+
+	// Reloader, if not nil, supplies the handler instead of Handler.
+	// Reloaded handlers get the paths at the next sync; call Apply to
+	// serve them at once.
+	Reloader *Reloader
+
+	// Org is the organization whose repositories are served.
+	Org string
+
+	// Topic, if set, restricts the paths to repositories with that topic.
+	Topic string
+
+	// Interval is the time between syncs.  Defaults to 10 minutes.
+	Interval time.Duration
+
+	// Token, if set, authenticates GitHub API requests, which is required
+	// for private repositories and raises the rate limit.
+	Token string
+
+	// Secret is the secret of the organization's webhook, which must sign
+	// the events ServeHTTP gets.  If empty, ServeHTTP rejects all of them.
+	Secret string
+
+	// Client is used to call the GitHub API.  If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	// Health, if not nil, gets the outcome of each sync reported as its
+	// "github" component.
+	Health *Health
+
+	// Logger receives changes and errors.  If nil, the standard logger is
+	// used.
+	Logger *log.Logger
+
+	githubAPI string // for tests
+
+	mu    sync.Mutex
+	repos map[string]string     // path to repository URL, as of the last sync
+	h     *Handler              // the paths were last applied to
+	owned map[string]PathConfig // paths added to h
+}
+
+// Run syncs every Interval until ctx is done.
+func (s *GitHubSync) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultGitHubSyncInterval
+	}
+	for {
+		err := s.Sync(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			s.logf("github sync: %v", err)
+		}
+		if s.Health != nil {
+			s.Health.Report("github", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Sync lists the repositories once and updates the paths.  If they cannot
+// be listed, the paths are left as they are.
+func (s *GitHubSync) Sync(ctx context.Context) error {
+	repos, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.repos = repos
+	s.mu.Unlock()
+	return s.Apply(s.handler())
+}
+
+// Apply makes h serve the repositories found by the last sync, e.g. right
+// after h was loaded to replace the handler being synced.  The paths are
+// replaced in one step, so that requests see all of the changes or none.
+// Later syncs update h.
+func (s *GitHubSync) Apply(h *Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h != s.h {
+		s.h, s.owned = h, make(map[string]PathConfig)
+	}
+	want := make(map[string]PathConfig, len(s.repos))
+	for path, repo := range s.repos {
+		want[path] = PathConfig{Path: path, Repo: repo}
+	}
+	return h.syncPaths(want, s.owned, "github sync", s.logf)
+}
+
+// list returns the paths for the repositories of the organization, to
+// their URLs.
+func (s *GitHubSync) list(ctx context.Context) (map[string]string, error) {
+	if s.Org == "" {
+		return nil, errors.New("no organization")
+	}
+	api := s.githubAPI
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	repos := make(map[string]string)
+	for page := 1; ; page++ {
+		u := fmt.Sprintf("%s/orgs/%s/repos?per_page=%d&page=%d", api, url.PathEscape(s.Org), githubPageSize, page)
+		var batch []struct {
+			Name    string   `json:"name"`
+			HTMLURL string   `json:"html_url"`
+			Topics  []string `json:"topics"`
+		}
+		if err := s.get(ctx, u, &batch); err != nil {
+			return nil, err
+		}
+		for _, r := range batch {
+			if s.Topic != "" && !contains(r.Topics, s.Topic) {
+				continue
+			}
+			repos["/"+r.Name] = r.HTMLURL
+		}
+		if len(batch) < githubPageSize {
+			return repos, nil
+		}
+	}
+}
+
+func (s *GitHubSync) get(ctx context.Context, u string, v interface{}) error {
+	h := s.handler()
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub API returned %s for %s", resp.Status, s.Org)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("GitHub API: %v", err)
+	}
+	return nil
+}
+
+func (s *GitHubSync) handler() *Handler {
+	if s.Reloader != nil {
+		return s.Reloader.Handler()
+	}
+	return s.Handler
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *GitHubSync) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
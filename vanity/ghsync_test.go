@@ -0,0 +1,117 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitHubSync(t *testing.T) {
+	repos := []string{"tools", "cli", "lib", "site"}
+	var pages []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orgs/example/repos" || r.Header.Get("Authorization") != "Bearer token" {
+			http.NotFound(w, r)
+			return
+		}
+		pages = append(pages, r.URL.Query().Get("page"))
+		var items []string
+		if r.URL.Query().Get("page") == "1" {
+			for _, name := range repos {
+				topics := `["go"]`
+				if name == "site" {
+					topics = `["web"]`
+				}
+				items = append(items, fmt.Sprintf(`{"name": %q, "html_url": "https://github.com/example/%s", "topics": %s}`, name, name, topics))
+			}
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(items, ","))
+	}))
+	defer api.Close()
+
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /lib:\n" +
+		"    repo: https://github.com/example/lib-v1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &GitHubSync{
+		Handler:   h,
+		Org:       "example",
+		Topic:     "go",
+		Token:     "token",
+		Logger:    log.New(ioutil.Discard, "", 0),
+		githubAPI: api.URL,
+	}
+	before := h.generation
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// All repositories are served at once.
+	if n := h.generation - before; n != 1 {
+		t.Errorf("sync changed the paths %d times; want once", n)
+	}
+	served := func() string {
+		var paths []string
+		for _, p := range h.Paths() {
+			paths = append(paths, p.Path+"="+p.Repo)
+		}
+		return strings.Join(paths, " ")
+	}
+	// The configured /lib is kept, and /site lacks the topic.
+	if got, want := served(), "/cli=https://github.com/example/cli /lib=https://github.com/example/lib-v1 /tools=https://github.com/example/tools"; got != want {
+		t.Errorf("paths after sync = %s; want %s", got, want)
+	}
+
+	repos = []string{"lib", "site"}
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := served(), "/lib=https://github.com/example/lib-v1"; got != want {
+		t.Errorf("paths after removing a repository = %s; want %s", got, want)
+	}
+
+	// A reloaded handler gets the last sync's paths.
+	repos = []string{"tools"}
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h2, err := NewHandler([]byte("host: example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Apply(h2); err != nil {
+		t.Fatal(err)
+	}
+	if len(h2.Paths()) != 1 || h2.Paths()[0].Path != "/tools" {
+		t.Errorf("paths of the reloaded handler = %+v", h2.Paths())
+	}
+
+	s.Token = "wrong"
+	if err := s.Sync(context.Background()); err == nil {
+		t.Error("Sync with a rejected token succeeded")
+	}
+	if len(pages) == 0 || pages[0] != "1" {
+		t.Errorf("pages = %v", pages)
+	}
+}
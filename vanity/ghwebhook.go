@@ -0,0 +1,133 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxWebhookSize bounds the body of webhook events.  GitHub sends at most
+// 25 MB, but repository events are much smaller.
+const maxWebhookSize = 1 << 20
+
+// githubEvent is the part of a repository event that matters for paths.
+type githubEvent struct {
+	Action     string `json:"action"`
+	Repository struct {
+		Name    string   `json:"name"`
+		HTMLURL string   `json:"html_url"`
+		Topics  []string `json:"topics"`
+		Owner   struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Changes struct {
+		Repository struct {
+			Name struct {
+				From string `json:"from"`
+			} `json:"name"`
+		} `json:"repository"`
+	} `json:"changes"`
+}
+
+// ServeHTTP handles the events of the organization's webhook, such as at
+// /webhooks/github.  Repositories that are created, renamed, transferred,
+// deleted or get their topics edited have their paths updated at once.
+// Events must be signed with Secret; others are rejected.
+func (s *GitHubSync) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		http.Error(w, "cannot read event", http.StatusBadRequest)
+		return
+	}
+	if !s.validSignature(body, r.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("X-GitHub-Event") != "repository" {
+		// Such as the ping sent when the webhook is created.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var e githubEvent
+	if err := json.Unmarshal(body, &e); err != nil || e.Repository.Name == "" {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	if err := s.applyEvent(&e); err != nil {
+		s.logf("github webhook: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validSignature reports whether sig, the X-Hub-Signature-256 header, is
+// the HMAC of body with Secret.
+func (s *GitHubSync) validSignature(body []byte, sig string) bool {
+	if s.Secret == "" || !strings.HasPrefix(sig, "sha256=") {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// applyEvent updates the repositories of the last sync with e and serves
+// them.
+func (s *GitHubSync) applyEvent(e *githubEvent) error {
+	repo := &e.Repository
+	path := "/" + repo.Name
+	s.mu.Lock()
+	if s.repos == nil {
+		s.repos = make(map[string]string)
+	}
+	switch e.Action {
+	case "renamed":
+		if from := e.Changes.Repository.Name.From; from != "" {
+			s.logf("github webhook: %s renamed to %s", from, repo.Name)
+			delete(s.repos, "/"+from)
+		}
+		fallthrough
+	case "created", "edited", "transferred":
+		if strings.EqualFold(repo.Owner.Login, s.Org) && (s.Topic == "" || contains(repo.Topics, s.Topic)) {
+			s.repos[path] = repo.HTMLURL
+		} else {
+			delete(s.repos, path)
+		}
+	case "deleted":
+		delete(s.repos, path)
+	default:
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	return s.Apply(s.handler())
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitHubWebhook(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &GitHubSync{
+		Handler: h,
+		Org:     "example",
+		Topic:   "go",
+		Secret:  "s3cret",
+		Logger:  log.New(ioutil.Discard, "", 0),
+	}
+	sign := func(secret, body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	repo := func(action, name, owner, topic string) string {
+		return `{"action": "` + action + `", "repository": {"name": "` + name + `", "html_url": "https://github.com/` + owner + `/` + name +
+			`", "topics": ["` + topic + `"], "owner": {"login": "` + owner + `"}}, "changes": {"repository": {"name": {"from": "tools"}}}}`
+	}
+	tests := []struct {
+		name  string
+		event string
+		body  string
+		sig   string // if not the right one
+		code  int
+		paths string
+	}{
+		{"ping", "ping", `{"zen": "Keep it logically awesome."}`, "", http.StatusNoContent, ""},
+		{"created", "repository", repo("created", "tools", "example", "go"), "", http.StatusNoContent, "/tools=https://github.com/example/tools"},
+		{"bad signature", "repository", repo("created", "lib", "example", "go"), sign("guess", repo("created", "lib", "example", "go")), http.StatusUnauthorized, "/tools=https://github.com/example/tools"},
+		{"unsigned", "repository", repo("created", "lib", "example", "go"), "none", http.StatusUnauthorized, "/tools=https://github.com/example/tools"},
+		{"without topic", "repository", repo("created", "site", "example", "web"), "", http.StatusNoContent, "/tools=https://github.com/example/tools"},
+		{"renamed", "repository", repo("renamed", "cmd", "example", "go"), "", http.StatusNoContent, "/cmd=https://github.com/example/cmd"},
+		{"topic added", "repository", repo("edited", "site", "example", "go"), "", http.StatusNoContent, "/cmd=https://github.com/example/cmd /site=https://github.com/example/site"},
+		{"transferred away", "repository", repo("transferred", "site", "other", "go"), "", http.StatusNoContent, "/cmd=https://github.com/example/cmd"},
+		{"archived", "repository", repo("archived", "cmd", "example", "go"), "", http.StatusNoContent, "/cmd=https://github.com/example/cmd"},
+		{"deleted", "repository", repo("deleted", "cmd", "example", "go"), "", http.StatusNoContent, ""},
+		{"invalid", "repository", `{"action": "created"}`, "", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/webhooks/github", strings.NewReader(test.body))
+		r.Header.Set("X-GitHub-Event", test.event)
+		switch test.sig {
+		case "":
+			r.Header.Set("X-Hub-Signature-256", sign("s3cret", test.body))
+		case "none":
+		default:
+			r.Header.Set("X-Hub-Signature-256", test.sig)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: status = %d; want %d", test.name, w.Code, test.code)
+		}
+		var paths []string
+		for _, p := range h.Paths() {
+			paths = append(paths, p.Path+"="+p.Repo)
+		}
+		if got := strings.Join(paths, " "); got != test.paths {
+			t.Errorf("%s: paths = %s; want %s", test.name, got, test.paths)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultGitLabSyncInterval = 10 * time.Minute
+
+// gitlabPageSize is the number of projects to ask for at once, the most the
+// GitLab API returns.
+const gitlabPageSize = 100
+
+// A GitLabSync serves a path for every project of a GitLab group and its
+// subgroups, so that new projects can be fetched without changing the
+// configuration.  The path of a project is its path within the group, e.g.
+// /tools for gitlab.com/example/tools and /team/lib for
+// gitlab.com/example/team/lib in the group example.  Paths from the
+// configuration take precedence, and paths are removed again once their
+// project is gone, moved out of the group or archived.  Served as the
+// group's webhook, it also applies project events as they happen instead
+// of at the next sync.
+type GitLabSync struct {
+	Handler *Handler
+
+	// Reloader, if not nil, supplies the handler instead of Handler.
+	// Reloaded handlers get the paths at the next sync; call Apply to
+	// serve them at once.
+	Reloader *Reloader
+
+	// URL is the base URL of the GitLab instance.  Defaults to
+	// https://gitlab.com.
+	URL string
+
+	// Group is the full path of the group whose projects are served, e.g.
+	// example or example/go.
+	Group string
+
+	// Interval is the time between syncs.  Defaults to 10 minutes.
+	Interval time.Duration
+
+	// Token, if set, authenticates GitLab API requests, which is required
+	// for private projects.
+	Token string
+
+	// Secret is the secret token of the group's webhook, which events
+	// ServeHTTP gets must carry.  If empty, ServeHTTP rejects all of them.
+	Secret string
+
+	// Client is used to call the GitLab API.  If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	// Health, if not nil, gets the outcome of each sync reported as its
+	// "gitlab" component.
+	Health *Health
+
+	// Logger receives changes and errors.  If nil, the standard logger is
+	// used.
+	Logger *log.Logger
+
+	mu       sync.Mutex
+	projects map[string]string     // path to project URL, as of the last sync
+	h        *Handler              // the paths were last applied to
+	owned    map[string]PathConfig // paths added to h
+}
+
+// Run syncs every Interval until ctx is done.
+func (s *GitLabSync) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultGitLabSyncInterval
+	}
+	for {
+		err := s.Sync(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			s.logf("gitlab sync: %v", err)
+		}
+		if s.Health != nil {
+			s.Health.Report("gitlab", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Sync lists the projects once and updates the paths.  If they cannot be
+// listed, the paths are left as they are.
+func (s *GitLabSync) Sync(ctx context.Context) error {
+	projects, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.projects = projects
+	s.mu.Unlock()
+	return s.Apply(s.handler())
+}
+
+// Apply makes h serve the projects found by the last sync, e.g. right after
+// h was loaded to replace the handler being synced.  The paths are replaced
+// in one step, so that requests see all of the changes or none.  Later
+// syncs update h.
+func (s *GitLabSync) Apply(h *Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h != s.h {
+		s.h, s.owned = h, make(map[string]PathConfig)
+	}
+	want := make(map[string]PathConfig, len(s.projects))
+	for path, repo := range s.projects {
+		// Self-hosted instances may not be configured as providers.
+		want[path] = PathConfig{Path: path, Repo: repo, VCS: "git"}
+	}
+	return h.syncPaths(want, s.owned, "gitlab sync", s.logf)
+}
+
+func (s *GitLabSync) baseURL() string {
+	if s.URL == "" {
+		return "https://gitlab.com"
+	}
+	return strings.TrimSuffix(s.URL, "/")
+}
+
+// pathOf returns the path for the project at the full path pwn, or the empty
+// string if it is not in the group.
+func (s *GitLabSync) pathOf(pwn string) string {
+	rest := strings.TrimPrefix(strings.ToLower(pwn), strings.ToLower(s.Group)+"/")
+	if len(rest) == len(pwn) || rest == "" {
+		return ""
+	}
+	return "/" + pwn[len(pwn)-len(rest):]
+}
+
+// list returns the paths for the projects of the group that are not
+// archived, to their URLs.
+func (s *GitLabSync) list(ctx context.Context) (map[string]string, error) {
+	if s.Group == "" {
+		return nil, errors.New("no group")
+	}
+	projects := make(map[string]string)
+	for page := 1; ; page++ {
+		u := fmt.Sprintf("%s/api/v4/groups/%s/projects?include_subgroups=true&archived=false&per_page=%d&page=%d",
+			s.baseURL(), url.PathEscape(s.Group), gitlabPageSize, page)
+		var batch []struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+			WebURL            string `json:"web_url"`
+		}
+		if err := s.get(ctx, u, &batch); err != nil {
+			return nil, err
+		}
+		for _, p := range batch {
+			if path := s.pathOf(p.PathWithNamespace); path != "" {
+				projects[path] = p.WebURL
+			}
+		}
+		if len(batch) < gitlabPageSize {
+			return projects, nil
+		}
+	}
+}
+
+func (s *GitLabSync) get(ctx context.Context, u string, v interface{}) error {
+	h := s.handler()
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	if s.Token != "" {
+		req.Header.Set("PRIVATE-TOKEN", s.Token)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitLab API returned %s for %s", resp.Status, s.Group)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("GitLab API: %v", err)
+	}
+	return nil
+}
+
+// gitlabEvent is the part of a project event of a group webhook that
+// matters for paths.
+type gitlabEvent struct {
+	EventName            string `json:"event_name"`
+	PathWithNamespace    string `json:"path_with_namespace"`
+	OldPathWithNamespace string `json:"old_path_with_namespace"`
+}
+
+// ServeHTTP handles the events of the group's webhook, such as at
+// /webhooks/gitlab.  Projects that are created, renamed, transferred or
+// deleted have their paths updated at once.  Other project events, such as
+// a project being archived, make the group be listed again.  Events must
+// carry Secret as their token; others are rejected.
+func (s *GitLabSync) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.Header.Get("X-Gitlab-Token")
+	if s.Secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Secret)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		http.Error(w, "cannot read event", http.StatusBadRequest)
+		return
+	}
+	var e gitlabEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(e.EventName, "project_") {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := s.applyEvent(&e); err != nil {
+		s.logf("gitlab webhook: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyEvent updates the projects of the last sync with e and serves them.
+func (s *GitLabSync) applyEvent(e *gitlabEvent) error {
+	base := s.baseURL()
+	s.mu.Lock()
+	if s.projects == nil {
+		s.projects = make(map[string]string)
+	}
+	switch e.EventName {
+	case "project_create", "project_rename", "project_transfer":
+		if old := s.pathOf(e.OldPathWithNamespace); old != "" {
+			delete(s.projects, old)
+		}
+		if path := s.pathOf(e.PathWithNamespace); path != "" {
+			s.projects[path] = base + "/" + e.PathWithNamespace
+		}
+	case "project_destroy":
+		if path := s.pathOf(e.PathWithNamespace); path != "" {
+			delete(s.projects, path)
+		}
+	default:
+		// The event does not tell whether the project is archived now.
+		s.mu.Unlock()
+		go func() {
+			if err := s.Sync(context.Background()); err != nil {
+				s.logf("gitlab sync: %v", err)
+			}
+		}()
+		return nil
+	}
+	s.mu.Unlock()
+	return s.Apply(s.handler())
+}
+
+func (s *GitLabSync) handler() *Handler {
+	if s.Reloader != nil {
+		return s.Reloader.Handler()
+	}
+	return s.Handler
+}
+
+func (s *GitLabSync) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitLabSync(t *testing.T) {
+	projects := []string{"example/tools", "example/team/lib"}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.EscapedPath() != "/api/v4/groups/example/projects" || r.Header.Get("PRIVATE-TOKEN") != "token" ||
+			q.Get("include_subgroups") != "true" || q.Get("archived") != "false" {
+			http.NotFound(w, r)
+			return
+		}
+		var items []string
+		if q.Get("page") == "1" {
+			for _, p := range projects {
+				items = append(items, fmt.Sprintf(`{"path_with_namespace": %q, "web_url": "https://gitlab.example.com/%s"}`, p, p))
+			}
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(items, ","))
+	}))
+	defer api.Close()
+
+	h, err := NewHandler([]byte("host: example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &GitLabSync{
+		Handler: h,
+		URL:     api.URL,
+		Group:   "example",
+		Token:   "token",
+		Secret:  "s3cret",
+		Logger:  log.New(ioutil.Discard, "", 0),
+	}
+	before := h.generation
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// All projects are served at once.
+	if n := h.generation - before; n != 1 {
+		t.Errorf("sync changed the paths %d times; want once", n)
+	}
+	served := func() string {
+		var paths []string
+		for _, p := range h.Paths() {
+			paths = append(paths, p.Path+"="+strings.TrimPrefix(p.Repo, "https://gitlab.example.com/"))
+		}
+		return strings.Join(paths, " ")
+	}
+	if got, want := served(), "/team/lib=example/team/lib /tools=example/tools"; got != want {
+		t.Errorf("paths after sync = %s; want %s", got, want)
+	}
+
+	s.URL = "https://gitlab.example.com"
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		code  int
+		paths string
+	}{
+		{"wrong token", "guess", `{"event_name": "project_create", "path_with_namespace": "example/cmd"}`, http.StatusUnauthorized, "/team/lib=example/team/lib /tools=example/tools"},
+		{"created", "s3cret", `{"event_name": "project_create", "path_with_namespace": "example/cmd"}`, http.StatusNoContent, "/cmd=example/cmd /team/lib=example/team/lib /tools=example/tools"},
+		{"other group", "s3cret", `{"event_name": "project_create", "path_with_namespace": "examples/cmd"}`, http.StatusNoContent, "/cmd=example/cmd /team/lib=example/team/lib /tools=example/tools"},
+		{"renamed", "s3cret", `{"event_name": "project_rename", "path_with_namespace": "example/team/libs", "old_path_with_namespace": "example/team/lib"}`, http.StatusNoContent, "/cmd=example/cmd /team/libs=example/team/libs /tools=example/tools"},
+		{"transferred away", "s3cret", `{"event_name": "project_transfer", "path_with_namespace": "other/tools", "old_path_with_namespace": "example/tools"}`, http.StatusNoContent, "/cmd=example/cmd /team/libs=example/team/libs"},
+		{"deleted", "s3cret", `{"event_name": "project_destroy", "path_with_namespace": "example/cmd"}`, http.StatusNoContent, "/team/libs=example/team/libs"},
+		{"push", "s3cret", `{"object_kind": "push"}`, http.StatusNoContent, "/team/libs=example/team/libs"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/webhooks/gitlab", strings.NewReader(test.body))
+		r.Header.Set("X-Gitlab-Token", test.token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: status = %d; want %d", test.name, w.Code, test.code)
+		}
+		if got := served(); got != test.paths {
+			t.Errorf("%s: paths = %s; want %s", test.name, got, test.paths)
+		}
+	}
+
+	// Archiving a project is an update, after which the group is listed
+	// again.
+	s.URL = api.URL
+	projects = projects[1:]
+	r := httptest.NewRequest("POST", "/webhooks/gitlab", strings.NewReader(`{"event_name": "project_update", "path_with_namespace": "example/team/libs"}`))
+	r.Header.Set("X-Gitlab-Token", "s3cret")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	want := "/team/lib=example/team/lib"
+	for deadline := time.Now().Add(5 * time.Second); served() != want && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := served(); got != want {
+		t.Errorf("paths after update = %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,1099 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vanity serves Go vanity import paths: it answers go get requests
+// with meta tags pointing at the repositories hosted elsewhere.
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Handler serves the vanity import paths of a configuration.
+type Handler struct {
+	// DefaultHost returns the host name to use in meta tags if the
+	// configuration does not set one.  If nil, the Host header of the
+	// request is used.
+	DefaultHost func(r *http.Request) string
+
+	// Resolver, if not nil, is consulted for paths that match neither a
+	// configured path nor a path rule.
+	Resolver Resolver
+
+	// Middleware wraps the handling of every request, before paths are
+	// matched.  The first element sees the request first.
+	Middleware []Middleware
+
+	// AfterResolve hooks are called in order for requests that matched a
+	// repository, before the page is rendered.
+	AfterResolve []ResolveHook
+
+	// NotFoundHandler, if not nil, serves requests that match no path, for
+	// example to fall through to the rest of a site.  Otherwise they get a
+	// 404 Not Found response, rendered by the not-found template if one is
+	// configured.
+	NotFoundHandler http.Handler
+
+	// Notifier is configured by the notify section of the configuration,
+	// for the program serving the handler to tell about operational
+	// events, e.g. through Health.
+	Notifier Notifier
+
+	host      string
+	prefix    string // of request and import paths, without trailing slash
+	canonical bool   // redirect browsers asking for other hosts to host
+	defaults  pathDefaults
+	builtin   []Middleware      // from the configuration, inside Middleware
+	headers   map[string]string // added to every response
+	logger    *log.Logger
+	now       func() time.Time
+	client    *http.Client
+	timeout   time.Duration // for each call to an upstream service
+	indexTmpl *template.Template
+	pageTmpl  *template.Template
+	pages     *pageCache // rendered by pageTmpl
+	errorTmpl *template.Template
+	catalogs  catalogs
+	style     template.CSS
+	rules     pathRuleSet
+	hosts     map[string]*Handler // by lowercase host name
+	proxy     *moduleProxy
+	vcs       *vcsProxy
+	sumdb     *sumdbProxy
+	latest    *latestCache
+	releases  *releaseCache
+	tls       TLSConfig // validated, not served
+
+	trustedProxies []*net.IPNet // whose X-Forwarded headers are believed
+
+	notFoundTmpl *template.Template // nil for a plain 404 response
+
+	index IndexConfig // validated
+
+	export bool
+	epoch  int64 // start time, to tell versions of different processes apart
+
+	panics uint64 // accessed atomically
+
+	// paths is read by requests without locking.  Changes are made with
+	// mu held and replace it as a whole.
+	paths atomic.Pointer[servedPaths]
+
+	mu         sync.Mutex
+	generation int           // number of changes to paths
+	changed    chan struct{} // closed on the next change, if not nil
+
+	// synced are the paths that syncs added, which are left out of the
+	// paths saved.
+	synced map[string]bool
+}
+
+// servedPaths are the paths a handler serves, by path and for finding them
+// by request path.  Neither is modified once served.
+type servedPaths struct {
+	set  pathConfigSet // sorted
+	trie *pathTrie
+}
+
+func newServedPaths(pset pathConfigSet) *servedPaths {
+	return &servedPaths{set: pset, trie: newPathTrie(pset)}
+}
+
+type pathConfig struct {
+	path    string
+	repo    string
+	display string
+	vcs     string
+	tool    bool
+
+	// redirect is where to send browsers instead, as in
+	// PathConfig.Redirect.
+	redirect string
+
+	// noSource omits the go-source meta tag, as in PathConfig.NoSource.
+	noSource bool
+
+	// cacheMaxAge is the max-age of the Cache-Control header of the page,
+	// in seconds, or nil to send none.
+	cacheMaxAge *int
+
+	// importDepth is the number of leading elements of path to advertise
+	// as the import prefix.  If zero, or not fewer than the elements in
+	// path, the path itself is used.
+	importDepth int
+
+	// majorSubdirs indicates that the repository keeps major versions v2 and
+	// above in vN subdirectories.
+	majorSubdirs bool
+
+	// subdir is the directory of the module within repo, or "".
+	subdir string
+
+	// aliases are the further paths serving the repository.  In the
+	// entries for them, aliasOf is the configured path instead.
+	aliases          []string
+	aliasOf          string
+	deprecateAliases bool
+
+	// rootOf is the configured path whose shortened import prefix, see
+	// importDepth, this entry serves, so that the go command finds the
+	// same go-import meta tag there.
+	rootOf string
+
+	// retired paths are answered 410 Gone, with retiredMessage.
+	retired        bool
+	retiredMessage string
+
+	// description, tags and owner describe the module on the index.
+	description string
+	tags        []string
+	owner       string
+
+	// config is the configuration pc was made from, for Paths.
+	config PathConfig
+}
+
+// defaultUpstreamTimeout bounds calls to upstream services unless the
+// configuration sets upstream_timeout.
+const defaultUpstreamTimeout = 10 * time.Second
+
+// NewHandler returns a handler for the YAML configuration config.
+func NewHandler(config []byte, opts ...Option) (*Handler, error) {
+	c, err := ParseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return New(c, opts...)
+}
+
+// New validates c and returns a handler serving it.
+func New(c *Config, opts ...Option) (*Handler, error) {
+	h := &Handler{
+		host:      c.Host,
+		canonical: c.CanonicalRedirect,
+		now:       time.Now,
+		client:    http.DefaultClient,
+		indexTmpl: indexTmpl,
+		pageTmpl:  vanityTmpl,
+		pages:     newPageCache(defaultPageCacheSize),
+		errorTmpl: errorTmpl,
+		timeout:   defaultUpstreamTimeout,
+		export:    c.Export,
+		epoch:     time.Now().UnixNano(),
+	}
+	for _, f := range secretFields(c) {
+		if strings.HasPrefix(*f.value, VaultPrefix) {
+			return nil, fmt.Errorf("configuration for %s: Vault reference was not expanded", f.name)
+		}
+	}
+	if c.Prefix != "" {
+		h.prefix = strings.TrimSuffix(c.Prefix, "/")
+		if !strings.HasPrefix(h.prefix, "/") || strings.ContainsAny(h.prefix, "?# ") {
+			return nil, fmt.Errorf("configuration for prefix: invalid prefix %q", c.Prefix)
+		}
+	}
+	if c.CanonicalRedirect && c.Host == "" {
+		return nil, errors.New("configuration for canonical_redirect: needs host")
+	}
+	if c.UpstreamTimeout < 0 {
+		return nil, errors.New("configuration for upstream_timeout: must not be negative")
+	}
+	if c.UpstreamTimeout > 0 {
+		h.timeout = c.UpstreamTimeout
+	}
+	latestProxy := defaultProxyURL
+	if c.Proxy.Upstream != "" {
+		h.proxy = newModuleProxy(c.Proxy.Upstream, c.Proxy.CacheDir)
+		latestProxy = c.Proxy.Upstream
+	}
+	if c.Proxy.SumDB {
+		h.sumdb = newSumdbProxy(c.Proxy.CacheDir)
+	}
+	if c.Proxy.VCS {
+		if c.Proxy.CacheDir == "" {
+			return nil, errors.New("configuration for proxy: vcs needs a cache_dir to clone into")
+		}
+		h.vcs = newVCSProxy(h, c.Proxy.CacheDir)
+		switch {
+		case c.Proxy.VCSTimeout < 0:
+			return nil, errors.New("configuration for proxy: vcs_timeout must not be negative")
+		case c.Proxy.VCSTimeout > 0:
+			h.vcs.timeout = c.Proxy.VCSTimeout
+		}
+	}
+	if len(c.Headers) > 0 {
+		for name := range c.Headers {
+			if !validHeaderName(name) {
+				return nil, fmt.Errorf("configuration for headers: invalid header name %q", name)
+			}
+		}
+		h.headers = c.Headers
+		h.builtin = append(h.builtin, headerMiddleware(c.Headers))
+	}
+	// The clock is set once the options have been applied.
+	rl, err := newRateLimiter(c.RateLimit, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rl != nil {
+		h.builtin = append(h.builtin, h.rateLimitMiddleware(rl))
+	}
+	switch {
+	case c.RequestTimeout < 0:
+		return nil, errors.New("configuration for request_timeout: must not be negative")
+	case c.RequestTimeout > 0:
+		h.builtin = append(h.builtin, h.timeoutMiddleware(c.RequestTimeout))
+	}
+	h.latest = newLatestCache(latestProxy)
+	h.releases = newReleaseCache()
+	var webhook *WebhookResolver
+	if rc := c.Resolver; rc.URL != "" {
+		webhook = &WebhookResolver{URL: rc.URL, Timeout: rc.Timeout}
+		h.Resolver = webhook
+		if rc.CacheTTL > 0 || rc.NegativeTTL > 0 {
+			h.Resolver = NewCachingResolver(webhook, rc.CacheTTL, rc.NegativeTTL, rc.CacheSize)
+		}
+	}
+	if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+		if _, err := NewTLSConfig(c.TLS); err != nil {
+			return nil, err
+		}
+	}
+	h.tls = c.TLS
+	if h.tls.Addr == "" {
+		h.tls.Addr = defaultTLSAddr
+	}
+	if c.Template != "" {
+		t, err := template.ParseFiles(c.Template)
+		if err != nil {
+			return nil, fmt.Errorf("configuration for template: %v", err)
+		}
+		h.pageTmpl = t
+	}
+	if c.NotFoundTemplate != "" {
+		t, err := template.ParseFiles(c.NotFoundTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("configuration for not_found_template: %v", err)
+		}
+		h.notFoundTmpl = t
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.proxy != nil {
+		h.proxy.client, h.proxy.timeout = h.client, h.timeout
+	}
+	if h.sumdb != nil {
+		h.sumdb.client, h.sumdb.timeout = h.client, h.timeout
+	}
+	if rl != nil {
+		rl.now = h.now
+	}
+	h.latest.client, h.latest.now, h.latest.timeout = h.client, h.now, h.timeout
+	h.releases.client, h.releases.now, h.releases.timeout = h.client, h.now, h.timeout
+	if webhook != nil {
+		webhook.Client = h.client
+	}
+	if h.style, err = newThemeStyle(c.Theme); err != nil {
+		return nil, err
+	}
+	if h.catalogs, err = newCatalogs(c.Locale, c.Messages); err != nil {
+		return nil, err
+	}
+	if h.NotFoundHandler, err = newFallback(c.Fallback, h.client); err != nil {
+		return nil, err
+	}
+	if h.Notifier, err = newNotifier(c.Notify, h.client); err != nil {
+		return nil, err
+	}
+	if h.trustedProxies, err = parseTrustedProxies(c.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if err := c.Index.validate(); err != nil {
+		return nil, err
+	}
+	h.index = c.Index
+	h.defaults.importDepth, h.defaults.branch, h.defaults.redirect = c.ImportDepth, c.Branch, c.Redirect
+	h.defaults.noSource = c.NoSource
+	if c.CacheMaxAge != nil && *c.CacheMaxAge < 0 {
+		return nil, errors.New("configuration for cache_max_age: must not be negative")
+	}
+	h.defaults.cacheMaxAge = c.CacheMaxAge
+	if h.defaults.providers, err = newProviders(c.Providers); err != nil {
+		return nil, err
+	}
+	pset, err := newPathConfigSet(c.Paths, h.defaults)
+	if err != nil {
+		return nil, err
+	}
+	h.paths.Store(newServedPaths(pset))
+	for _, r := range c.PathRules {
+		pr, err := newPathRule(r, h.defaults)
+		if err != nil {
+			return nil, err
+		}
+		h.rules = append(h.rules, pr)
+	}
+	sort.Sort(h.rules)
+	for _, hc := range c.Hosts {
+		host := strings.ToLower(hc.Host)
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			return nil, fmt.Errorf("configuration for hosts: invalid host %q", hc.Host)
+		}
+		if h.hosts[host] != nil {
+			return nil, fmt.Errorf("configuration for hosts: duplicate host %s", host)
+		}
+		// The other hosts share everything but their paths, and the
+		// proxies, export and TLS settings of this handler.
+		sub := *c
+		sub.Host, sub.Paths, sub.PathRules, sub.Hosts = host, hc.Paths, hc.PathRules, nil
+		sub.Proxy, sub.Export, sub.TLS = ProxyConfig{}, false, TLSConfig{}
+		hh, err := New(&sub, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %v", host, err)
+		}
+		if h.hosts == nil {
+			h.hosts = make(map[string]*Handler)
+		}
+		h.hosts[host] = hh
+	}
+	h.prerender()
+	return h, nil
+}
+
+// redirectCanonical permanently redirects r to the canonical host if it is
+// a request of a browser for another host, reporting whether it did.
+func (h *Handler) redirectCanonical(w http.ResponseWriter, r *http.Request) bool {
+	if !h.canonical || strings.EqualFold(requestHost(r), h.host) || r.URL.Query().Get("go-get") == "1" {
+		return false
+	}
+	scheme := "http"
+	if r.TLS != nil || r.URL.Scheme == "https" {
+		scheme = "https"
+	}
+	http.Redirect(w, r, scheme+"://"+h.host+h.prefix+r.URL.RequestURI(), http.StatusMovedPermanently)
+	return true
+}
+
+// proxies reports whether h serves the module proxy protocol.
+func (h *Handler) proxies() bool {
+	return h.proxy != nil || h.vcs != nil
+}
+
+// handlers returns h followed by the handlers of its other hosts, sorted by
+// host.
+func (h *Handler) handlers() []*Handler {
+	hosts := make([]string, 0, len(h.hosts))
+	for host := range h.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	handlers := []*Handler{h}
+	for _, host := range hosts {
+		handlers = append(handlers, h.hosts[host])
+	}
+	return handlers
+}
+
+// Hosts returns the host names configured for meta tags: Host, unless it
+// is empty, followed by the other hosts.
+func (h *Handler) Hosts() []string {
+	var hosts []string
+	for _, hh := range h.handlers() {
+		if hh.host != "" {
+			hosts = append(hosts, hh.host)
+		}
+	}
+	return hosts
+}
+
+// requestHost returns the lowercase host name r is for, without a port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// pathDefaults holds the settings that paths inherit from the configuration.
+type pathDefaults struct {
+	importDepth int
+	branch      string     // to link to, if not the provider's default
+	noSource    bool       // omit the go-source meta tag
+	redirect    string     // for browsers
+	cacheMaxAge *int       // in seconds, if pages may be cached
+	providers   []provider // longest base first
+}
+
+// newPathConfig validates the configuration e and fills in the display and
+// VCS if they can be inferred from the repository URL.
+func newPathConfig(e PathConfig, d pathDefaults) (pathConfig, error) {
+	path := e.Path
+	pc := pathConfig{
+		path:    strings.TrimSuffix(path, "/"),
+		repo:    e.Repo,
+		display: e.Display,
+		vcs:     e.VCS,
+		tool:    e.Tool,
+
+		redirect:    e.Redirect,
+		cacheMaxAge: e.CacheMaxAge,
+
+		importDepth:  d.importDepth,
+		majorSubdirs: e.MajorSubdirs,
+		subdir:       e.Subdir,
+
+		deprecateAliases: e.DeprecateAliases,
+
+		description: e.Description,
+		tags:        e.Tags,
+		owner:       e.Owner,
+
+		config: e,
+	}
+	if pc.redirect == "" {
+		pc.redirect = d.redirect
+	}
+	if pc.cacheMaxAge == nil {
+		pc.cacheMaxAge = d.cacheMaxAge
+	}
+	if pc.cacheMaxAge != nil && *pc.cacheMaxAge < 0 {
+		return pathConfig{}, fmt.Errorf("configuration for %v: negative cache_max_age", path)
+	}
+	pc.noSource = d.noSource
+	if e.NoSource != nil {
+		pc.noSource = *e.NoSource
+	}
+	if e.ImportDepth != nil {
+		pc.importDepth = *e.ImportDepth
+	}
+	if pc.importDepth < 0 {
+		return pathConfig{}, fmt.Errorf("configuration for %v: negative import_depth", path)
+	}
+	// The global depth applies to paths of every depth, but a path's own
+	// has to fit it.  Regular expressions have no depth to compare with.
+	if e.ImportDepth != nil && *e.ImportDepth > strings.Count(pc.path, "/") && !strings.HasPrefix(path, "^") {
+		return pathConfig{}, fmt.Errorf("configuration for %v: import_depth %d is deeper than the path", path, *e.ImportDepth)
+	}
+	if pc.importDepth >= strings.Count(pc.path, "/") && !strings.HasPrefix(path, "^") {
+		pc.importDepth = 0
+	}
+	for _, tag := range e.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return pathConfig{}, fmt.Errorf("configuration for %v: empty tag", path)
+		}
+	}
+	for _, alias := range e.Aliases {
+		alias = strings.TrimSuffix(alias, "/")
+		if !strings.HasPrefix(alias, "/") || alias == pc.path {
+			return pathConfig{}, fmt.Errorf("configuration for %v: invalid alias %q", path, alias)
+		}
+		pc.aliases = append(pc.aliases, alias)
+	}
+	if e.Retired {
+		// Nothing is served but the tombstone, so the repository may be
+		// gone already.
+		pc.retired, pc.retiredMessage = true, e.RetiredMessage
+		return pc, nil
+	}
+	if strings.HasPrefix(e.Repo, "http://") && !e.AllowInsecure {
+		// Like GOINSECURE, fetching over plain HTTP has to be asked for.
+		return pathConfig{}, fmt.Errorf("configuration for %v: insecure repository URL %s (set allow_insecure to permit it)", path, e.Repo)
+	}
+	branch := e.Branch
+	if branch == "" {
+		branch = d.branch
+	}
+	if strings.ContainsAny(branch, " \t\r\n") {
+		return pathConfig{}, fmt.Errorf("configuration for %v: invalid branch %q", path, branch)
+	}
+	if err := checkRedirect(pc.redirect, e.Repo); err != nil {
+		return pathConfig{}, fmt.Errorf("configuration for %v: %v", path, err)
+	}
+	if e.Subdir != "" && !validSubdir(e.Subdir) {
+		return pathConfig{}, fmt.Errorf("configuration for %v: invalid subdir %q", path, e.Subdir)
+	}
+	p := providerOf(d.providers, e.Repo)
+	if e.Display == "" && p != nil {
+		pc.display = p.display(e.Repo, branch)
+		if pc.subdir != "" {
+			pc.display = subdirDisplay(pc.display, pc.subdir)
+		}
+	}
+	switch {
+	case e.VCS == "mod":
+		// Repo is a module proxy, which the go command asks for the
+		// module instead of a repository.  It has no sources to link to
+		// unless display says otherwise.
+		if e.Subdir != "" {
+			return pathConfig{}, fmt.Errorf("configuration for %v: subdir needs a repository, not a module proxy", path)
+		}
+		if e.Display == "" {
+			pc.display, pc.noSource = "", true
+		}
+	case e.VCS != "":
+		// Already filled in.
+		if !validVCS(e.VCS) {
+			return pathConfig{}, fmt.Errorf("configuration for %v: unknown VCS %s", path, e.VCS)
+		}
+	case p != nil && p.vcs != "":
+		pc.vcs = p.vcs
+	default:
+		return pathConfig{}, fmt.Errorf("configuration for %v: cannot infer VCS from %s", path, e.Repo)
+	}
+	return pc, nil
+}
+
+// validVCS reports whether cmd/go knows how to fetch from vcs.
+func validVCS(vcs string) bool {
+	switch vcs {
+	case "bzr", "fossil", "git", "hg", "svn":
+		return true
+	}
+	return false
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = h.forwarded(r)
+	rw := &recoverWriter{ResponseWriter: w}
+	defer h.recover(rw, r)
+	if len(h.Middleware) == 0 && len(h.builtin) == 0 {
+		h.servePrefixed(rw, r)
+		return
+	}
+	wrap(wrap(http.HandlerFunc(h.servePrefixed), h.builtin), h.Middleware).ServeHTTP(rw, r)
+}
+
+// unprefixed returns path without the configured prefix, reporting whether
+// it was under the prefix at all.
+func (h *Handler) unprefixed(path string) (string, bool) {
+	if h.prefix == "" {
+		return path, true
+	}
+	rest := strings.TrimPrefix(path, h.prefix)
+	switch {
+	case len(rest) == len(path):
+		return "", false
+	case rest == "":
+		return "/", true
+	case rest[0] != '/':
+		return "", false
+	}
+	return rest, true
+}
+
+// servePrefixed serves r with the prefix removed from its path.  Requests
+// outside the prefix are not found.
+func (h *Handler) servePrefixed(w http.ResponseWriter, r *http.Request) {
+	if h.prefix == "" {
+		h.serve(w, r)
+		return
+	}
+	path, ok := h.unprefixed(r.URL.Path)
+	if !ok {
+		h.notFound(w, r)
+		return
+	}
+	sr := *r
+	u := *r.URL
+	u.Path, u.RawPath = path, ""
+	sr.URL = &u
+	h.serve(w, &sr)
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) {
+	current := r.URL.Path
+	if h.sumdb != nil && strings.HasPrefix(current, h.sumdb.prefix()) {
+		h.sumdb.ServeHTTP(w, r)
+		return
+	}
+	if h.proxies() && isProxyRequest(current) {
+		switch {
+		case h.vcs != nil && h.vcs.serve(w, r):
+		case h.proxy != nil:
+			h.proxy.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+	if hh := h.hosts[requestHost(r)]; hh != nil {
+		hh.serve(w, r)
+		return
+	}
+	if h.redirectCanonical(w, r) {
+		return
+	}
+	if isLatestRequest(current) {
+		h.serveLatest(w, r)
+		return
+	}
+	if isBadgeRequest(current) {
+		h.serveBadge(w, r)
+		return
+	}
+	if h.export && current == exportPath {
+		h.serveExport(w, r)
+		return
+	}
+	if current == listPath {
+		h.serveList(w, r)
+		return
+	}
+	pc, subpath := h.findPath(current)
+	if pc == nil {
+		pc, subpath = h.rules.find(current)
+	}
+	if pc == nil && current == "/" {
+		h.serveIndex(w, r)
+		return
+	}
+	if pc == nil && current == searchPath {
+		h.serveSearch(w, r)
+		return
+	}
+	if pc == nil && h.Resolver != nil {
+		var err error
+		pc, subpath, err = h.resolve(r.Context(), current)
+		if err != nil {
+			h.logf("resolving %s: %v", current, err)
+			h.error(w, r, http.StatusBadGateway, "cannot_resolve")
+			return
+		}
+	}
+	if pc == nil {
+		h.notFound(w, r)
+		return
+	}
+	if pc = h.afterResolve(w, r, pc); pc == nil {
+		return
+	}
+	setCacheControl(w, pc.cacheMaxAge)
+	if pc.retired {
+		h.serveRetired(w, r, pc)
+		return
+	}
+	goGet := r.URL.Query().Get("go-get") == "1"
+	if !goGet {
+		if u := pc.redirectURL(h.Host(r) + strings.TrimSuffix(current, "/")); u != "" {
+			http.Redirect(w, r, u, http.StatusFound)
+			return
+		}
+	}
+
+	lang, msgs := h.localize(w, r)
+	data := h.pageData(h.Host(r), lang, msgs, pc, current, subpath)
+	if pc.tool {
+		data.Tool = true
+		data.Install = h.Host(r) + strings.TrimSuffix(current, "/")
+		data.Releases = releasesURL(pc.repo)
+		// The go command only reads the meta tags, so it need not wait
+		// for the code hosting API.
+		if !goGet {
+			data.Release = h.releases.latest(r.Context(), pc.repo)
+		}
+	}
+	if h.proxy != nil && !goGet {
+		data.Majors = h.latest.majors(r.Context(), h.Host(r)+pc.path)
+	}
+	page, err := h.render(data)
+	if err != nil {
+		h.logf("rendering %s: %v", current, err)
+		h.error(w, r, http.StatusInternalServerError, "cannot_render")
+		return
+	}
+	w.Write(page)
+}
+
+// pageKey holds the fields of pageData that a page depends on.  The others
+// follow from the handler and Lang, except for those of tools, whose pages
+// are not cached.
+type pageKey struct {
+	Lang string
+
+	Host    string
+	Path    string // as configured
+	Subpath string // below Path, without the leading slash
+	Subdir  string // of the module in Repo
+	Import  string
+	Repo    string
+	Display string
+	VCS     string
+	Source  string
+
+	Canonical string // import path to use instead, for deprecated aliases
+}
+
+// pageData is what the page template of a path is executed with.
+type pageData struct {
+	pageKey
+
+	Msg   Messages
+	Style template.CSS
+
+	Tool     bool
+	Install  string
+	Releases string
+	Release  *release
+
+	// Majors are the major versions above v1 that the module proxy
+	// knows, for browsers.  Pages with them are not cached either.
+	Majors []string
+}
+
+// pageData returns the data of the page of pc for the request path
+// current, on host and in lang.
+func (h *Handler) pageData(host, lang string, msgs Messages, pc *pathConfig, current, subpath string) pageData {
+	data := pageData{
+		pageKey: pageKey{
+			Lang:    lang,
+			Host:    host,
+			Path:    pc.path,
+			Subpath: subpath,
+			Subdir:  pc.subdir,
+			Import:  host + pc.importPath(),
+			Repo:    pc.repo,
+			Display: pc.display,
+			VCS:     pc.vcs,
+		},
+		Msg:   msgs,
+		Style: h.style,
+	}
+	data.Source = data.Import
+	if pc.aliasOf != "" && pc.deprecateAliases {
+		data.Canonical = host + pc.aliasOf
+		if subpath != "" {
+			data.Canonical += "/" + subpath
+		}
+	}
+	if v := majorVersion(subpath); pc.majorSubdirs && v != "" {
+		// The go-import meta tag still names the repository root, but
+		// sources for the major version live in its subdirectory.
+		data.Source += "/" + v
+		data.Display = subdirDisplay(pc.display, v)
+	}
+	if pc.noSource {
+		data.Source, data.Display = "", ""
+	}
+	return data
+}
+
+// render returns the page for data, from the cache if it was rendered
+// before.
+func (h *Handler) render(data pageData) ([]byte, error) {
+	cached := !data.Tool && data.Majors == nil
+	if cached {
+		if page, ok := h.pages.get(data.pageKey); ok {
+			return page, nil
+		}
+	}
+	var buf bytes.Buffer
+	if err := h.pageTmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	if cached {
+		h.pages.add(data.pageKey, buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// prerender renders the pages of the paths as go get asks for them, in the
+// default locale, so that even the first requests after a load are served
+// from the cache.  Pages for other hosts or locales, subpaths and path
+// rules are rendered on demand.
+func (h *Handler) prerender() {
+	if h.host == "" {
+		return
+	}
+	lang := h.catalogs.def
+	pset := h.pathSet()
+	for i := range pset {
+		pc := &pset[i]
+		if pc.retired || pc.tool {
+			continue
+		}
+		if h.pages.full() {
+			return
+		}
+		if _, err := h.render(h.pageData(h.host+h.prefix, lang, h.catalogs.locales[lang], pc, pc.path, "")); err != nil {
+			// Requests for it will log the error.
+			return
+		}
+	}
+}
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	host := h.Host(r)
+	h.serveIndexOf(w, r, host, h.indexed(host), "")
+}
+
+// serveIndexOf serves the index page listing handlers, the import paths
+// found by the search query if it is not empty, one page at a time.
+func (h *Handler) serveIndexOf(w http.ResponseWriter, r *http.Request, host string, handlers []string, query string) {
+	h.sortByGroup(host, handlers)
+	p, ok := h.paginate(handlers, r.URL.Query())
+	if !ok {
+		h.notFound(w, r)
+		return
+	}
+	p.Search, p.Query = h.prefix+searchPath, query
+	setCacheControl(w, h.defaults.cacheMaxAge)
+	lang, msgs := h.localize(w, r)
+	if h.proxy != nil {
+		p.Majors = h.majors(r.Context(), p.Handlers)
+	}
+	page, err := h.renderIndex(host, lang, msgs, p)
+	if err != nil {
+		h.logf("rendering index: %v", err)
+		h.error(w, r, http.StatusInternalServerError, "cannot_render")
+		return
+	}
+	w.Write(page)
+}
+
+// indexed returns the import paths on host that the index lists.
+func (h *Handler) indexed(host string) []string {
+	var handlers []string
+	for _, pc := range h.pathSet().configured() {
+		if !pc.retired {
+			handlers = append(handlers, host+pc.path)
+		}
+	}
+	return handlers
+}
+
+// majors looks up the major versions above v1 of the import paths, all at
+// once, and returns those that have any by import path.
+func (h *Handler) majors(ctx context.Context, importPaths []string) map[string][]string {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		majors = make(map[string][]string)
+	)
+	for _, p := range importPaths {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			if vs := h.latest.majors(ctx, p); vs != nil {
+				mu.Lock()
+				majors[p] = vs
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	return majors
+}
+
+// An indexMeta describes the module at an import path on the index page.
+type indexMeta struct {
+	Description string
+	Tags        []string
+	Owner       string
+}
+
+// renderIndex returns the index page of host listing the import paths of
+// p, grouped if so configured, with their descriptions and their major
+// versions above v1 if p has them.
+func (h *Handler) renderIndex(host, lang string, msgs Messages, p indexPage) ([]byte, error) {
+	meta := make(map[string]indexMeta)
+	for _, pc := range h.pathSet().configured() {
+		if pc.description != "" || len(pc.tags) > 0 || pc.owner != "" {
+			meta[host+pc.path] = indexMeta{pc.description, pc.tags, pc.owner}
+		}
+	}
+	var buf bytes.Buffer
+	err := h.indexTmpl.Execute(&buf, struct {
+		Lang   string
+		Msg    Messages
+		Style  template.CSS
+		Host   string
+		Meta   map[string]indexMeta
+		Groups []indexGroup
+		indexPage
+	}{
+		Lang:      lang,
+		Msg:       msgs,
+		Style:     h.style,
+		Host:      host,
+		Meta:      meta,
+		Groups:    h.groups(host, p.Handlers, msgs["no_owner"]),
+		indexPage: p,
+	})
+	return buf.Bytes(), err
+}
+
+// serveRetired tells the client that the package at pc is gone for good,
+// without meta tags so that the go command stops looking for it.
+func (h *Handler) serveRetired(w http.ResponseWriter, r *http.Request, pc *pathConfig) {
+	_, msgs := h.localize(w, r)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusGone)
+	fmt.Fprintf(w, "%s%s: %s\n", h.Host(r), pc.path, msgs["retired"])
+	if pc.retiredMessage != "" {
+		fmt.Fprintln(w, pc.retiredMessage)
+	}
+}
+
+// setCacheControl lets a page be cached for maxAge seconds, unless maxAge is
+// nil.
+func setCacheControl(w http.ResponseWriter, maxAge *int) {
+	if maxAge != nil {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", *maxAge))
+	}
+}
+
+// Host returns the host name used in meta tags for r, followed by the
+// prefix if one is configured.
+func (h *Handler) Host(r *http.Request) string {
+	switch {
+	case h.canonical && !strings.EqualFold(requestHost(r), h.host):
+		// A go-get request for an old host.
+		return r.Host + h.prefix
+	case h.host != "":
+		return h.host + h.prefix
+	case h.DefaultHost != nil:
+		return h.DefaultHost(r) + h.prefix
+	}
+	return r.Host + h.prefix
+}
+
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<style>{{.Style}}</style>
+</head>
+<body>
+<h1>{{.Host}}</h1>
+{{with .Search}}<form action="{{.}}"><input type="search" name="q" id="filter" value="{{$.Query}}" placeholder="{{$.Msg.search}}" aria-label="{{$.Msg.search}}"></form>
+{{end}}{{range .Groups}}{{with .Name}}<h2>{{.}}</h2>
+{{end}}<ul class="paths">
+{{range $p := .Handlers}}<li><a href="https://godoc.org/{{.}}">{{.}}</a>{{with index $.Majors .}} ({{range $i, $v := .}}{{if $i}}, {{end}}<a href="https://godoc.org/{{$p}}/{{$v}}">{{$v}}</a>{{end}}){{end}}{{with index $.Meta .}}{{with .Description}} &ndash; {{.}}{{end}}{{with .Tags}} [{{range $i, $t := .}}{{if $i}}, {{end}}{{$t}}{{end}}]{{end}}{{with .Owner}} ({{$.Msg.owner}} {{.}}){{end}}{{end}}</li>{{end}}
+</ul>
+{{end}}{{if gt .Pages 1}}<nav>{{with .Prev}}<a href="{{.}}" rel="prev">{{$.Msg.previous_page}}</a> {{end}}{{.Page}}/{{.Pages}}{{with .Next}} <a href="{{.}}" rel="next">{{$.Msg.next_page}}</a>{{end}}</nav>
+{{end}}{{if .Search}}<script>
+var filter = document.getElementById("filter");
+filter.addEventListener("input", function() {
+  var terms = filter.value.toLowerCase().split(/\s+/).filter(Boolean);
+  document.querySelectorAll(".paths li").forEach(function(li) {
+    var text = li.textContent.toLowerCase();
+    li.hidden = !terms.every(function(t) { return text.indexOf(t) >= 0; });
+  });
+});
+</script>
+{{end}}</body>
+</html>
+`))
+
+var vanityTmpl = template.Must(template.New("vanity").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<meta name="go-import" content="{{.Import}} {{.VCS}} {{.Repo}}{{with .Subdir}} {{.}}{{end}}">
+{{with .Source}}<meta name="go-source" content="{{.}} {{$.Display}}">
+{{end}}{{if not (or .Tool .Canonical)}}<meta http-equiv="refresh" content="0; url=https://godoc.org/{{.Import}}">
+{{end}}<style>{{.Style}}</style>
+</head>
+<body>
+{{with .Canonical}}<p>{{$.Msg.deprecated_alias}} <a href="https://godoc.org/{{.}}">{{.}}</a></p>
+{{end}}{{if .Tool}}<h1>{{.Install}}</h1>
+<p>{{.Msg.install_with}}</p>
+<pre>go install {{.Install}}@latest</pre>
+{{with .Release}}<h2>{{$.Msg.latest_release}} <a href="{{.URL}}">{{.Name}}</a></h2>
+{{with .Assets}}<ul>
+{{range .}}<li><a href="{{.URL}}">{{.Name}}</a></li>
+{{end}}</ul>
+{{end}}{{end}}{{if .Releases}}<p><a href="{{.Releases}}">{{.Msg.all_releases}}</a></p>
+{{end}}<p><a href="https://godoc.org/{{.Import}}">{{.Msg.see_godoc}}</a>.</p>
+{{else}}{{.Msg.nothing_here}} <a href="https://godoc.org/{{.Import}}">{{.Msg.see_godoc_link}}</a>.
+{{end}}{{with .Majors}}<p>{{$.Msg.major_versions}} {{range $i, $v := .}}{{if $i}}, {{end}}<a href="https://godoc.org/{{$.Host}}{{$.Path}}/{{$v}}">{{$v}}</a>{{end}}</p>
+{{end}}</body>
+</html>`))
+
+// importPath returns the import path prefix to advertise in meta tags: the
+// first importDepth elements of the matched path, or all of them.  Deeper
+// prefixes would claim that a subdirectory is the root of the repository.
+func (pc *pathConfig) importPath() string {
+	elems := strings.Split(strings.Trim(pc.path, "/"), "/")
+	n := pc.importDepth
+	if n == 0 || n >= len(elems) {
+		return pc.path
+	}
+	// A major version suffix is part of the module path, not of the
+	// repository root, which is what the go-import meta tag names.
+	if n > 1 && majorVersion(elems[n-1]) != "" {
+		n--
+	}
+	return "/" + strings.Join(elems[:n], "/")
+}
+
+// majorVersion returns the major version suffix, such as "v2", that subpath
+// starts with, or the empty string if there is none.
+func majorVersion(subpath string) string {
+	v := subpath
+	if i := strings.IndexByte(v, '/'); i >= 0 {
+		v = v[:i]
+	}
+	if len(v) < 2 || v[0] != 'v' || v[1] == '0' || v == "v1" {
+		return ""
+	}
+	for _, c := range v[1:] {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return v
+}
+
+// subdirDisplay roots the directory and file templates of display in dir.
+func subdirDisplay(display, dir string) string {
+	return strings.Replace(display, "{/dir}", "/"+dir+"{/dir}", -1)
+}
+
+// validSubdir reports whether dir is a clean relative directory within a
+// repository.
+func validSubdir(dir string) bool {
+	return dir == path.Clean(dir) && !path.IsAbs(dir) && dir != "." && dir != ".." && !strings.HasPrefix(dir, "../")
+}
+
+type pathConfigSet []pathConfig
+
+func (pset pathConfigSet) Len() int {
+	return len(pset)
+}
+
+func (pset pathConfigSet) Less(i, j int) bool {
+	return pset[i].path < pset[j].path
+}
+
+func (pset pathConfigSet) Swap(i, j int) {
+	pset[i], pset[j] = pset[j], pset[i]
+}
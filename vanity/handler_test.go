@@ -0,0 +1,777 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		path   string
+
+		goImport string
+		goSource string
+	}{
+		{
+			name: "explicit display",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n",
+			path:     "/portmidi",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "display GitHub inference",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n",
+			path:     "/portmidi",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi https://github.com/rakyll/portmidi/tree/master{/dir} https://github.com/rakyll/portmidi/blob/master{/dir}/{file}#L{line}",
+		},
+		{
+			name: "GitLab inference",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /tool:\n" +
+				"    repo: https://gitlab.com/group/subgroup/tool\n",
+			path:     "/tool/pkg",
+			goImport: "example.com/tool git https://gitlab.com/group/subgroup/tool",
+			goSource: "example.com/tool https://gitlab.com/group/subgroup/tool https://gitlab.com/group/subgroup/tool/-/tree/master{/dir} https://gitlab.com/group/subgroup/tool/-/blob/master{/dir}/{file}#L{line}",
+		},
+		{
+			name: "GitLab with .git suffix",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /tool:\n" +
+				"    repo: https://gitlab.com/group/tool.git\n",
+			path:     "/tool",
+			goImport: "example.com/tool git https://gitlab.com/group/tool.git",
+			goSource: "example.com/tool https://gitlab.com/group/tool https://gitlab.com/group/tool/-/tree/master{/dir} https://gitlab.com/group/tool/-/blob/master{/dir}/{file}#L{line}",
+		},
+		{
+			name: "Bitbucket Mercurial",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /gopdf:\n" +
+				"    repo: https://bitbucket.org/zombiezen/gopdf\n" +
+				"    vcs: hg\n",
+			path:     "/gopdf",
+			goImport: "example.com/gopdf hg https://bitbucket.org/zombiezen/gopdf",
+			goSource: "example.com/gopdf https://bitbucket.org/zombiezen/gopdf https://bitbucket.org/zombiezen/gopdf/src/default{/dir} https://bitbucket.org/zombiezen/gopdf/src/default{/dir}/{file}#{file}-{line}",
+		},
+		{
+			name: "Bitbucket Git",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /mygit:\n" +
+				"    repo: https://bitbucket.org/zombiezen/mygit\n" +
+				"    vcs: git\n",
+			path:     "/mygit",
+			goImport: "example.com/mygit git https://bitbucket.org/zombiezen/mygit",
+			goSource: "example.com/mygit https://bitbucket.org/zombiezen/mygit https://bitbucket.org/zombiezen/mygit/src/default{/dir} https://bitbucket.org/zombiezen/mygit/src/default{/dir}/{file}#{file}-{line}",
+		},
+		{
+			name: "Subversion",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /legacy:\n" +
+				"    repo: https://svn.example.com/repos/legacy\n" +
+				"    vcs: svn\n",
+			path:     "/legacy",
+			goImport: "example.com/legacy svn https://svn.example.com/repos/legacy",
+			goSource: "example.com/legacy ",
+		},
+		{
+			name: "Fossil",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /fsl:\n" +
+				"    repo: https://fossil.example.com/fsl\n" +
+				"    vcs: fossil\n",
+			path:     "/fsl",
+			goImport: "example.com/fsl fossil https://fossil.example.com/fsl",
+			goSource: "example.com/fsl ",
+		},
+		{
+			name: "subpath",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n",
+			path:     "/portmidi/foo",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "subpath with trailing config slash",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /portmidi/:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n",
+			path:     "/portmidi/foo",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "import depth",
+			config: "host: example.com\n" +
+				"import_depth: 2\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n",
+			path:     "/portmidi/foo/bar",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "import depth at major version",
+			config: "host: example.com\n" +
+				"import_depth: 2\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n",
+			path:     "/portmidi/v3/foo",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "import depth of the first segment",
+			config: "host: example.com\n" +
+				"import_depth: 1\n" +
+				"paths:\n" +
+				"  /x/portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n",
+			path:     "/x/portmidi/foo",
+			goImport: "example.com/x git https://github.com/rakyll/portmidi",
+			goSource: "example.com/x https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "shortened import prefix",
+			config: "host: example.com\n" +
+				"import_depth: 1\n" +
+				"paths:\n" +
+				"  /x/portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n",
+			path:     "/x",
+			goImport: "example.com/x git https://github.com/rakyll/portmidi",
+			goSource: "example.com/x https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "shortened import prefix shared by paths of a repository",
+			config: "host: example.com\n" +
+				"import_depth: 1\n" +
+				"paths:\n" +
+				"  /x/a:\n" +
+				"    repo: https://github.com/example/x\n" +
+				"    display: https://github.com/example/x _ _\n" +
+				"  /x/b:\n" +
+				"    repo: https://github.com/example/x\n" +
+				"    display: https://github.com/example/x _ _\n",
+			path:     "/x",
+			goImport: "example.com/x git https://github.com/example/x",
+			goSource: "example.com/x https://github.com/example/x _ _",
+		},
+		{
+			name: "import depth per-path override",
+			config: "host: example.com\n" +
+				"import_depth: 2\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n" +
+				"    import_depth: 0\n",
+			path:     "/portmidi/foo/bar",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "major version subdirectory",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    major_subdirs: true\n",
+			path:     "/portmidi/v2/foo",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi/v2 https://github.com/rakyll/portmidi https://github.com/rakyll/portmidi/tree/master/v2{/dir} https://github.com/rakyll/portmidi/blob/master/v2{/dir}/{file}#L{line}",
+		},
+		{
+			name: "monorepo subdirectory",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /tools/foo:\n" +
+				"    repo: https://github.com/example/tools\n" +
+				"    subdir: foo\n",
+			path:     "/tools/foo/cmd",
+			goImport: "example.com/tools/foo git https://github.com/example/tools foo",
+			goSource: "example.com/tools/foo https://github.com/example/tools https://github.com/example/tools/tree/master/foo{/dir} https://github.com/example/tools/blob/master/foo{/dir}/{file}#L{line}",
+		},
+		{
+			name: "major version subdirectory unversioned",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n" +
+				"    major_subdirs: true\n",
+			path:     "/portmidi/v1/foo",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi _ _",
+		},
+		{
+			name: "allowed insecure repository",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /lab:\n" +
+				"    repo: http://git.internal.example/lab\n" +
+				"    vcs: git\n" +
+				"    allow_insecure: true\n",
+			path:     "/lab",
+			goImport: "example.com/lab git http://git.internal.example/lab",
+			goSource: "example.com/lab ",
+		},
+		{
+			name: "module proxy",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /internal:\n" +
+				"    repo: https://athens.internal.example\n" +
+				"    vcs: mod\n",
+			path:     "/internal/pkg",
+			goImport: "example.com/internal mod https://athens.internal.example",
+		},
+		{
+			name: "no source",
+			config: "host: example.com\n" +
+				"paths:\n" +
+				"  /private:\n" +
+				"    repo: https://github.com/example/private\n" +
+				"    no_source: true\n",
+			path:     "/private/pkg",
+			goImport: "example.com/private git https://github.com/example/private",
+		},
+		{
+			name: "no source by default",
+			config: "host: example.com\n" +
+				"no_source: true\n" +
+				"paths:\n" +
+				"  /private:\n" +
+				"    repo: https://github.com/example/private\n",
+			path:     "/private",
+			goImport: "example.com/private git https://github.com/example/private",
+		},
+		{
+			name: "source despite default",
+			config: "host: example.com\n" +
+				"no_source: true\n" +
+				"paths:\n" +
+				"  /portmidi:\n" +
+				"    repo: https://github.com/rakyll/portmidi\n" +
+				"    display: https://github.com/rakyll/portmidi _ _\n" +
+				"    no_source: false\n",
+			path:     "/portmidi",
+			goImport: "example.com/portmidi git https://github.com/rakyll/portmidi",
+			goSource: "example.com/portmidi https://github.com/rakyll/portmidi _ _",
+		},
+	}
+	for _, test := range tests {
+		h, err := NewHandler([]byte(test.config))
+		if err != nil {
+			t.Errorf("%s: newHandler: %v", test.name, err)
+			continue
+		}
+		s := httptest.NewServer(h)
+		resp, err := http.Get(s.URL + test.path)
+		if err != nil {
+			s.Close()
+			t.Errorf("%s: http.Get: %v", test.name, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		s.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status code = %s; want 200 OK", test.name, resp.Status)
+		}
+		if err != nil {
+			t.Errorf("%s: ioutil.ReadAll: %v", test.name, err)
+			continue
+		}
+		if got := findMeta(data, "go-import"); got != test.goImport {
+			t.Errorf("%s: meta go-import = %q; want %q", test.name, got, test.goImport)
+		}
+		if got := findMeta(data, "go-source"); got != test.goSource {
+			t.Errorf("%s: meta go-source = %q; want %q", test.name, got, test.goSource)
+		}
+	}
+}
+
+func TestBadConfigs(t *testing.T) {
+	badConfigs := []string{
+		"paths:\n" +
+			"  /missingvcs:\n" +
+			"    repo: https://bitbucket.org/zombiezen/gopdf\n",
+		"paths:\n" +
+			"  /unknownvcs:\n" +
+			"    repo: https://bitbucket.org/zombiezen/gopdf\n" +
+			"    vcs: xyzzy\n",
+		"paths:\n" +
+			"  /subdir:\n" +
+			"    repo: https://athens.internal.example\n" +
+			"    vcs: mod\n" +
+			"    subdir: foo\n",
+		"paths:\n" +
+			"  /insecure:\n" +
+			"    repo: http://git.internal.example/foo\n" +
+			"    vcs: git\n",
+		"hosts:\n" +
+			"  go.corp-a.com:\n" +
+			"    paths:\n" +
+			"      /missingvcs:\n" +
+			"        repo: https://bitbucket.org/zombiezen/gopdf\n",
+		"cache_max_age: -1\n",
+		"paths:\n" +
+			"  /self:\n" +
+			"    repo: https://github.com/example/self\n" +
+			"    aliases: [/self]\n",
+		"paths:\n" +
+			"  /a:\n" +
+			"    repo: https://github.com/example/a\n" +
+			"    aliases: [/b]\n" +
+			"  /b:\n" +
+			"    repo: https://github.com/example/b\n",
+		"paths:\n" +
+			"  /escape:\n" +
+			"    repo: https://github.com/example/tools\n" +
+			"    subdir: ../other\n",
+		"paths:\n" +
+			"  /absolute:\n" +
+			"    repo: https://github.com/example/tools\n" +
+			"    subdir: /foo\n",
+		"paths:\n" +
+			"  /negative:\n" +
+			"    repo: https://github.com/example/negative\n" +
+			"    cache_max_age: -1\n",
+		"paths:\n" +
+			"  /portmidi:\n" +
+			"    repo: https://github.com/rakyll/portmidi\n" +
+			"    import_depth: 2\n",
+		"import_depth: 1\n" +
+			"paths:\n" +
+			"  /x/a:\n" +
+			"    repo: https://github.com/example/a\n" +
+			"  /x/b:\n" +
+			"    repo: https://github.com/example/b\n",
+		"paths:\n" +
+			"  /x:\n" +
+			"    repo: https://github.com/example/x\n" +
+			"  /x/a:\n" +
+			"    repo: https://github.com/example/a\n" +
+			"    import_depth: 1\n",
+		"trusted_proxies: [10.0.0.0/33]\n",
+		"trusted_proxies: [proxy.internal]\n",
+	}
+	for _, config := range badConfigs {
+		_, err := NewHandler([]byte(config))
+		if err == nil {
+			t.Errorf("expected config to produce an error, but did not:\n%s", config)
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"cache_max_age: 86400\n" +
+		"paths:\n" +
+		"  /stable:\n" +
+		"    repo: https://github.com/example/stable\n" +
+		"  /moving:\n" +
+		"    repo: https://github.com/example/moving\n" +
+		"    cache_max_age: 60\n" +
+		"pathrules:\n" +
+		"  /x/{name}:\n" +
+		"    repo: https://github.com/x/{name}\n" +
+		"    cache_max_age: 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "public, max-age=86400"},
+		{"/stable/pkg?go-get=1", "public, max-age=86400"},
+		{"/moving?go-get=1", "public, max-age=60"},
+		{"/x/lib?go-get=1", "public, max-age=0"},
+		{"/missing", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if got := w.Header().Get("Cache-Control"); got != test.want {
+			t.Errorf("%s: Cache-Control = %q; want %q", test.path, got, test.want)
+		}
+	}
+
+	h, err = NewHandler([]byte("paths:\n" +
+		"  /stable:\n" +
+		"    repo: https://github.com/example/stable\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stable?go-get=1", nil))
+	if got := w.Header().Get("Cache-Control"); got != "" {
+		t.Errorf("without cache_max_age: Cache-Control = %q; want none", got)
+	}
+}
+
+func TestRetired(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"redirect: repo\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"  /oldlib:\n" +
+		"    retired: true\n" +
+		"    retired_message: Use example.com/newlib instead.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/oldlib?go-get=1", "/oldlib/sub", "/oldlib"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		body := w.Body.String()
+		if w.Code != http.StatusGone || !strings.Contains(body, "example.com/oldlib: this package has been retired") || !strings.Contains(body, "Use example.com/newlib instead.") {
+			t.Errorf("%s: %d %q; want 410 with the message", path, w.Code, body)
+		}
+		if findMeta(w.Body.Bytes(), "go-import") != "" {
+			t.Errorf("%s: served meta tags", path)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if strings.Contains(w.Body.String(), "oldlib") {
+		t.Errorf("index lists the retired path: %s", w.Body.String())
+	}
+}
+
+func TestIndexMetadata(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"    description: Go bindings for <PortMidi>\n" +
+		"    tags: [audio, midi]\n" +
+		"    owner: team-sound\n" +
+		"  /plain:\n" +
+		"    repo: https://github.com/example/plain\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Language", "de")
+	h.ServeHTTP(w, r)
+	body := w.Body.String()
+	for _, want := range []string{
+		`example.com/portmidi</a> &ndash; Go bindings for &lt;PortMidi&gt; [audio, midi] (verantwortlich: team-sound)</li>`,
+		`example.com/plain</a></li>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("index does not contain %q:\n%s", want, body)
+		}
+	}
+
+	if _, err := NewHandler([]byte("paths:\n  /a:\n    repo: https://github.com/example/a\n    tags: [ok, \" \"]\n")); err == nil || !strings.Contains(err.Error(), "empty tag") {
+		t.Errorf("blank tag: err = %v; want empty tag", err)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	h, err := NewHandler([]byte("host: go.example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"hosts:\n" +
+		"  go.corp-a.com:\n" +
+		"    paths:\n" +
+		"      /tools:\n" +
+		"        repo: https://github.com/corp-a/tools\n" +
+		"  Go.Corp-B.com:\n" +
+		"    pathrules:\n" +
+		"      /{name}:\n" +
+		"        repo: https://github.com/corp-b/{name}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host     string
+		path     string
+		goImport string
+	}{
+		{"go.example.com", "/portmidi", "go.example.com/portmidi git https://github.com/rakyll/portmidi"},
+		{"other.example.com", "/portmidi", "go.example.com/portmidi git https://github.com/rakyll/portmidi"},
+		{"go.corp-a.com", "/tools/cmd/x", "go.corp-a.com/tools git https://github.com/corp-a/tools"},
+		{"go.corp-a.com:8080", "/tools", "go.corp-a.com/tools git https://github.com/corp-a/tools"},
+		{"go.corp-a.com", "/portmidi", ""},
+		{"GO.CORP-B.COM", "/lib", "go.corp-b.com/lib git https://github.com/corp-b/lib"},
+		{"go.example.com", "/tools", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if test.goImport == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s%s: status = %d; want 404", test.host, test.path, w.Code)
+			}
+			continue
+		}
+		if got := findMeta(w.Body.Bytes(), "go-import"); got != test.goImport {
+			t.Errorf("%s%s: go-import = %q; want %q", test.host, test.path, got, test.goImport)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "go.corp-a.com"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if body := w.Body.String(); !bytes.Contains(w.Body.Bytes(), []byte("go.corp-a.com/tools")) || bytes.Contains(w.Body.Bytes(), []byte("portmidi")) {
+		t.Errorf("index of go.corp-a.com lists the wrong paths:\n%s", body)
+	}
+	if got, want := strings.Join(h.Hosts(), " "), "go.example.com go.corp-a.com go.corp-b.com"; got != want {
+		t.Errorf("Hosts() = %s; want %s", got, want)
+	}
+
+	if _, err := New(&Config{Hosts: []HostConfig{{Host: "a.example.com"}, {Host: "A.example.com"}}}); err == nil {
+		t.Error("New with a duplicate host succeeded; want error")
+	}
+}
+
+func TestCanonicalRedirect(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"canonical_redirect: true\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"hosts:\n" +
+		"  go.corp-a.com:\n" +
+		"    paths:\n" +
+		"      /tools:\n" +
+		"        repo: https://github.com/corp-a/tools\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url      string
+		location string
+		goImport string
+	}{
+		{"http://example.com/portmidi?go-get=1", "", "example.com/portmidi git https://github.com/rakyll/portmidi"},
+		{"http://Example.COM/portmidi", "", ""},
+		{"http://www.example.com/portmidi", "http://example.com/portmidi", ""},
+		{"https://old.example.org/portmidi/sub?tab=doc", "https://example.com/portmidi/sub?tab=doc", ""},
+		{"http://old.example.org/portmidi/sub?go-get=1", "", "old.example.org/portmidi git https://github.com/rakyll/portmidi"},
+		{"http://go.corp-a.com/tools", "", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if test.location != "" {
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != test.location {
+				t.Errorf("%s: status = %d, Location = %q; want 301 to %s", test.url, w.Code, w.Header().Get("Location"), test.location)
+			}
+			continue
+		}
+		if w.Code == http.StatusMovedPermanently {
+			t.Errorf("%s: redirected to %s; want no redirect", test.url, w.Header().Get("Location"))
+		}
+		if test.goImport != "" {
+			if got := findMeta(w.Body.Bytes(), "go-import"); got != test.goImport {
+				t.Errorf("%s: go-import = %q; want %q", test.url, got, test.goImport)
+			}
+		}
+	}
+
+	if _, err := New(&Config{CanonicalRedirect: true}); err == nil {
+		t.Error("New with canonical_redirect but no host succeeded; want error")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"prefix: /go/\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		goImport string
+	}{
+		{"/go/portmidi", "example.com/go/portmidi git https://github.com/rakyll/portmidi"},
+		{"/go/portmidi/sub", "example.com/go/portmidi git https://github.com/rakyll/portmidi"},
+		{"/portmidi", ""},
+		{"/gopher/portmidi", ""},
+		{"/go/other", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path+"?go-get=1", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if test.goImport == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s: status = %d; want 404", test.path, w.Code)
+			}
+			continue
+		}
+		if got := findMeta(w.Body.Bytes(), "go-import"); got != test.goImport {
+			t.Errorf("%s: go-import = %q; want %q", test.path, got, test.goImport)
+		}
+	}
+	for _, path := range []string{"/go", "/go/"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if !bytes.Contains(w.Body.Bytes(), []byte("example.com/go/portmidi")) {
+			t.Errorf("%s: index does not list example.com/go/portmidi:\n%s", path, w.Body)
+		}
+	}
+
+	if _, err := New(&Config{Prefix: "go"}); err == nil {
+		t.Error("New with a relative prefix succeeded; want error")
+	}
+}
+
+func findMeta(data []byte, name string) string {
+	var sep []byte
+	sep = append(sep, `<meta name="`...)
+	sep = append(sep, name...)
+	sep = append(sep, `" content="`...)
+	i := bytes.Index(data, sep)
+	if i == -1 {
+		return ""
+	}
+	content := data[i+len(sep):]
+	j := bytes.IndexByte(content, '"')
+	if j == -1 {
+		return ""
+	}
+	return string(content[:j])
+}
+
+func TestPathTrieFind(t *testing.T) {
+	tests := []struct {
+		paths   []string
+		query   string
+		want    string
+		subpath string
+	}{
+		{
+			paths: []string{"/portmidi"},
+			query: "/portmidi",
+			want:  "/portmidi",
+		},
+		{
+			paths: []string{"/portmidi"},
+			query: "/portmidi/",
+			want:  "/portmidi",
+		},
+		{
+			paths: []string{"/portmidi"},
+			query: "/foo",
+			want:  "",
+		},
+		{
+			paths: []string{"/portmidi"},
+			query: "/zzz",
+			want:  "",
+		},
+		{
+			paths: []string{"/abc", "/portmidi", "/xyz"},
+			query: "/portmidi",
+			want:  "/portmidi",
+		},
+		{
+			paths:   []string{"/abc", "/portmidi", "/xyz"},
+			query:   "/portmidi/foo",
+			want:    "/portmidi",
+			subpath: "foo",
+		},
+		{
+			paths:   []string{"/a", "/a-b", "/a/b"},
+			query:   "/a/c/d",
+			want:    "/a",
+			subpath: "c/d",
+		},
+		{
+			paths:   []string{"/a", "/a/b"},
+			query:   "/a/b/c",
+			want:    "/a/b",
+			subpath: "c",
+		},
+		{
+			paths: []string{"/a/b"},
+			query: "/a",
+			want:  "",
+		},
+	}
+	emptyToNil := func(s string) string {
+		if s == "" {
+			return "<nil>"
+		}
+		return s
+	}
+	for _, test := range tests {
+		pset := make(pathConfigSet, len(test.paths))
+		for i := range test.paths {
+			pset[i].path = test.paths[i]
+		}
+		sort.Sort(pset)
+		pc, subpath := newPathTrie(pset).find(test.query)
+		var got string
+		if pc != nil {
+			got = pc.path
+		}
+		if got != test.want || subpath != test.subpath {
+			t.Errorf("newPathTrie(%v).find(%q) = %v, %v; want %v, %v",
+				test.paths, test.query, emptyToNil(got), subpath, emptyToNil(test.want), test.subpath)
+		}
+	}
+}
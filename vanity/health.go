@@ -0,0 +1,245 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health answers the liveness and readiness probes of a server embedding a
+// handler: /healthz reports that the process is serving, /readyz that its
+// configuration has been loaded and validated.  A Health is not ready until
+// SetReady is called with a nil error, nor while one of ReadyComponents is
+// degraded or a component marked Pending has not yet been reported ok.
+//
+// /healthz also reports the status of the server's components, such as its
+// configuration source, as JSON.  It answers 200 OK even if a component is
+// degraded, since restarting the server would not help.
+type Health struct {
+	// HealthPath and ReadyPath are where the probes are answered.  They
+	// default to /healthz and /readyz.
+	HealthPath string
+	ReadyPath  string
+
+	// ReadyComponents are the components that must be ok for the server
+	// to be ready, e.g. "config" to take it out of rotation while its last
+	// reload failed.
+	ReadyComponents []string
+
+	// Notifier, if not nil, is told when a component starts failing or
+	// recovers, and whenever loading the configuration fails.
+	Notifier Notifier
+
+	// Logger receives errors notifying.  If nil, the standard logger is
+	// used.
+	Logger *log.Logger
+
+	mu         sync.Mutex
+	ready      bool
+	err        error
+	components map[string]*ComponentStatus
+	pending    map[string]bool
+
+	wg sync.WaitGroup // notifications in flight, for tests
+}
+
+// ComponentStatus is the status of one component of a server.
+type ComponentStatus struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+
+	// Checked is when the status was last reported, Since when it last
+	// changed between ok and degraded.
+	Checked time.Time `json:"checked"`
+	Since   time.Time `json:"since"`
+}
+
+// SetReady records the result of loading the configuration as the status of
+// the "config" component.  A non-nil err makes the server unready until
+// SetReady is called again with nil.
+func (hl *Health) SetReady(err error) {
+	if !hl.report("config", err) && err != nil {
+		hl.notify(newEvent(EventDegraded, "config", err))
+	}
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	hl.ready = err == nil
+	hl.err = err
+}
+
+// Ready reports whether the server is ready and, if not, why.
+func (hl *Health) Ready() (bool, error) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	if !hl.ready {
+		return false, hl.err
+	}
+	for _, name := range hl.ReadyComponents {
+		if st, ok := hl.components[name]; ok && !st.OK {
+			return false, fmt.Errorf("%s: %s", name, st.Error)
+		}
+	}
+	for name := range hl.pending {
+		if st, ok := hl.components[name]; ok {
+			return false, fmt.Errorf("%s: %s", name, st.Error)
+		}
+		return false, fmt.Errorf("%s: not synced yet", name)
+	}
+	return true, nil
+}
+
+// Pending makes the server unready until component is first reported ok,
+// e.g. while a sync that supplies paths has not yet succeeded.
+func (hl *Health) Pending(component string) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	if hl.pending == nil {
+		hl.pending = make(map[string]bool)
+	}
+	hl.pending[component] = true
+}
+
+// Report records the outcome of the latest operation of a component: ok if
+// err is nil, degraded otherwise.
+func (hl *Health) Report(component string, err error) {
+	hl.report(component, err)
+}
+
+// report records the status of a component and reports whether it
+// notified about it.
+func (hl *Health) report(component string, err error) bool {
+	now := time.Now()
+	hl.mu.Lock()
+	if hl.components == nil {
+		hl.components = make(map[string]*ComponentStatus)
+	}
+	st, ok := hl.components[component]
+	changed := !ok || st.OK != (err == nil)
+	if changed {
+		st = &ComponentStatus{OK: err == nil, Since: now}
+		hl.components[component] = st
+	}
+	st.Checked = now
+	st.Error = ""
+	if err != nil {
+		st.Error = err.Error()
+	} else {
+		delete(hl.pending, component)
+	}
+	hl.mu.Unlock()
+
+	switch {
+	case changed && err != nil:
+		hl.notify(newEvent(EventDegraded, component, err))
+	case changed && ok:
+		hl.notify(newEvent(EventRecovered, component, nil))
+	default:
+		return false
+	}
+	return true
+}
+
+// notify sends e in the background, so that a slow notifier does not hold
+// up the component reporting.
+func (hl *Health) notify(e Event) {
+	if hl.Notifier == nil {
+		return
+	}
+	hl.wg.Add(1)
+	go func() {
+		defer hl.wg.Done()
+		if err := hl.Notifier.Notify(context.Background(), e); err != nil {
+			if hl.Logger != nil {
+				hl.Logger.Printf("notifying %v: %v", e, err)
+			} else {
+				log.Printf("notifying %v: %v", e, err)
+			}
+		}
+	}()
+}
+
+// Components returns the status of every reported component.
+func (hl *Health) Components() map[string]ComponentStatus {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	m := make(map[string]ComponentStatus, len(hl.components))
+	for name, st := range hl.components {
+		m[name] = *st
+	}
+	return m
+}
+
+// Register adds the probes to mux.
+func (hl *Health) Register(mux *http.ServeMux) {
+	mux.Handle(hl.healthPath(), hl)
+	mux.Handle(hl.readyPath(), hl)
+}
+
+func (hl *Health) healthPath() string {
+	if hl.HealthPath != "" {
+		return hl.HealthPath
+	}
+	return "/healthz"
+}
+
+func (hl *Health) readyPath() string {
+	if hl.ReadyPath != "" {
+		return hl.ReadyPath
+	}
+	return "/readyz"
+}
+
+// ServeHTTP answers the liveness and readiness probes.
+func (hl *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.URL.Path {
+	case hl.healthPath():
+		report := struct {
+			Status     string                     `json:"status"`
+			Ready      bool                       `json:"ready"`
+			Components map[string]ComponentStatus `json:"components"`
+		}{Status: "ok", Components: hl.Components()}
+		report.Ready, _ = hl.Ready()
+		for _, st := range report.Components {
+			if !st.OK {
+				report.Status = "degraded"
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	case hl.readyPath():
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		ready, err := hl.Ready()
+		switch {
+		case ready:
+			io.WriteString(w, "ok\n")
+		case err != nil:
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "not ready: configuration not loaded", http.StatusServiceUnavailable)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	var hl Health
+	mux := http.NewServeMux()
+	hl.Register(mux)
+	check := func(when, path string, code int, body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code || !strings.Contains(w.Body.String(), body) {
+			t.Errorf("%s: %s = %d %q; want %d containing %q", when, path, w.Code, w.Body.String(), code, body)
+		}
+	}
+
+	check("initially", "/healthz", http.StatusOK, `"status": "ok"`)
+	check("initially", "/readyz", http.StatusServiceUnavailable, "configuration not loaded")
+	hl.SetReady(nil)
+	check("after load", "/readyz", http.StatusOK, "ok")
+	hl.SetReady(errors.New("paths: bad repo"))
+	check("after failed load", "/readyz", http.StatusServiceUnavailable, "bad repo")
+	check("after failed load", "/healthz", http.StatusOK, `"status": "degraded"`)
+	check("after failed load", "/healthz", http.StatusOK, `"error": "paths: bad repo"`)
+}
+
+func TestHealthConfigured(t *testing.T) {
+	hl := &Health{HealthPath: "/live", ReadyPath: "/ready", ReadyComponents: []string{"config"}}
+	mux := http.NewServeMux()
+	hl.Register(mux)
+	check := func(when, path string, code int, body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code || !strings.Contains(w.Body.String(), body) {
+			t.Errorf("%s: %s = %d %q; want %d containing %q", when, path, w.Code, w.Body.String(), code, body)
+		}
+	}
+
+	hl.SetReady(nil)
+	check("after load", "/ready", http.StatusOK, "ok")
+	check("after load", "/live", http.StatusOK, `"status": "ok"`)
+	check("after load", "/readyz", http.StatusNotFound, "")
+	hl.Report("links", errors.New("1 broken"))
+	check("with broken links", "/ready", http.StatusOK, "ok")
+	hl.Report("config", errors.New("paths: bad repo"))
+	check("after failed reload", "/ready", http.StatusServiceUnavailable, "config: paths: bad repo")
+	check("after failed reload", "/live", http.StatusOK, `"ready": false`)
+	hl.Report("config", nil)
+	check("after reload", "/ready", http.StatusOK, "ok")
+}
+
+func TestHealthComponents(t *testing.T) {
+	var hl Health
+	hl.Report("kubernetes", errors.New("watch: connection refused"))
+	first := hl.Components()["kubernetes"]
+	hl.Report("kubernetes", errors.New("list: timeout"))
+	second := hl.Components()["kubernetes"]
+	if second.OK || second.Error != "list: timeout" || !second.Since.Equal(first.Since) {
+		t.Errorf("after two failures: %+v; want degraded since %v", second, first.Since)
+	}
+	hl.Report("kubernetes", nil)
+	third := hl.Components()["kubernetes"]
+	if !third.OK || third.Error != "" || third.Since.Before(second.Checked) {
+		t.Errorf("after recovery: %+v; want ok since recovery", third)
+	}
+	if ready, _ := hl.Ready(); ready {
+		t.Error("components made the server ready")
+	}
+}
+
+func TestHealthPending(t *testing.T) {
+	var hl Health
+	hl.SetReady(nil)
+	hl.Pending("github")
+	if ready, err := hl.Ready(); ready || err == nil || err.Error() != "github: not synced yet" {
+		t.Errorf("before the first sync: ready %v, %v; want github: not synced yet", ready, err)
+	}
+	hl.Report("github", errors.New("401 Unauthorized"))
+	if ready, err := hl.Ready(); ready || err == nil || err.Error() != "github: 401 Unauthorized" {
+		t.Errorf("after a failed sync: ready %v, %v; want github: 401 Unauthorized", ready, err)
+	}
+	hl.Report("github", nil)
+	if ready, err := hl.Ready(); !ready {
+		t.Errorf("after the first sync: unready: %v", err)
+	}
+	hl.Report("github", errors.New("timeout"))
+	if ready, err := hl.Ready(); !ready {
+		t.Errorf("after a later failed sync: unready: %v", err)
+	}
+}
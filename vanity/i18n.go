@@ -0,0 +1,238 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Messages maps message keys, such as install_with, to text in one
+// language.  Templates find them in .Msg.
+type Messages map[string]string
+
+// defaultLocale is the locale of pages unless the configuration sets
+// locale.
+const defaultLocale = "en"
+
+// builtinMessages are the catalogs of the built-in pages.  The English one
+// has every key; others may leave keys out.
+var builtinMessages = map[string]Messages{
+	"en": {
+		"install_with":     "Install with:",
+		"latest_release":   "Latest release:",
+		"all_releases":     "All releases",
+		"major_versions":   "Major versions:",
+		"owner":            "owner:",
+		"search":           "Search",
+		"previous_page":    "Previous",
+		"next_page":        "Next",
+		"no_owner":         "No owner",
+		"see_godoc":        "See the package on godoc",
+		"nothing_here":     "Nothing to see here;",
+		"see_godoc_link":   "see the package on godoc",
+		"not_found":        "404 page not found",
+		"cannot_resolve":   "cannot resolve the import path",
+		"cannot_render":    "cannot render the page",
+		"timeout":          "The request took too long.  Please try again later.",
+		"rate_limited":     "Too many requests.  Please slow down and try again later.",
+		"deprecated_alias": "This import path is deprecated.  Use",
+		"retired":          "this package has been retired and is no longer available.",
+	},
+	"de": {
+		"install_with":     "Installieren mit:",
+		"latest_release":   "Neueste Version:",
+		"all_releases":     "Alle Versionen",
+		"major_versions":   "Hauptversionen:",
+		"owner":            "verantwortlich:",
+		"search":           "Suchen",
+		"previous_page":    "Zurück",
+		"next_page":        "Weiter",
+		"no_owner":         "Ohne Verantwortliche",
+		"see_godoc":        "Das Paket auf godoc ansehen",
+		"nothing_here":     "Hier gibt es nichts zu sehen;",
+		"see_godoc_link":   "das Paket auf godoc ansehen",
+		"not_found":        "404 Seite nicht gefunden",
+		"cannot_resolve":   "der Importpfad kann nicht aufgelöst werden",
+		"cannot_render":    "die Seite kann nicht dargestellt werden",
+		"timeout":          "Die Anfrage hat zu lange gedauert.  Bitte später erneut versuchen.",
+		"rate_limited":     "Zu viele Anfragen.  Bitte langsamer und später erneut versuchen.",
+		"deprecated_alias": "Dieser Importpfad ist veraltet.  Stattdessen verwenden:",
+		"retired":          "dieses Paket wurde eingestellt und ist nicht mehr verfügbar.",
+	},
+	"fr": {
+		"install_with":     "Installer avec :",
+		"latest_release":   "Dernière version :",
+		"all_releases":     "Toutes les versions",
+		"major_versions":   "Versions majeures :",
+		"owner":            "responsable :",
+		"search":           "Rechercher",
+		"previous_page":    "Précédente",
+		"next_page":        "Suivante",
+		"no_owner":         "Sans responsable",
+		"see_godoc":        "Voir le paquet sur godoc",
+		"nothing_here":     "Rien à voir ici ;",
+		"see_godoc_link":   "voir le paquet sur godoc",
+		"not_found":        "404 page introuvable",
+		"cannot_resolve":   "impossible de résoudre le chemin d'import",
+		"cannot_render":    "impossible d'afficher la page",
+		"timeout":          "La requête a pris trop de temps.  Veuillez réessayer plus tard.",
+		"rate_limited":     "Trop de requêtes.  Veuillez ralentir et réessayer plus tard.",
+		"deprecated_alias": "Ce chemin d'import est obsolète.  Utilisez",
+		"retired":          "ce paquet a été retiré et n'est plus disponible.",
+	},
+	"es": {
+		"install_with":     "Instalar con:",
+		"latest_release":   "Última versión:",
+		"all_releases":     "Todas las versiones",
+		"major_versions":   "Versiones principales:",
+		"owner":            "responsable:",
+		"search":           "Buscar",
+		"previous_page":    "Anterior",
+		"next_page":        "Siguiente",
+		"no_owner":         "Sin responsable",
+		"see_godoc":        "Ver el paquete en godoc",
+		"nothing_here":     "No hay nada que ver aquí;",
+		"see_godoc_link":   "ver el paquete en godoc",
+		"not_found":        "404 página no encontrada",
+		"cannot_resolve":   "no se puede resolver la ruta de importación",
+		"cannot_render":    "no se puede mostrar la página",
+		"timeout":          "La solicitud tardó demasiado.  Vuelva a intentarlo más tarde.",
+		"rate_limited":     "Demasiadas solicitudes.  Reduzca el ritmo y vuelva a intentarlo más tarde.",
+		"deprecated_alias": "Esta ruta de importación está obsoleta.  Use",
+		"retired":          "este paquete ha sido retirado y ya no está disponible.",
+	},
+}
+
+// catalogs holds the messages of every locale a handler serves, each
+// completed from the default locale and then from English.
+type catalogs struct {
+	def     string
+	locales map[string]Messages
+}
+
+// newCatalogs merges the configured messages over the built-in ones.
+func newCatalogs(def string, configured map[string]Messages) (catalogs, error) {
+	if def == "" {
+		def = defaultLocale
+	}
+	def = strings.ToLower(def)
+	raw := make(map[string]Messages)
+	for _, m := range []map[string]Messages{builtinMessages, configured} {
+		for locale, msgs := range m {
+			locale = strings.ToLower(locale)
+			if locale == "" || strings.ContainsAny(locale, " ,;*") {
+				return catalogs{}, fmt.Errorf("configuration for messages: invalid locale %q", locale)
+			}
+			if raw[locale] == nil {
+				raw[locale] = make(Messages)
+			}
+			for k, v := range msgs {
+				raw[locale][k] = v
+			}
+		}
+	}
+	if raw[def] == nil {
+		return catalogs{}, fmt.Errorf("configuration for locale: no messages for %s", def)
+	}
+	c := catalogs{def: def, locales: make(map[string]Messages, len(raw))}
+	for locale, msgs := range raw {
+		merged := make(Messages)
+		for _, m := range []Messages{raw[defaultLocale], raw[def], msgs} {
+			for k, v := range m {
+				merged[k] = v
+			}
+		}
+		c.locales[locale] = merged
+	}
+	return c, nil
+}
+
+// negotiate returns the locale to answer r in, and its messages, according
+// to the Accept-Language header.  A tag such as de-AT falls back to de;
+// without a match, the default locale is used.
+func (c catalogs) negotiate(r *http.Request) (string, Messages) {
+	for _, tag := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		if tag == "*" {
+			break
+		}
+		for {
+			if m, ok := c.locales[tag]; ok {
+				return tag, m
+			}
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return c.def, c.locales[c.def]
+}
+
+// acceptedLanguages returns the lowercased language tags of an
+// Accept-Language header, most preferred first, leaving out those with a
+// quality of zero.
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+	var tags []accepted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, accepted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// localize negotiates the locale of the page answering r and sets the
+// headers that say so.
+func (h *Handler) localize(w http.ResponseWriter, r *http.Request) (string, Messages) {
+	lang, msgs := h.catalogs.negotiate(r)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", lang)
+	return lang, msgs
+}
+
+// error replies to r with the message key in the negotiated locale.
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, code int, key string) {
+	_, msgs := h.localize(w, r)
+	http.Error(w, msgs[key], code)
+}
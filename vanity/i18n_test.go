@@ -0,0 +1,124 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedLanguages(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"de", []string{"de"}},
+		{"fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5", []string{"fr-ch", "fr", "en", "de", "*"}},
+		{"en;q=0.2, ja", []string{"ja", "en"}},
+		{"de;q=0, es", []string{"es"}},
+		{"es;q=bogus, it", []string{"it"}},
+	}
+	for _, test := range tests {
+		if got := acceptedLanguages(test.header); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("acceptedLanguages(%q) = %q; want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestLocalizedPages(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"locale: de\n" +
+		"messages:\n" +
+		"  de:\n" +
+		"    nothing_here: Nichts zu sehen;\n" +
+		"  nl:\n" +
+		"    see_godoc_link: bekijk het pakket op godoc\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		accept   string
+		lang     string
+		contains []string
+	}{
+		{"/portmidi", "", "de", []string{`<html lang="de">`, "Nichts zu sehen;", "das Paket auf godoc ansehen"}},
+		{"/portmidi", "fr-CA, en;q=0.5", "fr", []string{"Rien à voir ici ;"}},
+		{"/portmidi", "en-GB", "en", []string{"Nothing to see here;"}},
+		// Missing messages come from the default locale.
+		{"/portmidi", "nl", "nl", []string{"Nichts zu sehen;", "bekijk het pakket op godoc"}},
+		{"/portmidi", "ja, *;q=0.1", "de", []string{"Nichts zu sehen;"}},
+		{"/", "es", "es", []string{`<html lang="es">`}},
+		{"/unknown", "fr", "fr", []string{"404 page introuvable"}},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept-Language", test.accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Language"); got != test.lang {
+			t.Errorf("%s (Accept-Language %q): Content-Language = %q; want %q", test.path, test.accept, got, test.lang)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Language" {
+			t.Errorf("%s (Accept-Language %q): Vary = %q; want Accept-Language", test.path, test.accept, got)
+		}
+		for _, s := range test.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s (Accept-Language %q): body does not contain %q:\n%s", test.path, test.accept, s, w.Body)
+			}
+		}
+	}
+}
+
+func TestLocaleConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Config
+		ok   bool
+	}{
+		{name: "default", ok: true},
+		{name: "built-in locale", c: Config{Locale: "FR"}, ok: true},
+		{name: "configured locale", c: Config{Locale: "nl", Messages: map[string]Messages{"nl": {"not_found": "404 niet gevonden"}}}, ok: true},
+		{name: "unknown locale", c: Config{Locale: "nl"}},
+		{name: "invalid locale", c: Config{Messages: map[string]Messages{"en, de": {}}}},
+	}
+	for _, test := range tests {
+		err := test.c.Validate()
+		if test.ok && err != nil {
+			t.Errorf("%s: Validate: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: Validate succeeded; want error", test.name)
+		}
+	}
+	h, err := New(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusNotFound || w.Body.String() != "404 page not found\n" {
+		t.Errorf("GET /unknown = %d %q; want 404 \"404 page not found\\n\"", w.Code, w.Body)
+	}
+}
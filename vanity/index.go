@@ -0,0 +1,171 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IndexConfig configures the index page, for configurations with so many
+// paths that a single list is hard to use.
+type IndexConfig struct {
+	// PageSize is the number of import paths per page.  If zero, the
+	// index lists all of them on one page.
+	PageSize int
+
+	// GroupBy groups the import paths under headings: segment by the
+	// first element of the path, owner by their owner.  If empty, they
+	// are not grouped.
+	GroupBy string
+}
+
+func (c IndexConfig) validate() error {
+	if c.PageSize < 0 {
+		return fmt.Errorf("configuration for index: negative page_size")
+	}
+	switch c.GroupBy {
+	case "", "segment", "owner":
+		return nil
+	}
+	return fmt.Errorf("configuration for index: unknown group_by %q", c.GroupBy)
+}
+
+// An indexPage is the part of the list of import paths that an index page
+// shows.
+type indexPage struct {
+	Handlers []string
+	Majors   map[string][]string // above v1, of Handlers
+
+	// Search is where the page submits its filter box, or empty for no
+	// box, and Query what was searched for.
+	Search string
+	Query  string
+
+	// Page is the number of the page, from 1, out of Pages, and Prev and
+	// Next link to the neighboring pages if there are any.
+	Page, Pages int
+	Prev, Next  string
+}
+
+// An indexGroup is a run of import paths of an index page under a heading.
+type indexGroup struct {
+	Name     string
+	Handlers []string
+}
+
+// groupKey returns the heading of the group of the import path on host, or
+// the empty string for import paths listed after the groups.
+func (h *Handler) groupKey(host, importPath string) string {
+	path := strings.TrimPrefix(importPath, host)
+	switch h.index.GroupBy {
+	case "segment":
+		elem := strings.TrimPrefix(path, "/")
+		if i := strings.IndexByte(elem, '/'); i >= 0 {
+			elem = elem[:i]
+		}
+		return elem
+	case "owner":
+		if pc, _ := h.findPath(path); pc != nil {
+			return pc.owner
+		}
+	}
+	return ""
+}
+
+// sortByGroup sorts the import paths on host by the heading of their
+// group, keeping the order within groups, so that pages hold whole runs
+// of groups.
+func (h *Handler) sortByGroup(host string, handlers []string) {
+	if h.index.GroupBy == "" {
+		return
+	}
+	sort.SliceStable(handlers, func(i, j int) bool {
+		ki, kj := h.groupKey(host, handlers[i]), h.groupKey(host, handlers[j])
+		if ki == "" || kj == "" {
+			return kj == "" && ki != ""
+		}
+		return ki < kj
+	})
+}
+
+// groups splits the import paths of a page on host into the runs with the
+// same heading.  Import paths without a group are headed by other.
+func (h *Handler) groups(host string, handlers []string, other string) []indexGroup {
+	if h.index.GroupBy == "" {
+		return []indexGroup{{Handlers: handlers}}
+	}
+	var groups []indexGroup
+	for _, p := range handlers {
+		name := h.groupKey(host, p)
+		if name == "" {
+			name = other
+		}
+		if n := len(groups); n == 0 || groups[n-1].Name != name {
+			groups = append(groups, indexGroup{Name: name})
+		}
+		g := &groups[len(groups)-1]
+		g.Handlers = append(g.Handlers, p)
+	}
+	return groups
+}
+
+// paginate returns the page of the import paths asked for by the page
+// parameter of query, which keeps the other parameters in the links to
+// the neighboring pages.  It reports false if there is no such page.
+func (h *Handler) paginate(handlers []string, query url.Values) (indexPage, bool) {
+	p := indexPage{Handlers: handlers, Page: 1, Pages: 1}
+	if h.index.PageSize == 0 {
+		return p, query.Get("page") == "" || query.Get("page") == "1"
+	}
+	if n := len(handlers); n > h.index.PageSize {
+		p.Pages = (n + h.index.PageSize - 1) / h.index.PageSize
+	}
+	if s := query.Get("page"); s != "" {
+		page, err := strconv.Atoi(s)
+		if err != nil || page < 1 || page > p.Pages {
+			return indexPage{}, false
+		}
+		p.Page = page
+	}
+	start := (p.Page - 1) * h.index.PageSize
+	end := start + h.index.PageSize
+	if end > len(handlers) {
+		end = len(handlers)
+	}
+	p.Handlers = handlers[start:end]
+	link := func(page int) string {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		if page == 1 {
+			q.Del("page")
+		} else {
+			q.Set("page", strconv.Itoa(page))
+		}
+		return "?" + q.Encode()
+	}
+	if p.Page > 1 {
+		p.Prev = link(p.Page - 1)
+	}
+	if p.Page < p.Pages {
+		p.Next = link(p.Page + 1)
+	}
+	return p, true
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIndexPages(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"index:\n" +
+		"  page_size: 2\n" +
+		"  group_by: owner\n" +
+		"paths:\n" +
+		"  /a:\n" +
+		"    repo: https://github.com/example/a\n" +
+		"  /b:\n" +
+		"    repo: https://github.com/example/b\n" +
+		"    owner: team-z\n" +
+		"  /c:\n" +
+		"    repo: https://github.com/example/c\n" +
+		"    owner: team-y\n" +
+		"  /d:\n" +
+		"    repo: https://github.com/example/d\n" +
+		"    owner: team-y\n" +
+		"  /e:\n" +
+		"    repo: https://github.com/example/e\n" +
+		"    owner: team-z\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		code int
+		want []string // in order
+	}{
+		{"/", 200, []string{"<h2>team-y</h2>", "example.com/c</a>", "example.com/d</a>", "1/3", `<a href="?page=2" rel="next">`}},
+		{"/?page=2", 200, []string{"<h2>team-z</h2>", "example.com/b</a>", "example.com/e</a>", `<a href="?" rel="prev">`, "2/3"}},
+		{"/?page=3", 200, []string{"<h2>No owner</h2>", "example.com/a</a>", "3/3"}},
+		{"/?page=2&x=1", 200, []string{"example.com/b</a>", `<a href="?x=1" rel="prev">`, `<a href="?page=3&amp;x=1" rel="next">`}},
+		{"/?page=4", 404, nil},
+		{"/?page=x", 404, nil},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.code {
+			t.Errorf("%s: status = %d; want %d", test.path, w.Code, test.code)
+			continue
+		}
+		body := w.Body.String()
+		rest := body
+		for _, want := range test.want {
+			i := strings.Index(rest, want)
+			if i < 0 {
+				t.Errorf("%s: %q missing or out of order in\n%s", test.path, want, body)
+				break
+			}
+			rest = rest[i+len(want):]
+		}
+	}
+}
+
+func TestIndexGroupBySegment(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"index:\n" +
+		"  group_by: segment\n" +
+		"paths:\n" +
+		"  /tools/x:\n" +
+		"    repo: https://github.com/example/x\n" +
+		"  /audio/b:\n" +
+		"    repo: https://github.com/example/b\n" +
+		"  /audio/a:\n" +
+		"    repo: https://github.com/example/a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, "<h2>audio</h2>\n<ul class=\"paths\">\n<li><a href=\"https://godoc.org/example.com/audio/a\">") ||
+		strings.Index(body, "<h2>tools</h2>") < strings.Index(body, "example.com/audio/b</a>") || strings.Contains(body, "<nav>") {
+		t.Errorf("index grouped by segment:\n%s", body)
+	}
+}
+
+func TestIndexConfigErrors(t *testing.T) {
+	for _, test := range []struct{ config, want string }{
+		{"index:\n  page_size: -1\n", "negative page_size"},
+		{"index:\n  group_by: team\n", `unknown group_by "team"`},
+	} {
+		if _, err := NewHandler([]byte(test.config)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: err = %v; want %s", test.config, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kube serves vanity paths declared as VanityPath custom resources
+// in a Kubernetes cluster.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultTimeout    = 30 * time.Second
+)
+
+// Client talks to the Kubernetes API server.  It implements only the few
+// calls the controller needs.
+type Client struct {
+	// Server is the base URL of the API server.
+	Server string
+
+	// Token is the bearer token to authenticate with.  If TokenFile is set,
+	// the token is read from it for every request instead, so that rotated
+	// service account tokens are picked up.
+	Token     string
+	TokenFile string
+
+	// HTTPClient is used for requests.  If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Timeout bounds each call other than watches.  Defaults to 30
+	// seconds.
+	Timeout time.Duration
+}
+
+// InCluster returns a client for the cluster the program runs in, using the
+// service account of its pod.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kube: not running in a cluster")
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("kube: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("kube: no certificates in ca.crt")
+	}
+	return &Client{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+// InClusterNamespace returns the namespace of the pod the program runs in.
+func InClusterNamespace() (string, error) {
+	ns, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "", fmt.Errorf("kube: %v", err)
+	}
+	return strings.TrimSpace(string(ns)), nil
+}
+
+// StatusError is returned for responses other than 2xx.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// do sends a request and returns the response body, which the caller must
+// close.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body interface{}) (io.ReadCloser, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.Server, "/")+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	token := c.Token
+	if c.TokenFile != "" {
+		b, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("kube: %v", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&status)
+		return nil, &StatusError{Code: resp.StatusCode, Message: status.Message}
+	}
+	return resp.Body, nil
+}
+
+// getJSON decodes the response to a GET request for path into v.
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	body, err := c.do(ctx, "GET", path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+// sendJSON sends v as the body of a request for path and decodes the
+// response into out.
+func (c *Client) sendJSON(ctx context.Context, method, path string, v, out interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	body, err := c.do(ctx, method, path, "application/json", v)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(out)
+}
+
+// mergePatch applies a JSON merge patch to the object at path.
+func (c *Client) mergePatch(ctx context.Context, path string, patch interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	body, err := c.do(ctx, "PATCH", path, "application/merge-patch+json", patch)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+// withTimeout bounds a call that is not a watch.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
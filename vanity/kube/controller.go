@@ -0,0 +1,385 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+)
+
+// Group and Version identify the VanityPath custom resource definition.
+const (
+	Group   = "govanityurls.dev"
+	Version = "v1alpha1"
+)
+
+const (
+	defaultRetry    = time.Second
+	defaultMaxRetry = 2 * time.Minute
+)
+
+// VanityPath is a custom resource declaring one vanity path.
+type VanityPath struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion"`
+		Generation      int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec   VanityPathSpec   `json:"spec"`
+	Status VanityPathStatus `json:"status"`
+}
+
+// VanityPathSpec mirrors the keys of a path in the YAML configuration.
+type VanityPathSpec struct {
+	Path             string   `json:"path"`
+	Repo             string   `json:"repo"`
+	Display          string   `json:"display,omitempty"`
+	Branch           string   `json:"branch,omitempty"`
+	NoSource         *bool    `json:"noSource,omitempty"`
+	Redirect         string   `json:"redirect,omitempty"`
+	CacheMaxAge      *int     `json:"cacheMaxAge,omitempty"`
+	VCS              string   `json:"vcs,omitempty"`
+	Tool             bool     `json:"tool,omitempty"`
+	Subdir           string   `json:"subdir,omitempty"`
+	ImportDepth      *int     `json:"importDepth,omitempty"`
+	MajorSubdirs     bool     `json:"majorSubdirs,omitempty"`
+	AllowInsecure    bool     `json:"allowInsecure,omitempty"`
+	Aliases          []string `json:"aliases,omitempty"`
+	DeprecateAliases bool     `json:"deprecateAliases,omitempty"`
+	Retired          bool     `json:"retired,omitempty"`
+	RetiredMessage   string   `json:"retiredMessage,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	Owner            string   `json:"owner,omitempty"`
+}
+
+// VanityPathStatus reports whether a VanityPath is served.
+type VanityPathStatus struct {
+	ObservedGeneration int64  `json:"observedGeneration"`
+	Served             bool   `json:"served"`
+	Message            string `json:"message"`
+}
+
+func (s VanityPathSpec) pathConfig() vanity.PathConfig {
+	return vanity.PathConfig{
+		Path:             s.Path,
+		Repo:             s.Repo,
+		Display:          s.Display,
+		Branch:           s.Branch,
+		NoSource:         s.NoSource,
+		Redirect:         s.Redirect,
+		CacheMaxAge:      s.CacheMaxAge,
+		VCS:              s.VCS,
+		Tool:             s.Tool,
+		Subdir:           s.Subdir,
+		ImportDepth:      s.ImportDepth,
+		MajorSubdirs:     s.MajorSubdirs,
+		AllowInsecure:    s.AllowInsecure,
+		Aliases:          s.Aliases,
+		DeprecateAliases: s.DeprecateAliases,
+		Retired:          s.Retired,
+		RetiredMessage:   s.RetiredMessage,
+		Description:      s.Description,
+		Tags:             s.Tags,
+		Owner:            s.Owner,
+	}
+}
+
+func (vp *VanityPath) key() string {
+	return vp.Metadata.Namespace + "/" + vp.Metadata.Name
+}
+
+// Controller keeps the paths of a handler in sync with the VanityPath
+// resources in a cluster.  Paths from the handler's own configuration are
+// left alone; a VanityPath for one of them is reported as a conflict.
+type Controller struct {
+	Client  *Client
+	Handler *vanity.Handler
+
+	// Namespace restricts the controller to one namespace.  If empty,
+	// resources in all namespaces are served.
+	Namespace string
+
+	// Retry is how long to wait before listing the resources again after
+	// an error.  The wait doubles with every consecutive failure up to
+	// MaxRetry.  They default to 1 second and 2 minutes.
+	Retry    time.Duration
+	MaxRetry time.Duration
+
+	// StartupTimeout, if positive, makes Run give up if the resources could
+	// not be listed for that long after it started.  Once they have been
+	// listed, Run retries forever.
+	StartupTimeout time.Duration
+
+	// Logger receives errors.  If nil, the standard logger is used.
+	Logger *log.Logger
+
+	// Health, if not nil, is marked ready once the resources have first
+	// been listed and served, and unready with the error until then.  The
+	// connection to the API server is reported as its "kubernetes"
+	// component.
+	Health *vanity.Health
+
+	// Leader, if not nil, restricts updating the status of resources to the
+	// replica it elects, so that replicas do not fight over it.  A new
+	// leader brings statuses up to date the next time it lists the
+	// resources, which happens at least whenever the API server ends a
+	// watch.
+	Leader *Elector
+
+	specs  map[string]VanityPathSpec // by resource key
+	owners map[string]string         // resource key by served path
+}
+
+// Run serves the resources until ctx is done.
+func (c *Controller) Run(ctx context.Context) error {
+	retry := c.Retry
+	if retry <= 0 {
+		retry = defaultRetry
+	}
+	maxRetry := c.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = defaultMaxRetry
+	}
+	started := time.Now()
+	synced := false
+	delay := retry
+	for {
+		rv, err := c.list(ctx)
+		if !synced {
+			if c.Health != nil {
+				c.Health.SetReady(err)
+			}
+			synced = err == nil
+		}
+		if err == nil {
+			delay = retry
+			c.report(nil)
+			err = c.watch(ctx, rv)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.report(err)
+		if err == nil || err == errExpired {
+			// The API server ended the watch; list again right away.
+			continue
+		}
+		if !synced && c.StartupTimeout > 0 && time.Since(started)+delay > c.StartupTimeout {
+			return fmt.Errorf("kube: no resources listed after %v: %v", time.Since(started).Round(time.Second), err)
+		}
+		c.logf("kube: %v; retrying in %v", err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetry {
+			delay = maxRetry
+		}
+	}
+}
+
+// report records the status of the API server connection.
+func (c *Controller) report(err error) {
+	if c.Health != nil && err != errExpired {
+		c.Health.Report("kubernetes", err)
+	}
+}
+
+func (c *Controller) collection() string {
+	if c.Namespace == "" {
+		return "/apis/" + Group + "/" + Version + "/vanitypaths"
+	}
+	return "/apis/" + Group + "/" + Version + "/namespaces/" + url.PathEscape(c.Namespace) + "/vanitypaths"
+}
+
+// list reconciles all resources and returns the resource version to watch
+// from.
+func (c *Controller) list(ctx context.Context) (string, error) {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []*VanityPath `json:"items"`
+	}
+	if err := c.Client.getJSON(ctx, c.collection(), &list); err != nil {
+		return "", err
+	}
+	c.sync(ctx, list.Items)
+	return list.Metadata.ResourceVersion, nil
+}
+
+// errExpired ends a watch whose resource version is too old to resume.
+var errExpired = errors.New("watch expired")
+
+// watch applies changes after resource version rv until the watch ends.
+// It returns nil if the API server ended the watch normally.
+func (c *Controller) watch(ctx context.Context, rv string) error {
+	body, err := c.Client.do(ctx, "GET", c.collection()+"?watch=1&allowWatchBookmarks=true&resourceVersion="+url.QueryEscape(rv), "", nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	for {
+		var ev struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&ev); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch ev.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			vp := new(VanityPath)
+			if err := json.Unmarshal(ev.Object, vp); err != nil {
+				return err
+			}
+			if ev.Type == "DELETED" {
+				c.remove(vp.key())
+			} else {
+				c.apply(ctx, vp)
+			}
+		case "BOOKMARK":
+		case "ERROR":
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(ev.Object, &status)
+			if status.Code == http.StatusGone {
+				return errExpired
+			}
+			return &StatusError{Code: status.Code, Message: status.Message}
+		}
+	}
+}
+
+// sync serves exactly the resources in items.
+func (c *Controller) sync(ctx context.Context, items []*VanityPath) {
+	seen := make(map[string]bool)
+	for _, vp := range items {
+		seen[vp.key()] = true
+	}
+	for key := range c.specs {
+		if !seen[key] {
+			c.remove(key)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].key() < items[j].key() })
+	for _, vp := range items {
+		c.apply(ctx, vp)
+	}
+}
+
+// apply serves vp and records the outcome in its status.
+func (c *Controller) apply(ctx context.Context, vp *VanityPath) {
+	err := c.serve(vp.key(), vp.Spec)
+	status := VanityPathStatus{
+		ObservedGeneration: vp.Metadata.Generation,
+		Served:             err == nil,
+	}
+	if err != nil {
+		status.Message = err.Error()
+	}
+	if status == vp.Status || (c.Leader != nil && !c.Leader.IsLeader()) {
+		return
+	}
+	path := c.collection()
+	if c.Namespace == "" {
+		path = "/apis/" + Group + "/" + Version + "/namespaces/" + url.PathEscape(vp.Metadata.Namespace) + "/vanitypaths"
+	}
+	path += "/" + url.PathEscape(vp.Metadata.Name) + "/status"
+	patch := map[string]interface{}{"status": status}
+	if err := c.Client.mergePatch(ctx, path, patch); err != nil && ctx.Err() == nil {
+		c.logf("kube: updating status of %s: %v", vp.key(), err)
+	}
+}
+
+// serve makes the handler serve spec for the resource key.
+func (c *Controller) serve(key string, spec VanityPathSpec) error {
+	if c.specs == nil {
+		c.specs = make(map[string]VanityPathSpec)
+		c.owners = make(map[string]string)
+	}
+	spec.Path = strings.TrimSuffix(spec.Path, "/")
+	pc := spec.pathConfig()
+	if old, ok := c.specs[key]; ok && old.Path != spec.Path {
+		c.remove(key)
+	}
+	if owner, ok := c.owners[spec.Path]; ok && owner != key {
+		return fmt.Errorf("path %s is declared by %s", spec.Path, owner)
+	}
+	if _, ok := c.owners[spec.Path]; ok {
+		if err := c.Handler.UpdatePath(pc); err != nil {
+			return err
+		}
+	} else {
+		err := c.Handler.AddPath(pc)
+		if err == vanity.ErrPathExists {
+			return fmt.Errorf("path %s is in the static configuration", spec.Path)
+		}
+		if err != nil {
+			return err
+		}
+		c.owners[spec.Path] = key
+	}
+	c.specs[key] = spec
+	return nil
+}
+
+// remove stops serving the resource key.
+func (c *Controller) remove(key string) {
+	spec, ok := c.specs[key]
+	if !ok {
+		return
+	}
+	delete(c.specs, key)
+	if c.owners[spec.Path] != key {
+		return
+	}
+	delete(c.owners, spec.Path)
+	if err := c.Handler.RemovePath(spec.Path); err != nil {
+		c.logf("kube: removing %s: %v", spec.Path, err)
+	}
+}
+
+func (c *Controller) logf(format string, args ...interface{}) {
+	logTo(c.Logger, format, args...)
+}
+
+// logTo logs to l, or to the standard logger if l is nil.
+func logTo(l *log.Logger, format string, args ...interface{}) {
+	if l != nil {
+		l.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
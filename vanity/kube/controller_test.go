@@ -0,0 +1,213 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+	"github.com/GoogleCloudPlatform/govanityurls/vanity/vanitytest"
+)
+
+func vanityPath(name, spec string) string {
+	return `{"metadata":{"name":"` + name + `","namespace":"team","generation":1},"spec":` + spec + `}`
+}
+
+func TestController(t *testing.T) {
+	const collection = "/apis/govanityurls.dev/v1alpha1/namespaces/team/vanitypaths"
+	var (
+		mu       sync.Mutex
+		statuses = make(map[string]VanityPathStatus)
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("%s %s: Authorization = %q", r.Method, r.URL, got)
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == collection && r.URL.Query().Get("watch") == "":
+			io.WriteString(w, `{"metadata":{"resourceVersion":"7"},"items":[`+
+				vanityPath("foo", `{"path":"/foo","repo":"https://github.com/example/foo"}`)+","+
+				vanityPath("static", `{"path":"/static","repo":"https://github.com/example/other"}`)+","+
+				vanityPath("insecure", `{"path":"/insecure","repo":"http://example.com/insecure"}`)+
+				`]}`)
+		case r.Method == "GET" && r.URL.Path == collection:
+			if got := r.URL.Query().Get("resourceVersion"); got != "7" {
+				t.Errorf("watch resourceVersion = %q; want 7", got)
+			}
+			io.WriteString(w, `{"type":"ADDED","object":`+vanityPath("bar", `{"path":"/bar","repo":"https://github.com/example/bar"}`)+"}\n")
+			io.WriteString(w, `{"type":"MODIFIED","object":`+vanityPath("foo", `{"path":"/foo2","repo":"https://github.com/example/foo"}`)+"}\n")
+			io.WriteString(w, `{"type":"DELETED","object":`+vanityPath("bar", `{"path":"/bar","repo":"https://github.com/example/bar"}`)+"}\n")
+			io.WriteString(w, `{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old"}}`+"\n")
+		case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, collection+"/") && strings.HasSuffix(r.URL.Path, "/status"):
+			name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, collection+"/"), "/status")
+			var patch struct {
+				Status VanityPathStatus `json:"status"`
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &patch); err != nil {
+				t.Errorf("PATCH %s: %v", r.URL, err)
+			}
+			mu.Lock()
+			statuses[name] = patch.Status
+			mu.Unlock()
+			io.WriteString(w, "{}")
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	h := vanitytest.NewHandler(t, "host: example.com\n"+
+		"paths:\n"+
+		"  /static:\n"+
+		"    repo: https://github.com/example/static\n")
+	c := &Controller{
+		Client:    &Client{Server: api.URL, Token: "secret"},
+		Handler:   h,
+		Namespace: "team",
+	}
+	ctx := context.Background()
+	rv, err := c.list(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vanitytest.AssertResolves(t, h, "/foo", "example.com/foo git https://github.com/example/foo", "")
+	if err := c.watch(ctx, rv); err != errExpired {
+		t.Errorf("watch = %v; want %v", err, errExpired)
+	}
+
+	vanitytest.AssertResolves(t, h, "/foo2", "example.com/foo2 git https://github.com/example/foo", "")
+	vanitytest.AssertResolves(t, h, "/static", "example.com/static git https://github.com/example/static", "")
+	for _, path := range []string{"/foo", "/bar", "/insecure"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d; want 404", path, w.Code)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		served bool
+	}{
+		{"foo", true},
+		{"bar", true},
+		{"static", false},
+		{"insecure", false},
+	}
+	for _, test := range tests {
+		st, ok := statuses[test.name]
+		if !ok {
+			t.Errorf("%s: no status", test.name)
+			continue
+		}
+		if st.Served != test.served || st.ObservedGeneration != 1 || (st.Message == "") == !test.served {
+			t.Errorf("%s: status = %+v; want served = %v", test.name, st, test.served)
+		}
+	}
+}
+
+func TestControllerReady(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		lists int
+	)
+	failed, retry := make(chan struct{}), make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") != "" {
+			<-r.Context().Done()
+			return
+		}
+		mu.Lock()
+		lists++
+		n := lists
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, `{"message":"etcd unavailable"}`)
+			close(failed)
+			return
+		}
+		<-retry
+		io.WriteString(w, `{"metadata":{"resourceVersion":"1"},"items":[]}`)
+	}))
+	defer api.Close()
+
+	hl := new(vanity.Health)
+	c := &Controller{
+		Client:  &Client{Server: api.URL},
+		Handler: vanitytest.NewHandler(t, "host: example.com\n"),
+		Health:  hl,
+		Retry:   10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+
+	<-failed
+	waitReady := func(want bool) error {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			ready, err := hl.Ready()
+			if ready == want && (ready || err != nil) {
+				return err
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("ready = %v, %v; want %v", ready, err, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if err := waitReady(false); !strings.Contains(err.Error(), "etcd unavailable") {
+		t.Errorf("unready with %v; want list error", err)
+	}
+	close(retry)
+	waitReady(true)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestControllerStartupTimeout(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, `{"message":"etcd unavailable"}`)
+	}))
+	defer api.Close()
+
+	c := &Controller{
+		Client:         &Client{Server: api.URL},
+		Handler:        vanitytest.NewHandler(t, "host: example.com\n"),
+		Logger:         log.New(ioutil.Discard, "", 0),
+		Retry:          time.Millisecond,
+		StartupTimeout: 50 * time.Millisecond,
+	}
+	err := c.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "etcd unavailable") {
+		t.Errorf("Run = %v; want list error", err)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+// microTime is the format of times in leases.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// An Elector elects one of several replicas as the leader by holding a
+// coordination.k8s.io Lease, so that only one of them runs background jobs
+// that write or call rate limited APIs.
+type Elector struct {
+	Client    *Client
+	Namespace string
+	Name      string // of the lease
+
+	// Identity identifies this replica, typically by its pod name.
+	Identity string
+
+	// LeaseDuration is how long other replicas wait for the leader to renew
+	// the lease before taking over.  The leader steps down if it could not
+	// renew the lease for RenewDeadline.  Replicas try to acquire or renew
+	// the lease every RetryPeriod.  They default to 15, 10 and 2 seconds.
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+
+	// Logger receives errors.  If nil, the standard logger is used.
+	Logger *log.Logger
+
+	now func() time.Time // for tests
+
+	mu      sync.Mutex
+	leader  bool
+	renewed time.Time
+}
+
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec leaseSpec `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// IsLeader reports whether the replica currently holds the lease.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Run takes part in the election until ctx is done.  Whenever the replica
+// becomes the leader, it calls lead in a new goroutine with a context that
+// is canceled when the replica stops being the leader.
+func (e *Elector) Run(ctx context.Context, lead func(context.Context)) error {
+	var cancel context.CancelFunc
+	defer func() {
+		if cancel != nil {
+			cancel()
+		}
+		if e.IsLeader() {
+			e.release()
+		}
+	}()
+	for {
+		leader, err := e.try(ctx)
+		if err != nil && ctx.Err() == nil {
+			logTo(e.Logger, "kube: lease %s/%s: %v", e.Namespace, e.Name, err)
+		}
+		switch {
+		case leader && cancel == nil:
+			leadCtx, stop := context.WithCancel(ctx)
+			cancel = stop
+			if lead != nil {
+				go lead(leadCtx)
+			}
+		case !leader && cancel != nil:
+			cancel()
+			cancel = nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.retryPeriod()):
+		}
+	}
+}
+
+func (e *Elector) leaseURL() string {
+	return "/apis/coordination.k8s.io/v1/namespaces/" + url.PathEscape(e.Namespace) + "/leases"
+}
+
+func (e *Elector) time() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
+
+func (e *Elector) retryPeriod() time.Duration {
+	if e.RetryPeriod > 0 {
+		return e.RetryPeriod
+	}
+	return defaultRetryPeriod
+}
+
+func (e *Elector) leaseDuration() time.Duration {
+	if e.LeaseDuration > 0 {
+		return e.LeaseDuration
+	}
+	return defaultLeaseDuration
+}
+
+// try acquires or renews the lease once and reports whether the replica is
+// the leader afterwards.
+func (e *Elector) try(ctx context.Context) (bool, error) {
+	err := e.acquire(ctx)
+	now := e.time()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case err == nil:
+		e.leader, e.renewed = true, now
+	case err == errHeld:
+		e.leader = false
+		err = nil
+	case e.leader:
+		deadline := e.RenewDeadline
+		if deadline <= 0 {
+			deadline = defaultRenewDeadline
+		}
+		e.leader = now.Sub(e.renewed) < deadline
+	}
+	return e.leader, err
+}
+
+// errHeld is returned by acquire if another replica holds the lease.
+var errHeld = &StatusError{Code: http.StatusConflict, Message: "lease held by another replica"}
+
+func (e *Elector) acquire(ctx context.Context) error {
+	now := e.time()
+	var l lease
+	err := e.Client.getJSON(ctx, e.leaseURL()+"/"+url.PathEscape(e.Name), &l)
+	if se, ok := err.(*StatusError); ok && se.Code == http.StatusNotFound {
+		l.APIVersion, l.Kind = "coordination.k8s.io/v1", "Lease"
+		l.Metadata.Name, l.Metadata.Namespace = e.Name, e.Namespace
+		l.Spec = e.spec(now, now, 0)
+		return e.Client.sendJSON(ctx, "POST", e.leaseURL(), &l, &l)
+	}
+	if err != nil {
+		return err
+	}
+
+	acquired := l.Spec.AcquireTime
+	transitions := l.Spec.LeaseTransitions
+	if l.Spec.HolderIdentity != e.Identity {
+		if l.Spec.HolderIdentity != "" && !expired(l.Spec, now) {
+			return errHeld
+		}
+		acquired = now.UTC().Format(microTime)
+		transitions++
+	}
+	l.Spec = e.spec(now, now, transitions)
+	l.Spec.AcquireTime = acquired
+	err = e.Client.sendJSON(ctx, "PUT", e.leaseURL()+"/"+url.PathEscape(e.Name), &l, &l)
+	if se, ok := err.(*StatusError); ok && se.Code == http.StatusConflict {
+		// Another replica updated the lease first.
+		return errHeld
+	}
+	return err
+}
+
+func (e *Elector) spec(acquired, renewed time.Time, transitions int) leaseSpec {
+	return leaseSpec{
+		HolderIdentity:       e.Identity,
+		LeaseDurationSeconds: int((e.leaseDuration() + time.Second - 1) / time.Second),
+		AcquireTime:          acquired.UTC().Format(microTime),
+		RenewTime:            renewed.UTC().Format(microTime),
+		LeaseTransitions:     transitions,
+	}
+}
+
+// expired reports whether the holder of a lease failed to renew it in time.
+func expired(s leaseSpec, now time.Time) bool {
+	renewed, err := time.Parse(time.RFC3339Nano, s.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.Sub(renewed) > time.Duration(s.LeaseDurationSeconds)*time.Second
+}
+
+// release gives up the lease so that another replica can take over without
+// waiting for it to expire.
+func (e *Elector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var l lease
+	if err := e.Client.getJSON(ctx, e.leaseURL()+"/"+url.PathEscape(e.Name), &l); err != nil {
+		return
+	}
+	if l.Spec.HolderIdentity != e.Identity {
+		return
+	}
+	l.Spec.HolderIdentity = ""
+	if err := e.Client.sendJSON(ctx, "PUT", e.leaseURL()+"/"+url.PathEscape(e.Name), &l, &l); err != nil {
+		logTo(e.Logger, "kube: releasing lease %s/%s: %v", e.Namespace, e.Name, err)
+	}
+	e.mu.Lock()
+	e.leader = false
+	e.mu.Unlock()
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseServer is an API server holding a single lease.
+type leaseServer struct {
+	mu      sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (s *leaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	const leases = "/apis/coordination.k8s.io/v1/namespaces/team/leases"
+	reply := func() {
+		json.NewEncoder(w).Encode(s.lease)
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == leases+"/vanity":
+		if s.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"not found"}`))
+			return
+		}
+		reply()
+	case r.Method == "POST" && r.URL.Path == leases:
+		if s.lease != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.lease = new(lease)
+		json.NewDecoder(r.Body).Decode(s.lease)
+		s.version++
+		s.lease.Metadata.ResourceVersion = strconv.Itoa(s.version)
+		reply()
+	case r.Method == "PUT" && r.URL.Path == leases+"/vanity":
+		var l lease
+		json.NewDecoder(r.Body).Decode(&l)
+		if s.lease == nil || l.Metadata.ResourceVersion != s.lease.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"message":"conflict"}`))
+			return
+		}
+		s.version++
+		l.Metadata.ResourceVersion = strconv.Itoa(s.version)
+		s.lease = &l
+		reply()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestElector(t *testing.T) {
+	s := httptest.NewServer(new(leaseServer))
+	defer s.Close()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	newElector := func(id string) *Elector {
+		return &Elector{
+			Client:    &Client{Server: s.URL},
+			Namespace: "team",
+			Name:      "vanity",
+			Identity:  id,
+			now:       clock,
+		}
+	}
+	a, b := newElector("a"), newElector("b")
+	ctx := context.Background()
+
+	steps := []struct {
+		e       *Elector
+		advance time.Duration
+		leader  bool
+	}{
+		{a, 0, true},  // creates the lease
+		{b, 0, false}, // held by a
+		{a, 5 * time.Second, true},
+		{b, 10 * time.Second, false}, // renewed by a 10s ago
+		{b, 6 * time.Second, true},   // expired
+		{a, 0, false},
+	}
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		leader, err := step.e.try(ctx)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if leader != step.leader || step.e.IsLeader() != step.leader {
+			t.Errorf("step %d: %s is leader = %v; want %v", i, step.e.Identity, leader, step.leader)
+		}
+	}
+
+	b.release()
+	if b.IsLeader() {
+		t.Error("b is leader after release")
+	}
+	if leader, err := a.try(ctx); err != nil || !leader {
+		t.Errorf("a after release: leader = %v, %v; want true", leader, err)
+	}
+}
+
+func TestElectorRun(t *testing.T) {
+	s := httptest.NewServer(new(leaseServer))
+	defer s.Close()
+	e := &Elector{
+		Client:      &Client{Server: s.URL},
+		Namespace:   "team",
+		Name:        "vanity",
+		Identity:    "a",
+		RetryPeriod: time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	led := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- e.Run(ctx, func(ctx context.Context) {
+			close(led)
+			<-ctx.Done()
+		})
+	}()
+	select {
+	case <-led:
+	case <-time.After(5 * time.Second):
+		t.Fatal("never became leader")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run = %v; want %v", err, context.Canceled)
+	}
+	if e.IsLeader() {
+		t.Error("still leader after Run returned")
+	}
+}
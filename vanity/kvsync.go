@@ -0,0 +1,399 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// kvWatchTimeout bounds each blocking query or watch, after which the
+	// entries are listed again anyway.
+	kvWatchTimeout = 5 * time.Minute
+
+	// kvRequestTimeout bounds each other request, and how long past
+	// kvWatchTimeout a blocking query of Consul, which adds up to a
+	// sixteenth of the wait as jitter, may take.
+	kvRequestTimeout = defaultUpstreamTimeout
+
+	// kvRetryInterval is the time between failed attempts to list the
+	// entries.
+	kvRetryInterval = 5 * time.Second
+)
+
+// A KVSync serves the paths stored under a prefix of etcd or Consul and
+// follows changes to them as they are made, so that a fleet of servers can
+// be updated without distributing files.  The key of an entry is its path
+// after the prefix, e.g. vanity/paths/tools for /tools with the prefix
+// vanity/paths/, and its value holds the settings of the path in YAML or
+// JSON, as in the configuration file.  Paths from the configuration take
+// precedence.
+type KVSync struct {
+	Handler *Handler
+
+	// Reloader, if not nil, supplies the handler instead of Handler.
+	// Reloaded handlers get the paths at the next change; call Apply to
+	// serve them at once.
+	Reloader *Reloader
+
+	// Backend is consul or etcd.
+	Backend string
+
+	// Addr is the URL of the HTTP API, e.g. http://127.0.0.1:8500 for
+	// Consul or http://127.0.0.1:2379 for etcd.
+	Addr string
+
+	// Prefix is the prefix of the keys of the entries.
+	Prefix string
+
+	// Token, if set, authenticates the requests, as an ACL token for
+	// Consul and as an auth token for etcd.
+	Token string
+
+	// Client is used to call the backend.  If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	// Health, if not nil, gets the outcome of each change reported as its
+	// "kv" component.
+	Health *Health
+
+	// Logger receives changes and errors.  If nil, the standard logger is
+	// used.
+	Logger *log.Logger
+
+	mu      sync.Mutex
+	entries map[string]PathConfig // as of the last change
+	h       *Handler              // the paths were last applied to
+	owned   map[string]PathConfig // paths added to h
+}
+
+// Run follows the entries until ctx is done.  While they cannot be listed,
+// the paths are left as they are.
+func (s *KVSync) Run(ctx context.Context) error {
+	var index string
+	for {
+		next, err := s.sync(ctx, index)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			s.logf("kv sync: %v", err)
+		}
+		if s.Health != nil {
+			s.Health.Report("kv", err)
+		}
+		if next == "" {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(kvRetryInterval):
+			}
+			continue
+		}
+		index = next
+	}
+}
+
+// Sync lists the entries once and updates the paths.  If they cannot be
+// listed, the paths are left as they are.
+func (s *KVSync) Sync(ctx context.Context) error {
+	_, err := s.sync(ctx, "")
+	return err
+}
+
+// sync lists the entries once they changed after index, or at once if index
+// is empty, and updates the paths.  It returns the index to wait on next,
+// or the empty string if the entries could not be listed.
+func (s *KVSync) sync(ctx context.Context, index string) (string, error) {
+	var raw map[string][]byte
+	var err error
+	switch s.Backend {
+	case "consul":
+		raw, index, err = s.listConsul(ctx, index)
+	case "etcd":
+		raw, index, err = s.listEtcd(ctx, index)
+	default:
+		err = fmt.Errorf("unknown backend %q", s.Backend)
+	}
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	previous := s.entries
+	s.mu.Unlock()
+	entries := make(map[string]PathConfig)
+	var bad []string
+	for key, value := range raw {
+		name := strings.Trim(strings.TrimPrefix(key, s.Prefix), "/")
+		if name == "" || len(value) == 0 {
+			// Consul's folders.
+			continue
+		}
+		path := "/" + name
+		var e yamlPath
+		if err := yaml.UnmarshalStrict(value, &e); err != nil {
+			s.logf("kv sync: %s: %v", key, err)
+			bad = append(bad, key)
+			// Keep serving the entry as it was until it is fixed.
+			if p, ok := previous[path]; ok {
+				entries[path] = p
+			}
+			continue
+		}
+		entries[path] = e.pathConfig(path)
+	}
+	s.mu.Lock()
+	s.entries = entries
+	s.mu.Unlock()
+	if err := s.Apply(s.handler()); err != nil {
+		return index, err
+	}
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		return index, fmt.Errorf("cannot parse %s", strings.Join(bad, ", "))
+	}
+	return index, nil
+}
+
+// Apply makes h serve the entries found by the last sync, e.g. right after
+// h was loaded to replace the handler being synced.  Later changes update
+// h.
+func (s *KVSync) Apply(h *Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h != s.h {
+		s.h, s.owned = h, make(map[string]PathConfig)
+	}
+	return h.syncPaths(s.entries, s.owned, "kv sync", s.logf)
+}
+
+// listConsul returns the entries by their keys, with a blocking query if
+// index is not empty.
+func (s *KVSync) listConsul(ctx context.Context, index string) (map[string][]byte, string, error) {
+	q := url.Values{"recurse": {"true"}}
+	timeout := kvRequestTimeout
+	if index != "" {
+		q.Set("index", index)
+		q.Set("wait", kvWatchTimeout.String())
+		timeout += kvWatchTimeout + kvWatchTimeout/16
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	u := strings.TrimSuffix(s.Addr, "/") + "/v1/kv/" + s.Prefix + "?" + q.Encode()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.Token != "" {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+	resp, err := s.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	raw := make(map[string][]byte)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var kvs []struct {
+			Key   string
+			Value []byte // base64 in JSON
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&kvs); err != nil {
+			return nil, "", fmt.Errorf("consul: %v", err)
+		}
+		for _, kv := range kvs {
+			raw[kv.Key] = kv.Value
+		}
+	case http.StatusNotFound:
+		// No entries yet.
+	default:
+		return nil, "", fmt.Errorf("consul returned %s for %s", resp.Status, s.Prefix)
+	}
+	next := resp.Header.Get("X-Consul-Index")
+	if n, _ := strconv.ParseUint(next, 10, 64); n == 0 {
+		return nil, "", errors.New("consul: response without X-Consul-Index")
+	}
+	return raw, next, nil
+}
+
+// etcdKV is a key and value in the JSON API of etcd, which encodes both in
+// base64.
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// listEtcd returns the entries by their keys, once one changed after
+// revision index if it is not empty.
+func (s *KVSync) listEtcd(ctx context.Context, index string) (map[string][]byte, string, error) {
+	key, end := []byte(s.Prefix), etcdPrefixEnd([]byte(s.Prefix))
+	if index != "" {
+		if err := s.watchEtcd(ctx, key, end, index); err != nil {
+			return nil, "", err
+		}
+	}
+	var resp struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		KVs []etcdKV `json:"kvs"`
+	}
+	body := map[string]string{
+		"key":       base64.StdEncoding.EncodeToString(key),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	}
+	ctx, cancel := context.WithTimeout(ctx, kvRequestTimeout)
+	defer cancel()
+	r, err := s.postEtcd(ctx, "/v3/kv/range", body)
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, "", fmt.Errorf("etcd: %v", err)
+	}
+	rev, err := strconv.ParseInt(resp.Header.Revision, 10, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("etcd: bad revision %q", resp.Header.Revision)
+	}
+	raw := make(map[string][]byte)
+	for _, kv := range resp.KVs {
+		raw[string(kv.Key)] = kv.Value
+	}
+	return raw, strconv.FormatInt(rev+1, 10), nil
+}
+
+// watchEtcd returns once a key in [key, end) changed at or after revision
+// rev, or after kvWatchTimeout.
+func (s *KVSync) watchEtcd(ctx context.Context, key, end []byte, rev string) error {
+	wctx, cancel := context.WithTimeout(ctx, kvWatchTimeout)
+	defer cancel()
+	body := map[string]interface{}{
+		"create_request": map[string]string{
+			"key":            base64.StdEncoding.EncodeToString(key),
+			"range_end":      base64.StdEncoding.EncodeToString(end),
+			"start_revision": rev,
+		},
+	}
+	r, err := s.postEtcd(wctx, "/v3/watch", body)
+	if err != nil {
+		if ctx.Err() == nil && wctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	for {
+		var msg struct {
+			Result struct {
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() == nil && wctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("etcd watch: %v", err)
+		}
+		switch {
+		case msg.Error != nil:
+			return fmt.Errorf("etcd watch: %s", msg.Error.Message)
+		case len(msg.Result.Events) > 0:
+			return nil
+		}
+	}
+}
+
+// postEtcd calls the JSON API of etcd and returns the body of the response.
+func (s *KVSync) postEtcd(ctx context.Context, path string, body interface{}) (io.ReadCloser, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(s.Addr, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", s.Token)
+	}
+	resp, err := s.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd returned %s for %s", resp.Status, path)
+	}
+	return resp.Body, nil
+}
+
+// etcdPrefixEnd returns the end of the range of keys with prefix.
+func etcdPrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// Every key is after the prefix.
+	return []byte{0}
+}
+
+func (s *KVSync) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *KVSync) handler() *Handler {
+	if s.Reloader != nil {
+		return s.Reloader.Handler()
+	}
+	return s.Handler
+}
+
+func (s *KVSync) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
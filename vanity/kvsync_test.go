@@ -0,0 +1,230 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// kvStore is the state of a fake etcd or Consul.
+type kvStore struct {
+	mu      sync.Mutex
+	entries map[string]string
+	rev     int
+	changed chan struct{} // closed on the next change
+}
+
+func newKVStore(entries map[string]string) *kvStore {
+	return &kvStore{entries: entries, rev: 1, changed: make(chan struct{})}
+}
+
+func (st *kvStore) set(key, value string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if value == "" {
+		delete(st.entries, key)
+	} else {
+		st.entries[key] = value
+	}
+	st.rev++
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// waitPast blocks until the revision is past rev or ctx is done.
+func (st *kvStore) waitPast(ctx context.Context, rev int) {
+	for {
+		st.mu.Lock()
+		cur, changed := st.rev, st.changed
+		st.mu.Unlock()
+		if cur > rev {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (st *kvStore) consul(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/kv/vanity/paths/" || r.Header.Get("X-Consul-Token") != "token" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if index := r.URL.Query().Get("index"); index != "" {
+		n, _ := strconv.Atoi(index)
+		st.waitPast(r.Context(), n)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.Itoa(st.rev))
+	var kvs []map[string]interface{}
+	for k, v := range st.entries {
+		kvs = append(kvs, map[string]interface{}{"Key": k, "Value": []byte(v)})
+	}
+	if len(kvs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(kvs)
+}
+
+func (st *kvStore) etcd(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "token" {
+		http.Error(w, "forbidden", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Key           []byte `json:"key"`
+		RangeEnd      []byte `json:"range_end"`
+		CreateRequest *struct {
+			Key           []byte `json:"key"`
+			StartRevision string `json:"start_revision"`
+		} `json:"create_request"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		if string(req.Key) != "vanity/paths/" || string(req.RangeEnd) != "vanity/paths0" {
+			http.Error(w, "bad range", http.StatusBadRequest)
+			return
+		}
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		var kvs []string
+		for k, v := range st.entries {
+			kvs = append(kvs, fmt.Sprintf(`{"key": %q, "value": %q}`, base64.StdEncoding.EncodeToString([]byte(k)), base64.StdEncoding.EncodeToString([]byte(v))))
+		}
+		fmt.Fprintf(w, `{"header": {"revision": "%d"}, "kvs": [%s]}`, st.rev, strings.Join(kvs, ","))
+	case "/v3/watch":
+		start, _ := strconv.Atoi(req.CreateRequest.StartRevision)
+		fmt.Fprint(w, `{"result": {"created": true}}`)
+		w.(http.Flusher).Flush()
+		st.waitPast(r.Context(), start-1)
+		fmt.Fprint(w, `{"result": {"events": [{"type": "PUT"}]}}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestKVSync(t *testing.T) {
+	for _, backend := range []string{"consul", "etcd"} {
+		st := newKVStore(map[string]string{
+			"vanity/paths/":      "",
+			"vanity/paths/tools": "repo: https://github.com/example/tools\n",
+			"vanity/paths/lib":   `{"repo": "https://github.com/example/lib-v2"}`,
+		})
+		srv := httptest.NewServer(http.HandlerFunc(st.consul))
+		if backend == "etcd" {
+			srv = httptest.NewServer(http.HandlerFunc(st.etcd))
+		}
+		h, err := NewHandler([]byte("host: example.com\n" +
+			"paths:\n" +
+			"  /lib:\n" +
+			"    repo: https://github.com/example/lib-v1\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &KVSync{
+			Handler: h,
+			Backend: backend,
+			Addr:    srv.URL,
+			Prefix:  "vanity/paths/",
+			Token:   "token",
+			Logger:  log.New(ioutil.Discard, "", 0),
+		}
+		served := func() string {
+			var paths []string
+			for _, p := range h.Paths() {
+				paths = append(paths, p.Path+"="+p.Repo)
+			}
+			return strings.Join(paths, " ")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- s.Run(ctx) }()
+		waitFor := func(want string) {
+			t.Helper()
+			for deadline := time.Now().Add(5 * time.Second); served() != want; {
+				if time.Now().After(deadline) {
+					t.Fatalf("%s: paths = %s; want %s", backend, served(), want)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+		// The configured /lib is kept.
+		waitFor("/lib=https://github.com/example/lib-v1 /tools=https://github.com/example/tools")
+		st.set("vanity/paths/site", "repo: https://github.com/example/site\n")
+		waitFor("/lib=https://github.com/example/lib-v1 /site=https://github.com/example/site /tools=https://github.com/example/tools")
+		st.set("vanity/paths/tools", "")
+		waitFor("/lib=https://github.com/example/lib-v1 /site=https://github.com/example/site")
+
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("%s: Run = %v; want context.Canceled", backend, err)
+		}
+		s.Token = "wrong"
+		if err := s.Sync(context.Background()); err == nil {
+			t.Errorf("%s: Sync with a rejected token succeeded", backend)
+		}
+		srv.Close()
+	}
+}
+
+func TestKVSyncBadEntry(t *testing.T) {
+	st := newKVStore(map[string]string{
+		"vanity/paths/tools": "repo: https://github.com/example/tools\n",
+		"vanity/paths/typo":  "rpeo: https://github.com/example/typo\n",
+	})
+	srv := httptest.NewServer(http.HandlerFunc(st.consul))
+	defer srv.Close()
+	h, err := NewHandler([]byte("host: example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &KVSync{Handler: h, Backend: "consul", Addr: srv.URL, Prefix: "vanity/paths/", Token: "token", Logger: log.New(ioutil.Discard, "", 0)}
+	if err := s.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "vanity/paths/typo") {
+		t.Errorf("Sync = %v; want an error about vanity/paths/typo", err)
+	}
+	if paths := h.Paths(); len(paths) != 1 || paths[0].Path != "/tools" {
+		t.Errorf("paths = %+v; want /tools only", paths)
+	}
+
+	// A valid entry changed to one that does not parse is still served.
+	st.set("vanity/paths/tools", "repo: [\n")
+	if err := s.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "vanity/paths/tools") {
+		t.Errorf("Sync after breaking vanity/paths/tools = %v; want an error about it", err)
+	}
+	if paths := h.Paths(); len(paths) != 1 || paths[0].Repo != "https://github.com/example/tools" {
+		t.Errorf("paths after breaking vanity/paths/tools = %+v; want /tools as before", paths)
+	}
+}
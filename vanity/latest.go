@@ -0,0 +1,173 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProxyURL = "https://proxy.golang.org"
+	latestTTL       = 5 * time.Minute
+
+	// maxMajor bounds the major versions probed for a module.
+	maxMajor = 100
+)
+
+var errModuleNotFound = errors.New("module not found")
+
+// moduleVersion is a version reported by a module proxy's @latest endpoint.
+type moduleVersion struct {
+	Version string
+	Time    time.Time
+}
+
+// latestCache looks up the latest version of modules from a module proxy and
+// remembers the answers, including that there is none, for latestTTL.
+type latestCache struct {
+	proxy   string
+	client  *http.Client
+	now     func() time.Time
+	timeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]latestEntry
+}
+
+type latestEntry struct {
+	v       moduleVersion
+	err     error // errModuleNotFound or nil
+	fetched time.Time
+}
+
+func newLatestCache(proxy string) *latestCache {
+	return &latestCache{
+		proxy:   strings.TrimSuffix(proxy, "/"),
+		client:  http.DefaultClient,
+		now:     time.Now,
+		timeout: defaultUpstreamTimeout,
+		entries: make(map[string]latestEntry),
+	}
+}
+
+func (c *latestCache) lookup(ctx context.Context, modPath string) (moduleVersion, error) {
+	c.mu.Lock()
+	e, ok := c.entries[modPath]
+	c.mu.Unlock()
+	if ok && c.now().Sub(e.fetched) < latestTTL {
+		return e.v, e.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", c.proxy+"/"+escapeModulePath(modPath)+"/@latest", nil)
+	if err != nil {
+		return moduleVersion{}, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return moduleVersion{}, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		c.mu.Lock()
+		c.entries[modPath] = latestEntry{err: errModuleNotFound, fetched: c.now()}
+		c.mu.Unlock()
+		return moduleVersion{}, errModuleNotFound
+	default:
+		return moduleVersion{}, fmt.Errorf("%s@latest: proxy returned %s", modPath, resp.Status)
+	}
+	var v moduleVersion
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return moduleVersion{}, fmt.Errorf("%s@latest: %v", modPath, err)
+	}
+	c.mu.Lock()
+	c.entries[modPath] = latestEntry{v: v, fetched: c.now()}
+	c.mu.Unlock()
+	return v, nil
+}
+
+// majors returns the major versions above v1, such as v2, of modPath that
+// the module proxy knows, trying them in order until one is missing.
+func (c *latestCache) majors(ctx context.Context, modPath string) []string {
+	var vs []string
+	for n := 2; n <= maxMajor; n++ {
+		v := "v" + strconv.Itoa(n)
+		if _, err := c.lookup(ctx, modPath+"/"+v); err != nil {
+			break
+		}
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+// escapeModulePath encodes modPath for use in a module proxy URL by replacing
+// every upper-case letter with an exclamation mark followed by its lower-case
+// equivalent.
+func escapeModulePath(modPath string) string {
+	var b strings.Builder
+	for _, r := range modPath {
+		if 'A' <= r && r <= 'Z' {
+			b.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// serveLatest serves /api/v1/paths/{path}/latest.
+func (h *Handler) serveLatest(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/paths"), "/latest")
+	pc, subpath := h.findPath(p)
+	if pc == nil || subpath != "" {
+		http.NotFound(w, r)
+		return
+	}
+	modPath := h.Host(r) + pc.path
+	v, err := h.latest.lookup(r.Context(), modPath)
+	if err == errModuleNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "cannot look up latest version", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Path    string    `json:"path"`
+		Version string    `json:"version"`
+		Time    time.Time `json:"time"`
+	}{
+		Path:    modPath,
+		Version: v.Version,
+		Time:    v.Time,
+	})
+}
+
+func isLatestRequest(p string) bool {
+	return strings.HasPrefix(p, "/api/v1/paths/") && strings.HasSuffix(p, "/latest")
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeLatest(t *testing.T) {
+	hits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path != "/example.com/!port!midi/@latest" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"Version":"v1.2.3","Time":"2019-01-02T03:04:05Z"}`)
+	}))
+	defer upstream.Close()
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /PortMidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"proxy:\n" +
+		"  upstream: " + upstream.URL + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(s.URL + "/api/v1/paths/PortMidi/latest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Path    string `json:"path"`
+			Version string `json:"version"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Path != "example.com/PortMidi" || got.Version != "v1.2.3" {
+			t.Errorf("latest = %+v; want example.com/PortMidi v1.2.3", got)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("upstream requests = %d; want 1", hits)
+	}
+
+	resp, err := http.Get(s.URL + "/api/v1/paths/unknown/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown path: status code = %s; want 404 Not Found", resp.Status)
+	}
+}
+
+func TestMajorVersions(t *testing.T) {
+	hits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch r.URL.Path {
+		case "/example.com/portmidi/v2/@latest", "/example.com/portmidi/v3/@latest":
+			io.WriteString(w, `{"Version":"v3.0.0","Time":"2019-01-02T03:04:05Z"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"  /other:\n" +
+		"    repo: https://github.com/example/other\n" +
+		"proxy:\n" +
+		"  upstream: " + upstream.URL + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+	get := func(path string) string {
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	const v2, v3 = `href="https://godoc.org/example.com/portmidi/v2"`, `href="https://godoc.org/example.com/portmidi/v3"`
+	for _, path := range []string{"/portmidi", "/portmidi/v3/sub", "/"} {
+		body := get(path)
+		if !strings.Contains(body, v2) || !strings.Contains(body, v3) || strings.Contains(body, "portmidi/v4") {
+			t.Errorf("%s does not link to exactly v2 and v3:\n%s", path, body)
+		}
+		if strings.Contains(body, "other/v") {
+			t.Errorf("%s links to major versions of /other:\n%s", path, body)
+		}
+	}
+	if body := get("/portmidi?go-get=1"); strings.Contains(body, v2) {
+		t.Errorf("go get page links to major versions:\n%s", body)
+	}
+	// portmidi v2, v3 and v4 and other v2, each asked for once.
+	if hits != 4 {
+		t.Errorf("upstream requests = %d; want 4", hits)
+	}
+}
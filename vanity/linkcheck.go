@@ -0,0 +1,340 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultLinkCheckInterval = 6 * time.Hour
+
+// LinkCheck is the result of checking the repository of a path.
+type LinkCheck struct {
+	Path string `json:"path"`
+	Repo string `json:"repo"`
+	OK   bool   `json:"ok"`
+
+	// Archived and RenamedTo are only known for GitHub repositories.
+	// Archived repositories still work but no longer get changes; renamed
+	// ones work through a redirect that breaks once the old name is reused.
+	Archived  bool   `json:"archived,omitempty"`
+	RenamedTo string `json:"renamed_to,omitempty"`
+
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// Broken reports whether the repository needs attention.
+func (c LinkCheck) Broken() bool {
+	return !c.OK || c.Archived || c.RenamedTo != ""
+}
+
+// A LinkChecker periodically checks that the repositories of the paths a
+// handler serves are reachable, so that dead paths are found before users
+// run into them.  Its ServeHTTP method reports the latest results as JSON.
+type LinkChecker struct {
+	Handler *Handler
+
+	// Reloader, if not nil, supplies the handler instead of Handler, so
+	// that checks follow reloads of the configuration.
+	Reloader *Reloader
+
+	// Interval is the time between checks.  Defaults to 6 hours.
+	Interval time.Duration
+
+	// Client is used for checks.  If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// GitHubToken, if set, authenticates GitHub API requests, which are
+	// rate limited to 60 an hour otherwise.
+	GitHubToken string
+
+	// Active, if not nil, is consulted before each round of checks, which is
+	// skipped unless it returns true.  With several replicas, it lets only
+	// the leader check.
+	Active func() bool
+
+	// Health, if not nil, gets the outcome of each round reported as its
+	// "links" component, which is degraded while any repository is broken.
+	Health *Health
+
+	// Logger receives broken repositories.  If nil, the standard logger is
+	// used.
+	Logger *log.Logger
+
+	githubAPI string // for tests
+
+	mu      sync.Mutex
+	results []LinkCheck
+}
+
+// Run checks the repositories every Interval until ctx is done.
+func (lc *LinkChecker) Run(ctx context.Context) error {
+	interval := lc.Interval
+	if interval <= 0 {
+		interval = defaultLinkCheckInterval
+	}
+	for {
+		if lc.Active == nil || lc.Active() {
+			lc.CheckAll(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// CheckAll checks the repositories of all paths once and returns the
+// results, which are also kept for ServeHTTP.
+func (lc *LinkChecker) CheckAll(ctx context.Context) []LinkCheck {
+	var results []LinkCheck
+	broken := 0
+	h := lc.handler()
+	for _, hh := range h.handlers() {
+		for _, pc := range hh.pathSet().configured() {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if pc.retired {
+				continue
+			}
+			c := lc.check(ctx, pc)
+			if hh != h {
+				// Paths of other hosts are told apart by their host.
+				c.Path = hh.host + c.Path
+			}
+			if c.Broken() {
+				broken++
+				lc.logf("link check: %s: %s", c.Path, c.Problem())
+			}
+			results = append(results, c)
+		}
+	}
+	lc.mu.Lock()
+	lc.results = results
+	lc.mu.Unlock()
+	if lc.Health != nil {
+		var err error
+		if broken > 0 {
+			err = fmt.Errorf("%d of %d repositories broken", broken, len(results))
+		}
+		lc.Health.Report("links", err)
+	}
+	return results
+}
+
+// Results returns the results of the latest round of checks.
+func (lc *LinkChecker) Results() []LinkCheck {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return append([]LinkCheck(nil), lc.results...)
+}
+
+// ServeHTTP reports the latest results, broken repositories first.
+func (lc *LinkChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	results := lc.Results()
+	report := struct {
+		Checked int         `json:"checked"`
+		Broken  int         `json:"broken"`
+		Results []LinkCheck `json:"results"`
+	}{Checked: len(results), Results: make([]LinkCheck, 0, len(results))}
+	for _, c := range results {
+		if c.Broken() {
+			report.Broken++
+			report.Results = append(report.Results, c)
+		}
+	}
+	for _, c := range results {
+		if !c.Broken() {
+			report.Results = append(report.Results, c)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+// Problem describes what needs attention about a broken repository.
+func (c LinkCheck) Problem() string {
+	switch {
+	case !c.OK:
+		return c.Error
+	case c.Archived:
+		return c.Repo + " is archived"
+	default:
+		return c.Repo + " was renamed to " + c.RenamedTo
+	}
+}
+
+func (lc *LinkChecker) check(ctx context.Context, pc pathConfig) LinkCheck {
+	c := LinkCheck{Path: pc.path, Repo: pc.repo, Checked: time.Now().UTC()}
+	var err error
+	switch ownerRepo := githubRepo(pc.repo); {
+	case ownerRepo != "":
+		err = lc.checkGitHub(ctx, ownerRepo, &c)
+	case strings.HasPrefix(pc.repo, "https://") || strings.HasPrefix(pc.repo, "http://"):
+		err = lc.checkURL(ctx, pc)
+	case pc.vcs == "git" && checkRemote(pc.repo) == nil && gitInstalled():
+		err = lc.lsRemote(ctx, pc.repo)
+	default:
+		c.OK = true // cannot check other schemes
+		return c
+	}
+	c.OK = err == nil
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// githubRepo returns owner/repo for a GitHub repository URL, or the empty
+// string.
+func githubRepo(repo string) string {
+	ownerRepo := strings.TrimSuffix(strings.TrimPrefix(repo, "https://github.com/"), ".git")
+	if ownerRepo == repo || strings.Count(ownerRepo, "/") != 1 {
+		return ""
+	}
+	return ownerRepo
+}
+
+func (lc *LinkChecker) handler() *Handler {
+	if lc.Reloader != nil {
+		return lc.Reloader.Handler()
+	}
+	return lc.Handler
+}
+
+func (lc *LinkChecker) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, lc.handler().timeout)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	client := lc.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelCloser{resp.Body, cancel}
+	return resp, nil
+}
+
+func (lc *LinkChecker) checkGitHub(ctx context.Context, ownerRepo string, c *LinkCheck) error {
+	api := lc.githubAPI
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	header := http.Header{"Accept": {"application/vnd.github+json"}}
+	if lc.GitHubToken != "" {
+		header.Set("Authorization", "Bearer "+lc.GitHubToken)
+	}
+	resp, err := lc.get(ctx, api+"/repos/"+ownerRepo, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub API returned %s for %s", resp.Status, ownerRepo)
+	}
+	var repo struct {
+		FullName string `json:"full_name"`
+		Archived bool   `json:"archived"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repo); err != nil {
+		return fmt.Errorf("GitHub API: %v", err)
+	}
+	c.Archived = repo.Archived
+	if repo.FullName != "" && !strings.EqualFold(repo.FullName, ownerRepo) {
+		c.RenamedTo = "https://github.com/" + repo.FullName
+	}
+	return nil
+}
+
+// checkURL checks a repository that is not on GitHub by asking for its
+// references the way the VCS tool does, a module proxy for the versions of
+// the module, or fetching the repository URL for other VCSes.
+func (lc *LinkChecker) checkURL(ctx context.Context, pc pathConfig) error {
+	u := pc.repo
+	switch h := lc.handler(); {
+	case pc.vcs == "git":
+		u = strings.TrimSuffix(u, "/") + "/info/refs?service=git-upload-pack"
+	case pc.vcs == "mod" && h.host != "":
+		u = strings.TrimSuffix(u, "/") + "/" + escapeModulePath(h.host+h.prefix+pc.path) + "/@v/list"
+	}
+	resp, err := lc.get(ctx, u, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	return nil
+}
+
+// lsRemote checks a Git repository reached over another scheme, such as
+// ssh, by listing its branches with git.
+func (lc *LinkChecker) lsRemote(ctx context.Context, repo string) error {
+	ctx, cancel := context.WithTimeout(ctx, lc.handler().timeout)
+	defer cancel()
+	_, err := runGit(ctx, "", "ls-remote", "--heads", "--", repo)
+	return err
+}
+
+func gitInstalled() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+func (lc *LinkChecker) logf(format string, args ...interface{}) {
+	if lc.Logger != nil {
+		lc.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// cancelCloser cancels the context of a response when its body is closed.
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
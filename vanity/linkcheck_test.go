@@ -0,0 +1,166 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkChecker(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RequestURI() {
+		case "/repos/example/ok":
+			io.WriteString(w, `{"full_name":"example/ok","archived":false}`)
+		case "/repos/example/Old":
+			io.WriteString(w, `{"full_name":"example/old","archived":true}`)
+		case "/repos/example/moved":
+			io.WriteString(w, `{"full_name":"newowner/moved"}`)
+		case "/git/ok/info/refs?service=git-upload-pack":
+			io.WriteString(w, "001e# service=git-upload-pack\n")
+		case "/athens/example.com/!internal/@v/list":
+			io.WriteString(w, "v1.0.0\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /ok:\n" +
+		"    repo: https://github.com/example/ok\n" +
+		"  /old:\n" +
+		"    repo: https://github.com/example/Old\n" +
+		"  /moved:\n" +
+		"    repo: https://github.com/example/moved\n" +
+		"  /deleted:\n" +
+		"    repo: https://github.com/example/deleted\n" +
+		"  /self:\n" +
+		"    repo: " + srv.URL + "/git/ok\n" +
+		"    vcs: git\n" +
+		"  /gone:\n" +
+		"    repo: " + srv.URL + "/git/gone\n" +
+		"    vcs: git\n" +
+		"  /Internal:\n" +
+		"    repo: " + srv.URL + "/athens\n" +
+		"    vcs: mod\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := new(Health)
+	lc := &LinkChecker{
+		Handler:   h,
+		Client:    srv.Client(),
+		Health:    health,
+		Logger:    log.New(ioutil.Discard, "", 0),
+		githubAPI: srv.URL,
+	}
+	lc.CheckAll(context.Background())
+
+	want := map[string]struct {
+		ok        bool
+		archived  bool
+		renamedTo string
+	}{
+		"/ok":       {true, false, ""},
+		"/old":      {true, true, ""},
+		"/moved":    {true, false, "https://github.com/newowner/moved"},
+		"/deleted":  {false, false, ""},
+		"/self":     {true, false, ""},
+		"/gone":     {false, false, ""},
+		"/Internal": {true, false, ""},
+	}
+	results := lc.Results()
+	if len(results) != len(want) {
+		t.Fatalf("got %d results; want %d", len(results), len(want))
+	}
+	for _, c := range results {
+		w := want[c.Path]
+		if c.OK != w.ok || c.Archived != w.archived || c.RenamedTo != w.renamedTo {
+			t.Errorf("%s: %+v; want ok=%v archived=%v renamed_to=%q", c.Path, c, w.ok, w.archived, w.renamedTo)
+		}
+	}
+	if st := health.Components()["links"]; st.OK || st.Error != "4 of 7 repositories broken" {
+		t.Errorf("links component = %+v", st)
+	}
+
+	w := httptest.NewRecorder()
+	lc.ServeHTTP(w, httptest.NewRequest("GET", "/admin/links", nil))
+	var report struct {
+		Checked int
+		Broken  int
+		Results []LinkCheck
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 7 || report.Broken != 4 || !report.Results[0].Broken() || report.Results[6].Broken() {
+		t.Errorf("report = %+v; want broken repositories first", report)
+	}
+}
+
+func TestLinkCheckerInactive(t *testing.T) {
+	h, err := NewHandler([]byte("paths:\n  /ok:\n    repo: https://github.com/example/ok\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := &LinkChecker{Handler: h, Active: func() bool { return false }}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lc.Run(ctx)
+	if len(lc.Results()) != 0 {
+		t.Error("inactive checker checked")
+	}
+}
+
+func TestLinkCheckerLsRemote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linkcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	os.Mkdir(repo, 0755)
+	gitRepo(t, repo, []string{"v1.0.0"}, map[string]map[string]string{
+		"v1.0.0": {"go.mod": "module example.com/repo\n"},
+	})
+
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /repo:\n" +
+		"    repo: file://" + filepath.ToSlash(repo) + "\n" +
+		"    vcs: git\n" +
+		"  /missing:\n" +
+		"    repo: file://" + filepath.ToSlash(dir) + "/missing\n" +
+		"    vcs: git\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := &LinkChecker{Handler: h, Logger: log.New(ioutil.Discard, "", 0)}
+	for _, c := range lc.CheckAll(context.Background()) {
+		if want := c.Path == "/repo"; c.OK != want {
+			t.Errorf("%s: ok = %v (%s); want %v", c.Path, c.OK, c.Error, want)
+		}
+	}
+}
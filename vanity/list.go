@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const listPath = "/api/v1/list"
+
+// pathList is the body of a list response.
+type pathList struct {
+	Host  string       `json:"host"`
+	Paths []listedPath `json:"paths"`
+}
+
+// listedPath describes a configured path for tools that catalog the
+// modules, with the values the go command is served.
+type listedPath struct {
+	ImportPath  string   `json:"import_path"`
+	Repo        string   `json:"repo,omitempty"`
+	VCS         string   `json:"vcs,omitempty"`
+	Subdir      string   `json:"subdir,omitempty"`
+	Tool        bool     `json:"tool,omitempty"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Retired     bool     `json:"retired,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+}
+
+// serveList serves the configured paths of the host as JSON, with an ETag
+// so that pollers can ask whether they changed.
+func (h *Handler) serveList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	host := h.Host(r)
+	list := pathList{Host: host, Paths: []listedPath{}}
+	for _, pc := range h.pathSet().configured() {
+		p := listedPath{
+			ImportPath:  host + pc.path,
+			Repo:        pc.repo,
+			VCS:         pc.vcs,
+			Subdir:      pc.subdir,
+			Tool:        pc.tool,
+			Retired:     pc.retired,
+			Description: pc.description,
+			Tags:        pc.tags,
+			Owner:       pc.owner,
+		}
+		for _, alias := range pc.aliases {
+			p.Aliases = append(p.Aliases, host+alias)
+		}
+		list.Paths = append(list.Paths, p)
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(list); err != nil {
+		h.logf("encoding path list: %v", err)
+		http.Error(w, "cannot encode paths", http.StatusInternalServerError)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(buf.Bytes()))
+	w.Header().Set("ETag", etag)
+	setCacheControl(w, h.defaults.cacheMaxAge)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
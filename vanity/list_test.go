@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServeList(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"    aliases: [/midi]\n" +
+		"    description: Go bindings for PortMidi\n" +
+		"    tags: [audio]\n" +
+		"    owner: team-sound\n" +
+		"  /oldlib:\n" +
+		"    retired: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/list", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", w.Code)
+	}
+	var list pathList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	want := pathList{
+		Host: "example.com",
+		Paths: []listedPath{
+			{ImportPath: "example.com/oldlib", Retired: true},
+			{
+				ImportPath:  "example.com/portmidi",
+				Repo:        "https://github.com/rakyll/portmidi",
+				VCS:         "git",
+				Aliases:     []string{"example.com/midi"},
+				Description: "Go bindings for PortMidi",
+				Tags:        []string{"audio"},
+				Owner:       "team-sound",
+			},
+		},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("list = %+v; want %+v", list, want)
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	r := httptest.NewRequest("GET", "/api/v1/list", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("with the ETag: %d %q; want 304", w.Code, w.Body)
+	}
+
+	h.AddPath(PathConfig{Path: "/foo", Repo: "https://github.com/example/foo"})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("after a change: %d, ETag %s; want 200 and a new ETag", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/list", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d; want 405", w.Code)
+	}
+}
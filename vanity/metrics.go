@@ -0,0 +1,256 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds of the request duration histogram,
+// in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics counts the requests a handler serves and reports them, with the
+// state of the components watching it, in the Prometheus text format.
+// Requests are told apart by the route that served them: the configured path
+// or path rule pattern they matched, or one of index, search, proxy, sumdb,
+// api, badge and other.
+type Metrics struct {
+	Handler *Handler
+
+	// Reloader, if not nil, supplies the handler instead of Handler, and
+	// its reloads are counted.
+	Reloader *Reloader
+
+	// Each of these, if not nil, has its statistics reported too.
+	Shadow      *Shadow
+	LinkChecker *LinkChecker
+	CertMonitor *CertMonitor
+
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[string]*histogram // by route
+}
+
+type requestKey struct {
+	route string
+	code  int
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Middleware is a Middleware that counts and times requests.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := "other"
+		if h := m.handler(); h != nil {
+			route = h.route(r)
+		}
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		m.observe(route, sw.code, time.Since(start))
+	})
+}
+
+func (m *Metrics) observe(route string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests == nil {
+		m.requests = make(map[requestKey]uint64)
+		m.durations = make(map[string]*histogram)
+	}
+	m.requests[requestKey{route, code}]++
+	hist := m.durations[route]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[route] = hist
+	}
+	secs := d.Seconds()
+	for i, le := range durationBuckets {
+		if secs <= le {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.sum += secs
+	hist.count++
+}
+
+func (m *Metrics) handler() *Handler {
+	if m.Reloader != nil {
+		return m.Reloader.Handler()
+	}
+	return m.Handler
+}
+
+// ServeHTTP reports the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	m.mu.Lock()
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].code < keys[j].code
+	})
+	metricHeader(bw, "govanityurls_requests_total", "counter", "Requests served, by route and status code.")
+	for _, k := range keys {
+		fmt.Fprintf(bw, "govanityurls_requests_total{path=%s,code=\"%d\"} %d\n", quoteLabel(k.route), k.code, m.requests[k])
+	}
+	routes := make([]string, 0, len(m.durations))
+	for route := range m.durations {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	metricHeader(bw, "govanityurls_request_duration_seconds", "histogram", "Time taken to serve requests, by route.")
+	for _, route := range routes {
+		hist := m.durations[route]
+		label := quoteLabel(route)
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(bw, "govanityurls_request_duration_seconds_bucket{path=%s,le=\"%s\"} %d\n", label, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(bw, "govanityurls_request_duration_seconds_bucket{path=%s,le=\"+Inf\"} %d\n", label, hist.count)
+		fmt.Fprintf(bw, "govanityurls_request_duration_seconds_sum{path=%s} %s\n", label, formatFloat(hist.sum))
+		fmt.Fprintf(bw, "govanityurls_request_duration_seconds_count{path=%s} %d\n", label, hist.count)
+	}
+	m.mu.Unlock()
+
+	if h := m.handler(); h != nil {
+		paths := 0
+		for _, hh := range h.handlers() {
+			paths += len(hh.pathSet())
+		}
+		metricHeader(bw, "govanityurls_paths", "gauge", "Paths configured, across all hosts.")
+		fmt.Fprintf(bw, "govanityurls_paths %d\n", paths)
+		metricHeader(bw, "govanityurls_panics_total", "counter", "Requests that panicked.")
+		fmt.Fprintf(bw, "govanityurls_panics_total %d\n", h.Panics())
+	}
+	if m.Reloader != nil {
+		s := m.Reloader.Stats()
+		metricHeader(bw, "govanityurls_config_reloads_total", "counter", "Reloads of the configuration file, by result.")
+		fmt.Fprintf(bw, "govanityurls_config_reloads_total{result=\"success\"} %d\n", s.Succeeded)
+		fmt.Fprintf(bw, "govanityurls_config_reloads_total{result=\"failure\"} %d\n", s.Failed)
+	}
+	if m.Shadow != nil {
+		s := m.Shadow.Stats()
+		metricHeader(bw, "govanityurls_shadow_compared_total", "counter", "Requests compared with the candidate configuration.")
+		fmt.Fprintf(bw, "govanityurls_shadow_compared_total %d\n", s.Compared)
+		metricHeader(bw, "govanityurls_shadow_diverged_total", "counter", "Compared requests that the candidate configuration answered differently.")
+		fmt.Fprintf(bw, "govanityurls_shadow_diverged_total %d\n", s.Diverged)
+	}
+	if m.LinkChecker != nil {
+		results := m.LinkChecker.Results()
+		broken := 0
+		for _, c := range results {
+			if c.Broken() {
+				broken++
+			}
+		}
+		metricHeader(bw, "govanityurls_links_checked", "gauge", "Repositories checked in the latest round of link checks.")
+		fmt.Fprintf(bw, "govanityurls_links_checked %d\n", len(results))
+		metricHeader(bw, "govanityurls_links_broken", "gauge", "Repositories found broken in the latest round of link checks.")
+		fmt.Fprintf(bw, "govanityurls_links_broken %d\n", broken)
+	}
+	if m.CertMonitor != nil {
+		if t := m.CertMonitor.NotAfter(); !t.IsZero() {
+			metricHeader(bw, "govanityurls_tls_cert_not_after_seconds", "gauge", "Expiry of the first serving certificate to expire, in seconds since the epoch.")
+			fmt.Fprintf(bw, "govanityurls_tls_cert_not_after_seconds %d\n", t.Unix())
+		}
+	}
+}
+
+// route names the part of h that serves r, for metrics.
+func (h *Handler) route(r *http.Request) string {
+	p, ok := h.unprefixed(r.URL.Path)
+	switch {
+	case !ok:
+		return "other"
+	case h.sumdb != nil && strings.HasPrefix(p, h.sumdb.prefix()):
+		return "sumdb"
+	case h.proxies() && isProxyRequest(p):
+		return "proxy"
+	}
+	if hh := h.hosts[requestHost(r)]; hh != nil {
+		route := hh.routeOf(p)
+		if strings.HasPrefix(route, "/") {
+			return hh.host + route
+		}
+		return route
+	}
+	return h.routeOf(p)
+}
+
+// routeOf names the part of h that serves path, once the proxies and hosts
+// are ruled out.
+func (h *Handler) routeOf(path string) string {
+	if isLatestRequest(path) || (h.export && path == exportPath) || path == listPath {
+		return "api"
+	}
+	if isBadgeRequest(path) {
+		return "badge"
+	}
+	if pc, _ := h.findPath(path); pc != nil {
+		return pc.path
+	}
+	for i := range h.rules {
+		if pc, _ := h.rules[i].match(path); pc != nil {
+			return h.rules[i].pattern
+		}
+	}
+	if path == "/" {
+		return "index"
+	}
+	if path == searchPath {
+		return "search"
+	}
+	return "other"
+}
+
+func metricHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// quoteLabel quotes a label value as the text format requires.
+func quoteLabel(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	v = strings.Replace(v, "\n", `\n`, -1)
+	return `"` + v + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := new(Metrics)
+	h, err := NewHandler([]byte("host: go.example.com\n"+
+		"paths:\n"+
+		"  /portmidi:\n"+
+		"    repo: https://github.com/rakyll/portmidi\n"+
+		"pathrules:\n"+
+		"  /x/{name}:\n"+
+		"    repo: https://github.com/x/{name}\n"+
+		"hosts:\n"+
+		"  go.corp-a.com:\n"+
+		"    paths:\n"+
+		"      /tools:\n"+
+		"        repo: https://github.com/corp-a/tools\n"),
+		WithMiddleware(m.Middleware))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Handler = h
+	for _, req := range []struct{ host, path string }{
+		{"go.example.com", "/portmidi?go-get=1"},
+		{"go.example.com", "/portmidi/sub"},
+		{"go.example.com", "/x/a"},
+		{"go.example.com", "/x/b/c"},
+		{"go.example.com", "/"},
+		{"go.example.com", "/nope"},
+		{"go.corp-a.com", "/tools"},
+		{"go.example.com", "/portmidi/@v/list"},
+	} {
+		r := httptest.NewRequest("GET", req.path, nil)
+		r.Host = req.host
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`govanityurls_requests_total{path="/portmidi",code="200"} 3`,
+		`govanityurls_requests_total{path="/x/{name}",code="200"} 2`,
+		`govanityurls_requests_total{path="index",code="200"} 1`,
+		`govanityurls_requests_total{path="other",code="404"} 1`,
+		`govanityurls_requests_total{path="go.corp-a.com/tools",code="200"} 1`,
+		`govanityurls_request_duration_seconds_bucket{path="/portmidi",le="+Inf"} 3`,
+		`govanityurls_request_duration_seconds_count{path="index"} 1`,
+		"govanityurls_paths 2\n",
+		"govanityurls_panics_total 0\n",
+		"# TYPE govanityurls_request_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "govanityurls_config_reloads_total") {
+		t.Errorf("reloads reported without a reloader:\n%s", body)
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/portmidi", `"/portmidi"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"a\nb", `"a\nb"`},
+	}
+	for _, test := range tests {
+		if got := quoteLabel(test.in); got != test.want {
+			t.Errorf("%s: quoteLabel = %s; want %s", test.in, got, test.want)
+		}
+	}
+}
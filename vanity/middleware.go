@@ -0,0 +1,122 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+)
+
+// Middleware wraps the handling of a request, for example to check
+// authorization or to log it.
+type Middleware func(http.Handler) http.Handler
+
+// A ResolveHook is called once a request has been matched to a repository,
+// before the page is rendered.  It may set response headers or change the
+// Repo, VCS and Display of res.  If it writes a response itself, it must
+// return false to stop the handler from rendering the page.
+type ResolveHook func(w http.ResponseWriter, r *http.Request, res *Resolution) bool
+
+// wrap applies the middleware to handler, so that the first element of mw
+// sees the request first.
+func wrap(handler http.Handler, mw []Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		handler = mw[i](handler)
+	}
+	return handler
+}
+
+// headerMiddleware adds header to every response.
+func headerMiddleware(header map[string]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range header {
+				w.Header().Set(k, v)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// afterResolve runs the handler's resolve hooks on pc.  It returns the
+// configuration to render, or nil if a hook has already responded.
+func (h *Handler) afterResolve(w http.ResponseWriter, r *http.Request, pc *pathConfig) *pathConfig {
+	if len(h.AfterResolve) == 0 {
+		return pc
+	}
+	res := &Resolution{Path: pc.path, Repo: pc.repo, VCS: pc.vcs, Display: pc.display}
+	for _, hook := range h.AfterResolve {
+		if !hook(w, r, res) {
+			return nil
+		}
+	}
+	// pc may be shared with other requests; change a copy.
+	c := *pc
+	c.repo, c.vcs, c.display = res.Repo, res.VCS, res.Display
+	return &c
+}
+
+func validHeaderName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " :\r\n")
+}
+
+// recover turns a panic while serving r into a 500 Internal Server Error
+// response, so that a bug triggered by one request is logged with the request
+// and counted instead of breaking the connection.  It must be deferred.
+func (h *Handler) recover(w *recoverWriter, r *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	atomic.AddUint64(&h.panics, 1)
+	h.logf("panic serving %s %s for %s: %v\n%s", r.Method, r.URL.RequestURI(), r.RemoteAddr, v, debug.Stack())
+	if !w.wroteHeader {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// Panics returns the number of requests that panicked.
+func (h *Handler) Panics() uint64 {
+	return atomic.LoadUint64(&h.panics)
+}
+
+// recoverWriter remembers whether a response has been started.
+type recoverWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoverWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recoverWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher if the underlying writer does.
+func (w *recoverWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
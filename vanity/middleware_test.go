@@ -0,0 +1,125 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"headers:\n" +
+		"  X-Frame-Options: DENY\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"  /secret:\n" +
+		"    repo: https://github.com/example/secret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h.Middleware = []Middleware{mw("outer"), mw("inner")}
+	h.AfterResolve = []ResolveHook{
+		func(w http.ResponseWriter, r *http.Request, res *Resolution) bool {
+			if strings.HasPrefix(res.Path, "/secret") {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return false
+			}
+			res.Repo = "https://mirror.example.com/portmidi"
+			return true
+		},
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/portmidi", nil))
+	if got := strings.Join(order, ","); got != "outer,inner" {
+		t.Errorf("middleware order = %s; want outer,inner", got)
+	}
+	if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("X-Frame-Options = %q; want DENY", got)
+	}
+	if got, want := findMeta(w.Body.Bytes(), "go-import"), "example.com/portmidi git https://mirror.example.com/portmidi"; got != want {
+		t.Errorf("go-import = %q; want %q", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/secret", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("/secret: status = %d; want 403", w.Code)
+	}
+	if findMeta(w.Body.Bytes(), "go-import") != "" {
+		t.Errorf("/secret: page rendered after hook stopped it")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := NewHandler([]byte("host: example.com\n"+
+		"paths:\n"+
+		"  /portmidi:\n"+
+		"    repo: https://github.com/rakyll/portmidi\n"),
+		WithLogger(log.New(&buf, "", 0)),
+		WithMiddleware(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/boom":
+					panic("template bug")
+				case "/late":
+					w.WriteHeader(http.StatusTeapot)
+					panic("after writing")
+				}
+				next.ServeHTTP(w, r)
+			})
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/boom", http.StatusInternalServerError},
+		{"/late", http.StatusTeapot},
+		{"/portmidi", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path+"?go-get=1", nil))
+		if w.Code != test.code {
+			t.Errorf("%s: status = %d; want %d", test.path, w.Code, test.code)
+		}
+	}
+	if got := h.Panics(); got != 2 {
+		t.Errorf("Panics() = %d; want 2", got)
+	}
+	logged := buf.String()
+	if !strings.Contains(logged, "panic serving GET /boom?go-get=1 for 192.0.2.1:1234: template bug") || !strings.Contains(logged, "goroutine") {
+		t.Errorf("log lacks request or stack:\n%s", logged)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultNotifyTimeout = 10 * time.Second
+
+// Event kinds.
+const (
+	// EventDegraded is sent when a component starts failing, and whenever
+	// loading the configuration fails.
+	EventDegraded = "degraded"
+
+	// EventRecovered is sent when a failing component works again.
+	EventRecovered = "recovered"
+)
+
+// An Event is an operational event that the people running a server should
+// know about even if nobody watches its logs.
+type Event struct {
+	Kind      string    `json:"kind"`
+	Component string    `json:"component"`
+	Error     string    `json:"error,omitempty"`
+	Instance  string    `json:"instance"` // host name of the server
+	Time      time.Time `json:"time"`
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%s: %s %s", e.Instance, e.Component, e.Kind)
+	if e.Error != "" {
+		s += ": " + e.Error
+	}
+	return s
+}
+
+// A Notifier tells someone about events.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// WebhookNotifier POSTs events as JSON to URL.
+type WebhookNotifier struct {
+	URL string
+
+	// Client is used to call URL.  If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Timeout bounds each call.  Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Notify implements Notifier.
+func (wn *WebhookNotifier) Notify(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, wn.Client, wn.Timeout, wn.URL, body)
+}
+
+// SlackNotifier posts events to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+
+	// Client is used to call WebhookURL.  If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+}
+
+// Notify implements Notifier.
+func (sn *SlackNotifier) Notify(ctx context.Context, e Event) error {
+	icon := ":warning:"
+	if e.Kind == EventRecovered {
+		icon = ":white_check_mark:"
+	}
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{icon + " " + e.String()})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, sn.Client, 0, sn.WebhookURL, body)
+}
+
+// SMTPNotifier mails events.
+type SMTPNotifier struct {
+	// Addr is the host:port of the mail server.
+	Addr string
+	From string
+	To   []string
+
+	// Username and Password, if set, are used to authenticate with PLAIN
+	// authentication, which the mail server only accepts over TLS.
+	Username string
+	Password string
+
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error // for tests
+}
+
+// Notify implements Notifier.
+func (sn *SMTPNotifier) Notify(ctx context.Context, e Event) error {
+	var auth smtp.Auth
+	if sn.Username != "" {
+		host, _, err := net.SplitHostPort(sn.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", sn.Username, sn.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", sn.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(sn.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [govanityurls] %s %s on %s\r\n", e.Component, e.Kind, e.Instance)
+	fmt.Fprintf(&msg, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nTime: %s\r\n", e, e.Time.Format(time.RFC3339))
+	send := sn.send
+	if send == nil {
+		send = smtp.SendMail
+	}
+	return send(sn.Addr, auth, sn.From, sn.To, msg.Bytes())
+}
+
+// Notifiers sends every event to all of its elements.
+type Notifiers []Notifier
+
+// Notify implements Notifier.  It returns the first error, after trying all
+// notifiers.
+func (ns Notifiers) Notify(ctx context.Context, e Event) error {
+	var first error
+	for _, n := range ns {
+		if err := n.Notify(ctx, e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// postJSON POSTs body to url and checks for a 2xx response.
+func postJSON(ctx context.Context, client *http.Client, timeout time.Duration, url string, body []byte) error {
+	if timeout <= 0 {
+		timeout = defaultNotifyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notifying %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// newEvent returns an event about component happening now.
+func newEvent(kind, component string, err error) Event {
+	e := Event{Kind: kind, Component: component, Time: time.Now().UTC()}
+	e.Instance, _ = os.Hostname()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// newNotifier returns the notifier configured by c, or nil if there is none.
+func newNotifier(c NotifyConfig, client *http.Client) (Notifier, error) {
+	var ns Notifiers
+	if c.Webhook != "" {
+		if _, err := parseAbsURL(c.Webhook); err != nil {
+			return nil, fmt.Errorf("configuration for notify: %v", err)
+		}
+		ns = append(ns, &WebhookNotifier{URL: c.Webhook, Client: client})
+	}
+	if c.Slack != "" {
+		if _, err := parseAbsURL(c.Slack); err != nil {
+			return nil, fmt.Errorf("configuration for notify: slack: %v", err)
+		}
+		ns = append(ns, &SlackNotifier{WebhookURL: c.Slack, Client: client})
+	}
+	if c.SMTP.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.SMTP.Addr); err != nil {
+			return nil, fmt.Errorf("configuration for notify: smtp: %v", err)
+		}
+		if c.SMTP.From == "" || len(c.SMTP.To) == 0 {
+			return nil, errors.New("configuration for notify: smtp needs from and to")
+		}
+		s := c.SMTP
+		ns = append(ns, &SMTPNotifier{Addr: s.Addr, From: s.From, To: s.To, Username: s.Username, Password: s.Password})
+	}
+	switch len(ns) {
+	case 0:
+		return nil, nil
+	case 1:
+		return ns[0], nil
+	}
+	return ns, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHealthNotifies(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	defer s.Close()
+	h, err := NewHandler([]byte("notify:\n  webhook: " + s.URL + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hl := &Health{Notifier: h.Notifier}
+
+	hl.SetReady(nil)
+	hl.Report("kubernetes", nil)
+	hl.Report("kubernetes", errors.New("watch: connection refused"))
+	hl.Report("kubernetes", errors.New("list: connection refused"))
+	hl.Report("kubernetes", nil)
+	hl.SetReady(errors.New("paths: bad repo"))
+	hl.SetReady(errors.New("paths: bad repo"))
+	hl.wg.Wait()
+
+	want := []struct{ kind, component, err string }{
+		{EventDegraded, "kubernetes", "watch: connection refused"},
+		{EventRecovered, "kubernetes", ""},
+		{EventDegraded, "config", "paths: bad repo"},
+		{EventDegraded, "config", "paths: bad repo"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %+v; want %d", len(events), events, len(want))
+	}
+	// Notifications are sent concurrently; compare without order.
+	for _, w := range want {
+		found := false
+		for i, e := range events {
+			if e.Kind == w.kind && e.Component == w.component && e.Error == w.err {
+				events = append(events[:i], events[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no %s event for %s with error %q", w.kind, w.component, w.err)
+		}
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var got struct {
+		Text string `json:"text"`
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer s.Close()
+	sn := &SlackNotifier{WebhookURL: s.URL}
+	e := Event{Kind: EventDegraded, Component: "config", Error: "paths: bad repo", Instance: "vanity-1"}
+	if err := sn.Notify(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if want := ":warning: vanity-1: config degraded: paths: bad repo"; got.Text != want {
+		t.Errorf("text = %q; want %q", got.Text, want)
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotMsg []byte
+	sn := &SMTPNotifier{
+		Addr:     "mail.example.com:587",
+		From:     "vanity@example.com",
+		To:       []string{"ops@example.com", "dev@example.com"},
+		Username: "vanity",
+		Password: "secret",
+		send: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			if a == nil {
+				t.Error("no authentication")
+			}
+			gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, msg
+			return nil
+		},
+	}
+	e := Event{Kind: EventRecovered, Component: "kubernetes", Instance: "vanity-1", Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := sn.Notify(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if gotAddr != sn.Addr || gotFrom != sn.From || strings.Join(gotTo, ",") != "ops@example.com,dev@example.com" {
+		t.Errorf("sent to %s from %s to %v", gotAddr, gotFrom, gotTo)
+	}
+	for _, want := range []string{
+		"To: ops@example.com, dev@example.com\r\n",
+		"Subject: [govanityurls] kubernetes recovered on vanity-1\r\n",
+		"\r\n\r\nvanity-1: kubernetes recovered\r\n",
+	} {
+		if !strings.Contains(string(gotMsg), want) {
+			t.Errorf("message does not contain %q:\n%s", want, gotMsg)
+		}
+	}
+}
+
+func TestNotifyConfigs(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string // type of the notifier, or "error"
+	}{
+		{"", "<nil>"},
+		{"notify:\n  webhook: https://hooks.example.com/vanity\n", "*vanity.WebhookNotifier"},
+		{"notify:\n  slack: https://hooks.slack.com/services/T/B/X\n", "*vanity.SlackNotifier"},
+		{"notify:\n  smtp:\n    addr: mail.example.com:25\n    from: a@example.com\n    to: [b@example.com]\n", "*vanity.SMTPNotifier"},
+		{"notify:\n  webhook: https://hooks.example.com/vanity\n  slack: https://hooks.slack.com/services/T/B/X\n", "vanity.Notifiers"},
+		{"notify:\n  webhook: /hook\n", "error"},
+		{"notify:\n  slack: hooks.slack.com\n", "error"},
+		{"notify:\n  smtp:\n    addr: mail.example.com\n    from: a@example.com\n    to: [b@example.com]\n", "error"},
+		{"notify:\n  smtp:\n    addr: mail.example.com:25\n", "error"},
+	}
+	for _, test := range tests {
+		h, err := NewHandler([]byte(test.config))
+		got := "error"
+		if err == nil {
+			got = fmt.Sprintf("%T", h.Notifier)
+		}
+		if got != test.want {
+			t.Errorf("%q: notifier = %s (%v); want %s", test.config, got, err, test.want)
+		}
+	}
+}
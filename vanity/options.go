@@ -0,0 +1,110 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// An Option changes how New and NewHandler construct a handler.
+type Option func(*Handler)
+
+// WithLogger makes the handler report errors to l instead of the standard
+// logger.
+func WithLogger(l *log.Logger) Option {
+	return func(h *Handler) { h.logger = l }
+}
+
+// WithClock makes the handler use now instead of time.Now, e.g. to expire
+// cached answers in tests.
+func WithClock(now func() time.Time) Option {
+	return func(h *Handler) { h.now = now }
+}
+
+// WithHTTPClient makes the handler use c for all outbound requests: module
+// proxy and checksum database forwarding, version and release lookups, the
+// resolver webhook and the fallback proxy.
+func WithHTTPClient(c *http.Client) Option {
+	return func(h *Handler) { h.client = c }
+}
+
+// WithResolver sets the handler's Resolver, replacing any resolver from the
+// configuration.
+func WithResolver(r Resolver) Option {
+	return func(h *Handler) { h.Resolver = r }
+}
+
+// WithTemplates replaces the templates for the index page and for the pages
+// of paths.  A nil template keeps the built-in one.  The templates receive
+// the same data as the built-in ones.
+func WithTemplates(index, page *template.Template) Option {
+	return func(h *Handler) {
+		if index != nil {
+			h.indexTmpl = index
+		}
+		if page != nil {
+			h.pageTmpl = page
+		}
+	}
+}
+
+// WithErrorTemplate replaces the template for the page served when a
+// request exceeds request_timeout.  It receives Lang, Msg and Style like the
+// other templates, and the status Code and a Message.
+func WithErrorTemplate(t *template.Template) Option {
+	return func(h *Handler) { h.errorTmpl = t }
+}
+
+// WithNotFoundTemplate sets the template for the page served to requests
+// that match no path, which otherwise get a plain 404 response.  It receives
+// the same fields as the error template, and the Host and Path of the
+// request.
+func WithNotFoundTemplate(t *template.Template) Option {
+	return func(h *Handler) { h.notFoundTmpl = t }
+}
+
+// WithMiddleware appends mw to the handler's Middleware.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(h *Handler) { h.Middleware = append(h.Middleware, mw...) }
+}
+
+// WithAfterResolve appends hooks to the handler's AfterResolve hooks.
+func WithAfterResolve(hooks ...ResolveHook) Option {
+	return func(h *Handler) { h.AfterResolve = append(h.AfterResolve, hooks...) }
+}
+
+// WithGitHubToken makes the handler authenticate its GitHub API requests,
+// for the releases of tools, with token.
+func WithGitHubToken(token string) Option {
+	return func(h *Handler) { h.releases.githubToken = token }
+}
+
+// WithGitLabToken makes the handler authenticate its GitLab API requests,
+// for the releases of tools, with token.
+func WithGitLabToken(token string) Option {
+	return func(h *Handler) { h.releases.gitlabToken = token }
+}
+
+// logf reports an error serving a request.
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.logger != nil {
+		h.logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestWithHTTPClientAndClock(t *testing.T) {
+	hits := 0
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		hits++
+		if r.URL.String() != "https://proxy.example/example.com/portmidi/@latest" {
+			t.Errorf("unexpected outbound request %s", r.URL)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"Version":"v1.0.0"}`)),
+		}, nil
+	})}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	h, err := NewHandler([]byte("host: example.com\n"+
+		"paths:\n"+
+		"  /portmidi:\n"+
+		"    repo: https://github.com/rakyll/portmidi\n"+
+		"proxy:\n"+
+		"  upstream: https://proxy.example\n"),
+		WithHTTPClient(client),
+		WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/paths/portmidi/latest", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d; want 200", w.Code)
+		}
+	}
+	get()
+	get()
+	if hits != 1 {
+		t.Errorf("outbound requests before expiry = %d; want 1", hits)
+	}
+	now = now.Add(latestTTL)
+	get()
+	if hits != 2 {
+		t.Errorf("outbound requests after expiry = %d; want 2", hits)
+	}
+}
+
+func TestWithTemplates(t *testing.T) {
+	index := template.Must(template.New("index").Parse(`hosted on {{.Host}}`))
+	page := template.Must(template.New("page").Parse(`{{.Import}} {{.VCS}} {{.Repo}}`))
+	h, err := NewHandler([]byte("host: example.com\n"+
+		"paths:\n"+
+		"  /portmidi:\n"+
+		"    repo: https://github.com/rakyll/portmidi\n"),
+		WithTemplates(index, page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "hosted on example.com"},
+		{"/portmidi", "example.com/portmidi git https://github.com/rakyll/portmidi"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if got := w.Body.String(); got != test.want {
+			t.Errorf("%s: body = %q; want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestTemplateConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{{.Host}} {{.Path}} {{.Subpath}} {{.Repo}} {{.VCS}} {{.Display}}`)
+	f.Close()
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"template: " + f.Name() + "\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/portmidi/sub/pkg", nil))
+	want := "example.com /portmidi sub/pkg https://github.com/rakyll/portmidi git " +
+		"https://github.com/rakyll/portmidi https://github.com/rakyll/portmidi/tree/master{/dir} https://github.com/rakyll/portmidi/blob/master{/dir}/{file}#L{line}"
+	if got := w.Body.String(); got != want {
+		t.Errorf("body = %q; want %q", got, want)
+	}
+
+	_, err = NewHandler([]byte("template: " + f.Name() + ".missing\n"))
+	if err == nil || !strings.Contains(err.Error(), "configuration for template") {
+		t.Errorf("missing template: err = %v; want configuration error", err)
+	}
+}
+
+func TestWithResolverAndLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := NewHandler([]byte("host: example.com\n"),
+		WithResolver(mapResolver{
+			"/dyn": {Path: "/dyn", Repo: "https://github.com/example/dyn"},
+		}),
+		WithLogger(log.New(&buf, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/dyn/sub", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("/dyn/sub: status = %d; want 200", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/broken", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("/broken: status = %d; want 502", w.Code)
+	}
+	if got := buf.String(); !strings.Contains(got, "registry unavailable") {
+		t.Errorf("log = %q; want resolver error", got)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultPageCacheSize is the number of rendered pages a handler keeps.
+const defaultPageCacheSize = 10000
+
+// A pageCache keeps rendered pages of paths, so that the template is
+// executed once for a page rather than for each of the many requests for it
+// that go get stampedes make.  The least recently used pages are evicted
+// first.
+type pageCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[pageKey]*list.Element
+	lru     *list.List // of *pageEntry, most recently used first
+}
+
+type pageEntry struct {
+	key  pageKey
+	page []byte
+}
+
+func newPageCache(maxEntries int) *pageCache {
+	return &pageCache{
+		maxEntries: maxEntries,
+		entries:    make(map[pageKey]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// get returns the page for k, if it was rendered before.
+func (c *pageCache) get(k pageKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*pageEntry).page, true
+}
+
+// add remembers the page rendered for k, which must not be modified later.
+func (c *pageCache) add(k pageKey, page []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[k]; ok {
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[k] = c.lru.PushFront(&pageEntry{key: k, page: page})
+	for c.lru.Len() > c.maxEntries {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*pageEntry).key)
+	}
+}
+
+// full reports whether adding a page would evict another.
+func (c *pageCache) full() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len() >= c.maxEntries
+}
+
+// reset forgets all pages.
+func (c *pageCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[pageKey]*list.Element)
+	c.lru.Init()
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPageCache(t *testing.T) {
+	c := newPageCache(2)
+	a, b, d := pageKey{Path: "/a"}, pageKey{Path: "/b"}, pageKey{Path: "/d"}
+	c.add(a, []byte("a"))
+	c.add(b, []byte("b"))
+	if !c.full() {
+		t.Error("cache of 2 pages is not full")
+	}
+	if page, ok := c.get(a); !ok || string(page) != "a" {
+		t.Errorf("get(a) = %q, %v; want a, true", page, ok)
+	}
+	// b is now the least recently used.
+	c.add(d, []byte("d"))
+	if _, ok := c.get(b); ok {
+		t.Error("b was not evicted")
+	}
+	for _, k := range []pageKey{a, d} {
+		if _, ok := c.get(k); !ok {
+			t.Errorf("%s was evicted", k.Path)
+		}
+	}
+	c.reset()
+	if _, ok := c.get(a); ok || c.full() {
+		t.Error("reset did not empty the cache")
+	}
+}
+
+func TestPrerender(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := h.pages.lru.Len(); n != 1 {
+		t.Fatalf("%d pages rendered at load; want 1", n)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/portmidi?go-get=1", nil))
+	page, ok := h.pages.get(pageKey{
+		Lang:    "en",
+		Host:    "example.com",
+		Path:    "/portmidi",
+		Import:  "example.com/portmidi",
+		Repo:    "https://github.com/rakyll/portmidi",
+		Display: "https://github.com/rakyll/portmidi https://github.com/rakyll/portmidi/tree/master{/dir} https://github.com/rakyll/portmidi/blob/master{/dir}/{file}#L{line}",
+		VCS:     "git",
+		Source:  "example.com/portmidi",
+	})
+	if !ok || w.Body.String() != string(page) {
+		t.Errorf("served %q; want the prerendered page %q", w.Body.String(), page)
+	}
+
+	// Subpaths are rendered on demand.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/portmidi/foo?go-get=1", nil))
+	if n := h.pages.lru.Len(); n != 2 {
+		t.Errorf("%d pages cached after a subpath; want 2", n)
+	}
+	if err := h.AddPath(PathConfig{Path: "/launchpad", Repo: "https://github.com/rakyll/launchpad"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := h.pages.lru.Len(); n != 0 {
+		t.Errorf("%d pages cached after a change; want 0", n)
+	}
+}
+
+func BenchmarkServePage(b *testing.B) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/portmidi/foo?go-get=1", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const pathAPIPrefix = "/api/v1/paths"
+
+// PathAPI serves /api/v1/paths, through which clients holding Token list,
+// add, change and remove the paths of a handler at runtime, e.g. to register
+// modules from CI without editing the configuration.  Mount it at both
+// /api/v1/paths and /api/v1/paths/; requests for the latest versions of
+// modules under it are passed on to the handler.
+type PathAPI struct {
+	Handler *Handler
+
+	// Reloader, if not nil, supplies the handler instead of Handler.
+	// Changes last until the next reload unless Save persists them.
+	Reloader *Reloader
+
+	// Token is the bearer token that clients must present.  If empty,
+	// every request is refused.
+	Token string
+
+	// Save, if not nil, is called with all paths after every change, for
+	// example to write them back to the configuration file.  Paths added
+	// by a GitHubSync, GitLabSync or KVSync are left out, so that the sync
+	// can still remove them later.  It is not called concurrently.
+	Save func(paths []PathConfig) error
+
+	// Logger receives changes.  If nil, the standard logger is used.
+	Logger *log.Logger
+
+	mu sync.Mutex // serializes changes, so they are saved in order
+}
+
+// apiPath is the JSON form of a path, with the keys of the configuration
+// file.
+type apiPath struct {
+	Path             string   `json:"path"`
+	Repo             string   `json:"repo"`
+	Display          string   `json:"display,omitempty"`
+	Branch           string   `json:"branch,omitempty"`
+	NoSource         *bool    `json:"no_source,omitempty"`
+	Redirect         string   `json:"redirect,omitempty"`
+	CacheMaxAge      *int     `json:"cache_max_age,omitempty"`
+	VCS              string   `json:"vcs,omitempty"`
+	Tool             bool     `json:"tool,omitempty"`
+	Subdir           string   `json:"subdir,omitempty"`
+	ImportDepth      *int     `json:"import_depth,omitempty"`
+	MajorSubdirs     bool     `json:"major_subdirs,omitempty"`
+	AllowInsecure    bool     `json:"allow_insecure,omitempty"`
+	Aliases          []string `json:"aliases,omitempty"`
+	DeprecateAliases bool     `json:"deprecate_aliases,omitempty"`
+	Retired          bool     `json:"retired,omitempty"`
+	RetiredMessage   string   `json:"retired_message,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	Owner            string   `json:"owner,omitempty"`
+}
+
+func apiPathOf(p PathConfig) apiPath {
+	return apiPath{
+		Path:             p.Path,
+		Repo:             p.Repo,
+		Display:          p.Display,
+		Branch:           p.Branch,
+		NoSource:         p.NoSource,
+		Redirect:         p.Redirect,
+		CacheMaxAge:      p.CacheMaxAge,
+		VCS:              p.VCS,
+		Tool:             p.Tool,
+		Subdir:           p.Subdir,
+		ImportDepth:      p.ImportDepth,
+		MajorSubdirs:     p.MajorSubdirs,
+		AllowInsecure:    p.AllowInsecure,
+		Aliases:          p.Aliases,
+		DeprecateAliases: p.DeprecateAliases,
+		Retired:          p.Retired,
+		RetiredMessage:   p.RetiredMessage,
+		Description:      p.Description,
+		Tags:             p.Tags,
+		Owner:            p.Owner,
+	}
+}
+
+func (p apiPath) pathConfig() PathConfig {
+	return PathConfig{
+		Path:             p.Path,
+		Repo:             p.Repo,
+		Display:          p.Display,
+		Branch:           p.Branch,
+		NoSource:         p.NoSource,
+		Redirect:         p.Redirect,
+		CacheMaxAge:      p.CacheMaxAge,
+		VCS:              p.VCS,
+		Tool:             p.Tool,
+		Subdir:           p.Subdir,
+		ImportDepth:      p.ImportDepth,
+		MajorSubdirs:     p.MajorSubdirs,
+		AllowInsecure:    p.AllowInsecure,
+		Aliases:          p.Aliases,
+		DeprecateAliases: p.DeprecateAliases,
+		Retired:          p.Retired,
+		RetiredMessage:   p.RetiredMessage,
+		Description:      p.Description,
+		Tags:             p.Tags,
+		Owner:            p.Owner,
+	}
+}
+
+func (api *PathAPI) handler() *Handler {
+	if api.Reloader != nil {
+		return api.Reloader.Handler()
+	}
+	return api.Handler
+}
+
+func (api *PathAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := api.handler()
+	if h == nil {
+		http.Error(w, "configuration not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	if isLatestRequest(r.URL.Path) {
+		h.ServeHTTP(w, r)
+		return
+	}
+	if !api.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="govanityurls"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	path := strings.TrimPrefix(r.URL.Path, pathAPIPrefix)
+	switch {
+	case path == "" || path == "/":
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		paths := make([]apiPath, 0)
+		for _, p := range h.Paths() {
+			paths = append(paths, apiPathOf(p))
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Paths []apiPath `json:"paths"`
+		}{paths})
+		return
+	case !strings.HasPrefix(path, "/"):
+		http.NotFound(w, r)
+		return
+	}
+	path = strings.TrimSuffix(path, "/")
+	switch r.Method {
+	case "GET", "HEAD":
+		for _, p := range h.Paths() {
+			if strings.TrimSuffix(p.Path, "/") == path {
+				writeJSON(w, http.StatusOK, apiPathOf(p))
+				return
+			}
+		}
+		http.Error(w, "path not found", http.StatusNotFound)
+	case "PUT":
+		var p apiPath
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, fmt.Sprintf("decoding path: %v", err), http.StatusBadRequest)
+			return
+		}
+		if p.Path != "" && strings.TrimSuffix(p.Path, "/") != path {
+			http.Error(w, fmt.Sprintf("body is for %s, not %s", p.Path, path), http.StatusBadRequest)
+			return
+		}
+		p.Path = path
+		api.change(w, r, h, func() (int, error) {
+			switch err := h.UpdatePath(p.pathConfig()); err {
+			case ErrPathNotFound:
+				return http.StatusCreated, h.AddPath(p.pathConfig())
+			default:
+				return http.StatusOK, err
+			}
+		})
+	case "DELETE":
+		api.change(w, r, h, func() (int, error) {
+			return http.StatusNoContent, h.RemovePath(path)
+		})
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// change applies a change to the paths of h with f, which returns the
+// status to answer with, and saves the result.
+func (api *PathAPI) change(w http.ResponseWriter, r *http.Request, h *Handler, f func() (int, error)) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	code, err := f()
+	switch {
+	case err == ErrPathNotFound:
+		http.Error(w, "path not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.logf("paths: %s %s", r.Method, strings.TrimPrefix(r.URL.Path, pathAPIPrefix))
+	if api.Save != nil {
+		if err := api.Save(h.configuredPaths()); err != nil {
+			// The change is served, but would be lost on restart.
+			api.logf("paths: saving: %v", err)
+			http.Error(w, fmt.Sprintf("applied, but not saved: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(code)
+}
+
+func (api *PathAPI) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if api.Token == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(api.Token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (api *PathAPI) logf(format string, args ...interface{}) {
+	if api.Logger != nil {
+		api.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
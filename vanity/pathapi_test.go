@@ -0,0 +1,178 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPathAPI(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"    description: Go bindings for PortMidi\n" +
+		"    tags: [audio]\n" +
+		"    owner: team-sound\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved [][]PathConfig
+	api := &PathAPI{
+		Handler: h,
+		Token:   "secret",
+		Save: func(paths []PathConfig) error {
+			saved = append(saved, paths)
+			return nil
+		},
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		body   string
+		code   int
+		want   string // in the response body
+	}{
+		{"GET", "/api/v1/paths", "", "", 401, ""},
+		{"GET", "/api/v1/paths", "wrong", "", 401, ""},
+		{"GET", "/api/v1/paths", "secret", "", 200, `{"paths":[{"path":"/portmidi","repo":"https://github.com/rakyll/portmidi","description":"Go bindings for PortMidi","tags":["audio"],"owner":"team-sound"}]}`},
+		{"PUT", "/api/v1/paths/tools", "secret", `{"repo": "https://github.com/example/tools", "tool": true}`, 201, ""},
+		{"GET", "/api/v1/paths/tools", "secret", "", 200, `{"path":"/tools","repo":"https://github.com/example/tools","tool":true}`},
+		{"PUT", "/api/v1/paths/tools", "secret", `{"path": "/tools", "repo": "https://github.com/example/tools2"}`, 200, ""},
+		{"PUT", "/api/v1/paths/tools", "secret", `{"path": "/other", "repo": "https://github.com/example/tools"}`, 400, "not /tools"},
+		{"PUT", "/api/v1/paths/bad", "secret", `{"repo": "https://example.com/bad"}`, 400, "cannot infer VCS"},
+		{"PUT", "/api/v1/paths/bad", "secret", `{`, 400, "decoding path"},
+		{"DELETE", "/api/v1/paths/portmidi", "secret", "", 204, ""},
+		{"DELETE", "/api/v1/paths/portmidi", "secret", "", 404, ""},
+		{"GET", "/api/v1/paths/portmidi", "secret", "", 404, ""},
+		{"POST", "/api/v1/paths", "secret", "", 405, ""},
+		{"GET", "/api/v1/paths", "secret", "", 200, `{"paths":[{"path":"/tools","repo":"https://github.com/example/tools2"}]}`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: status = %d; want %d (%s)", test.method, test.path, w.Code, test.code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s %s: body = %s; want %s", test.method, test.path, w.Body.String(), test.want)
+		}
+	}
+	if len(saved) != 3 {
+		t.Fatalf("saved %d times; want 3", len(saved))
+	}
+	if last := saved[2]; len(last) != 1 || last[0].Repo != "https://github.com/example/tools2" {
+		t.Errorf("last saved paths = %+v", last)
+	}
+
+	// The handler serves the changes.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/tools?go-get=1", nil))
+	if got := findMeta(w.Body.Bytes(), "go-import"); got != "example.com/tools git https://github.com/example/tools2" {
+		t.Errorf("go-import after changes = %q", got)
+	}
+}
+
+func TestPathAPINoToken(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &PathAPI{Handler: h}
+	r := httptest.NewRequest("GET", "/api/v1/paths", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if w.Code != 401 {
+		t.Errorf("status without a token configured = %d; want 401", w.Code)
+	}
+}
+
+func TestPathAPISync(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logf := func(string, ...interface{}) {}
+	owned := make(map[string]PathConfig)
+	want := map[string]PathConfig{
+		"/synced": {Path: "/synced", Repo: "https://github.com/example/synced"},
+	}
+	if err := h.syncPaths(want, owned, "test sync", logf); err != nil {
+		t.Fatal(err)
+	}
+	var saved []PathConfig
+	api := &PathAPI{
+		Handler: h,
+		Token:   "secret",
+		Save: func(paths []PathConfig) error {
+			saved = paths
+			return nil
+		},
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+	r := httptest.NewRequest("PUT", "/api/v1/paths/tools", strings.NewReader(`{"repo": "https://github.com/example/tools"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if w.Code != 201 {
+		t.Fatalf("status = %d; want 201 (%s)", w.Code, w.Body.String())
+	}
+	var got []string
+	for _, p := range saved {
+		got = append(got, p.Path)
+	}
+	if strings.Join(got, " ") != "/portmidi /tools" {
+		t.Errorf("saved paths = %v; want [/portmidi /tools]", got)
+	}
+
+	// After a restart with the saved paths, the sync can still remove its
+	// path.
+	h, err = NewHandler([]byte("host: example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetPaths(saved); err != nil {
+		t.Fatal(err)
+	}
+	owned = make(map[string]PathConfig)
+	if err := h.syncPaths(want, owned, "test sync", logf); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Paths()) != 3 {
+		t.Errorf("paths after the sync = %+v; want 3", h.Paths())
+	}
+	if err := h.syncPaths(nil, owned, "test sync", logf); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range h.Paths() {
+		if p.Path == "/synced" {
+			t.Errorf("%s still served after the sync removed it", p.Path)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const namePlaceholder = "{name}"
+
+// A pathRule serves every path matching its pattern, in which the {name}
+// placeholder stands for a single path element.  The placeholder may also
+// appear in the repository and display templates.
+type pathRule struct {
+	pattern  string
+	elems    []string
+	re       *regexp.Regexp // instead of elems, for regular expression rules
+	subpaths bool
+	config   pathConfig
+}
+
+// placeholderRE matches the placeholders in the repository template of a
+// regular expression rule.
+var placeholderRE = regexp.MustCompile(`{([^{}/]*)}`)
+
+func newPathRule(r PathRule, d pathDefaults) (pathRule, error) {
+	if len(r.Aliases) > 0 {
+		return pathRule{}, fmt.Errorf("configuration for pathrule %v: path rules cannot have aliases", r.Pattern)
+	}
+	if r.Regexp {
+		return newRegexpRule(r, d)
+	}
+	pattern := strings.TrimSuffix(r.Pattern, "/")
+	pr := pathRule{
+		pattern:  pattern,
+		elems:    strings.Split(strings.TrimPrefix(pattern, "/"), "/"),
+		subpaths: !r.Exact,
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		return pathRule{}, fmt.Errorf("configuration for pathrule %v: pattern must start with /", pattern)
+	}
+	n := 0
+	for _, elem := range pr.elems {
+		switch {
+		case elem == namePlaceholder:
+			n++
+		case elem == "" || strings.ContainsAny(elem, "{}"):
+			return pathRule{}, fmt.Errorf("configuration for pathrule %v: invalid path element %q", pattern, elem)
+		}
+	}
+	if n != 1 {
+		return pathRule{}, fmt.Errorf("configuration for pathrule %v: pattern must contain %s exactly once", pattern, namePlaceholder)
+	}
+	pc := r.PathConfig
+	pc.Path = pattern
+	var err error
+	pr.config, err = newPathConfig(pc, d)
+	if err != nil {
+		return pathRule{}, err
+	}
+	return pr, nil
+}
+
+// newRegexpRule returns a rule for the regular expression r.Pattern.
+func newRegexpRule(r PathRule, d pathDefaults) (pathRule, error) {
+	if !strings.HasPrefix(r.Pattern, "^/") {
+		return pathRule{}, fmt.Errorf("configuration for pathrule %v: regular expression must start with ^/", r.Pattern)
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return pathRule{}, fmt.Errorf("configuration for pathrule %v: %v", r.Pattern, err)
+	}
+	groups := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		groups[name] = name != ""
+	}
+	for _, m := range placeholderRE.FindAllStringSubmatch(r.Repo, -1) {
+		if !groups[m[1]] {
+			return pathRule{}, fmt.Errorf("configuration for pathrule %v: repo refers to unknown group %s", r.Pattern, m[0])
+		}
+	}
+	pc := r.PathConfig
+	pc.Path = r.Pattern
+	pr := pathRule{pattern: r.Pattern, re: re, subpaths: !r.Exact}
+	if pr.config, err = newPathConfig(pc, d); err != nil {
+		return pathRule{}, err
+	}
+	return pr, nil
+}
+
+// match reports whether the rule serves path, returning the path
+// configuration with the placeholder filled in.
+func (pr *pathRule) match(path string) (pc *pathConfig, subpath string) {
+	if pr.re != nil {
+		return pr.matchRegexp(path)
+	}
+	elems := strings.Split(strings.Trim(path, "/"), "/")
+	if len(elems) < len(pr.elems) || len(elems) > len(pr.elems) && !pr.subpaths {
+		return nil, ""
+	}
+	var name string
+	for i, elem := range pr.elems {
+		switch {
+		case elem == namePlaceholder && elems[i] != "":
+			name = elems[i]
+		case elem != elems[i]:
+			return nil, ""
+		}
+	}
+	c := pr.config
+	c.path = "/" + strings.Join(elems[:len(pr.elems)], "/")
+	c.repo = strings.Replace(c.repo, namePlaceholder, name, -1)
+	c.display = strings.Replace(c.display, namePlaceholder, name, -1)
+	c.redirect = strings.Replace(c.redirect, namePlaceholder, name, -1)
+	c.subdir = strings.Replace(c.subdir, namePlaceholder, name, -1)
+	return &c, strings.Join(elems[len(pr.elems):], "/")
+}
+
+// matchRegexp is match for regular expression rules.  The expression has to
+// match whole path elements.
+func (pr *pathRule) matchRegexp(path string) (pc *pathConfig, subpath string) {
+	path = "/" + strings.Trim(path, "/")
+	m := pr.re.FindStringSubmatchIndex(path)
+	if m == nil || m[0] != 0 {
+		return nil, ""
+	}
+	end := m[1]
+	if end < len(path) && path[end] != '/' && path[end-1] != '/' {
+		return nil, ""
+	}
+	matched := strings.TrimSuffix(path[:end], "/")
+	subpath = strings.Trim(path[end:], "/")
+	if matched == "" || subpath != "" && !pr.subpaths {
+		return nil, ""
+	}
+	var pairs []string
+	for i, name := range pr.re.SubexpNames() {
+		if name != "" && m[2*i] >= 0 {
+			pairs = append(pairs, "{"+name+"}", path[m[2*i]:m[2*i+1]])
+		}
+	}
+	r := strings.NewReplacer(pairs...)
+	c := pr.config
+	c.path = matched
+	c.repo = r.Replace(c.repo)
+	c.display = r.Replace(c.display)
+	c.redirect = r.Replace(c.redirect)
+	c.subdir = r.Replace(c.subdir)
+	return &c, subpath
+}
+
+// fixedPrefix returns the path that every path the rule matches is at or
+// below, or the empty string if there is none.
+func (pr *pathRule) fixedPrefix() string {
+	var prefix string
+	if pr.re != nil {
+		prefix, _ = regexp.MustCompile(strings.TrimPrefix(pr.pattern, "^")).LiteralPrefix()
+		// The expression matches whole path elements, but the last
+		// one may go on.
+		prefix = prefix[:strings.LastIndexByte(prefix, '/')+1]
+	} else {
+		for _, elem := range pr.elems {
+			if elem == namePlaceholder {
+				break
+			}
+			prefix += "/" + elem
+		}
+	}
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// overlaps reports whether both pr and other match some paths of the same
+// length, which cannot be told for regular expression rules.
+func (pr *pathRule) overlaps(other *pathRule) bool {
+	if pr.re != nil || other.re != nil || len(pr.elems) != len(other.elems) {
+		return false
+	}
+	for i, elem := range pr.elems {
+		if elem != other.elems[i] && elem != namePlaceholder && other.elems[i] != namePlaceholder {
+			return false
+		}
+	}
+	return true
+}
+
+// pathRuleSet is a list of path rules ordered from the most to the least
+// specific.
+type pathRuleSet []pathRule
+
+func (rs pathRuleSet) Len() int {
+	return len(rs)
+}
+
+func (rs pathRuleSet) Less(i, j int) bool {
+	if (rs[i].re == nil) != (rs[j].re == nil) {
+		return rs[i].re == nil
+	}
+	if len(rs[i].elems) != len(rs[j].elems) {
+		return len(rs[i].elems) > len(rs[j].elems)
+	}
+	return rs[i].pattern < rs[j].pattern
+}
+
+func (rs pathRuleSet) Swap(i, j int) {
+	rs[i], rs[j] = rs[j], rs[i]
+}
+
+func (rs pathRuleSet) find(path string) (pc *pathConfig, subpath string) {
+	for i := range rs {
+		if pc, subpath := rs[i].match(path); pc != nil {
+			return pc, subpath
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPathRuleSetFind(t *testing.T) {
+	rules := []PathRule{
+		{
+			Pattern:    "/{name}",
+			Exact:      true,
+			PathConfig: PathConfig{Repo: "https://github.com/flat/{name}"},
+		},
+		{
+			Pattern:    "/mono/{name}",
+			PathConfig: PathConfig{Repo: "https://github.com/mono/{name}"},
+		},
+		{
+			Pattern:    "^/team-(?P<team>[a-z]+)/(?P<name>[a-z-]+)",
+			Regexp:     true,
+			PathConfig: PathConfig{Repo: "https://github.com/{team}/{name}"},
+		},
+		{
+			Pattern:    "^/mono/v[0-9]+$",
+			Regexp:     true,
+			PathConfig: PathConfig{Repo: "https://github.com/mono/versions"},
+		},
+	}
+	var rs pathRuleSet
+	for _, r := range rules {
+		pr, err := newPathRule(r, pathDefaults{providers: builtinProviders})
+		if err != nil {
+			t.Fatalf("newPathRule(%q): %v", r.Pattern, err)
+		}
+		rs = append(rs, pr)
+	}
+	sort.Sort(rs)
+
+	tests := []struct {
+		query   string
+		path    string
+		repo    string
+		subpath string
+	}{
+		{query: "/foo", path: "/foo", repo: "https://github.com/flat/foo"},
+		{query: "/foo/", path: "/foo", repo: "https://github.com/flat/foo"},
+		{query: "/foo/bar"},
+		{query: "/"},
+		{query: "/mono/foo", path: "/mono/foo", repo: "https://github.com/mono/foo"},
+		{query: "/mono/foo/sub/pkg", path: "/mono/foo", repo: "https://github.com/mono/foo", subpath: "sub/pkg"},
+		{query: "/team-infra/deploy-tools", path: "/team-infra/deploy-tools", repo: "https://github.com/infra/deploy-tools"},
+		{query: "/team-infra/deploy-tools/cmd/", path: "/team-infra/deploy-tools", repo: "https://github.com/infra/deploy-tools", subpath: "cmd"},
+		{query: "/team-infra/deploy_tools"},
+		{query: "/team-/tools"},
+		// The more specific element rule takes precedence.
+		{query: "/mono/v2", path: "/mono/v2", repo: "https://github.com/mono/v2"},
+	}
+	for _, test := range tests {
+		pc, subpath := rs.find(test.query)
+		var path, repo string
+		if pc != nil {
+			path, repo = pc.path, pc.repo
+		}
+		if path != test.path || repo != test.repo || subpath != test.subpath {
+			t.Errorf("find(%q) = %q, %q, %q; want %q, %q, %q",
+				test.query, path, repo, subpath, test.path, test.repo, test.subpath)
+		}
+	}
+}
+
+func TestPathRuleDisplayInference(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"pathrules:\n" +
+		"  /{name}:\n" +
+		"    repo: https://github.com/example/{name}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, _ := h.rules.find("/foo")
+	if pc == nil {
+		t.Fatal("find(\"/foo\") = nil")
+	}
+	want := "https://github.com/example/foo https://github.com/example/foo/tree/master{/dir} https://github.com/example/foo/blob/master{/dir}/{file}#L{line}"
+	if pc.display != want || pc.vcs != "git" {
+		t.Errorf("display, vcs = %q, %q; want %q, git", pc.display, pc.vcs, want)
+	}
+}
+
+func TestRegexpPathRuleConfig(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"pathrules:\n" +
+		"  ^/team-(?P<team>[a-z]+)/(?P<name>[a-z-]+)$:\n" +
+		"    regex: true\n" +
+		"    repo: https://github.com/{team}/{name}\n" +
+		"    redirect: https://docs.example.com/{team}/{name}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/team-infra/tools?go-get=1", nil))
+	want := `<meta name="go-source" content="example.com/team-infra/tools https://github.com/infra/tools https://github.com/infra/tools/tree/master{/dir} https://github.com/infra/tools/blob/master{/dir}/{file}#L{line}">`
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("body = %s; want it to contain %s", w.Body.String(), want)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/team-infra/tools/cmd", nil))
+	if w.Code != 404 {
+		t.Errorf("subpath of an anchored rule: status = %d; want 404", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/team-infra/tools", nil))
+	if loc := w.Header().Get("Location"); loc != "https://docs.example.com/infra/tools" {
+		t.Errorf("Location = %q", loc)
+	}
+}
+
+func TestBadPathRules(t *testing.T) {
+	badConfigs := []string{
+		"pathrules:\n" +
+			"  /static:\n" +
+			"    repo: https://github.com/example/static\n",
+		"pathrules:\n" +
+			"  /{name}/{name}:\n" +
+			"    repo: https://github.com/example/{name}\n",
+		"pathrules:\n" +
+			"  /x{name}:\n" +
+			"    repo: https://github.com/example/{name}\n",
+		"pathrules:\n" +
+			"  /{name}:\n" +
+			"    repo: https://bitbucket.org/example/{name}\n",
+		"pathrules:\n" +
+			"  /team-(?P<team>[a-z]+):\n" +
+			"    regex: true\n" +
+			"    repo: https://github.com/{team}/tools\n",
+		"pathrules:\n" +
+			"  ^/team-(?P<team>[a-z+:\n" +
+			"    regex: true\n" +
+			"    repo: https://github.com/{team}/tools\n",
+		"pathrules:\n" +
+			"  ^/team-(?P<team>[a-z]+):\n" +
+			"    regex: true\n" +
+			"    repo: https://github.com/{org}/tools\n",
+	}
+	for _, config := range badConfigs {
+		if _, err := NewHandler([]byte(config)); err == nil {
+			t.Errorf("expected config to produce an error, but did not:\n%s", config)
+		}
+	}
+}
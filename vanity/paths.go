@@ -0,0 +1,453 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrPathExists is returned by AddPath if the path is already served.
+	ErrPathExists = errors.New("vanity: path already exists")
+
+	// ErrPathNotFound is returned by UpdatePath and RemovePath if the path
+	// is not served.
+	ErrPathNotFound = errors.New("vanity: path not found")
+)
+
+// pathSet returns the paths currently served.  The returned set must not be
+// modified: changes replace the set instead, so that requests in flight keep
+// a consistent view.
+func (h *Handler) pathSet() pathConfigSet {
+	return h.paths.Load().set
+}
+
+// findPath returns the configuration of the path that path is or is below,
+// and the rest of path below it.
+func (h *Handler) findPath(path string) (*pathConfig, string) {
+	return h.paths.Load().trie.find(path)
+}
+
+// Paths returns the configuration of the paths currently served, sorted by
+// path.  Aliases are part of the configuration of their path.
+func (h *Handler) Paths() []PathConfig {
+	pset := h.pathSet()
+	paths := make([]PathConfig, 0, len(pset))
+	for _, pc := range pset.configured() {
+		paths = append(paths, pc.config)
+	}
+	return paths
+}
+
+// configuredPaths returns the paths served other than those a sync added,
+// which the sync serves again after a restart, sorted by path.
+func (h *Handler) configuredPaths() []PathConfig {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var paths []PathConfig
+	for _, pc := range h.pathSet().configured() {
+		if !h.synced[pc.path] {
+			paths = append(paths, pc.config)
+		}
+	}
+	return paths
+}
+
+// SetPaths replaces all served paths with paths.  If any of them is invalid,
+// the served paths are left unchanged.
+func (h *Handler) SetPaths(paths []PathConfig) error {
+	pset, err := newPathConfigSet(paths, h.defaults)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.paths.Store(newServedPaths(pset))
+	h.changedLocked()
+	return nil
+}
+
+// AddPath starts serving p.  It returns ErrPathExists if p.Path is already
+// served.
+func (h *Handler) AddPath(p PathConfig) error {
+	pc, err := newPathConfig(p, h.defaults)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pset := h.pathSet()
+	if i, found := pset.index(pc.path); found && pset[i].rootOf == "" {
+		return ErrPathExists
+	}
+	return h.replaceLocked(append(pset.configured(), pc))
+}
+
+// UpdatePath replaces the configuration of p.Path.  It returns
+// ErrPathNotFound if p.Path is not served.
+func (h *Handler) UpdatePath(p PathConfig) error {
+	pc, err := newPathConfig(p, h.defaults)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pset := h.pathSet()
+	i, found := pset.index(pc.path)
+	if !found || pset[i].aliasOf != "" || pset[i].rootOf != "" {
+		return ErrPathNotFound
+	}
+	pcs := pset.configured()
+	for i := range pcs {
+		if pcs[i].path == pc.path {
+			pcs[i] = pc
+		}
+	}
+	return h.replaceLocked(pcs)
+}
+
+// RemovePath stops serving path and its aliases.  It returns
+// ErrPathNotFound if path is not served.
+func (h *Handler) RemovePath(path string) error {
+	path = strings.TrimSuffix(path, "/")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pset := h.pathSet()
+	i, found := pset.index(path)
+	if !found || pset[i].aliasOf != "" || pset[i].rootOf != "" {
+		return ErrPathNotFound
+	}
+	var pcs []pathConfig
+	for _, pc := range pset.configured() {
+		if pc.path != path {
+			pcs = append(pcs, pc)
+		}
+	}
+	return h.replaceLocked(pcs)
+}
+
+// syncPaths makes h serve the paths want, given owned, the paths that
+// earlier syncs added to h, which it updates.  Paths configured otherwise
+// take precedence.  All changes are made at once, so that requests never
+// see a partial sync, and logged with the prefix name.
+func (h *Handler) syncPaths(want, owned map[string]PathConfig, name string, logf func(string, ...interface{})) error {
+	paths := make([]string, 0, len(want))
+	for path := range want {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pcs := h.pathSet().configured()
+	// index is where each path is in pcs, and taken the path that each
+	// served path, alias or not, belongs to.
+	index := make(map[string]int, len(pcs))
+	taken := make(map[string]string, len(pcs))
+	for i, pc := range pcs {
+		index[pc.path] = i
+		taken[pc.path] = pc.path
+		for _, alias := range pc.aliases {
+			taken[alias] = pc.path
+		}
+	}
+	removed := make(map[string]bool)
+	var (
+		msgs []string
+		errs int
+	)
+	fail := func(format string, args ...interface{}) {
+		msgs = append(msgs, fmt.Sprintf(format, args...))
+		errs++
+	}
+	// conflict returns the first alias of pc served by another path.
+	conflict := func(pc pathConfig) string {
+		for _, alias := range pc.aliases {
+			if owner, ok := taken[alias]; ok && owner != pc.path {
+				return alias
+			}
+		}
+		return ""
+	}
+	// mark records whether pc and its aliases are taken.
+	mark := func(pc pathConfig, served bool) {
+		for _, path := range append([]string{pc.path}, pc.aliases...) {
+			if served {
+				taken[path] = pc.path
+			} else {
+				delete(taken, path)
+			}
+		}
+	}
+
+	// Removals come first, so that their paths can be taken.
+	var gone []string
+	for path := range owned {
+		if _, ok := want[path]; !ok {
+			gone = append(gone, path)
+		}
+	}
+	sort.Strings(gone)
+	for _, path := range gone {
+		if i, ok := index[path]; ok {
+			mark(pcs[i], false)
+			removed[path] = true
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: no longer serving %s", name, path))
+	}
+	added := make(map[string]PathConfig)
+	previous := make(map[string]pathConfig) // of updated paths
+	for _, path := range paths {
+		p := want[path]
+		old, ok := owned[path]
+		if ok && reflect.DeepEqual(old, p) {
+			continue
+		}
+		pc, err := newPathConfig(p, h.defaults)
+		if err != nil {
+			fail("%s: serving %s: %v", name, p.Repo, err)
+			continue
+		}
+		i, found := index[pc.path]
+		if _, served := taken[pc.path]; !ok && served {
+			// Configured by hand.
+			continue
+		}
+		if ok && !found {
+			fail("%s: serving %s: %v", name, p.Repo, ErrPathNotFound)
+			continue
+		}
+		if alias := conflict(pc); alias != "" {
+			fail("%s: serving %s: configuration for %v: duplicate path", name, p.Repo, alias)
+			continue
+		}
+		if ok {
+			mark(pcs[i], false)
+			previous[pc.path] = pcs[i]
+			pcs[i] = pc
+		} else {
+			index[pc.path] = len(pcs)
+			pcs = append(pcs, pc)
+		}
+		mark(pc, true)
+		added[path] = p
+	}
+
+	for len(gone) > 0 || len(added) > 0 {
+		var keep []pathConfig
+		for _, pc := range pcs {
+			if !removed[pc.path] {
+				keep = append(keep, pc)
+			}
+		}
+		err := h.replaceLocked(keep)
+		if err == nil {
+			break
+		}
+		// A synced path whose import prefix is claimed for another
+		// repository is left as it was, rather than the whole sync.
+		conflict, ok := err.(*pathConflict)
+		if !ok {
+			return err
+		}
+		var drop string
+		for _, path := range conflict.owners {
+			if _, ok := added[path]; ok {
+				drop = path
+			}
+		}
+		if drop == "" {
+			return err
+		}
+		fail("%s: serving %s: %v", name, added[drop].Repo, err)
+		delete(added, drop)
+		if pc, ok := previous[drop]; ok {
+			pcs[index[drop]] = pc
+		} else {
+			removed[drop] = true
+		}
+	}
+	if h.synced == nil {
+		h.synced = make(map[string]bool)
+	}
+	for _, path := range gone {
+		delete(owned, path)
+		delete(h.synced, path)
+	}
+	for _, path := range paths {
+		if p, ok := added[path]; ok {
+			owned[path] = p
+			h.synced[path] = true
+			msgs = append(msgs, fmt.Sprintf("%s: serving %s at %s", name, p.Repo, path))
+		}
+	}
+	for _, msg := range msgs {
+		logf("%s", msg)
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d paths could not be changed", errs)
+	}
+	return nil
+}
+
+// replaceLocked serves pcs instead of the current paths.  h.mu must be
+// held.
+func (h *Handler) replaceLocked(pcs []pathConfig) error {
+	pset, err := makePathConfigSet(pcs)
+	if err != nil {
+		return err
+	}
+	h.paths.Store(newServedPaths(pset))
+	h.changedLocked()
+	return nil
+}
+
+// changedLocked records a change of the served paths and wakes up the
+// requests waiting for one.  h.mu must be held.
+func (h *Handler) changedLocked() {
+	h.generation++
+	// Pages of changed or removed paths would not be asked for again.
+	h.pages.reset()
+	if h.changed != nil {
+		close(h.changed)
+		h.changed = nil
+	}
+}
+
+// watchPaths returns the paths currently served, their version, and a
+// channel that is closed when they change.
+func (h *Handler) watchPaths() (pathConfigSet, string, <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.changed == nil {
+		h.changed = make(chan struct{})
+	}
+	return h.pathSet(), fmt.Sprintf("%x.%d", h.epoch, h.generation), h.changed
+}
+
+// newPathConfigSet validates paths and returns them sorted.
+func newPathConfigSet(paths []PathConfig, d pathDefaults) (pathConfigSet, error) {
+	pcs := make([]pathConfig, 0, len(paths))
+	for _, p := range paths {
+		pc, err := newPathConfig(p, d)
+		if err != nil {
+			return nil, err
+		}
+		pcs = append(pcs, pc)
+	}
+	return makePathConfigSet(pcs)
+}
+
+// pathConflict is the error of a set in which a path is served twice, or
+// a shortened import prefix is claimed for different repositories.
+type pathConflict struct {
+	path   string    // at fault
+	owners [2]string // the configured paths in conflict
+	msg    string
+}
+
+func (e *pathConflict) Error() string {
+	return fmt.Sprintf("configuration for %v: %s", e.path, e.msg)
+}
+
+// makePathConfigSet returns the set serving pcs, their aliases and their
+// shortened import prefixes, sorted.
+func makePathConfigSet(pcs []pathConfig) (pathConfigSet, error) {
+	pset := make(pathConfigSet, 0, len(pcs))
+	for _, pc := range pcs {
+		pset = append(pset, pc)
+		for _, alias := range pc.aliases {
+			a := pc
+			a.path, a.aliasOf, a.aliases = alias, pc.path, nil
+			pset = append(pset, a)
+		}
+	}
+	// The go command checks that the import prefix in a go-import meta tag
+	// serves the same tag, so a shortened prefix that is not configured
+	// itself is served for the path that claims it.
+	served := make(map[string]int, len(pset))
+	for i := range pset {
+		served[pset[i].path] = i
+	}
+	for i, n := 0, len(pset); i < n; i++ {
+		pc := pset[i]
+		prefix := pc.importPath()
+		if prefix == pc.path || pc.retired {
+			continue
+		}
+		if j, ok := served[prefix]; ok {
+			if other := pset[j]; other.repo != pc.repo || other.vcs != pc.vcs {
+				return nil, &pathConflict{
+					path:   pc.path,
+					owners: [2]string{other.configuredPath(), pc.configuredPath()},
+					msg:    fmt.Sprintf("import prefix %s is served for another repository by %s", prefix, other.configuredPath()),
+				}
+			}
+			continue
+		}
+		root := pc
+		root.path, root.rootOf, root.aliasOf, root.aliases = prefix, pc.configuredPath(), "", nil
+		root.importDepth, root.tool = 0, false
+		served[prefix] = len(pset)
+		pset = append(pset, root)
+	}
+	sort.Sort(pset)
+	for i := 1; i < len(pset); i++ {
+		if pset[i-1].path == pset[i].path {
+			return nil, &pathConflict{
+				path:   pset[i].path,
+				owners: [2]string{pset[i-1].configuredPath(), pset[i].configuredPath()},
+				msg:    "duplicate path",
+			}
+		}
+	}
+	return pset, nil
+}
+
+// configuredPath returns the configured path that pc serves for.
+func (pc *pathConfig) configuredPath() string {
+	switch {
+	case pc.aliasOf != "":
+		return pc.aliasOf
+	case pc.rootOf != "":
+		return pc.rootOf
+	}
+	return pc.path
+}
+
+// configured returns the paths of pset other than aliases and shortened
+// import prefixes.
+func (pset pathConfigSet) configured() []pathConfig {
+	var pcs []pathConfig
+	for _, pc := range pset {
+		if pc.aliasOf == "" && pc.rootOf == "" {
+			pcs = append(pcs, pc)
+		}
+	}
+	return pcs
+}
+
+// index returns the position of path in the sorted set and whether it is
+// present.
+func (pset pathConfigSet) index(path string) (int, bool) {
+	i := sort.Search(len(pset), func(i int) bool {
+		return pset[i].path >= path
+	})
+	return i, i < len(pset) && pset[i].path == path
+}
@@ -0,0 +1,286 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRuntimePaths(t *testing.T) {
+	h, err := New(&Config{Host: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) (status int, goImport string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, findMeta(w.Body.Bytes(), "go-import")
+	}
+
+	if status, _ := get("/portmidi"); status != http.StatusNotFound {
+		t.Errorf("before AddPath: status = %d; want 404", status)
+	}
+	p := PathConfig{Path: "/portmidi", Repo: "https://github.com/rakyll/portmidi"}
+	if err := h.AddPath(p); err != nil {
+		t.Fatalf("AddPath: %v", err)
+	}
+	if err := h.AddPath(p); err != ErrPathExists {
+		t.Errorf("second AddPath = %v; want ErrPathExists", err)
+	}
+	if _, got := get("/portmidi/foo"); got != "example.com/portmidi git https://github.com/rakyll/portmidi" {
+		t.Errorf("after AddPath: go-import = %q", got)
+	}
+
+	p.Repo = "https://github.com/example/portmidi"
+	if err := h.UpdatePath(p); err != nil {
+		t.Fatalf("UpdatePath: %v", err)
+	}
+	if _, got := get("/portmidi"); got != "example.com/portmidi git https://github.com/example/portmidi" {
+		t.Errorf("after UpdatePath: go-import = %q", got)
+	}
+	if err := h.UpdatePath(PathConfig{Path: "/nope", Repo: p.Repo}); err != ErrPathNotFound {
+		t.Errorf("UpdatePath(/nope) = %v; want ErrPathNotFound", err)
+	}
+	if err := h.UpdatePath(PathConfig{Path: "/portmidi", Repo: "https://bitbucket.org/x/y"}); err == nil {
+		t.Error("UpdatePath with invalid configuration succeeded")
+	}
+
+	if err := h.RemovePath("/portmidi/"); err != nil {
+		t.Fatalf("RemovePath: %v", err)
+	}
+	if err := h.RemovePath("/portmidi"); err != ErrPathNotFound {
+		t.Errorf("second RemovePath = %v; want ErrPathNotFound", err)
+	}
+	if status, _ := get("/portmidi"); status != http.StatusNotFound {
+		t.Errorf("after RemovePath: status = %d; want 404", status)
+	}
+}
+
+func TestRuntimePathsConcurrent(t *testing.T) {
+	h, err := New(&Config{Host: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			h.AddPath(PathConfig{Path: "/a", Repo: "https://github.com/example/a"})
+			h.RemovePath("/a")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+		}
+	}()
+	wg.Wait()
+}
+
+func TestSetPaths(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.SetPaths([]PathConfig{
+		{Path: "/foo", Repo: "https://github.com/example/foo"},
+		{Path: "/foo", Repo: "https://github.com/example/bar"},
+	})
+	if err == nil {
+		t.Error("SetPaths with duplicate paths succeeded")
+	}
+	if pc, _ := h.findPath("/portmidi"); pc == nil {
+		t.Error("failed SetPaths changed the paths")
+	}
+
+	if err := h.SetPaths([]PathConfig{{Path: "/foo", Repo: "https://github.com/example/foo"}}); err != nil {
+		t.Fatal(err)
+	}
+	if pc, _ := h.findPath("/portmidi"); pc != nil {
+		t.Error("/portmidi still served after SetPaths")
+	}
+	if pc, _ := h.findPath("/foo/bar"); pc == nil || pc.repo != "https://github.com/example/foo" {
+		t.Errorf("/foo/bar = %+v after SetPaths", pc)
+	}
+}
+
+func TestSyncPaths(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := func(path string) string {
+		if pc, _ := h.findPath(path); pc != nil {
+			return pc.repo
+		}
+		return ""
+	}
+	var logged []string
+	logf := func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	owned := make(map[string]PathConfig)
+	apply := func(paths ...PathConfig) (changes int, err error) {
+		want := make(map[string]PathConfig)
+		for _, p := range paths {
+			want[p.Path] = p
+		}
+		logged = nil
+		before := h.generation
+		err = h.syncPaths(want, owned, "test sync", logf)
+		return h.generation - before, err
+	}
+
+	changes, err := apply(
+		PathConfig{Path: "/portmidi", Repo: "https://github.com/example/portmidi"},
+		PathConfig{Path: "/a", Repo: "https://github.com/example/a"},
+		PathConfig{Path: "/b", Repo: "https://github.com/example/b"},
+	)
+	if err != nil || changes != 1 {
+		t.Errorf("first sync: %d changes, %v; want 1 change", changes, err)
+	}
+	if got := repo("/portmidi"); got != "https://github.com/rakyll/portmidi" {
+		t.Errorf("first sync: /portmidi = %s; want the configured repository", got)
+	}
+	if got := repo("/b"); got != "https://github.com/example/b" || len(owned) != 2 {
+		t.Errorf("first sync: /b = %q, owning %d paths; want the synced repository and 2", got, len(owned))
+	}
+
+	changes, err = apply(
+		PathConfig{Path: "/a", Repo: "https://github.com/example/a2"},
+		PathConfig{Path: "/c", Repo: "https://github.com/example/c", Aliases: []string{"/portmidi"}},
+		PathConfig{Path: "/d", Repo: "https://github.com/example/d"},
+	)
+	if err == nil || changes != 1 {
+		t.Errorf("second sync: %d changes, %v; want 1 change and an error for /c", changes, err)
+	}
+	for path, want := range map[string]string{
+		"/a":        "https://github.com/example/a2",
+		"/b":        "",
+		"/c":        "",
+		"/d":        "https://github.com/example/d",
+		"/portmidi": "https://github.com/rakyll/portmidi",
+	} {
+		if got := repo(path); got != want {
+			t.Errorf("second sync: %s = %q; want %q", path, got, want)
+		}
+	}
+	if got := strings.Join(logged, "\n"); !strings.Contains(got, "test sync: serving https://github.com/example/c: configuration for /portmidi: duplicate path") ||
+		!strings.Contains(got, "test sync: no longer serving /b") {
+		t.Errorf("second sync logged:\n%s", got)
+	}
+
+	changes, err = apply(
+		PathConfig{Path: "/a", Repo: "https://github.com/example/a2"},
+		PathConfig{Path: "/d", Repo: "https://github.com/example/d"},
+	)
+	if err != nil || changes != 0 || len(logged) != 0 {
+		t.Errorf("unchanged sync: %d changes, %v, logged %q; want none", changes, err, logged)
+	}
+
+	// Of two paths claiming the import prefix /y for different
+	// repositories, only the first is served.
+	depth := 1
+	changes, err = apply(
+		PathConfig{Path: "/a", Repo: "https://github.com/example/a2"},
+		PathConfig{Path: "/d", Repo: "https://github.com/example/d"},
+		PathConfig{Path: "/y/a", Repo: "https://github.com/example/ya", ImportDepth: &depth},
+		PathConfig{Path: "/y/b", Repo: "https://github.com/example/yb", ImportDepth: &depth},
+	)
+	if err == nil || changes != 1 {
+		t.Errorf("conflicting sync: %d changes, %v; want 1 change and an error for /y/b", changes, err)
+	}
+	if got, want := repo("/y"), "https://github.com/example/ya"; got != want {
+		t.Errorf("conflicting sync: /y = %q; want %q", got, want)
+	}
+	if got := repo("/y/b"); got != "https://github.com/example/ya" {
+		t.Errorf("conflicting sync: /y/b = %q; want it served by the prefix of /y/a", got)
+	}
+	if _, ok := owned["/y/b"]; ok {
+		t.Error("conflicting sync: /y/b owned")
+	}
+}
+
+func TestAliases(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /newname:\n" +
+		"    repo: https://github.com/example/newname\n" +
+		"    aliases: [/oldname, /older/name]\n" +
+		"    deprecate_aliases: true\n" +
+		"  /quiet:\n" +
+		"    repo: https://github.com/example/quiet\n" +
+		"    aliases: [/hush]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) (status int, goImport, body string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, findMeta(w.Body.Bytes(), "go-import"), w.Body.String()
+	}
+
+	tests := []struct {
+		path     string
+		goImport string
+		banner   string // empty if none
+	}{
+		{"/newname", "example.com/newname git https://github.com/example/newname", ""},
+		{"/oldname/pkg", "example.com/oldname git https://github.com/example/newname", `<a href="https://godoc.org/example.com/newname/pkg">example.com/newname/pkg</a>`},
+		{"/older/name", "example.com/older/name git https://github.com/example/newname", `<a href="https://godoc.org/example.com/newname">`},
+		{"/hush", "example.com/hush git https://github.com/example/quiet", ""},
+	}
+	for _, test := range tests {
+		_, goImport, body := get(test.path)
+		if goImport != test.goImport {
+			t.Errorf("%s: go-import = %q; want %q", test.path, goImport, test.goImport)
+		}
+		if banner := strings.Contains(body, "deprecated"); banner != (test.banner != "") || !strings.Contains(body, test.banner) {
+			t.Errorf("%s: body = %s; want banner %q", test.path, body, test.banner)
+		}
+	}
+	if paths := h.Paths(); len(paths) != 2 {
+		t.Errorf("Paths() = %+v; want the two configured paths", paths)
+	}
+
+	if err := h.AddPath(PathConfig{Path: "/oldname", Repo: "https://github.com/example/other"}); err != ErrPathExists {
+		t.Errorf("AddPath(alias) = %v; want ErrPathExists", err)
+	}
+	if err := h.AddPath(PathConfig{Path: "/other", Repo: "https://github.com/example/other", Aliases: []string{"/hush"}}); err == nil {
+		t.Error("AddPath with a taken alias succeeded")
+	}
+	if err := h.RemovePath("/oldname"); err != ErrPathNotFound {
+		t.Errorf("RemovePath(alias) = %v; want ErrPathNotFound", err)
+	}
+	if err := h.RemovePath("/newname"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := get("/oldname"); status != http.StatusNotFound {
+		t.Errorf("alias of removed path: status = %d; want 404", status)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import "strings"
+
+// A pathTrie finds the configuration of a request path by its longest
+// prefix of whole path elements, with one map lookup per element however
+// many paths there are.  Each node is a path element.
+type pathTrie struct {
+	pc       *pathConfig // for the path ending at this node, if any
+	children map[string]*pathTrie
+}
+
+// newPathTrie returns the trie of the paths of pset, which must not be
+// modified while the trie is in use.
+func newPathTrie(pset pathConfigSet) *pathTrie {
+	root := new(pathTrie)
+	for i := range pset {
+		n := root
+		if p := pset[i].path; p != "" {
+			for _, elem := range strings.Split(p[1:], "/") {
+				child := n.children[elem]
+				if child == nil {
+					if n.children == nil {
+						n.children = make(map[string]*pathTrie)
+					}
+					child = new(pathTrie)
+					n.children[elem] = child
+				}
+				n = child
+			}
+		}
+		n.pc = &pset[i]
+	}
+	return root
+}
+
+// find returns the configuration of the longest path that is path or one
+// of its parents, and the rest of path below it.
+func (t *pathTrie) find(path string) (pc *pathConfig, subpath string) {
+	matched := 0 // length of the prefix of path that pc is for
+	for n, i := t, 0; n != nil; {
+		if n.pc != nil {
+			pc, matched = n.pc, i
+		}
+		if i == len(path) || path[i] != '/' {
+			break
+		}
+		end := strings.IndexByte(path[i+1:], '/')
+		if end < 0 {
+			end = len(path)
+		} else {
+			end += i + 1
+		}
+		n, i = n.children[path[i+1:end]], end
+	}
+	if pc == nil || matched == len(path) {
+		return pc, ""
+	}
+	return pc, path[matched+1:]
+}
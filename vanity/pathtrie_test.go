@@ -0,0 +1,82 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// benchmarkPaths returns a set of n paths like those of an organization
+// sync, a few of them nested, and request paths below them.
+func benchmarkPaths(n int) (pathConfigSet, []string) {
+	pset := make(pathConfigSet, n)
+	queries := make([]string, n)
+	for i := range pset {
+		pset[i].path = fmt.Sprintf("/repo%06d", i)
+		if i%10 == 0 {
+			pset[i].path += "/v2"
+		}
+		queries[i] = pset[i].path + "/internal/pkg"
+	}
+	sort.Sort(pset)
+	return pset, queries
+}
+
+// findSorted is the binary search over the sorted paths that the trie
+// replaced, kept to compare with.
+func findSorted(pset pathConfigSet, path string) (*pathConfig, string) {
+	i := sort.Search(len(pset), func(i int) bool {
+		return pset[i].path >= path
+	})
+	if i < len(pset) && pset[i].path == path {
+		return &pset[i], ""
+	}
+	if i > 0 && strings.HasPrefix(path, pset[i-1].path+"/") {
+		return &pset[i-1], path[len(pset[i-1].path)+1:]
+	}
+	return nil, ""
+}
+
+func BenchmarkPathTrieFind(b *testing.B) {
+	for _, n := range []int{100, 50000} {
+		pset, queries := benchmarkPaths(n)
+		t := newPathTrie(pset)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if pc, _ := t.find(queries[i%n]); pc == nil {
+					b.Fatal("not found")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSortedFind(b *testing.B) {
+	for _, n := range []int{100, 50000} {
+		pset, queries := benchmarkPaths(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if pc, _ := findSorted(pset, queries[i%n]); pc == nil {
+					b.Fatal("not found")
+				}
+			}
+		})
+	}
+}
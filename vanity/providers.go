@@ -0,0 +1,166 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ProviderConfig declares that repositories under Base are hosted by
+// software of the given Type, so that their go-source meta tags and VCS can
+// be inferred like those of the public services.
+type ProviderConfig struct {
+	// Base is the URL prefix of the repositories, e.g.
+	// https://git.internal.example.
+	Base string
+
+	// Type is one of github, gitlab, gitea, forgejo, gogs, bitbucket,
+	// bitbucket-server and sourcehut.
+	Type string
+}
+
+// layout is how a type of code hosting software lays out the web pages of
+// repositories.
+type layout struct {
+	// dir and file are appended to the repository URL to form the last two
+	// fields of the go-source meta tag, with %s standing for the branch.
+	dir, file string
+
+	// branch is the branch to link to unless one is configured.
+	branch string
+
+	// vcs is the version control system it hosts, or empty if it hosts
+	// several.
+	vcs string
+
+	// web, if not nil, returns the URL of the web pages of repo under
+	// base, unless they live at repo without .git.
+	web func(base, repo string) string
+}
+
+var layouts = map[string]layout{
+	"github":           {"/tree/%s{/dir}", "/blob/%s{/dir}/{file}#L{line}", "master", "git", nil},
+	"gitlab":           {"/-/tree/%s{/dir}", "/-/blob/%s{/dir}/{file}#L{line}", "master", "git", nil},
+	"gitea":            {"/src/branch/%s{/dir}", "/src/branch/%s{/dir}/{file}#L{line}", "master", "git", nil},
+	"forgejo":          {"/src/branch/%s{/dir}", "/src/branch/%s{/dir}/{file}#L{line}", "master", "git", nil},
+	"gogs":             {"/src/%s{/dir}", "/src/%s{/dir}/{file}#L{line}", "master", "git", nil},
+	"bitbucket":        {"/src/%s{/dir}", "/src/%s{/dir}/{file}#{file}-{line}", "default", "", nil},
+	"bitbucket-server": {"/browse{/dir}?at=refs/heads/%s", "/browse{/dir}/{file}?at=refs/heads/%s#{line}", "master", "git", bitbucketServerWeb},
+	"sourcehut":        {"/tree/%s/item{/dir}", "/tree/%s/item{/dir}/{file}#L{line}", "master", "git", nil},
+}
+
+// A provider is a code hosting service whose layout is known.
+type provider struct {
+	base string // ends in a slash
+	layout
+}
+
+// builtinProviders are the public code hosting services.
+var builtinProviders = []provider{
+	{"https://github.com/", layouts["github"]},
+	{"https://gitlab.com/", layouts["gitlab"]},
+	{"https://bitbucket.org/", layouts["bitbucket"]},
+	{"https://git.sr.ht/", layouts["sourcehut"]},
+	{"https://codeberg.org/", layouts["forgejo"]},
+	{"https://gitee.com/", layouts["github"]},
+}
+
+// newProviders validates the configured providers and returns them,
+// longest base first, followed by the built-in ones.
+func newProviders(configs []ProviderConfig) ([]provider, error) {
+	var providers []provider
+	for _, c := range configs {
+		l, ok := layouts[c.Type]
+		if !ok {
+			return nil, fmt.Errorf("configuration for providers: unknown type %q for %s", c.Type, c.Base)
+		}
+		u, err := parseAbsURL(c.Base)
+		if err != nil {
+			return nil, fmt.Errorf("configuration for providers: %v", err)
+		}
+		if u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("configuration for providers: base %s has a query or fragment", c.Base)
+		}
+		providers = append(providers, provider{strings.TrimSuffix(c.Base, "/") + "/", l})
+	}
+	sort.SliceStable(providers, func(i, j int) bool { return len(providers[i].base) > len(providers[j].base) })
+	return append(providers, builtinProviders...), nil
+}
+
+// providerOf returns the provider hosting repo, or nil if it is not known.
+func providerOf(providers []provider, repo string) *provider {
+	for i := range providers {
+		if strings.HasPrefix(repo, providers[i].base) {
+			return &providers[i]
+		}
+	}
+	return detectProvider(repo)
+}
+
+// detectProvider returns the provider for an owner/repository URL on a
+// host named after the software serving it, such as
+// https://gitea.example.com/org/repo, or nil if the host is not.
+func detectProvider(repo string) *provider {
+	u, err := url.Parse(repo)
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.User != nil || u.RawQuery != "" {
+		return nil
+	}
+	if strings.Count(strings.Trim(u.Path, "/"), "/") != 1 {
+		return nil
+	}
+	label := u.Hostname()
+	if i := strings.IndexByte(label, '.'); i >= 0 {
+		label = label[:i]
+	}
+	switch label = strings.ToLower(label); label {
+	case "gitea", "forgejo", "gogs":
+		return &provider{u.Scheme + "://" + u.Host + "/", layouts[label]}
+	}
+	return nil
+}
+
+// display returns the last three fields of the go-source meta tag for repo,
+// linking to branch, or to the layout's default branch if branch is empty.
+func (p *provider) display(repo, branch string) string {
+	if branch == "" {
+		branch = p.branch
+	}
+	// Web pages of the repository live at its URL without .git.
+	web := strings.TrimSuffix(repo, ".git")
+	if p.web != nil {
+		web = p.web(p.base, repo)
+	}
+	return web + " " + web + fmt.Sprintf(p.dir, branch) + " " + web + fmt.Sprintf(p.file, branch)
+}
+
+// bitbucketServerWeb maps the clone URL of a Bitbucket Server (Data
+// Center) repository, base/scm/KEY/slug.git, to its web pages at
+// base/projects/KEY/repos/slug, or base/users/NAME/repos/slug for the
+// personal repository of ~NAME.  Other URLs, such as the web pages
+// themselves, are kept.
+func bitbucketServerWeb(base, repo string) string {
+	repo = strings.TrimSuffix(repo, ".git")
+	elems := strings.Split(strings.TrimPrefix(repo, base), "/")
+	if len(elems) != 3 || elems[0] != "scm" {
+		return repo
+	}
+	if strings.HasPrefix(elems[1], "~") {
+		return base + "users/" + strings.TrimPrefix(elems[1], "~") + "/repos/" + elems[2]
+	}
+	return base + "projects/" + elems[1] + "/repos/" + elems[2]
+}
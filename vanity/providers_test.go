@@ -0,0 +1,179 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import "testing"
+
+func TestProviders(t *testing.T) {
+	c := &Config{Providers: []ProviderConfig{
+		{Base: "https://git.internal.example", Type: "gitlab"},
+		{Base: "https://git.internal.example/legacy/", Type: "gogs"},
+		{Base: "https://gitea.example.com", Type: "gitea"},
+		{Base: "https://code.example.com", Type: "forgejo"},
+		{Base: "https://gogs.example.com", Type: "gitea"},
+		{Base: "https://bitbucket.example.com/context", Type: "bitbucket-server"},
+		{Base: "https://ghe.example.com", Type: "github"},
+		{Base: "https://stash.example.com", Type: "bitbucket"},
+		{Base: "https://git.srht.example.com", Type: "sourcehut"},
+	}}
+	d := pathDefaults{}
+	var err error
+	if d.providers, err = newProviders(c.Providers); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		repo    string
+		display string
+		vcs     string // empty if it cannot be inferred
+	}{
+		{
+			repo:    "https://git.internal.example/team/project.git",
+			display: "https://git.internal.example/team/project https://git.internal.example/team/project/-/tree/master{/dir} https://git.internal.example/team/project/-/blob/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://git.internal.example/legacy/old",
+			display: "https://git.internal.example/legacy/old https://git.internal.example/legacy/old/src/master{/dir} https://git.internal.example/legacy/old/src/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://gitea.example.com/org/repo",
+			display: "https://gitea.example.com/org/repo https://gitea.example.com/org/repo/src/branch/master{/dir} https://gitea.example.com/org/repo/src/branch/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://ghe.example.com/org/repo",
+			display: "https://ghe.example.com/org/repo https://ghe.example.com/org/repo/tree/master{/dir} https://ghe.example.com/org/repo/blob/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://stash.example.com/proj/repo",
+			display: "https://stash.example.com/proj/repo https://stash.example.com/proj/repo/src/default{/dir} https://stash.example.com/proj/repo/src/default{/dir}/{file}#{file}-{line}",
+		},
+		{
+			repo:    "https://bitbucket.example.com/context/scm/PROJ/repo.git",
+			display: "https://bitbucket.example.com/context/projects/PROJ/repos/repo https://bitbucket.example.com/context/projects/PROJ/repos/repo/browse{/dir}?at=refs/heads/master https://bitbucket.example.com/context/projects/PROJ/repos/repo/browse{/dir}/{file}?at=refs/heads/master#{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://bitbucket.example.com/context/scm/~jdoe/dotfiles.git",
+			display: "https://bitbucket.example.com/context/users/jdoe/repos/dotfiles https://bitbucket.example.com/context/users/jdoe/repos/dotfiles/browse{/dir}?at=refs/heads/master https://bitbucket.example.com/context/users/jdoe/repos/dotfiles/browse{/dir}/{file}?at=refs/heads/master#{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://github.com/rakyll/portmidi",
+			display: "https://github.com/rakyll/portmidi https://github.com/rakyll/portmidi/tree/master{/dir} https://github.com/rakyll/portmidi/blob/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://git.sr.ht/~sircmpwn/scdoc",
+			display: "https://git.sr.ht/~sircmpwn/scdoc https://git.sr.ht/~sircmpwn/scdoc/tree/master/item{/dir} https://git.sr.ht/~sircmpwn/scdoc/tree/master/item{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://git.srht.example.com/~team/repo",
+			display: "https://git.srht.example.com/~team/repo https://git.srht.example.com/~team/repo/tree/master/item{/dir} https://git.srht.example.com/~team/repo/tree/master/item{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://code.example.com/org/repo",
+			display: "https://code.example.com/org/repo https://code.example.com/org/repo/src/branch/master{/dir} https://code.example.com/org/repo/src/branch/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://codeberg.org/forgejo/forgejo",
+			display: "https://codeberg.org/forgejo/forgejo https://codeberg.org/forgejo/forgejo/src/branch/master{/dir} https://codeberg.org/forgejo/forgejo/src/branch/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://gitee.com/openharmony/docs.git",
+			display: "https://gitee.com/openharmony/docs https://gitee.com/openharmony/docs/tree/master{/dir} https://gitee.com/openharmony/docs/blob/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		// Hosts named after the software are detected, unless configured
+		// otherwise.
+		{
+			repo:    "https://forgejo.example.net/org/repo.git",
+			display: "https://forgejo.example.net/org/repo https://forgejo.example.net/org/repo/src/branch/master{/dir} https://forgejo.example.net/org/repo/src/branch/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://Gogs.example.net/org/repo",
+			display: "https://Gogs.example.net/org/repo https://Gogs.example.net/org/repo/src/master{/dir} https://Gogs.example.net/org/repo/src/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{
+			repo:    "https://gogs.example.com/org/repo",
+			display: "https://gogs.example.com/org/repo https://gogs.example.com/org/repo/src/branch/master{/dir} https://gogs.example.com/org/repo/src/branch/master{/dir}/{file}#L{line}",
+			vcs:     "git",
+		},
+		{repo: "https://gitea.example.net/org/group/repo"},
+		{repo: "https://gitty.example.net/org/repo"},
+		// A base is a prefix of whole path elements.
+		{repo: "https://git.internal.example.org/x/y"},
+		{repo: "https://elsewhere.example/x/y"},
+	}
+	for _, test := range tests {
+		pc, err := newPathConfig(PathConfig{Path: "/p", Repo: test.repo}, d)
+		if test.vcs == "" {
+			if err == nil {
+				t.Errorf("%s: VCS inferred as %q; want error", test.repo, pc.vcs)
+			}
+			pc, err = newPathConfig(PathConfig{Path: "/p", Repo: test.repo, VCS: "git"}, d)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.repo, err)
+			continue
+		}
+		if pc.display != test.display {
+			t.Errorf("%s: display = %q; want %q", test.repo, pc.display, test.display)
+		}
+		if test.vcs != "" && pc.vcs != test.vcs {
+			t.Errorf("%s: vcs = %q; want %q", test.repo, pc.vcs, test.vcs)
+		}
+	}
+
+	for _, bad := range []ProviderConfig{
+		{Base: "https://git.example.com", Type: "sourceforge"},
+		{Base: "git.example.com", Type: "gitlab"},
+		{Base: "https://git.example.com?x=1", Type: "gitlab"},
+	} {
+		if err := (&Config{Providers: []ProviderConfig{bad}}).Validate(); err == nil {
+			t.Errorf("Validate with provider %+v succeeded; want error", bad)
+		}
+	}
+}
+
+func TestParseProviders(t *testing.T) {
+	c, err := ParseConfig([]byte("providers:\n" +
+		"  https://git.internal.example: gitlab\n" +
+		"  https://gitea.example.com: gitea\n" +
+		"paths:\n" +
+		"  /project:\n" +
+		"    repo: https://git.internal.example/team/project\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ProviderConfig{
+		{Base: "https://git.internal.example", Type: "gitlab"},
+		{Base: "https://gitea.example.com", Type: "gitea"},
+	}
+	if len(c.Providers) != len(want) || c.Providers[0] != want[0] || c.Providers[1] != want[1] {
+		t.Errorf("Providers = %+v; want %+v", c.Providers, want)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
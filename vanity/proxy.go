@@ -0,0 +1,204 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// moduleProxy forwards Go module proxy requests to an upstream GOPROXY.
+// Responses that never change for a given URL (.info, .mod and .zip files)
+// are kept in an on-disk cache if cacheDir is set.
+type moduleProxy struct {
+	upstream string
+	cacheDir string
+	client   *http.Client
+	timeout  time.Duration
+}
+
+func newModuleProxy(upstream, cacheDir string) *moduleProxy {
+	return &moduleProxy{
+		upstream: strings.TrimSuffix(upstream, "/"),
+		cacheDir: cacheDir,
+		client:   http.DefaultClient,
+		timeout:  defaultUpstreamTimeout,
+	}
+}
+
+// isProxyRequest reports whether p is a request in the module proxy protocol.
+func isProxyRequest(p string) bool {
+	return strings.Contains(p, "/@v/") || strings.HasSuffix(p, "/@latest")
+}
+
+// immutable reports whether the proxy response for p can be cached forever.
+func immutable(p string) bool {
+	if !strings.Contains(p, "/@v/") {
+		return false
+	}
+	switch path.Ext(p) {
+	case ".info", ".mod", ".zip":
+		return true
+	}
+	return false
+}
+
+func (p *moduleProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cacheKey := ""
+	if immutable(r.URL.Path) {
+		cacheKey = r.URL.Path
+	}
+	forward(w, r, p.client, p.timeout, p.upstream+r.URL.Path, p.cacheDir, cacheKey)
+}
+
+// forward serves r from url.  If cacheDir and cacheKey are both set, a
+// successful response is stored under cacheKey and later requests are served
+// from disk without contacting url.  The upstream must start responding
+// within timeout; the transfer itself is only bounded by the request's
+// context, so that large module zips are not cut off.
+func forward(w http.ResponseWriter, r *http.Request, client *http.Client, timeout time.Duration, url, cacheDir, cacheKey string) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if path.Clean(r.URL.Path) != r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	cache := cacheDir != "" && cacheKey != ""
+	var cachePath string
+	if cache {
+		cachePath = filepath.Join(cacheDir, filepath.FromSlash(cacheKey))
+		if f, err := os.Open(cachePath); err == nil {
+			defer f.Close()
+			w.Header().Set("Content-Type", proxyContentType(cacheKey))
+			io.Copy(w, f)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	timer := time.AfterFunc(timeout, cancel)
+	resp, err := client.Do(req.WithContext(ctx))
+	timer.Stop()
+	if err != nil {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if resp.StatusCode != http.StatusOK || !cache {
+		// Pass errors through unchanged: the go command treats 404 and 410
+		// as "not found" and falls back to the next proxy or direct mode.
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	tmp, err := createTemp(cachePath)
+	if err != nil {
+		io.Copy(w, resp.Body)
+		return
+	}
+	_, err = io.Copy(w, io.TeeReader(resp.Body, tmp))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// createTemp creates a temporary file next to dst so that it can be renamed
+// into place once it has been completely written.
+func createTemp(dst string) (*os.File, error) {
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, ".tmp-")
+}
+
+func proxyContentType(p string) string {
+	if strings.Contains(p, "/tile/") {
+		return "application/octet-stream"
+	}
+	switch path.Ext(p) {
+	case ".info":
+		return "application/json"
+	case ".zip":
+		return "application/zip"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// sumdbProxy forwards checksum database requests made through the proxy
+// protocol (/sumdb/<name>/...) to the checksum database itself.  Lookups and
+// tiles never change once published and are cached like module files.
+type sumdbProxy struct {
+	name     string
+	upstream string
+	cacheDir string
+	client   *http.Client
+	timeout  time.Duration
+}
+
+func newSumdbProxy(cacheDir string) *sumdbProxy {
+	return &sumdbProxy{
+		name:     "sum.golang.org",
+		upstream: "https://sum.golang.org",
+		cacheDir: cacheDir,
+		client:   http.DefaultClient,
+		timeout:  defaultUpstreamTimeout,
+	}
+}
+
+func (p *sumdbProxy) prefix() string {
+	return "/sumdb/" + p.name + "/"
+}
+
+func (p *sumdbProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, p.prefix())
+	if rest == "supported" {
+		// Tell the go command that it may route checksum database requests
+		// through this host.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	cacheKey := ""
+	if strings.HasPrefix(rest, "lookup/") || strings.HasPrefix(rest, "tile/") {
+		cacheKey = r.URL.Path
+	}
+	forward(w, r, p.client, p.timeout, p.upstream+"/"+rest, p.cacheDir, cacheKey)
+}
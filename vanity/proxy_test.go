@@ -0,0 +1,184 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestModuleProxy(t *testing.T) {
+	hits := make(map[string]int)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/example.com/foo/@v/list":
+			io.WriteString(w, "v1.0.0\n")
+		case "/example.com/foo/@v/v1.0.0.mod":
+			io.WriteString(w, "module example.com/foo\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+	dir, err := ioutil.TempDir("", "govanityurls-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := NewHandler([]byte("proxy:\n" +
+		"  upstream: " + upstream.URL + "\n" +
+		"  cache_dir: " + dir + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/example.com/foo/@v/list", http.StatusOK, "v1.0.0\n"},
+		{"/example.com/foo/@v/list", http.StatusOK, "v1.0.0\n"},
+		{"/example.com/foo/@v/v1.0.0.mod", http.StatusOK, "module example.com/foo\n"},
+		{"/example.com/foo/@v/v1.0.0.mod", http.StatusOK, "module example.com/foo\n"},
+		{"/example.com/foo/@v/v2.0.0.mod", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(s.URL + test.path)
+		if err != nil {
+			t.Errorf("http.Get(%q): %v", test.path, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("%s: ioutil.ReadAll: %v", test.path, err)
+			continue
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status code = %s; want %d", test.path, resp.Status, test.status)
+		}
+		if test.status == http.StatusOK && string(data) != test.body {
+			t.Errorf("%s: body = %q; want %q", test.path, data, test.body)
+		}
+	}
+	if n := hits["/example.com/foo/@v/list"]; n != 2 {
+		t.Errorf("upstream list requests = %d; want 2 (lists are not cached)", n)
+	}
+	if n := hits["/example.com/foo/@v/v1.0.0.mod"]; n != 1 {
+		t.Errorf("upstream .mod requests = %d; want 1 (served from cache)", n)
+	}
+}
+
+func TestSumdbProxy(t *testing.T) {
+	hits := make(map[string]int)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer upstream.Close()
+	dir, err := ioutil.TempDir("", "govanityurls-sumdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := NewHandler([]byte("proxy:\n" +
+		"  sumdb: true\n" +
+		"  cache_dir: " + dir + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.sumdb.upstream = upstream.URL
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	paths := []string{
+		"/sumdb/sum.golang.org/supported",
+		"/sumdb/sum.golang.org/latest",
+		"/sumdb/sum.golang.org/latest",
+		"/sumdb/sum.golang.org/lookup/example.com/foo@v1.0.0",
+		"/sumdb/sum.golang.org/lookup/example.com/foo@v1.0.0",
+		"/sumdb/sum.golang.org/tile/8/0/001",
+		"/sumdb/sum.golang.org/tile/8/0/001",
+	}
+	for _, p := range paths {
+		resp, err := http.Get(s.URL + p)
+		if err != nil {
+			t.Errorf("http.Get(%q): %v", p, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status code = %s; want 200 OK", p, resp.Status)
+		}
+	}
+	want := map[string]int{
+		"/latest":                        2,
+		"/lookup/example.com/foo@v1.0.0": 1,
+		"/tile/8/0/001":                  1,
+	}
+	for p, n := range want {
+		if hits[p] != n {
+			t.Errorf("upstream requests for %s = %d; want %d", p, hits[p], n)
+		}
+	}
+	if hits["/supported"] != 0 {
+		t.Errorf("/supported was forwarded upstream; want it answered locally")
+	}
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"upstream_timeout: 50ms\n" +
+		"paths:\n" +
+		"  /foo:\n" +
+		"    repo: https://github.com/example/foo\n" +
+		"proxy:\n" +
+		"  upstream: " + upstream.URL + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/example.com/foo/@v/list", "/api/v1/paths/foo/latest"} {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("%s: status = %d; want 502", path, w.Code)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: took %v", path, d)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultProxyHeaderTimeout bounds reading the PROXY protocol header unless
+// the listener says otherwise.
+const defaultProxyHeaderTimeout = 10 * time.Second
+
+// ProxyProtocolListener accepts connections from a TCP load balancer that
+// starts each with a PROXY protocol header, version 1 or 2, as HAProxy and
+// many cloud load balancers send.  The connections report the client
+// address of the header as their RemoteAddr, so that access logs and rate
+// limits see the client instead of the load balancer.  Connections without
+// a valid header are closed.
+type ProxyProtocolListener struct {
+	net.Listener
+
+	// Timeout bounds reading the header.  Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Accept returns the next connection.  Its header is only read on the first
+// call of Read or RemoteAddr, so that slow clients do not hold up others.
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	return &proxyConn{Conn: c, timeout: timeout}, nil
+}
+
+// proxyConn is a connection that starts with a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+
+	once   sync.Once
+	r      *bufio.Reader // the rest of the connection
+	remote net.Addr      // from the header, if it had one
+	err    error         // reading the header
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.r = bufio.NewReader(c.Conn)
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// proxyV2Signature starts a version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader reads a PROXY protocol header from r and returns the
+// source address in it, or nil if the header does not name one, as for
+// health checks of the load balancer itself.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(len(proxyV2Signature))
+	switch {
+	case err == nil && bytes.Equal(b, proxyV2Signature):
+		return readProxyHeaderV2(r)
+	case len(b) >= 6 && string(b[:6]) == "PROXY ":
+		return readProxyHeaderV1(r)
+	case err != nil:
+		return nil, fmt.Errorf("reading PROXY protocol header: %v", err)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// readProxyHeaderV1 reads a header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// The header is at most 107 bytes long.
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == 107 {
+			return nil, errors.New("PROXY protocol header too long")
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading PROXY protocol header: %v", err)
+		}
+		line = append(line, c)
+	}
+	f := strings.Fields(string(line))
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || f[1] != "TCP4" && f[1] != "TCP6" {
+		return nil, fmt.Errorf("invalid PROXY protocol header %q", line)
+	}
+	ip := net.ParseIP(f[2])
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (f[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid PROXY protocol header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads a binary header.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading PROXY protocol header: %v", err)
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("reading PROXY protocol header: %v", err)
+	}
+	if hdr[12]&0xf == 0 {
+		// LOCAL: the load balancer talking for itself.
+		return nil, nil
+	}
+	// The address family is in the high nibble and the transport, which
+	// must be a stream, in the low one.
+	switch hdr[13] {
+	case 0x11:
+		if len(body) < 12 {
+			return nil, errors.New("PROXY protocol header too short")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21:
+		if len(body) < 36 {
+			return nil, errors.New("PROXY protocol header too short")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	// Unix sockets and the like tell nothing useful.
+	return nil, nil
+}
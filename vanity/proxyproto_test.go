@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolListener(t *testing.T) {
+	v2 := func(cmd, fam byte, addrs ...byte) string {
+		return string(proxyV2Signature) + string([]byte{0x20 | cmd, fam, 0, byte(len(addrs))}) + string(addrs)
+	}
+	tests := []struct {
+		name   string
+		header string
+		remote string // empty to keep the connection's
+		err    bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 mismatched family", "PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 192.0.2.1 198.51.100.1 99999 443\r\n", "", true},
+		{"v2 tcp4", v2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb), "192.0.2.1:56324", false},
+		{"v2 tcp6", v2(1, 0x21, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0xdc, 0x04, 0x01, 0xbb), "[2001:db8::1]:56324", false},
+		{"v2 local", v2(0, 0), "", false},
+		{"v2 short", v2(1, 0x11, 192, 0, 2, 1), "", true},
+		{"missing", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "", true},
+	}
+	for _, test := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pl := &ProxyProtocolListener{Listener: l, Timeout: time.Second}
+		go func(header string) {
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			c.Write([]byte(header + "hello"))
+		}(test.header)
+		c, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote := c.RemoteAddr().String()
+		body, err := ioutil.ReadAll(c)
+		c.Close()
+		l.Close()
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: read %q; want error", test.name, body)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(body) != "hello" {
+			t.Errorf("%s: read %q; want hello", test.name, body)
+		}
+		if test.remote == "" {
+			if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.1" {
+				t.Errorf("%s: RemoteAddr = %s; want the connection's", test.name, remote)
+			}
+		} else if remote != test.remote {
+			t.Errorf("%s: RemoteAddr = %s; want %s", test.name, remote, test.remote)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig limits how fast each client, by IP address, may make
+// requests, with a token bucket per client.
+type RateLimitConfig struct {
+	// Rate is the number of requests per second that a client may make
+	// on average.  If zero, requests are not limited.
+	Rate float64
+
+	// Burst is the number of requests that a client may make at once.
+	// Defaults to Rate, but at least 1.
+	Burst int
+}
+
+// rateLimitPruneInterval is how often the buckets of clients that have not
+// made requests in a while are dropped.
+const rateLimitPruneInterval = time.Minute
+
+// rateLimiter keeps a token bucket per client.
+type rateLimiter struct {
+	rate  float64 // tokens added per second
+	burst float64 // tokens a bucket holds at most
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket // by client IP
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // when tokens was computed
+}
+
+func newRateLimiter(c RateLimitConfig, now func() time.Time) (*rateLimiter, error) {
+	switch {
+	case c.Rate < 0 || math.IsInf(c.Rate, 0) || math.IsNaN(c.Rate):
+		return nil, errors.New("configuration for rate_limit: rate must be a positive number")
+	case c.Burst < 0:
+		return nil, errors.New("configuration for rate_limit: burst must not be negative")
+	case c.Rate == 0 && c.Burst > 0:
+		return nil, errors.New("configuration for rate_limit: burst needs a rate")
+	case c.Rate == 0:
+		return nil, nil
+	}
+	burst := float64(c.Burst)
+	if burst == 0 {
+		burst = math.Max(1, math.Ceil(c.Rate))
+	}
+	return &rateLimiter{rate: c.Rate, burst: burst, now: now, buckets: make(map[string]*bucket)}, nil
+}
+
+// allow takes a token from the bucket of client if there is one.  If there
+// is none, it returns how long until there is.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) >= rateLimitPruneInterval {
+		l.prune(now)
+	}
+	b := l.buckets[client]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// refill returns the tokens in b at now.
+func (l *rateLimiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// prune drops the buckets that are full again, which are no different from
+// new ones.
+func (l *rateLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.pruned = now
+}
+
+// rateLimitMiddleware answers 429 Too Many Requests, with a Retry-After
+// header, to clients that exceed the rate limit.
+func (h *Handler) rateLimitMiddleware(l *rateLimiter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, wait := l.allow(h.clientIP(r))
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				h.error(w, r, http.StatusTooManyRequests, "rate_limited")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
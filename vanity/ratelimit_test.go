@@ -0,0 +1,124 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l, err := newRateLimiter(RateLimitConfig{Rate: 2, Burst: 3}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		advance time.Duration
+		client  string
+		ok      bool
+		wait    time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		{0, "b", true, 0},
+		{250 * time.Millisecond, "a", false, 250 * time.Millisecond},
+		{250 * time.Millisecond, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		{time.Hour, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+	}
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		ok, wait := l.allow(step.client)
+		if ok != step.ok || wait != step.wait {
+			t.Errorf("step %d: allow(%s) = %v, %v; want %v, %v", i, step.client, ok, wait, step.ok, step.wait)
+		}
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets after pruning; want 1", len(l.buckets))
+	}
+
+	for _, c := range []RateLimitConfig{{Rate: -1}, {Rate: 1, Burst: -1}, {Burst: 5}} {
+		if _, err := newRateLimiter(c, time.Now); err == nil {
+			t.Errorf("newRateLimiter(%+v) succeeded; want error", c)
+		}
+	}
+	if l, err := newRateLimiter(RateLimitConfig{}, time.Now); l != nil || err != nil {
+		t.Errorf("newRateLimiter without a rate = %v, %v; want nil, nil", l, err)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	now := time.Unix(0, 0)
+	config := []byte("rate_limit:\n" +
+		"  rate: 1\n" +
+		"trusted_proxies: [10.0.0.0/8]\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n")
+	h, err := NewHandler(config, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote string
+		xff    string
+		code   int
+	}{
+		{"192.0.2.1:1234", "", http.StatusOK},
+		{"192.0.2.1:5678", "", http.StatusTooManyRequests},
+		{"192.0.2.2:1234", "", http.StatusOK},
+		// Behind a proxy, clients are told apart by X-Forwarded-For.
+		{"10.0.0.1:1234", "198.51.100.1", http.StatusOK},
+		{"10.0.0.2:1234", "198.51.100.2, 10.0.0.1", http.StatusOK},
+		{"10.0.0.1:1234", "198.51.100.1", http.StatusTooManyRequests},
+		// Addresses before the first untrusted one may be made up.
+		{"10.0.0.1:1234", "203.0.113.1, 198.51.100.1", http.StatusTooManyRequests},
+		// Other clients cannot claim to be someone else.
+		{"192.0.2.3:1234", "198.51.100.3", http.StatusOK},
+		{"192.0.2.3:1234", "198.51.100.4", http.StatusTooManyRequests},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/portmidi?go-get=1", nil)
+		r.RemoteAddr = test.remote
+		if test.xff != "" {
+			r.Header.Set("X-Forwarded-For", test.xff)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s for %s: status = %d; want %d", test.remote, test.xff, w.Code, test.code)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("%s for %s: Retry-After = %q; want 1", test.remote, test.xff, w.Header().Get("Retry-After"))
+		}
+	}
+	// Tokens come back at the rate, by the handler's clock.
+	now = now.Add(time.Second)
+	r := httptest.NewRequest("GET", "/portmidi?go-get=1", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("a second later: status = %d; want %d", w.Code, http.StatusOK)
+	}
+}
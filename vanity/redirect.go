@@ -0,0 +1,61 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Redirect targets that name a destination instead of giving its URL.
+const (
+	redirectPkgGoDev = "pkg.go.dev"
+	redirectRepo     = "repo"
+)
+
+// importPlaceholder stands for the requested import path in redirect URLs.
+const importPlaceholder = "{import}"
+
+// checkRedirect reports whether target is a valid redirect for visitors to
+// the repository repo.
+func checkRedirect(target, repo string) error {
+	switch target {
+	case "", redirectPkgGoDev:
+		return nil
+	case redirectRepo:
+		if !strings.HasPrefix(repo, "https://") && !strings.HasPrefix(repo, "http://") {
+			return fmt.Errorf("redirect to repo needs a web repository URL, got %s", repo)
+		}
+		return nil
+	}
+	if _, err := parseAbsURL(strings.Replace(target, importPlaceholder, "x", -1)); err != nil {
+		return fmt.Errorf("redirect: want %s, %s or an absolute URL: %v", redirectPkgGoDev, redirectRepo, err)
+	}
+	return nil
+}
+
+// redirectURL returns where to send a browser asking for importPath, or ""
+// to serve the page.
+func (pc *pathConfig) redirectURL(importPath string) string {
+	switch pc.redirect {
+	case "":
+		return ""
+	case redirectPkgGoDev:
+		return "https://pkg.go.dev/" + importPath
+	case redirectRepo:
+		return strings.TrimSuffix(pc.repo, ".git")
+	}
+	return strings.Replace(pc.redirect, importPlaceholder, importPath, -1)
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBrowserRedirect(t *testing.T) {
+	h, err := NewHandler([]byte("host: go.example.com\n" +
+		"redirect: pkg.go.dev\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"  /internal:\n" +
+		"    repo: https://git.corp.example/internal.git\n" +
+		"    vcs: git\n" +
+		"    redirect: repo\n" +
+		"  /docs:\n" +
+		"    repo: https://github.com/corp/docs\n" +
+		"    redirect: https://docs.corp.example/{import}\n" +
+		"pathrules:\n" +
+		"  /x/{name}:\n" +
+		"    repo: https://github.com/x/{name}\n" +
+		"    redirect: https://wiki.corp.example/{name}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		location string // empty if the page is served
+	}{
+		{"/portmidi", "https://pkg.go.dev/go.example.com/portmidi"},
+		{"/portmidi/sub/", "https://pkg.go.dev/go.example.com/portmidi/sub"},
+		{"/portmidi?go-get=1", ""},
+		{"/portmidi/sub?go-get=1", ""},
+		{"/internal/pkg", "https://git.corp.example/internal"},
+		{"/docs/a", "https://docs.corp.example/go.example.com/docs/a"},
+		{"/x/lib", "https://wiki.corp.example/lib"},
+		{"/x/lib?go-get=1", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if test.location == "" {
+			if w.Code != 200 || !strings.Contains(w.Body.String(), `<meta name="go-import"`) {
+				t.Errorf("%s: status = %d, body = %s; want the meta tags", test.path, w.Code, w.Body.String())
+			}
+			continue
+		}
+		if w.Code != 302 || w.Header().Get("Location") != test.location {
+			t.Errorf("%s: status = %d, Location = %q; want 302 to %s", test.path, w.Code, w.Header().Get("Location"), test.location)
+		}
+	}
+}
+
+func TestBadRedirects(t *testing.T) {
+	tests := []struct {
+		redirect string
+		repo     string
+	}{
+		{"godoc", "https://github.com/rakyll/portmidi"},
+		{"/relative/{import}", "https://github.com/rakyll/portmidi"},
+		{"repo", "ssh://git@github.com/rakyll/portmidi"},
+	}
+	for _, test := range tests {
+		_, err := NewHandler([]byte("paths:\n" +
+			"  /portmidi:\n" +
+			"    repo: " + test.repo + "\n" +
+			"    vcs: git\n" +
+			"    redirect: " + test.redirect + "\n"))
+		if err == nil || !strings.Contains(err.Error(), "configuration for /portmidi: redirect") {
+			t.Errorf("%s: err = %v; want redirect error", test.redirect, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const releasesTTL = 15 * time.Minute
+
+// release is the latest release of a repository on its code hosting service.
+type release struct {
+	Name   string
+	URL    string
+	Assets []releaseAsset
+}
+
+// releaseAsset is a binary attached to a release.
+type releaseAsset struct {
+	Name string
+	URL  string
+}
+
+// releasesURL returns the page listing the releases of repo, or the empty
+// string if the code hosting service is not known.
+func releasesURL(repo string) string {
+	switch {
+	case strings.HasPrefix(repo, "https://github.com/"):
+		return repo + "/releases"
+	case strings.HasPrefix(repo, "https://gitlab.com/"):
+		return repo + "/-/releases"
+	}
+	return ""
+}
+
+// releaseCache fetches the latest releases of GitHub and GitLab.com
+// repositories and keeps them for releasesTTL, so that landing pages don't
+// eat into the API rate limits.
+type releaseCache struct {
+	githubAPI string
+	gitlabAPI string
+	client    *http.Client
+	now       func() time.Time
+	timeout   time.Duration
+
+	// githubToken and gitlabToken, if set, authenticate the API requests.
+	githubToken string
+	gitlabToken string
+
+	mu      sync.Mutex
+	entries map[string]releaseEntry // by repository
+}
+
+type releaseEntry struct {
+	rel     *release
+	fetched time.Time
+}
+
+func newReleaseCache() *releaseCache {
+	return &releaseCache{
+		githubAPI: "https://api.github.com",
+		gitlabAPI: "https://gitlab.com",
+		client:    http.DefaultClient,
+		now:       time.Now,
+		timeout:   defaultUpstreamTimeout,
+		entries:   make(map[string]releaseEntry),
+	}
+}
+
+// latest returns the latest release of repo.  It returns nil if repo is not
+// hosted on GitHub or GitLab.com, has no releases, or the API could not be
+// reached.
+func (c *releaseCache) latest(ctx context.Context, repo string) *release {
+	var fetch func(context.Context, string) (*release, error)
+	name := githubRepo(repo)
+	if name != "" {
+		fetch = c.fetchGitHub
+	} else if name = gitlabProject(repo); name != "" {
+		fetch = c.fetchGitLab
+	} else {
+		return nil
+	}
+	c.mu.Lock()
+	e, ok := c.entries[repo]
+	c.mu.Unlock()
+	if ok && c.now().Sub(e.fetched) < releasesTTL {
+		return e.rel
+	}
+	rel, err := fetch(ctx, name)
+	if err != nil {
+		// Keep serving what we had before, if anything.
+		return e.rel
+	}
+	c.mu.Lock()
+	c.entries[repo] = releaseEntry{rel: rel, fetched: c.now()}
+	c.mu.Unlock()
+	return rel
+}
+
+// gitlabProject returns the path of the GitLab.com project at repo, or the
+// empty string if repo is not one.
+func gitlabProject(repo string) string {
+	project := strings.TrimSuffix(strings.TrimPrefix(repo, "https://gitlab.com/"), ".git")
+	if project == repo || !strings.Contains(project, "/") || strings.Contains(project, "/-/") {
+		return ""
+	}
+	return project
+}
+
+func (c *releaseCache) fetchGitHub(ctx context.Context, ownerRepo string) (*release, error) {
+	header := http.Header{"Accept": {"application/vnd.github+json"}}
+	if c.githubToken != "" {
+		header.Set("Authorization", "Bearer "+c.githubToken)
+	}
+	var parsed struct {
+		Name    string `json:"name"`
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	u := c.githubAPI + "/repos/" + ownerRepo + "/releases/latest"
+	if found, err := c.get(ctx, u, header, &parsed); err != nil || !found {
+		return nil, err
+	}
+	rel := &release{Name: parsed.Name, URL: parsed.HTMLURL}
+	if rel.Name == "" {
+		rel.Name = parsed.TagName
+	}
+	for _, a := range parsed.Assets {
+		rel.Assets = append(rel.Assets, releaseAsset{Name: a.Name, URL: a.URL})
+	}
+	return rel, nil
+}
+
+func (c *releaseCache) fetchGitLab(ctx context.Context, project string) (*release, error) {
+	header := make(http.Header)
+	if c.gitlabToken != "" {
+		header.Set("PRIVATE-TOKEN", c.gitlabToken)
+	}
+	var parsed struct {
+		Name    string `json:"name"`
+		TagName string `json:"tag_name"`
+		Links   struct {
+			Self string `json:"self"`
+		} `json:"_links"`
+		Assets struct {
+			Links []struct {
+				Name           string `json:"name"`
+				URL            string `json:"url"`
+				DirectAssetURL string `json:"direct_asset_url"`
+			} `json:"links"`
+		} `json:"assets"`
+	}
+	u := c.gitlabAPI + "/api/v4/projects/" + url.PathEscape(project) + "/releases/permalink/latest"
+	if found, err := c.get(ctx, u, header, &parsed); err != nil || !found {
+		return nil, err
+	}
+	rel := &release{Name: parsed.Name, URL: parsed.Links.Self}
+	if rel.Name == "" {
+		rel.Name = parsed.TagName
+	}
+	for _, a := range parsed.Assets.Links {
+		asset := releaseAsset{Name: a.Name, URL: a.DirectAssetURL}
+		if asset.URL == "" {
+			asset.URL = a.URL
+		}
+		rel.Assets = append(rel.Assets, asset)
+	}
+	return rel, nil
+}
+
+// get decodes the response of the API at u into v.  It returns false if
+// there is no such release.
+func (c *releaseCache) get(ctx context.Context, u string, header http.Header, v interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header = header
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s: API returned %s", u, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("%s: %v", u, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestToolLandingPage(t *testing.T) {
+	calls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/repos/example/tool/releases/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"tag_name":"v1.0.0","html_url":"https://github.com/example/tool/releases/tag/v1.0.0",`+
+			`"assets":[{"name":"tool_linux_amd64.tar.gz","browser_download_url":"https://github.com/example/tool/releases/download/v1.0.0/tool_linux_amd64.tar.gz"}]}`)
+	}))
+	defer api.Close()
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /tool:\n" +
+		"    repo: https://github.com/example/tool\n" +
+		"    tool: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithGitHubToken("token")(h)
+	h.releases.githubAPI = api.URL
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// go get is served the meta tags without asking for releases.
+	resp, err := http.Get(s.URL + "/tool/cmd/tool?go-get=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls != 0 {
+		t.Errorf("go-get request made %d release API calls; want none", calls)
+	}
+
+	resp, err = http.Get(s.URL + "/tool/cmd/tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := findMeta(data, "go-import"), "example.com/tool git https://github.com/example/tool"; got != want {
+		t.Errorf("meta go-import = %q; want %q", got, want)
+	}
+	for _, want := range []string{
+		"go install example.com/tool/cmd/tool@latest",
+		`<a href="https://github.com/example/tool/releases/tag/v1.0.0">v1.0.0</a>`,
+		"tool_linux_amd64.tar.gz",
+		`<a href="https://github.com/example/tool/releases">`,
+	} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("landing page does not contain %q:\n%s", want, data)
+		}
+	}
+	if bytes.Contains(data, []byte(`http-equiv="refresh"`)) {
+		t.Errorf("landing page redirects away:\n%s", data)
+	}
+}
+
+func TestToolLandingPageGitLab(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "token" {
+			http.Error(w, "not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.EscapedPath() != "/api/v4/projects/example%2Ftools%2Ftool/releases/permalink/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"name":"Tool 1.0","tag_name":"v1.0.0","_links":{"self":"https://gitlab.com/example/tools/tool/-/releases/v1.0.0"},`+
+			`"assets":{"links":[{"name":"tool_linux_amd64.tar.gz","url":"https://gitlab.com/example/tools/tool/-/jobs/1/artifacts/raw/tool_linux_amd64.tar.gz"}]}}`)
+	}))
+	defer api.Close()
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /tool:\n" +
+		"    repo: https://gitlab.com/example/tools/tool\n" +
+		"    tool: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithGitLabToken("token")(h)
+	h.releases.gitlabAPI = api.URL
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/tool", nil))
+	for _, want := range []string{
+		`<a href="https://gitlab.com/example/tools/tool/-/releases/v1.0.0">Tool 1.0</a>`,
+		`href="https://gitlab.com/example/tools/tool/-/jobs/1/artifacts/raw/tool_linux_amd64.tar.gz"`,
+		`<a href="https://gitlab.com/example/tools/tool/-/releases">`,
+	} {
+		if !bytes.Contains(w.Body.Bytes(), []byte(want)) {
+			t.Errorf("landing page does not contain %q:\n%s", want, w.Body.Bytes())
+		}
+	}
+}
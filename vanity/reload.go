@@ -0,0 +1,226 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultReloadDebounce = time.Second
+
+// A Reloader serves the handler for a configuration file and replaces it
+// whenever the file changes.  A changed configuration that does not load
+// is logged and the previous one kept.
+type Reloader struct {
+	// Path is the configuration file, or a directory of them.
+	Path string
+
+	// Read returns the configuration.  If nil, the file at Path is read.
+	// It is needed for directories.
+	Read func() ([]byte, error)
+
+	// Load returns the handler for the contents of the file.  If nil,
+	// NewHandler is used.
+	Load func(config []byte) (*Handler, error)
+
+	// Interval, if positive, makes Run reread the configuration at this
+	// interval instead of watching Path, e.g. for a RemoteConfig.
+	Interval time.Duration
+
+	// Debounce is how long the file must stay unchanged before it is
+	// reloaded, so that a series of writes is loaded once.  Defaults to a
+	// second.
+	Debounce time.Duration
+
+	// Health, if not nil, gets the outcome of each reload reported as its
+	// "config" component.
+	Health *Health
+
+	// Logger receives the outcome of reloads.  If nil, the standard logger
+	// is used.
+	Logger *log.Logger
+
+	// h is read by requests without locking, so that a reload never holds
+	// them up.
+	h atomic.Pointer[Handler]
+
+	mu     sync.Mutex
+	config []byte // from which h was loaded
+	stats  ReloadStats
+}
+
+// ReloadStats counts the reloads that Run attempted after changes.
+type ReloadStats struct {
+	Succeeded int
+	Failed    int
+}
+
+// Stats returns the number of reloads so far by their outcome.
+func (rl *Reloader) Stats() ReloadStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.stats
+}
+
+// Handler returns the current handler, or nil before the first load.
+func (rl *Reloader) Handler() *Handler {
+	return rl.h.Load()
+}
+
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := rl.Handler()
+	if h == nil {
+		http.Error(w, "configuration not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// Reload loads the file and, if it changed and loads, replaces the handler.
+// It reports whether the handler was replaced.
+func (rl *Reloader) Reload() (bool, error) {
+	read := rl.Read
+	if read == nil {
+		read = func() ([]byte, error) { return ioutil.ReadFile(rl.Path) }
+	}
+	config, err := read()
+	if err != nil {
+		return false, err
+	}
+	rl.mu.Lock()
+	unchanged := rl.h.Load() != nil && bytes.Equal(config, rl.config)
+	rl.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+	load := rl.Load
+	if load == nil {
+		load = func(config []byte) (*Handler, error) { return NewHandler(config) }
+	}
+	h, err := load(config)
+	if err != nil {
+		return false, err
+	}
+	rl.mu.Lock()
+	rl.h.Store(h)
+	rl.config = config
+	rl.mu.Unlock()
+	return true, nil
+}
+
+// Run watches the file and reloads it after changes until ctx is done.  The
+// directory of the file is watched, so that files replaced by renaming, as
+// editors and Kubernetes ConfigMaps do, are followed too.  If Path is a
+// directory, it is watched itself.
+func (rl *Reloader) Run(ctx context.Context) error {
+	if rl.Handler() == nil {
+		if _, err := rl.Reload(); err != nil {
+			return err
+		}
+	}
+	if rl.Interval > 0 {
+		t := time.NewTicker(rl.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-t.C:
+				rl.reload()
+			}
+		}
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	dir := filepath.Dir(rl.Path)
+	if fi, err := os.Stat(rl.Path); err == nil && fi.IsDir() {
+		dir = rl.Path
+	}
+	if err := w.Add(dir); err != nil {
+		return err
+	}
+	debounce := rl.Debounce
+	if debounce <= 0 {
+		debounce = defaultReloadDebounce
+	}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case _, ok := <-w.Events:
+			if !ok {
+				return errors.New("reload: watcher closed")
+			}
+			// Any change in the directory might be to the file; Reload
+			// ignores the others by comparing contents.
+			timer.Reset(debounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return errors.New("reload: watcher closed")
+			}
+			rl.logf("reload: watching %s: %v", rl.Path, err)
+		case <-timer.C:
+			rl.reload()
+		}
+	}
+}
+
+// reload reloads the configuration for Run and reports the outcome.
+func (rl *Reloader) reload() {
+	replaced, err := rl.Reload()
+	rl.mu.Lock()
+	switch {
+	case err != nil:
+		rl.stats.Failed++
+	case replaced:
+		rl.stats.Succeeded++
+	}
+	rl.mu.Unlock()
+	switch {
+	case err != nil:
+		rl.logf("reload: keeping the previous configuration: %s: %v", rl.Path, err)
+	case replaced:
+		rl.logf("reload: loaded %s", rl.Path)
+	}
+	if rl.Health != nil {
+		rl.Health.Report("config", err)
+	}
+}
+
+func (rl *Reloader) logf(format string, args ...interface{}) {
+	if rl.Logger != nil {
+		rl.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vanity.yaml")
+	write := func(config string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("paths:\n  /portmidi:\n    repo: https://github.com/rakyll/portmidi\n")
+
+	logs := new(syncBuffer)
+	health := new(Health)
+	rl := &Reloader{Path: path, Debounce: 10 * time.Millisecond, Health: health, Logger: log.New(logs, "", 0)}
+	if rl.Handler() != nil {
+		t.Error("Handler before the first load is not nil")
+	}
+	w := httptest.NewRecorder()
+	rl.ServeHTTP(w, httptest.NewRequest("GET", "/portmidi", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status before the first load = %d; want 503", w.Code)
+	}
+	if replaced, err := rl.Reload(); !replaced || err != nil {
+		t.Fatalf("first Reload = %v, %v; want true, nil", replaced, err)
+	}
+	if replaced, err := rl.Reload(); replaced || err != nil {
+		t.Errorf("Reload of an unchanged file = %v, %v; want false, nil", replaced, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- rl.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Run = %v; want context.Canceled", err)
+		}
+	}()
+	status := func(p string) int {
+		w := httptest.NewRecorder()
+		rl.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+		return w.Code
+	}
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !cond(); {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s; logs:\n%s", what, logs)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	if status("/portmidi") != http.StatusOK || status("/gopdf") != http.StatusNotFound {
+		t.Fatal("initial configuration not served")
+	}
+
+	// The watch may start after the first write; write again now and then
+	// until the change is noticed, but not so often that the debounce
+	// timer never fires.
+	var lastWrite time.Time
+	waitFor("the edit", func() bool {
+		if time.Since(lastWrite) > 200*time.Millisecond {
+			write("paths:\n  /gopdf:\n    repo: https://github.com/zombiezen/gopdf\n")
+			lastWrite = time.Now()
+		}
+		return status("/gopdf") == http.StatusOK
+	})
+	if status("/portmidi") != http.StatusNotFound {
+		t.Error("/portmidi still served after it was removed")
+	}
+	waitFor("the log", func() bool { return strings.Contains(logs.String(), "reload: loaded "+path) })
+
+	// Replacing the file by renaming is noticed too, and a broken
+	// configuration keeps the previous one.
+	tmp := filepath.Join(dir, "vanity.yaml.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("paths:\n  /broken:\n    repo: https://example.com/broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the failed reload", func() bool {
+		return strings.Contains(logs.String(), "reload: keeping the previous configuration: "+path+": configuration for /broken: cannot infer VCS")
+	})
+	if status("/gopdf") != http.StatusOK {
+		t.Error("previous configuration not kept after a failed reload")
+	}
+	if s := health.Components()["config"]; s.OK {
+		t.Errorf(`component "config" = %+v; want degraded`, s)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// A RemoteConfig reads a configuration file from a URL.  Its Read method
+// can be a Reloader's, which then needs an Interval.  Conditional requests
+// keep the file from being transferred again while it is unchanged.
+type RemoteConfig struct {
+	URL string
+
+	// Client is used for the requests.  If nil, a client with the
+	// default upstream timeout is used.
+	Client *http.Client
+
+	mu           sync.Mutex
+	config       []byte
+	etag         string
+	lastModified string
+}
+
+// Read fetches the file, or returns the last one fetched if it has not
+// changed since.
+func (rc *RemoteConfig) Read() ([]byte, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	req, err := http.NewRequest(http.MethodGet, rc.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rc.config != nil {
+		if rc.etag != "" {
+			req.Header.Set("If-None-Match", rc.etag)
+		}
+		if rc.lastModified != "" {
+			req.Header.Set("If-Modified-Since", rc.lastModified)
+		}
+	}
+	client := rc.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultUpstreamTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && rc.config != nil:
+		return rc.config, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("GET %s: %s", rc.URL, resp.Status)
+	}
+	config, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %v", rc.URL, err)
+	}
+	rc.config, rc.etag, rc.lastModified = config, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	return config, nil
+}
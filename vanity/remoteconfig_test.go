@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoteConfig(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		config  = "paths:\n  /portmidi:\n    repo: https://github.com/rakyll/portmidi\n"
+		version = 1
+		down    bool
+		sent    int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		etag := fmt.Sprintf(`"%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		sent++
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, config)
+	}))
+	defer srv.Close()
+
+	rc := &RemoteConfig{URL: srv.URL + "/vanity.yaml"}
+	for i := 0; i < 2; i++ {
+		got, err := rc.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != config {
+			t.Errorf("Read %d = %q; want %q", i, got, config)
+		}
+	}
+	if sent != 1 {
+		t.Errorf("server sent the file %d times; want once", sent)
+	}
+
+	rl := &Reloader{Path: rc.URL, Read: rc.Read, Interval: 10 * time.Millisecond, Logger: log.New(ioutil.Discard, "", 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- rl.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Run = %v; want context.Canceled", err)
+		}
+	}()
+	status := func(p string) int {
+		w := httptest.NewRecorder()
+		rl.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+		return w.Code
+	}
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !cond(); {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("first load", func() bool { return status("/portmidi") == http.StatusOK })
+
+	mu.Lock()
+	config, version = "paths:\n  /launchpad:\n    repo: https://github.com/rakyll/launchpad\n", 2
+	mu.Unlock()
+	waitFor("refresh", func() bool { return status("/launchpad") == http.StatusOK })
+
+	mu.Lock()
+	down = true
+	mu.Unlock()
+	waitFor("failed refresh", func() bool { return rl.Stats().Failed > 0 })
+	if got := status("/launchpad"); got != http.StatusOK {
+		t.Errorf("status while the server is down = %d; want 200", got)
+	}
+}
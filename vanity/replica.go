@@ -0,0 +1,146 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReplicaRetry    = time.Second
+	defaultReplicaMaxRetry = 2 * time.Minute
+)
+
+// A Replica keeps the paths of a handler in sync with those of a primary
+// server that has export enabled.  Only the primary needs access to where
+// the paths come from; replicas copy them and learn about changes as soon
+// as the primary serves them.
+type Replica struct {
+	Handler *Handler
+
+	// URL is the base URL of the primary, e.g. https://primary.example.com.
+	URL string
+
+	// Client is used to call the primary.  If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	// Health, if not nil, is marked ready once the paths have first been
+	// copied.  The connection to the primary is reported as its "primary"
+	// component.
+	Health *Health
+
+	// Logger receives errors.  If nil, the standard logger is used.
+	Logger *log.Logger
+
+	// Retry is how long to wait before calling the primary again after an
+	// error.  The wait doubles with every consecutive failure up to
+	// MaxRetry.  They default to 1 second and 2 minutes.
+	Retry    time.Duration
+	MaxRetry time.Duration
+}
+
+// Run copies the paths until ctx is done.
+func (rp *Replica) Run(ctx context.Context) error {
+	retry := rp.Retry
+	if retry <= 0 {
+		retry = defaultReplicaRetry
+	}
+	maxRetry := rp.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = defaultReplicaMaxRetry
+	}
+	version, synced := "", false
+	delay := retry
+	for {
+		export, err := rp.fetch(ctx, version)
+		if err == nil && export.Version != version {
+			paths := make([]PathConfig, len(export.Paths))
+			for i, p := range export.Paths {
+				paths[i] = p.pathConfig()
+			}
+			if err = rp.Handler.SetPaths(paths); err == nil {
+				version = export.Version
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if rp.Health != nil {
+			if !synced {
+				rp.Health.SetReady(err)
+			}
+			rp.Health.Report("primary", err)
+		}
+		if err == nil {
+			synced = true
+			delay = retry
+			continue
+		}
+		rp.logf("replica: %v; retrying in %v", err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetry {
+			delay = maxRetry
+		}
+	}
+}
+
+// fetch returns the primary's paths once they differ from version, or after
+// the primary gave up waiting.
+func (rp *Replica) fetch(ctx context.Context, version string) (*pathExport, error) {
+	ctx, cancel := context.WithTimeout(ctx, exportWait+30*time.Second)
+	defer cancel()
+	u := strings.TrimSuffix(rp.URL, "/") + exportPath + "?since=" + url.QueryEscape(version)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := rp.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: primary returned %s", u, resp.Status)
+	}
+	export := new(pathExport)
+	if err := json.NewDecoder(resp.Body).Decode(export); err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+	return export, nil
+}
+
+func (rp *Replica) logf(format string, args ...interface{}) {
+	if rp.Logger != nil {
+		rp.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
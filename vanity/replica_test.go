@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReplica(t *testing.T) {
+	primary, err := NewHandler([]byte("host: example.com\n" +
+		"export: true\n" +
+		"paths:\n" +
+		"  /audio/portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"    import_depth: 1\n" +
+		"  /plain:\n" +
+		"    repo: http://example.org/plain\n" +
+		"    vcs: git\n" +
+		"    allow_insecure: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(primary)
+	defer s.Close()
+
+	replica, err := NewHandler([]byte("host: example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := new(Health)
+	rp := &Replica{Handler: replica, URL: s.URL, Health: health}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- rp.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(path, want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			w := httptest.NewRecorder()
+			replica.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			got := ""
+			if w.Code == http.StatusOK {
+				got = findMeta(w.Body.Bytes(), "go-import")
+			}
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: go-import = %q; want %q", path, got, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("/audio/portmidi/a/b", "example.com/audio git https://github.com/rakyll/portmidi")
+	waitFor("/plain", "example.com/plain git http://example.org/plain")
+	if ready, err := health.Ready(); !ready {
+		t.Errorf("replica not ready after copying paths: %v", err)
+	}
+
+	if err := primary.UpdatePath(PathConfig{Path: "/audio/portmidi", Repo: "https://github.com/example/portmidi"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("/audio/portmidi", "example.com/audio/portmidi git https://github.com/example/portmidi")
+	if err := primary.RemovePath("/plain"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("/plain", "")
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A Resolver resolves paths that are not in the static configuration at
+// request time, e.g. by consulting a service registry.
+type Resolver interface {
+	// Resolve returns where the code for path lives, or nil if path is
+	// unknown.  path is the URL path of the request, such as "/foo/bar".
+	Resolve(ctx context.Context, path string) (*Resolution, error)
+}
+
+// Resolution describes the repository that serves a path.
+type Resolution struct {
+	// Path is the prefix of the requested path that corresponds to the
+	// repository root.  If empty, the whole requested path is used.
+	Path string
+
+	Repo string
+
+	// VCS and Display are inferred from Repo if empty, as in PathConfig.
+	VCS     string
+	Display string
+}
+
+// resolve asks the handler's resolver about path.
+func (h *Handler) resolve(ctx context.Context, path string) (pc *pathConfig, subpath string, err error) {
+	res, err := h.Resolver.Resolve(ctx, path)
+	if err != nil || res == nil {
+		return nil, "", err
+	}
+	root := strings.TrimSuffix(path, "/")
+	if res.Path != "" {
+		root = strings.TrimSuffix(res.Path, "/")
+		if root != strings.TrimSuffix(path, "/") && !strings.HasPrefix(path, root+"/") {
+			return nil, "", fmt.Errorf("resolving %s: resolution path %s is not a prefix", path, res.Path)
+		}
+	}
+	c, err := newPathConfig(PathConfig{
+		Path:    root,
+		Repo:    res.Repo,
+		VCS:     res.VCS,
+		Display: res.Display,
+	}, h.defaults)
+	if err != nil {
+		return nil, "", err
+	}
+	return &c, strings.Trim(strings.TrimPrefix(path, root), "/"), nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type mapResolver map[string]*Resolution
+
+func (m mapResolver) Resolve(ctx context.Context, path string) (*Resolution, error) {
+	if path == "/broken" {
+		return nil, errors.New("registry unavailable")
+	}
+	for prefix, res := range m {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return res, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestResolver(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /static:\n" +
+		"    repo: https://github.com/example/static\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Resolver = mapResolver{
+		"/dynamic": {Path: "/dynamic", Repo: "https://github.com/example/dynamic"},
+		"/static":  {Path: "/static", Repo: "https://github.com/example/shadowed"},
+	}
+	tests := []struct {
+		path     string
+		status   int
+		goImport string
+	}{
+		{"/static", http.StatusOK, "example.com/static git https://github.com/example/static"},
+		{"/dynamic/sub", http.StatusOK, "example.com/dynamic git https://github.com/example/dynamic"},
+		{"/unknown", http.StatusNotFound, ""},
+		{"/broken", http.StatusBadGateway, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d; want %d", test.path, w.Code, test.status)
+		}
+		if got := findMeta(w.Body.Bytes(), "go-import"); got != test.goImport {
+			t.Errorf("%s: go-import = %q; want %q", test.path, got, test.goImport)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const searchPath = "/search"
+
+// serveSearch serves the index page listing only the paths matching the q
+// parameter, best matches first.
+func (h *Handler) serveSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	host := h.Host(r)
+	handlers := h.indexed(host)
+	if strings.TrimSpace(q) != "" {
+		handlers = h.search(host, q)
+	}
+	h.serveIndexOf(w, r, host, handlers, q)
+}
+
+// search returns the import paths on host that the index lists and that
+// match every word of q, ranked by how well they match.
+func (h *Handler) search(host, q string) []string {
+	terms := strings.Fields(strings.ToLower(q))
+	type result struct {
+		importPath string
+		score      int
+	}
+	var results []result
+	for _, pc := range h.pathSet().configured() {
+		if pc.retired {
+			continue
+		}
+		score := 0
+		for _, term := range terms {
+			s := matchScore(&pc, term)
+			if s == 0 {
+				score = 0
+				break
+			}
+			score += s
+		}
+		if score > 0 {
+			results = append(results, result{host + pc.path, score})
+		}
+	}
+	// The paths are sorted, which breaks ties.
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score > results[j].score })
+	importPaths := make([]string, len(results))
+	for i, r := range results {
+		importPaths[i] = r.importPath
+	}
+	return importPaths
+}
+
+// matchScore rates how well the lowercase term matches pc: best if it is
+// an element of the path, then if it starts one, then if it is a tag, then
+// if it is part of the path, and least if only the description contains
+// it.  It returns 0 if the term does not match.
+func matchScore(pc *pathConfig, term string) int {
+	score := 0
+	for _, elem := range strings.Split(strings.ToLower(pc.path), "/") {
+		switch {
+		case elem == term:
+			return 5
+		case strings.HasPrefix(elem, term):
+			score = 4
+		}
+	}
+	if score > 0 {
+		return score
+	}
+	for _, tag := range pc.tags {
+		if strings.ToLower(tag) == term {
+			return 3
+		}
+	}
+	switch {
+	case strings.Contains(strings.ToLower(pc.path), term):
+		return 2
+	case strings.Contains(strings.ToLower(pc.description), term):
+		return 1
+	}
+	return 0
+}
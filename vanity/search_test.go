@@ -0,0 +1,81 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /audio/portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"    description: Go bindings for PortMidi\n" +
+		"    tags: [midi]\n" +
+		"  /midiparse:\n" +
+		"    repo: https://github.com/example/midiparse\n" +
+		"  /midi:\n" +
+		"    repo: https://github.com/example/midi\n" +
+		"  /synth:\n" +
+		"    repo: https://github.com/example/synth\n" +
+		"    description: A software synthesizer that speaks MIDI\n" +
+		"  /kernelmidi:\n" +
+		"    repo: https://github.com/example/kernelmidi\n" +
+		"  /oldmidi:\n" +
+		"    retired: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"midi", []string{"/midi", "/midiparse", "/audio/portmidi", "/kernelmidi", "/synth"}},
+		{"MIDI audio", []string{"/audio/portmidi"}},
+		{"bindings", []string{"/audio/portmidi"}},
+		{"nothing", nil},
+	}
+	for _, test := range tests {
+		var want []string
+		for _, p := range test.want {
+			want = append(want, "example.com"+p)
+		}
+		if got := h.search("example.com", test.q); len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+			t.Errorf("search(%q) = %v; want %v", test.q, got, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/search?q=synth", nil))
+	body := w.Body.String()
+	if w.Code != 200 || !strings.Contains(body, "example.com/synth</a>") || strings.Contains(body, "example.com/midi</a>") {
+		t.Errorf("search page: %d\n%s", w.Code, body)
+	}
+	if !strings.Contains(body, `action="/search"`) || !strings.Contains(body, `value="synth"`) {
+		t.Errorf("search page does not keep the query:\n%s", body)
+	}
+
+	// Configured paths take precedence.
+	h.AddPath(PathConfig{Path: "/search", Repo: "https://github.com/example/search"})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/search?go-get=1", nil))
+	if got := findMeta(w.Body.Bytes(), "go-import"); got != "example.com/search git https://github.com/example/search" {
+		t.Errorf("go-import of /search = %q", got)
+	}
+}
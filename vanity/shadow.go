@@ -0,0 +1,177 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// shadowBodyLimit is how much of a response is kept for comparison.  The
+// meta tags are in the head of the page.
+const shadowBodyLimit = 16 << 10
+
+// Shadow compares a candidate configuration with the one being served, to
+// find out before switching to it whether it changes how paths resolve.  Its
+// Middleware serves every request as usual and, for a sample of them, also
+// lets the candidate answer it in the background and logs any difference in
+// status or go-import and go-source meta tags.  Module proxy, checksum
+// database and API requests are not compared.
+type Shadow struct {
+	Candidate http.Handler
+
+	// Sample is the fraction of requests to compare, between 0 and 1.
+	Sample float64
+
+	// Logger receives differences.  If nil, the standard logger is used.
+	Logger *log.Logger
+
+	wg sync.WaitGroup // for tests
+
+	mu    sync.Mutex
+	stats ShadowStats
+}
+
+// ShadowStats counts the comparisons made by a Shadow.
+type ShadowStats struct {
+	Compared int
+	Diverged int
+}
+
+// Stats returns the number of requests compared so far and how many of them
+// diverged.
+func (s *Shadow) Stats() ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Middleware is a Middleware that compares sampled requests.
+func (s *Shadow) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !shadowed(r) || rand.Float64() >= s.Sample {
+			next.ServeHTTP(w, r)
+			return
+		}
+		active := &capture{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(active, r)
+		shadowReq := r.Clone(context.Background())
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			candidate := &capture{ResponseWriter: discardWriter{make(http.Header)}, code: http.StatusOK}
+			s.Candidate.ServeHTTP(candidate, shadowReq)
+			s.compare(shadowReq, active, candidate)
+		}()
+	})
+}
+
+// shadowed reports whether r is a page request worth comparing.
+func shadowed(r *http.Request) bool {
+	return (r.Method == "GET" || r.Method == "HEAD") &&
+		!isProxyRequest(r.URL.Path) &&
+		!strings.HasPrefix(r.URL.Path, "/sumdb/") &&
+		!strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+func (s *Shadow) compare(r *http.Request, active, candidate *capture) {
+	var diffs []string
+	if active.code != candidate.code {
+		diffs = append(diffs, "status "+strconv.Itoa(active.code)+" -> "+strconv.Itoa(candidate.code))
+	}
+	for _, name := range []string{"go-import", "go-source"} {
+		a, c := metaContent(active.body.Bytes(), name), metaContent(candidate.body.Bytes(), name)
+		if a != c {
+			diffs = append(diffs, name+" "+quoteOrNone(a)+" -> "+quoteOrNone(c))
+		}
+	}
+	s.mu.Lock()
+	s.stats.Compared++
+	if len(diffs) > 0 {
+		s.stats.Diverged++
+	}
+	s.mu.Unlock()
+	if len(diffs) == 0 {
+		return
+	}
+	format, args := "shadow: %s: %s", []interface{}{r.URL.RequestURI(), strings.Join(diffs, "; ")}
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func quoteOrNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return `"` + s + `"`
+}
+
+var metaTag = regexp.MustCompile(`<meta name="(go-import|go-source)" content="([^"]*)">`)
+
+// metaContent returns the content of the meta tag called name in page.
+func metaContent(page []byte, name string) string {
+	for _, m := range metaTag.FindAllSubmatch(page, -1) {
+		if string(m[1]) == name {
+			return string(m[2])
+		}
+	}
+	return ""
+}
+
+// capture records the status and the start of the body of a response while
+// passing it on.
+type capture struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (c *capture) WriteHeader(code int) {
+	if !c.wroteHeader {
+		c.code, c.wroteHeader = code, true
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.wroteHeader = true
+	if n := shadowBodyLimit - c.body.Len(); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		c.body.Write(p[:n])
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// discardWriter is where the candidate's responses go.
+type discardWriter struct {
+	header http.Header
+}
+
+func (d discardWriter) Header() http.Header         { return d.header }
+func (d discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShadow(t *testing.T) {
+	active, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"  /old:\n" +
+		"    repo: https://github.com/example/old\n" +
+		"  /same:\n" +
+		"    repo: https://github.com/example/same\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidate, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/example/portmidi\n" +
+		"  /same:\n" +
+		"    repo: https://github.com/example/same\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	s := &Shadow{Candidate: candidate, Sample: 1, Logger: log.New(&buf, "", 0)}
+	active.Middleware = append(active.Middleware, s.Middleware)
+
+	for _, path := range []string{"/portmidi", "/old/sub", "/same", "/example.com/foo/@v/list"} {
+		w := httptest.NewRecorder()
+		active.ServeHTTP(w, httptest.NewRequest("GET", path+"?go-get=1", nil))
+		if path == "/portmidi" && !strings.Contains(w.Body.String(), "https://github.com/rakyll/portmidi") {
+			t.Errorf("%s: served the candidate's answer", path)
+		}
+	}
+	s.wg.Wait()
+
+	if got, want := s.Stats(), (ShadowStats{Compared: 3, Diverged: 2}); got != want {
+		t.Errorf("stats = %+v; want %+v", got, want)
+	}
+	logged := buf.String()
+	for _, want := range []string{
+		`/portmidi?go-get=1: go-import "example.com/portmidi git https://github.com/rakyll/portmidi" -> "example.com/portmidi git https://github.com/example/portmidi"`,
+		`/old/sub?go-get=1: status 200 -> 404`,
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log does not contain %q:\n%s", want, logged)
+		}
+	}
+	if strings.Contains(logged, "/same") {
+		t.Errorf("log reports identical answer:\n%s", logged)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A StaticFile is a file of a static copy of the pages of a handler.
+type StaticFile struct {
+	// Name is the slash-separated path of the file within the site, e.g.
+	// tools/index.html.
+	Name string
+	Data []byte
+}
+
+// StaticSite returns the index and the pages of the configured paths and
+// their aliases as files for a static web server, such as GitHub Pages,
+// Netlify or S3, so that a host can be served without running the handler.
+// Each page is index.html in the directory of its path, in the default
+// locale.  Path rules, other hosts and retired paths need the handler and
+// are left out, as is the latest release of tools.  The configuration must
+// set the host.
+//
+// Hosting is the service to add files for, if any.  For netlify, they are
+// _redirects, which serves the page of a path for the packages below it,
+// and _headers, with the configured headers and Cache-Control.
+// Cloudflare Pages reads the same files, and for cloudflare a Pages
+// Function is added as well that answers go get requests before any of
+// them apply.  These files are always at the top of the site.
+func (h *Handler) StaticSite(hosting string) ([]StaticFile, error) {
+	if h.host == "" {
+		return nil, errors.New("configuration must set host")
+	}
+	if hosting != "" && hosting != "netlify" && hosting != "cloudflare" {
+		return nil, fmt.Errorf("unknown hosting service %q", hosting)
+	}
+	host := h.host + h.prefix
+	lang := h.catalogs.def
+	msgs := h.catalogs.locales[lang]
+	// The index is a single page, without the search box that needs the
+	// server.
+	handlers := h.indexed(host)
+	h.sortByGroup(host, handlers)
+	index, err := h.renderIndex(host, lang, msgs, indexPage{Handlers: handlers, Page: 1, Pages: 1})
+	if err != nil {
+		return nil, fmt.Errorf("rendering index: %v", err)
+	}
+	files := []StaticFile{{Name: staticName(h.prefix, "/"), Data: index}}
+	var served []*pathConfig
+	pset := h.pathSet()
+	for i := range pset {
+		pc := &pset[i]
+		if pc.retired {
+			continue
+		}
+		data := h.pageData(host, lang, msgs, pc, pc.path, "")
+		if pc.tool {
+			data.Tool = true
+			data.Install = host + pc.path
+			data.Releases = releasesURL(pc.repo)
+		}
+		page, err := h.render(data)
+		if err != nil {
+			return nil, fmt.Errorf("rendering %s: %v", pc.path, err)
+		}
+		files = append(files, StaticFile{Name: staticName(h.prefix, pc.path), Data: page})
+		served = append(served, pc)
+	}
+	if hosting == "" {
+		return files, nil
+	}
+	// Rules for longer paths come first, since the first that matches
+	// wins.
+	sort.SliceStable(served, func(i, j int) bool {
+		return len(served[i].path) > len(served[j].path)
+	})
+	files = append(files, h.staticRedirects(served), h.staticHeaders(served))
+	if hosting == "cloudflare" {
+		files = append(files, h.staticFunction(served))
+	}
+	return files, nil
+}
+
+// staticName returns the name of the file serving the path under prefix.
+func staticName(prefix, path string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(prefix+path, "/")+"/index.html", "/")
+}
+
+// staticRedirects returns the _redirects file serving the pages of paths
+// for the packages below them.
+func (h *Handler) staticRedirects(paths []*pathConfig) StaticFile {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by govanityurls export.\n")
+	for _, pc := range paths {
+		p := h.prefix + pc.path
+		fmt.Fprintf(&buf, "%s/* /%s 200\n", p, staticName(h.prefix, pc.path))
+	}
+	return StaticFile{Name: "_redirects", Data: buf.Bytes()}
+}
+
+// staticHeaders returns the _headers file adding the configured headers to
+// every page and Cache-Control to those that may be cached.
+func (h *Handler) staticHeaders(paths []*pathConfig) StaticFile {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by govanityurls export.\n")
+	if len(h.headers) > 0 {
+		names := make([]string, 0, len(h.headers))
+		for name := range h.headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		buf.WriteString("/*\n")
+		for _, name := range names {
+			fmt.Fprintf(&buf, "  %s: %s\n", name, h.headers[name])
+		}
+	}
+	cache := func(path string, maxAge *int) {
+		if maxAge != nil {
+			fmt.Fprintf(&buf, "%s\n  Cache-Control: public, max-age=%d\n", path, *maxAge)
+		}
+	}
+	cache(h.prefix+"/", h.defaults.cacheMaxAge)
+	for _, pc := range paths {
+		cache(h.prefix+pc.path, pc.cacheMaxAge)
+		cache(h.prefix+pc.path+"/*", pc.cacheMaxAge)
+	}
+	return StaticFile{Name: "_headers", Data: buf.Bytes()}
+}
+
+// staticFunction returns a Cloudflare Pages Function that answers go get
+// requests for paths and the packages below them with the page of the path
+// and leaves other requests to the static files.
+func (h *Handler) staticFunction(paths []*pathConfig) StaticFile {
+	var list []string
+	for _, pc := range paths {
+		list = append(list, strconv.Quote(h.prefix+pc.path))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `// Generated by govanityurls export.
+const paths = [%s];
+
+export async function onRequest(context) {
+  const url = new URL(context.request.url);
+  if (url.searchParams.get("go-get") === "1") {
+    for (const p of paths) {
+      if (url.pathname === p || url.pathname.startsWith(p + "/")) {
+        return context.env.ASSETS.fetch(new URL(p + "/index.html", url));
+      }
+    }
+  }
+  return context.next();
+}
+`, strings.Join(list, ", "))
+	return StaticFile{Name: "functions/_middleware.js", Data: buf.Bytes()}
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStaticSite(t *testing.T) {
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"prefix: /go\n" +
+		"cache_max_age: 3600\n" +
+		"headers:\n" +
+		"  X-Frame-Options: DENY\n" +
+		"paths:\n" +
+		"  /portmidi:\n" +
+		"    repo: https://github.com/rakyll/portmidi\n" +
+		"    aliases: [/pm]\n" +
+		"  /tool:\n" +
+		"    repo: https://github.com/example/tool\n" +
+		"    tool: true\n" +
+		"  /gone:\n" +
+		"    retired: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := h.StaticSite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name     string
+		goImport string
+	}{
+		{"go/index.html", ""},
+		{"go/pm/index.html", "example.com/go/pm git https://github.com/rakyll/portmidi"},
+		{"go/portmidi/index.html", "example.com/go/portmidi git https://github.com/rakyll/portmidi"},
+		{"go/tool/index.html", "example.com/go/tool git https://github.com/example/tool"},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("StaticSite returned %d files; want %d", len(files), len(want))
+	}
+	for i, w := range want {
+		f := files[i]
+		if f.Name != w.name {
+			t.Errorf("file %d is %s; want %s", i, f.Name, w.name)
+			continue
+		}
+		if w.goImport == "" {
+			if !bytes.Contains(f.Data, []byte("example.com/go/portmidi")) || bytes.Contains(f.Data, []byte("gone")) {
+				t.Errorf("%s lists the wrong paths:\n%s", f.Name, f.Data)
+			}
+			continue
+		}
+		if got := findMeta(f.Data, "go-import"); got != w.goImport {
+			t.Errorf("%s: go-import = %q; want %q", f.Name, got, w.goImport)
+		}
+	}
+
+	for _, hosting := range []string{"netlify", "cloudflare"} {
+		files, err := h.StaticSite(hosting)
+		if err != nil {
+			t.Fatalf("%s: %v", hosting, err)
+		}
+		extra := map[string]string{}
+		for _, f := range files[len(want):] {
+			extra[f.Name] = string(f.Data)
+		}
+		if got, want := extra["_redirects"], "/go/portmidi/* /go/portmidi/index.html 200\n"; !strings.Contains(got, want) {
+			t.Errorf("%s: _redirects = %q; want it to contain %q", hosting, got, want)
+		}
+		if got, want := extra["_headers"], "/go/tool/*\n  Cache-Control: public, max-age=3600\n"; !strings.Contains(got, want) {
+			t.Errorf("%s: _headers = %q; want it to contain %q", hosting, got, want)
+		}
+		if got, want := extra["_headers"], "/*\n  X-Frame-Options: DENY\n"; !strings.Contains(got, want) {
+			t.Errorf("%s: _headers = %q; want it to contain %q", hosting, got, want)
+		}
+		fn, ok := extra["functions/_middleware.js"]
+		if ok != (hosting == "cloudflare") {
+			t.Errorf("%s: has function = %v; want %v", hosting, ok, !ok)
+		}
+		if ok && !strings.Contains(fn, `"/go/portmidi"`) {
+			t.Errorf("%s: function does not list /go/portmidi:\n%s", hosting, fn)
+		}
+	}
+	if _, err := h.StaticSite("s3"); err == nil {
+		t.Error("StaticSite for an unknown hosting service succeeded; want error")
+	}
+
+	h, err = NewHandler([]byte("paths:\n  /portmidi:\n    repo: https://github.com/rakyll/portmidi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.StaticSite(""); err == nil {
+		t.Error("StaticSite without a host succeeded; want error")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ThemeConfig configures the look of the built-in pages.
+type ThemeConfig struct {
+	// Mode is auto, light or dark.  In auto mode, the default, pages follow
+	// the color scheme preferred by the browser.
+	Mode string
+
+	// Variables override CSS custom properties of the built-in style sheet
+	// by name, without the leading dashes, e.g. "accent": "#00add8".  They
+	// apply to both color schemes.
+	Variables map[string]string
+}
+
+// lightTheme and darkTheme are the custom properties of each color scheme.
+// The style sheet only refers to these.
+const (
+	lightTheme = `--bg: #ffffff; --fg: #202224; --muted: #555b61; --accent: #007d9c; --code-bg: #f2f4f5; --border: #dadce0;`
+	darkTheme  = `--bg: #1b1d1f; --fg: #e8eaed; --muted: #a0a6ab; --accent: #5dc9e2; --code-bg: #2a2d30; --border: #3c4043;`
+)
+
+const baseStyle = `body { margin: 2em auto; max-width: 48em; padding: 0 1em; font-family: var(--font); line-height: 1.5; background: var(--bg); color: var(--fg); }
+a { color: var(--accent); }
+pre { padding: 0.5em 1em; background: var(--code-bg); border: 1px solid var(--border); border-radius: 4px; overflow-x: auto; }
+ul { padding-left: 1.5em; }
+`
+
+var (
+	cssVariableName  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`)
+	cssVariableValue = regexp.MustCompile(`^[^;{}<>\\"'\n]+$`)
+)
+
+// newThemeStyle returns the style sheet of the built-in pages for c.
+func newThemeStyle(c ThemeConfig) (template.CSS, error) {
+	var b strings.Builder
+	b.WriteString(":root { --font: -apple-system, BlinkMacSystemFont, \"Segoe UI\", Roboto, sans-serif; ")
+	switch c.Mode {
+	case "", "auto":
+		b.WriteString("color-scheme: light dark; " + lightTheme + " }\n")
+		b.WriteString("@media (prefers-color-scheme: dark) { :root { " + darkTheme + " } }\n")
+	case "light":
+		b.WriteString("color-scheme: light; " + lightTheme + " }\n")
+	case "dark":
+		b.WriteString("color-scheme: dark; " + darkTheme + " }\n")
+	default:
+		return "", fmt.Errorf("configuration for theme: unknown mode %s", c.Mode)
+	}
+	if len(c.Variables) > 0 {
+		names := make([]string, 0, len(c.Variables))
+		for name := range c.Variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b.WriteString(":root {")
+		for _, name := range names {
+			value := c.Variables[name]
+			if !cssVariableName.MatchString(name) {
+				return "", fmt.Errorf("configuration for theme: invalid variable name %q", name)
+			}
+			if !cssVariableValue.MatchString(value) {
+				return "", fmt.Errorf("configuration for theme: invalid value %q for %s", value, name)
+			}
+			fmt.Fprintf(&b, " --%s: %s;", name, strings.TrimSpace(value))
+		}
+		b.WriteString(" }\n")
+	}
+	b.WriteString(baseStyle)
+	return template.CSS(b.String()), nil
+}
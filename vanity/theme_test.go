@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTheme(t *testing.T) {
+	tests := []struct {
+		name     string
+		theme    ThemeConfig
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "auto",
+			contains: []string{"color-scheme: light dark;", "@media (prefers-color-scheme: dark)", lightTheme, darkTheme},
+		},
+		{
+			name:     "light",
+			theme:    ThemeConfig{Mode: "light"},
+			contains: []string{"color-scheme: light;", lightTheme},
+			excludes: []string{"prefers-color-scheme", darkTheme},
+		},
+		{
+			name:     "dark",
+			theme:    ThemeConfig{Mode: "dark"},
+			contains: []string{"color-scheme: dark;", darkTheme},
+			excludes: []string{"prefers-color-scheme", lightTheme},
+		},
+		{
+			name:     "variables",
+			theme:    ThemeConfig{Variables: map[string]string{"font": "Georgia, serif", "accent": " #00add8 "}},
+			contains: []string{":root { --accent: #00add8; --font: Georgia, serif; }"},
+		},
+	}
+	for _, test := range tests {
+		h, err := New(&Config{Theme: test.theme, Paths: []PathConfig{
+			{Path: "/portmidi", Repo: "https://github.com/rakyll/portmidi"},
+		}})
+		if err != nil {
+			t.Errorf("%s: New: %v", test.name, err)
+			continue
+		}
+		for _, path := range []string{"/", "/portmidi"} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			body := w.Body.String()
+			for _, s := range test.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("%s: %s does not contain %q:\n%s", test.name, path, s, body)
+				}
+			}
+			for _, s := range test.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("%s: %s contains %q:\n%s", test.name, path, s, body)
+				}
+			}
+		}
+	}
+}
+
+func TestThemeConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		theme ThemeConfig
+	}{
+		{"unknown mode", ThemeConfig{Mode: "sepia"}},
+		{"invalid name", ThemeConfig{Variables: map[string]string{"--accent": "red"}}},
+		{"escaping value", ThemeConfig{Variables: map[string]string{"accent": "red; } body { display: none"}}},
+		{"closing tag", ThemeConfig{Variables: map[string]string{"accent": "</style><script>"}}},
+	}
+	for _, test := range tests {
+		if err := (&Config{Theme: test.theme}).Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded; want error", test.name)
+		}
+	}
+}
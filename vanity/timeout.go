@@ -0,0 +1,170 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeoutMiddleware bounds the handling of each request by d, like
+// http.TimeoutHandler.  Requests that take longer are logged and get a 503
+// Service Unavailable page instead of what the handler writes too late.
+// Module proxy, checksum database and export requests stream or long-poll
+// and are bounded by the upstream timeout instead.
+func (h *Handler) timeoutMiddleware(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h.streams(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if v := recover(); v != nil {
+						panicked <- v
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+			select {
+			case v := <-panicked:
+				// Let the handler's recovery see the panic.
+				panic(v)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				dst := w.Header()
+				for k, v := range tw.header {
+					dst[k] = v
+				}
+				if tw.code == 0 {
+					tw.code = http.StatusOK
+				}
+				w.WriteHeader(tw.code)
+				w.Write(tw.buf.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				tw.timedOut = true
+				tw.mu.Unlock()
+				if ctx.Err() != context.DeadlineExceeded {
+					// The client went away; there is no one left to answer.
+					return
+				}
+				h.logf("timing out %s %s for %s after %v", r.Method, r.URL.RequestURI(), r.RemoteAddr, d)
+				h.serveTimeout(w, r)
+			}
+		})
+	}
+}
+
+// streams reports whether requests for path may legitimately take long.
+func (h *Handler) streams(path string) bool {
+	return (h.sumdb != nil && strings.HasPrefix(path, h.sumdb.prefix())) ||
+		(h.proxies() && isProxyRequest(path)) ||
+		(h.export && path == exportPath)
+}
+
+// serveTimeout renders the page for requests that took too long.
+func (h *Handler) serveTimeout(w http.ResponseWriter, r *http.Request) {
+	h.serveErrorPage(w, r, h.errorTmpl, http.StatusServiceUnavailable, "timeout")
+}
+
+// serveErrorPage replies to r with the page rendered by t for the status
+// code and the message key, or with the plain message if that fails.
+func (h *Handler) serveErrorPage(w http.ResponseWriter, r *http.Request, t *template.Template, code int, key string) {
+	lang, msgs := h.localize(w, r)
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, struct {
+		Lang    string
+		Msg     Messages
+		Style   template.CSS
+		Code    int
+		Message string
+		Host    string
+		Path    string
+	}{
+		Lang:    lang,
+		Msg:     msgs,
+		Style:   h.style,
+		Code:    code,
+		Message: msgs[key],
+		Host:    r.Host,
+		Path:    r.URL.Path,
+	}); err != nil {
+		h.logf("rendering %d page: %v", code, err)
+		http.Error(w, msgs[key], code)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// timeoutWriter buffers a response until the handler is done, and drops it
+// if the handler took too long.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header { return w.header }
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.code == 0 && !w.timedOut {
+		w.code = code
+	}
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.Write(p)
+}
+
+var errorTmpl = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<style>{{.Style}}</style>
+</head>
+<body>
+<h1>{{.Code}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
@@ -0,0 +1,133 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowResolver answers once ctx is done, or after delay.
+type slowResolver struct {
+	delay time.Duration
+}
+
+func (s slowResolver) Resolve(ctx context.Context, path string) (*Resolution, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+		return &Resolution{Path: path, Repo: "https://github.com/example" + path, VCS: "git"}, nil
+	}
+}
+
+// syncBuffer is a bytes.Buffer that handlers abandoned by the timeout may
+// keep writing to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		header   string
+		code     int
+		contains string
+	}{
+		{name: "fast", delay: 0, code: http.StatusOK, contains: `<meta name="go-import" content="example.com/fast git https://github.com/example/fast">`},
+		{name: "slow", delay: time.Hour, code: http.StatusServiceUnavailable, contains: "<p>The request took too long.  Please try again later.</p>"},
+		{name: "slow-de", delay: time.Hour, header: "de", code: http.StatusServiceUnavailable, contains: "Die Anfrage hat zu lange gedauert."},
+	}
+	for _, test := range tests {
+		var logs syncBuffer
+		h, err := New(&Config{
+			Host:           "example.com",
+			Headers:        map[string]string{"X-Frame-Options": "DENY"},
+			RequestTimeout: 20 * time.Millisecond,
+		}, WithResolver(slowResolver{test.delay}), WithLogger(log.New(&logs, "", 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/"+test.name, nil)
+		r.Header.Set("Accept-Language", test.header)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: status = %d; want %d", test.name, w.Code, test.code)
+		}
+		if !strings.Contains(w.Body.String(), test.contains) {
+			t.Errorf("%s: body does not contain %q:\n%s", test.name, test.contains, w.Body)
+		}
+		if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("%s: X-Frame-Options = %q; want DENY", test.name, got)
+		}
+		timedOut := strings.Contains(logs.String(), "timing out GET /"+test.name+" for 192.0.2.1:1234 after 20ms")
+		if timedOut != (test.code == http.StatusServiceUnavailable) {
+			t.Errorf("%s: logs = %q", test.name, logs.String())
+		}
+	}
+}
+
+func TestRequestTimeoutPanic(t *testing.T) {
+	h, err := New(&Config{RequestTimeout: time.Second}, WithLogger(log.New(&bytes.Buffer{}, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.NotFoundHandler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") })
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != http.StatusInternalServerError || h.Panics() != 1 {
+		t.Errorf("status = %d, panics = %d; want 500, 1", w.Code, h.Panics())
+	}
+}
+
+func TestRequestTimeoutConfig(t *testing.T) {
+	if err := (&Config{RequestTimeout: -time.Second}).Validate(); err == nil {
+		t.Error("Validate with a negative request_timeout succeeded; want error")
+	}
+	h, err := New(&Config{RequestTimeout: time.Second, Export: true, Proxy: ProxyConfig{Upstream: "https://proxy.golang.org", SumDB: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/example.com/m/@v/list", "/sumdb/sum.golang.org/latest", exportPath} {
+		if !h.streams(path) {
+			t.Errorf("streams(%s) = false; want true", path)
+		}
+	}
+	if h.streams("/example.com/m") {
+		t.Error("streams(/example.com/m) = true; want false")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultTLSAddr = ":8443"
+
+// TLSConfig configures serving HTTPS.
+type TLSConfig struct {
+	// Addr is the address to listen on, or several separated by commas,
+	// e.g. 0.0.0.0:443,[::]:443.  Defaults to :8443.
+	Addr string
+
+	// CertFile and KeyFile are PEM files with the certificate chain and
+	// its private key.  They may be the same file.
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the oldest TLS version to accept: 1.0, 1.1, 1.2 or
+	// 1.3.  Defaults to 1.2.
+	MinVersion string
+
+	// CipherSuites restricts the cipher suites of TLS 1.2 and below, by
+	// their names in crypto/tls, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256.
+	// If empty, Go's defaults are used.  The suites of TLS 1.3 are not
+	// configurable.
+	CipherSuites []string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig returns a TLS configuration serving the certificate in c.
+// The certificate files are read again once they change, so that renewed
+// certificates are served without a restart.
+func NewTLSConfig(c TLSConfig) (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("configuration for tls: cert_file and key_file are required")
+	}
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("configuration for tls: unknown min_version %q", c.MinVersion)
+		}
+		tc.MinVersion = v
+	}
+	if len(c.CipherSuites) > 0 {
+		ids := make(map[string]uint16)
+		for _, s := range tls.CipherSuites() {
+			ids[s.Name] = s.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := ids[name]
+			if !ok {
+				return nil, fmt.Errorf("configuration for tls: unknown or insecure cipher suite %s", name)
+			}
+			tc.CipherSuites = append(tc.CipherSuites, id)
+		}
+	}
+	kp := &keyPair{certFile: c.CertFile, keyFile: c.KeyFile}
+	if _, err := kp.get(); err != nil {
+		return nil, fmt.Errorf("configuration for tls: %v", err)
+	}
+	tc.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return kp.get()
+	}
+	return tc, nil
+}
+
+// keyPair is a certificate loaded from files, reloaded when they change.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	modTime time.Time // of the newer file when cert was loaded
+	cert    *tls.Certificate
+}
+
+func (kp *keyPair) get() (*tls.Certificate, error) {
+	modTime, err := kp.stat()
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.cert != nil && (err != nil || modTime.Equal(kp.modTime)) {
+		// Files that cannot be read were probably caught halfway
+		// through a renewal; keep serving the old certificate until they
+		// settle.
+		return kp.cert, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		if kp.cert != nil {
+			return kp.cert, nil
+		}
+		return nil, err
+	}
+	kp.cert, kp.modTime = &cert, modTime
+	return kp.cert, nil
+}
+
+func (kp *keyPair) stat() (time.Time, error) {
+	var newest time.Time
+	for _, name := range []string{kp.certFile, kp.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// TLS returns the configuration for serving HTTPS, with defaults filled in,
+// and whether a certificate is configured.
+func (h *Handler) TLS() (TLSConfig, bool) {
+	return h.tls, h.tls.CertFile != ""
+}
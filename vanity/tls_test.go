@@ -0,0 +1,130 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeCert(t, dir, "go.example.com", time.Now().Add(30*24*time.Hour))
+
+	tc, err := NewTLSConfig(TLSConfig{
+		CertFile:     path,
+		KeyFile:      path,
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.MinVersion != tls.VersionTLS13 || len(tc.CipherSuites) != 1 || tc.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("MinVersion = %x, CipherSuites = %x", tc.MinVersion, tc.CipherSuites)
+	}
+	cert, err := tc.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := cert.Certificate[0]
+
+	// A renewed certificate is picked up once the file changes.
+	writeCert(t, dir, "go.example.com", time.Now().Add(60*24*time.Hour))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if cert, err = tc.GetCertificate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if string(cert.Certificate[0]) == string(first) {
+		t.Error("certificate not reloaded after the file changed")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := time.Until(leaf.NotAfter); left < 59*24*time.Hour {
+		t.Errorf("serving a certificate expiring in %v; want the renewed one", left)
+	}
+
+	// The last good certificate survives the files going missing.
+	os.Remove(path)
+	if cert, err = tc.GetCertificate(nil); err != nil || cert == nil {
+		t.Errorf("GetCertificate after removal = %v, %v; want the last certificate", cert, err)
+	}
+}
+
+func TestBadTLSConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeCert(t, dir, "go.example.com", time.Now().Add(30*24*time.Hour))
+	tests := []struct {
+		name string
+		c    TLSConfig
+		want string
+	}{
+		{"no key", TLSConfig{CertFile: path}, "key_file"},
+		{"missing file", TLSConfig{CertFile: path + ".missing", KeyFile: path}, "no such file"},
+		{"version", TLSConfig{CertFile: path, KeyFile: path, MinVersion: "1.4"}, "min_version"},
+		{"insecure suite", TLSConfig{CertFile: path, KeyFile: path, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, "cipher suite"},
+	}
+	for _, test := range tests {
+		_, err := NewTLSConfig(test.c)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: err = %v; want error containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestHandlerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeCert(t, dir, "go.example.com", time.Now().Add(30*24*time.Hour))
+
+	h, err := NewHandler([]byte("host: go.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.TLS(); ok {
+		t.Error("TLS configured without a tls section")
+	}
+	h, err = NewHandler([]byte("tls:\n" +
+		"  cert_file: " + path + "\n" +
+		"  key_file: " + path + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := h.TLS(); !ok || c.Addr != ":8443" || c.CertFile != path {
+		t.Errorf("TLS() = %+v, %v; want defaults filled in", c, ok)
+	}
+	if _, err := NewHandler([]byte("tls:\n  cert_file: " + path + "\n")); err == nil {
+		t.Error("tls without key_file accepted")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vanitytest provides utilities for testing programs that embed the
+// vanity handler.
+package vanitytest
+
+import (
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+)
+
+// Meta holds the meta tags the go command looks at.
+type Meta struct {
+	GoImport string
+	GoSource string
+}
+
+// NewHandler returns a handler for the YAML configuration config, failing
+// the test if it is invalid.
+func NewHandler(t testing.TB, config string) *vanity.Handler {
+	t.Helper()
+	h, err := vanity.NewHandler([]byte(config))
+	if err != nil {
+		t.Fatalf("vanity.NewHandler: %v", err)
+	}
+	return h
+}
+
+// NewServer starts a server for the YAML configuration config.  The server
+// is closed when the test ends.
+func NewServer(t testing.TB, config string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(NewHandler(t, config))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Fetch requests url the way the go command does and returns the meta tags
+// of the response.  It fails the test unless the response is 200 OK.
+func Fetch(t testing.TB, url string) Meta {
+	t.Helper()
+	if !strings.Contains(url, "?") {
+		url += "?go-get=1"
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status = %s; want 200 OK", url, resp.Status)
+	}
+	return ParseMeta(data)
+}
+
+// Resolve serves a go get request for path with h, without a network
+// round trip, and returns the meta tags of the response.  It fails the test
+// unless the response is 200 OK.
+func Resolve(t testing.TB, h http.Handler, path string) Meta {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path+"?go-get=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d; want 200", path, w.Code)
+	}
+	return ParseMeta(w.Body.Bytes())
+}
+
+// AssertResolves checks that h answers a go get request for path with the
+// given go-import and go-source meta tags.  An empty goSource is not
+// checked.
+func AssertResolves(t testing.TB, h http.Handler, path, goImport, goSource string) {
+	t.Helper()
+	m := Resolve(t, h, path)
+	if m.GoImport != goImport {
+		t.Errorf("%s: go-import = %q; want %q", path, m.GoImport, goImport)
+	}
+	if goSource != "" && m.GoSource != goSource {
+		t.Errorf("%s: go-source = %q; want %q", path, m.GoSource, goSource)
+	}
+}
+
+var (
+	metaTag  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttr = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// ParseMeta extracts the go-import and go-source meta tags from an HTML
+// page.  Missing tags are left empty.
+func ParseMeta(page []byte) Meta {
+	var m Meta
+	for _, tag := range metaTag.FindAll(page, -1) {
+		var name, content string
+		for _, a := range metaAttr.FindAllSubmatch(tag, -1) {
+			v := html.UnescapeString(string(a[2]) + string(a[3]))
+			switch strings.ToLower(string(a[1])) {
+			case "name":
+				name = v
+			case "content":
+				content = v
+			}
+		}
+		switch name {
+		case "go-import":
+			if m.GoImport == "" {
+				m.GoImport = content
+			}
+		case "go-source":
+			if m.GoSource == "" {
+				m.GoSource = content
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanitytest
+
+import "testing"
+
+const config = "host: example.com\n" +
+	"paths:\n" +
+	"  /portmidi:\n" +
+	"    repo: https://github.com/rakyll/portmidi\n" +
+	"    display: https://github.com/rakyll/portmidi _ _\n"
+
+func TestParseMeta(t *testing.T) {
+	page := `<html><head>
+<META content='example.com/foo git https://github.com/example/foo' name="go-import">
+<meta name="go-source" content="example.com/foo a&amp;b _ _">
+</head></html>`
+	want := Meta{
+		GoImport: "example.com/foo git https://github.com/example/foo",
+		GoSource: "example.com/foo a&b _ _",
+	}
+	if got := ParseMeta([]byte(page)); got != want {
+		t.Errorf("ParseMeta = %+v; want %+v", got, want)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	s := NewServer(t, config)
+	m := Fetch(t, s.URL+"/portmidi/foo")
+	if want := "example.com/portmidi git https://github.com/rakyll/portmidi"; m.GoImport != want {
+		t.Errorf("go-import = %q; want %q", m.GoImport, want)
+	}
+}
+
+func TestAssertResolves(t *testing.T) {
+	h := NewHandler(t, config)
+	AssertResolves(t, h, "/portmidi",
+		"example.com/portmidi git https://github.com/rakyll/portmidi",
+		"example.com/portmidi https://github.com/rakyll/portmidi _ _")
+}
@@ -0,0 +1,442 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultPrefix starts settings whose value is a secret read from Vault, e.g.
+// vault:secret/data/govanityurls#slack for the slack field of the secret at
+// secret/data/govanityurls.
+const VaultPrefix = "vault:"
+
+const (
+	defaultVaultAuthMount = "kubernetes"
+	defaultVaultJWTFile   = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultVaultTimeout   = 10 * time.Second
+	defaultVaultRetry     = time.Second
+	defaultVaultMaxRetry  = 2 * time.Minute
+
+	// vaultPoll bounds how long Run sleeps, so that leases of secrets read
+	// in the meantime are renewed in time.
+	vaultPoll = time.Minute
+)
+
+// Vault reads secrets from a HashiCorp Vault server and keeps its token and
+// the leases of the secrets it read alive.  It authenticates with Token,
+// the token in TokenFile or, if Role is set, a Kubernetes service account.
+type Vault struct {
+	// Address is the base URL of the server, e.g. https://vault:8200.
+	Address string
+
+	Token     string
+	TokenFile string
+
+	// Role is the role to log in as with the Kubernetes auth method
+	// mounted at AuthMount, which defaults to kubernetes.  JWTFile is the
+	// service account token and defaults to the one Kubernetes mounts into
+	// pods.
+	Role      string
+	AuthMount string
+	JWTFile   string
+
+	// Client is used to call the server.  If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	// Health, if not nil, gets renewals reported as its "vault" component.
+	Health *Health
+
+	// Logger receives errors.  If nil, the standard logger is used.
+	Logger *log.Logger
+
+	// Retry is how long to wait before renewing again after an error.  The
+	// wait doubles with every consecutive failure up to MaxRetry.  They
+	// default to 1 second and 2 minutes.
+	Retry    time.Duration
+	MaxRetry time.Duration
+
+	now func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	self   *vaultLease // of the token, if renewable
+	leases map[string]*vaultLease
+}
+
+// A vaultLease is renewed halfway through its duration.
+type vaultLease struct {
+	id       string // empty for the token
+	duration time.Duration
+	renewAt  time.Time
+}
+
+// vaultResponse is the part of Vault's responses that Vault uses.
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// Expand returns s, or the secret it refers to if it starts with
+// VaultPrefix.
+func (v *Vault) Expand(ctx context.Context, s string) (string, error) {
+	if !strings.HasPrefix(s, VaultPrefix) {
+		return s, nil
+	}
+	return v.Secret(ctx, strings.TrimPrefix(s, VaultPrefix))
+}
+
+// ExpandConfig replaces references to Vault secrets in the settings of c
+// that hold credentials.
+func (v *Vault) ExpandConfig(ctx context.Context, c *Config) error {
+	for _, f := range secretFields(c) {
+		var err error
+		if *f.value, err = v.Expand(ctx, *f.value); err != nil {
+			return fmt.Errorf("configuration for %s: %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+// Secret returns the field key of the secret at path, given as path#key.
+// Both versions of the key/value secrets engine are supported.
+func (v *Vault) Secret(ctx context.Context, ref string) (string, error) {
+	i := strings.LastIndexByte(ref, '#')
+	if i <= 0 || i == len(ref)-1 {
+		return "", fmt.Errorf("vault: reference %q is not of the form path#key", ref)
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+	var resp vaultResponse
+	if err := v.call(ctx, "GET", path, nil, &resp); err != nil {
+		return "", err
+	}
+	data := resp.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = inner
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault: %s has no field %s", path, key)
+	}
+	if resp.Renewable && resp.LeaseID != "" {
+		v.mu.Lock()
+		if v.leases == nil {
+			v.leases = make(map[string]*vaultLease)
+		}
+		v.leases[resp.LeaseID] = v.newLease(resp.LeaseID, resp.LeaseDuration)
+		v.mu.Unlock()
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Run renews the token and leases until ctx is done.
+func (v *Vault) Run(ctx context.Context) error {
+	retry := v.Retry
+	if retry <= 0 {
+		retry = defaultVaultRetry
+	}
+	maxRetry := v.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = defaultVaultMaxRetry
+	}
+	delay := retry
+	for {
+		err := v.renew(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if v.Health != nil {
+			v.Health.Report("vault", err)
+		}
+		wait := delay
+		if err == nil {
+			delay = retry
+			wait = v.untilRenewal()
+		} else {
+			v.logf("vault: %v; retrying in %v", err, delay)
+			if delay *= 2; delay > maxRetry {
+				delay = maxRetry
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// untilRenewal returns how long to wait before the next renewal is due.
+func (v *Vault) untilRenewal() time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	wait := vaultPoll
+	for _, l := range v.dueLocked(time.Time{}) {
+		if d := l.renewAt.Sub(v.clock()); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// dueLocked returns the leases to renew by t, or all of them if t is
+// zero, the token's first.
+func (v *Vault) dueLocked(t time.Time) []*vaultLease {
+	var due []*vaultLease
+	if v.self != nil && (t.IsZero() || !v.self.renewAt.After(t)) {
+		due = append(due, v.self)
+	}
+	for _, l := range v.leases {
+		if t.IsZero() || !l.renewAt.After(t) {
+			due = append(due, l)
+		}
+	}
+	return due
+}
+
+// renew renews what is due.  If the token cannot be renewed and was
+// obtained by logging in, it logs in again.
+func (v *Vault) renew(ctx context.Context) error {
+	v.mu.Lock()
+	due := v.dueLocked(v.clock())
+	v.mu.Unlock()
+	for _, l := range due {
+		var resp vaultResponse
+		if l.id == "" {
+			err := v.call(ctx, "POST", "auth/token/renew-self", struct{}{}, &resp)
+			if err != nil && v.Role != "" {
+				v.logf("vault: renewing token: %v; logging in again", err)
+				v.mu.Lock()
+				v.token, v.self = "", nil
+				v.mu.Unlock()
+				_, err = v.currentToken(ctx)
+			}
+			if err != nil {
+				return fmt.Errorf("renewing token: %v", err)
+			}
+			if resp.Auth != nil {
+				v.mu.Lock()
+				v.self = nil
+				if resp.Auth.Renewable && resp.Auth.LeaseDuration > 0 {
+					v.self = v.newLease("", resp.Auth.LeaseDuration)
+				}
+				v.mu.Unlock()
+			}
+			continue
+		}
+		err := v.call(ctx, "PUT", "sys/leases/renew", map[string]interface{}{
+			"lease_id":  l.id,
+			"increment": int(l.duration / time.Second),
+		}, &resp)
+		v.mu.Lock()
+		if err != nil {
+			// An expired lease cannot be renewed; forget it rather than fail
+			// forever.
+			if l.renewAt.Add(l.duration / 2).Before(v.clock()) {
+				delete(v.leases, l.id)
+			}
+		} else {
+			v.leases[l.id] = v.newLease(l.id, resp.LeaseDuration)
+		}
+		v.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("renewing lease %s: %v", l.id, err)
+		}
+	}
+	return nil
+}
+
+// newLease returns a lease of the given number of seconds, starting now.
+func (v *Vault) newLease(id string, seconds int) *vaultLease {
+	d := time.Duration(seconds) * time.Second
+	return &vaultLease{id: id, duration: d, renewAt: v.clock().Add(d / 2)}
+}
+
+// currentToken returns the token to call Vault with, logging in first if
+// necessary.
+func (v *Vault) currentToken(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	token := v.token
+	v.mu.Unlock()
+	if token != "" {
+		return token, nil
+	}
+	var lease *vaultLease
+	switch {
+	case v.Token != "" || v.TokenFile != "":
+		token = v.Token
+		if v.TokenFile != "" {
+			b, err := ioutil.ReadFile(v.TokenFile)
+			if err != nil {
+				return "", fmt.Errorf("vault: %v", err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		var resp vaultResponse
+		if err := v.send(ctx, "GET", "auth/token/lookup-self", token, nil, &resp); err != nil {
+			return "", err
+		}
+		ttl, _ := resp.Data["ttl"].(float64)
+		if renewable, _ := resp.Data["renewable"].(bool); renewable && ttl > 0 {
+			lease = v.newLease("", int(ttl))
+		}
+	case v.Role != "":
+		jwtFile := v.JWTFile
+		if jwtFile == "" {
+			jwtFile = defaultVaultJWTFile
+		}
+		jwt, err := ioutil.ReadFile(jwtFile)
+		if err != nil {
+			return "", fmt.Errorf("vault: %v", err)
+		}
+		mount := v.AuthMount
+		if mount == "" {
+			mount = defaultVaultAuthMount
+		}
+		var resp vaultResponse
+		if err := v.send(ctx, "POST", "auth/"+strings.Trim(mount, "/")+"/login", "", map[string]string{
+			"role": v.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}, &resp); err != nil {
+			return "", err
+		}
+		if resp.Auth == nil || resp.Auth.ClientToken == "" {
+			return "", errors.New("vault: login returned no token")
+		}
+		token = resp.Auth.ClientToken
+		if resp.Auth.Renewable && resp.Auth.LeaseDuration > 0 {
+			lease = v.newLease("", resp.Auth.LeaseDuration)
+		}
+	default:
+		return "", errors.New("vault: no token or role to authenticate with")
+	}
+	v.mu.Lock()
+	v.token, v.self = token, lease
+	v.mu.Unlock()
+	return token, nil
+}
+
+// call calls the API at path with the current token.
+func (v *Vault) call(ctx context.Context, method, path string, body interface{}, result *vaultResponse) error {
+	token, err := v.currentToken(ctx)
+	if err != nil {
+		return err
+	}
+	return v.send(ctx, method, path, token, body, result)
+}
+
+// send calls the API at path, sending body and decoding the response into
+// result.
+func (v *Vault) send(ctx context.Context, method, path, token string, body interface{}, result *vaultResponse) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultVaultTimeout)
+	defer cancel()
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	u := strings.TrimSuffix(v.Address, "/") + "/v1/" + path
+	req, err := http.NewRequest(method, u, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("vault: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("vault: %v", err)
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if resp.StatusCode != http.StatusOK {
+		msg := resp.Status
+		if len(result.Errors) > 0 {
+			msg += ": " + strings.Join(result.Errors, "; ")
+		}
+		return fmt.Errorf("vault: %s %s: %s", method, path, msg)
+	}
+	if err != nil {
+		return fmt.Errorf("vault: %s %s: %v", method, path, err)
+	}
+	return nil
+}
+
+func (v *Vault) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+func (v *Vault) logf(format string, args ...interface{}) {
+	if v.Logger != nil {
+		v.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// secretField is a setting of a Config that may hold a credential.
+type secretField struct {
+	name  string
+	value *string
+}
+
+// secretFields returns the settings of c that may hold credentials, and so
+// may refer to Vault.
+func secretFields(c *Config) []secretField {
+	return []secretField{
+		{"resolver url", &c.Resolver.URL},
+		{"notify webhook", &c.Notify.Webhook},
+		{"notify slack", &c.Notify.Slack},
+		{"notify smtp username", &c.Notify.SMTP.Username},
+		{"notify smtp password", &c.Notify.SMTP.Password},
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault serves the parts of the Vault API that Vault uses.
+type fakeVault struct {
+	mu        sync.Mutex
+	tokens    map[string]bool // valid tokens
+	calls     []string
+	failRenew bool
+	logins    int
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	f.calls = append(f.calls, r.Method+" "+path)
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+	if path == "auth/kubernetes/login" {
+		var body struct{ Role, JWT string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Role != "vanity" || body.JWT != "service-account-jwt" {
+			w.WriteHeader(http.StatusBadRequest)
+			reply(map[string]interface{}{"errors": []string{"invalid role or jwt"}})
+			return
+		}
+		f.logins++
+		token := "login-token-" + string(rune('0'+f.logins))
+		f.tokens[token] = true
+		reply(map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": token, "lease_duration": 60, "renewable": true,
+		}})
+		return
+	}
+	if !f.tokens[r.Header.Get("X-Vault-Token")] {
+		w.WriteHeader(http.StatusForbidden)
+		reply(map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	switch path {
+	case "auth/token/lookup-self":
+		reply(map[string]interface{}{"data": map[string]interface{}{"ttl": 3600, "renewable": true}})
+	case "auth/token/renew-self":
+		if f.failRenew {
+			w.WriteHeader(http.StatusForbidden)
+			reply(map[string]interface{}{"errors": []string{"token expired"}})
+			return
+		}
+		reply(map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": r.Header.Get("X-Vault-Token"), "lease_duration": 60, "renewable": true,
+		}})
+	case "sys/leases/renew":
+		reply(map[string]interface{}{"lease_id": "database/creds/vanity/1", "lease_duration": 120, "renewable": true})
+	case "secret/data/govanityurls":
+		reply(map[string]interface{}{"data": map[string]interface{}{
+			"data":     map[string]interface{}{"slack": "https://hooks.slack.com/services/T/B/X", "port": 587},
+			"metadata": map[string]interface{}{"version": 3},
+		}})
+	case "kv/govanityurls":
+		reply(map[string]interface{}{"data": map[string]interface{}{"password": "hunter2"}})
+	case "database/creds/vanity":
+		reply(map[string]interface{}{
+			"lease_id": "database/creds/vanity/1", "lease_duration": 120, "renewable": true,
+			"data": map[string]interface{}{"password": "dynamic"},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		reply(map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (f *fakeVault) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func TestVaultSecret(t *testing.T) {
+	f := &fakeVault{tokens: map[string]bool{"root": true}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	v := &Vault{Address: srv.URL, Token: "root"}
+	tests := []struct {
+		ref  string
+		want string
+		err  bool
+	}{
+		{ref: "secret/data/govanityurls#slack", want: "https://hooks.slack.com/services/T/B/X"},
+		{ref: "secret/data/govanityurls#port", want: "587"},
+		{ref: "/kv/govanityurls#password", want: "hunter2"},
+		{ref: "kv/govanityurls#missing", err: true},
+		{ref: "kv/missing#password", err: true},
+		{ref: "kv/govanityurls", err: true},
+		{ref: "kv/govanityurls#", err: true},
+	}
+	for _, test := range tests {
+		got, err := v.Secret(context.Background(), test.ref)
+		if test.err {
+			if err == nil {
+				t.Errorf("Secret(%q) = %q; want error", test.ref, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Secret(%q) = %q, %v; want %q", test.ref, got, err, test.want)
+		}
+	}
+
+	c, err := ParseConfig([]byte("notify:\n" +
+		"  slack: vault:secret/data/govanityurls#slack\n" +
+		"  smtp:\n" +
+		"    addr: smtp.example.com:587\n" +
+		"    from: vanity@example.com\n" +
+		"    to: [ops@example.com]\n" +
+		"    password: vault:kv/govanityurls#password\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err == nil {
+		t.Error("Validate succeeded with Vault references; want error")
+	}
+	if err := v.ExpandConfig(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Notify.Slack != "https://hooks.slack.com/services/T/B/X" || c.Notify.SMTP.Password != "hunter2" {
+		t.Errorf("after ExpandConfig, slack = %q, password = %q", c.Notify.Slack, c.Notify.SMTP.Password)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate after ExpandConfig: %v", err)
+	}
+
+	unauthorized := &Vault{Address: srv.URL, Token: "wrong"}
+	if _, err := unauthorized.Secret(context.Background(), "kv/govanityurls#password"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Secret with a wrong token: err = %v; want permission denied", err)
+	}
+}
+
+func TestVaultRenewal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jwtFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(jwtFile, []byte("service-account-jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeVault{tokens: map[string]bool{}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := &Vault{Address: srv.URL, Role: "vanity", JWTFile: jwtFile, now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	if got, err := v.Secret(ctx, "database/creds/vanity#password"); err != nil || got != "dynamic" {
+		t.Fatalf("Secret = %q, %v; want dynamic", got, err)
+	}
+	check := func(step string, want ...string) {
+		t.Helper()
+		if err := v.renew(ctx); err != nil {
+			t.Errorf("%s: renew: %v", step, err)
+		}
+		got := f.takeCalls()
+		if strings.Join(got, ", ") != strings.Join(want, ", ") {
+			t.Errorf("%s: calls = %q; want %q", step, got, want)
+		}
+	}
+	f.takeCalls()
+	check("before anything is due")
+	if d := v.untilRenewal(); d != 30*time.Second {
+		t.Errorf("untilRenewal = %v; want 30s", d)
+	}
+
+	now = now.Add(30 * time.Second)
+	check("token due", "POST auth/token/renew-self")
+
+	now = now.Add(30 * time.Second)
+	check("token and lease due", "POST auth/token/renew-self", "PUT sys/leases/renew")
+
+	f.mu.Lock()
+	f.failRenew = true
+	f.mu.Unlock()
+	now = now.Add(30 * time.Second)
+	check("token renewal fails", "POST auth/token/renew-self", "POST auth/kubernetes/login")
+	if v.token != "login-token-2" {
+		t.Errorf("token after logging in again = %q; want login-token-2", v.token)
+	}
+}
+
+func TestVaultNoCredentials(t *testing.T) {
+	v := &Vault{Address: "http://127.0.0.1:0"}
+	if _, err := v.Secret(context.Background(), "kv/x#y"); err == nil {
+		t.Error("Secret without credentials succeeded; want error")
+	}
+	if got, err := v.Expand(context.Background(), "plain"); err != nil || got != "plain" {
+		t.Errorf("Expand(plain) = %q, %v; want plain", got, err)
+	}
+}
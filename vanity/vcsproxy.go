@@ -0,0 +1,473 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	modzip "golang.org/x/mod/zip"
+)
+
+// defaultVCSRefresh is how long a clone is trusted to know the current tags
+// before version lists fetch again.
+const defaultVCSRefresh = time.Minute
+
+// defaultVCSTimeout bounds each clone or fetch of a repository, which may
+// transfer much more than a request to an upstream.
+const defaultVCSTimeout = 5 * time.Minute
+
+// vcsProxy serves the module proxy protocol for configured git repositories
+// from tagged versions in clones of them, so that private modules can be
+// fetched through the vanity host without another proxy.  Modules are
+// requested by their full path, host included, as the go command does with
+// GOPROXY set to the vanity host.
+type vcsProxy struct {
+	h        *Handler // whose paths are served
+	cacheDir string
+	refresh  time.Duration
+	timeout  time.Duration // for each clone or fetch
+
+	mu    sync.Mutex
+	repos map[string]*gitClone // by repository URL
+}
+
+// gitClone is a bare mirror of a repository.
+type gitClone struct {
+	dir string
+
+	mu      sync.Mutex
+	fetched time.Time
+}
+
+func newVCSProxy(h *Handler, cacheDir string) *vcsProxy {
+	return &vcsProxy{
+		h:        h,
+		cacheDir: cacheDir,
+		refresh:  defaultVCSRefresh,
+		timeout:  defaultVCSTimeout,
+		repos:    make(map[string]*gitClone),
+	}
+}
+
+// vcsModule is a module served from a repository.
+type vcsModule struct {
+	path   string // module path
+	repo   string
+	subdir string // of the module in the repository, or ""
+
+	// tagPrefix is what the version tags of the module start with, the
+	// subdirectory of a path in a monorepo followed by a slash.
+	tagPrefix string
+}
+
+// tag returns the tag of version v of m.
+func (m vcsModule) tag(v string) string {
+	return "refs/tags/" + m.tagPrefix + v
+}
+
+// serve serves r if it is for a module from one of the configured paths, and
+// reports whether it did.
+func (p *vcsProxy) serve(w http.ResponseWriter, r *http.Request) bool {
+	escaped, rest := splitProxyPath(r.URL.Path)
+	modPath, err := module.UnescapePath(escaped)
+	if err != nil {
+		return false
+	}
+	m, ok := p.module(r, modPath)
+	if !ok {
+		return false
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return true
+	}
+	if err := p.serveModule(w, r, m, rest); err != nil {
+		p.h.logf("proxy: %s: %v", r.URL.Path, err)
+		// The go command moves on to the next proxy on 404 and 410.
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+	return true
+}
+
+// splitProxyPath splits a module proxy request path into the escaped module
+// path and the rest, e.g. @v/list or @latest.
+func splitProxyPath(p string) (escaped, rest string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.Index(p, "/@v/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return strings.TrimSuffix(p, "/@latest"), "@latest"
+}
+
+// module finds the configured git repository serving modPath.  Only the
+// configured paths are modules, with a major version suffix if the paths
+// keep major versions in subdirectories.  Modules in the subdirectory of a
+// monorepo are tagged with the subdirectory, e.g. foo/v1.2.3.
+func (p *vcsProxy) module(r *http.Request, modPath string) (vcsModule, bool) {
+	i := strings.Index(modPath, "/")
+	if i < 0 {
+		return vcsModule{}, false
+	}
+	host, rest := modPath[:i], modPath[i:]
+	h := p.h.hosts[host]
+	if h == nil && host+p.h.prefix == p.h.Host(r) {
+		h = p.h
+	}
+	if h == nil {
+		return vcsModule{}, false
+	}
+	rest, ok := h.unprefixed(rest)
+	if !ok {
+		return vcsModule{}, false
+	}
+	root, major, ok := module.SplitPathVersion(rest)
+	if !ok {
+		return vcsModule{}, false
+	}
+	pc, subpath := h.findPath(root)
+	if pc == nil {
+		pc, subpath = h.rules.find(root)
+	}
+	if pc == nil || subpath != "" || pc.vcs != "git" {
+		return vcsModule{}, false
+	}
+	m := vcsModule{path: modPath, repo: pc.repo, subdir: pc.subdir}
+	if pc.subdir != "" {
+		m.tagPrefix = pc.subdir + "/"
+	}
+	if major != "" && pc.majorSubdirs {
+		m.subdir = path.Join(m.subdir, strings.TrimPrefix(major, "/"))
+	}
+	return m, true
+}
+
+func (p *vcsProxy) serveModule(w http.ResponseWriter, r *http.Request, m vcsModule, rest string) error {
+	ctx := r.Context()
+	c, err := p.clone(ctx, m.repo)
+	if err != nil {
+		return err
+	}
+	if rest == "@v/list" || rest == "@latest" {
+		if err := p.fetch(ctx, c, true); err != nil {
+			return err
+		}
+		versions, err := c.versions(ctx, m)
+		if err != nil {
+			return err
+		}
+		if rest == "@v/list" {
+			w.Header().Set("Content-Type", proxyContentType(rest))
+			for _, v := range versions {
+				fmt.Fprintln(w, v)
+			}
+			return nil
+		}
+		if len(versions) == 0 {
+			return fmt.Errorf("no tagged versions of %s", m.path)
+		}
+		return p.serveInfo(ctx, w, c, m, latestVersion(versions))
+	}
+
+	ext := path.Ext(rest)
+	v, err := module.UnescapeVersion(strings.TrimSuffix(strings.TrimPrefix(rest, "@v/"), ext))
+	if err != nil {
+		return err
+	}
+	if err := module.Check(m.path, v); err != nil || v != semver.Canonical(v) {
+		return fmt.Errorf("invalid version %s", v)
+	}
+	if !c.hasTag(ctx, m, v) {
+		if err := p.fetch(ctx, c, false); err != nil {
+			return err
+		}
+		if !c.hasTag(ctx, m, v) {
+			return fmt.Errorf("unknown version %s", v)
+		}
+	}
+	switch ext {
+	case ".info":
+		return p.serveInfo(ctx, w, c, m, v)
+	case ".mod", ".zip":
+		return p.serveCached(w, r, func(f io.Writer) error {
+			if ext == ".mod" {
+				return c.goMod(ctx, m, v, f)
+			}
+			return c.zip(ctx, m, v, f)
+		})
+	}
+	return fmt.Errorf("unknown request %s", rest)
+}
+
+func (p *vcsProxy) serveInfo(ctx context.Context, w http.ResponseWriter, c *gitClone, m vcsModule, v string) error {
+	out, err := c.git(ctx, "log", "-1", "--format=%cI", m.tag(v))
+	if err != nil {
+		return err
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(out)))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", proxyContentType(".info"))
+	return json.NewEncoder(w).Encode(struct {
+		Version string
+		Time    time.Time
+	}{v, t.UTC()})
+}
+
+// serveCached serves the immutable file for r, creating it with create and
+// keeping it in the cache directory.
+func (p *vcsProxy) serveCached(w http.ResponseWriter, r *http.Request, create func(io.Writer) error) error {
+	cachePath := filepath.Join(p.cacheDir, filepath.FromSlash(r.URL.Path))
+	if _, err := os.Stat(cachePath); err != nil {
+		tmp, err := createTemp(cachePath)
+		if err != nil {
+			return err
+		}
+		err = create(tmp)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), cachePath)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", proxyContentType(r.URL.Path))
+	io.Copy(w, f)
+	return nil
+}
+
+// clone returns the clone of repo, cloning it first if necessary.
+func (p *vcsProxy) clone(ctx context.Context, repo string) (*gitClone, error) {
+	if err := checkRemote(repo); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	c := p.repos[repo]
+	if c == nil {
+		sum := sha256.Sum256([]byte(repo))
+		c = &gitClone{dir: filepath.Join(p.cacheDir, "vcs", fmt.Sprintf("%x", sum[:8]))}
+		p.repos[repo] = c
+	}
+	p.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := os.Stat(filepath.Join(c.dir, "HEAD")); err == nil {
+		return c, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.dir), 0755); err != nil {
+		return nil, err
+	}
+	tmp := c.dir + ".tmp"
+	os.RemoveAll(tmp)
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if _, err := runGit(ctx, "", "clone", "--mirror", "--quiet", "--", repo, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, c.dir); err != nil {
+		return nil, err
+	}
+	c.fetched = time.Now()
+	return c, nil
+}
+
+// fetch updates c from its remote, unless it was updated within the refresh
+// interval and fresh is true.
+func (p *vcsProxy) fetch(ctx context.Context, c *gitClone, fresh bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fresh && time.Since(c.fetched) < p.refresh {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if _, err := c.git(ctx, "fetch", "--quiet", "--prune", "--tags", "origin"); err != nil {
+		return err
+	}
+	c.fetched = time.Now()
+	return nil
+}
+
+// versions returns the tagged versions of m, sorted.
+func (c *gitClone) versions(ctx context.Context, m vcsModule) ([]string, error) {
+	out, err := c.git(ctx, "tag", "--list", m.tagPrefix+"v*")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, tag := range strings.Fields(string(out)) {
+		v := strings.TrimPrefix(tag, m.tagPrefix)
+		if v == semver.Canonical(v) && module.Check(m.path, v) == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return semver.Compare(versions[i], versions[j]) < 0 })
+	return versions, nil
+}
+
+// latestVersion returns the highest release in versions, or the highest
+// pre-release if there are no releases.
+func latestVersion(versions []string) string {
+	latest := ""
+	for _, v := range versions {
+		if semver.Prerelease(v) == "" {
+			latest = v
+		}
+	}
+	if latest == "" {
+		latest = versions[len(versions)-1]
+	}
+	return latest
+}
+
+func (c *gitClone) hasTag(ctx context.Context, m vcsModule, v string) bool {
+	_, err := c.git(ctx, "rev-parse", "--verify", "--quiet", m.tag(v)+"^{commit}")
+	return err == nil
+}
+
+// goMod writes the go.mod file of m at version v, or a minimal one if the
+// repository has none.
+func (c *gitClone) goMod(ctx context.Context, m vcsModule, v string, w io.Writer) error {
+	file := path.Join(m.subdir, "go.mod")
+	out, err := c.git(ctx, "show", m.tag(v)+":"+file)
+	if err != nil {
+		out = []byte(fmt.Sprintf("module %s\n", m.path))
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// zip writes the module zip file of m at version v.
+func (c *gitClone) zip(ctx context.Context, m vcsModule, v string, w io.Writer) error {
+	dir, err := ioutil.TempDir(filepath.Dir(c.dir), ".zip-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	archive, err := c.git(ctx, "archive", "--format=tar", m.tag(v))
+	if err != nil {
+		return err
+	}
+	if err := untar(bytes.NewReader(archive), dir); err != nil {
+		return err
+	}
+	return modzip.CreateFromDir(w, module.Version{Path: m.path, Version: v}, filepath.Join(dir, filepath.FromSlash(m.subdir)))
+}
+
+// untar extracts the regular files and directories of a tar archive into
+// dir.
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(name, dir+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %s outside the archive", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(name, 0755)
+		case tar.TypeReg:
+			err = writeFile(name, tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeFile(name string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *gitClone) git(ctx context.Context, args ...string) ([]byte, error) {
+	return runGit(ctx, c.dir, args...)
+}
+
+// runGit runs git with args in dir.  Repositories among args must have
+// passed checkRemote and follow a "--", so that they are never taken for
+// options.
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// Never wait for a password prompt.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %v: %s", args[0], err, msg)
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return out, nil
+}
+
+// checkRemote reports an error unless repo is a URL with a scheme, which
+// git cannot mistake for an option or a local path.
+func checkRemote(repo string) error {
+	if u, err := url.Parse(repo); err != nil || u.Scheme == "" {
+		return fmt.Errorf("git: repository %q is not a URL", repo)
+	}
+	return nil
+}
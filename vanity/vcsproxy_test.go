@@ -0,0 +1,242 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitRepo creates a git repository in dir with a commit of files for each
+// tag, in order.
+func gitRepo(t *testing.T, dir string, tags []string, files map[string]map[string]string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+	run("init", "--quiet")
+	for _, tag := range tags {
+		for name, content := range files[tag] {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		run("add", "-A")
+		run("commit", "--quiet", "-m", tag)
+		run("tag", tag)
+	}
+}
+
+func TestVCSProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcsproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	os.Mkdir(repo, 0755)
+	gitRepo(t, repo, []string{"v1.0.0", "v1.1.0", "v1.2.0-rc.1", "v2.0.0"}, map[string]map[string]string{
+		"v1.0.0": {
+			"go.mod": "module example.com/mod\n",
+			"mod.go": "package mod\n",
+		},
+		"v1.1.0":      {"mod.go": "package mod\n\nconst V = 1\n"},
+		"v1.2.0-rc.1": {"mod.go": "package mod\n\nconst V = 2\n"},
+		"v2.0.0": {
+			"v2/go.mod": "module example.com/mod/v2\n",
+			"v2/mod.go": "package mod\n",
+		},
+	})
+
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"proxy:\n" +
+		"  vcs: true\n" +
+		"  cache_dir: " + filepath.Join(dir, "cache") + "\n" +
+		"paths:\n" +
+		"  /mod:\n" +
+		"    repo: file://" + repo + "\n" +
+		"    vcs: git\n" +
+		"    major_subdirs: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/example.com/mod/@v/list", 200, "v1.0.0\nv1.1.0\nv1.2.0-rc.1\n"},
+		{"/example.com/mod/@latest", 200, `"Version":"v1.1.0"`},
+		{"/example.com/mod/@v/v1.0.0.info", 200, `"Version":"v1.0.0"`},
+		{"/example.com/mod/@v/v1.0.0.mod", 200, "module example.com/mod\n"},
+		{"/example.com/mod/@v/v1.9.0.info", 404, "unknown version"},
+		{"/example.com/mod/@v/v2.0.0.info", 404, "invalid version"},
+		{"/example.com/mod/v2/@v/list", 200, "v2.0.0\n"},
+		{"/example.com/mod/v2/@v/v2.0.0.mod", 200, "module example.com/mod/v2\n"},
+		{"/example.com/other/@v/list", 404, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s: %d %q; want %d %q", test.path, w.Code, w.Body.String(), test.code, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		path string
+		file string
+	}{
+		{"/example.com/mod/@v/v1.1.0.zip", "example.com/mod@v1.1.0/mod.go"},
+		{"/example.com/mod/v2/@v/v2.0.0.zip", "example.com/mod/v2@v2.0.0/go.mod"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Errorf("%s: %d, not a zip: %v", test.path, w.Code, err)
+			continue
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		if !strings.Contains(strings.Join(names, " "), test.file) {
+			t.Errorf("%s: files %v; want %s", test.path, names, test.file)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cache", "example.com", "mod", "@v", "v1.1.0.zip")); err != nil {
+		t.Errorf("zip not cached: %v", err)
+	}
+}
+
+func TestVCSProxySubdir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcsproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	os.Mkdir(repo, 0755)
+	gitRepo(t, repo, []string{"v1.0.0", "foo/v1.1.0"}, map[string]map[string]string{
+		"v1.0.0": {
+			"go.mod":     "module example.com/tools\n",
+			"foo/go.mod": "module example.com/tools/foo\n",
+			"foo/foo.go": "package foo\n",
+		},
+		"foo/v1.1.0": {"foo/foo.go": "package foo\n\nconst V = 1\n"},
+	})
+
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"proxy:\n" +
+		"  vcs: true\n" +
+		"  cache_dir: " + filepath.Join(dir, "cache") + "\n" +
+		"paths:\n" +
+		"  /tools/foo:\n" +
+		"    repo: file://" + repo + "\n" +
+		"    vcs: git\n" +
+		"    subdir: foo\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/example.com/tools/foo/@v/list", 200, "v1.1.0\n"},
+		{"/example.com/tools/foo/@v/v1.1.0.mod", 200, "module example.com/tools/foo\n"},
+		{"/example.com/tools/foo/@v/v1.0.0.info", 404, "unknown version"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s: %d %q; want %d %q", test.path, w.Code, w.Body.String(), test.code, test.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/example.com/tools/foo/@v/v1.1.0.zip", nil))
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("%d, not a zip: %v", w.Code, err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if got, want := strings.Join(names, " "), "example.com/tools/foo@v1.1.0/foo.go example.com/tools/foo@v1.1.0/go.mod"; got != want {
+		t.Errorf("files = %s; want %s", got, want)
+	}
+}
+
+func TestVCSProxyNeedsCacheDir(t *testing.T) {
+	_, err := NewHandler([]byte("proxy:\n  vcs: true\n"))
+	if err == nil || !strings.Contains(err.Error(), "cache_dir") {
+		t.Errorf("err = %v; want cache_dir error", err)
+	}
+}
+
+func TestVCSProxyTimeout(t *testing.T) {
+	h, err := NewHandler([]byte("proxy:\n  vcs: true\n  cache_dir: /tmp\n  vcs_timeout: 10m\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.vcs.timeout != 10*time.Minute {
+		t.Errorf("timeout = %v; want 10m", h.vcs.timeout)
+	}
+	_, err = NewHandler([]byte("proxy:\n  vcs: true\n  cache_dir: /tmp\n  vcs_timeout: -1s\n"))
+	if err == nil || !strings.Contains(err.Error(), "vcs_timeout") {
+		t.Errorf("err = %v; want vcs_timeout error", err)
+	}
+}
+
+func TestCheckRemote(t *testing.T) {
+	tests := []struct {
+		repo string
+		ok   bool
+	}{
+		{"https://github.com/example/tools", true},
+		{"ssh://git@example.com/tools.git", true},
+		{"file:///srv/git/tools", true},
+		{"/srv/git/tools", false},
+		{"--upload-pack=touch /tmp/pwned", false},
+		{"-uhttps://github.com/example/tools", false},
+		{"git@github.com:example/tools.git", false},
+	}
+	for _, test := range tests {
+		if err := checkRemote(test.repo); (err == nil) != test.ok {
+			t.Errorf("checkRemote(%q) = %v; want ok %v", test.repo, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Verification is the result of having the go command download the module
+// at a path's import path from the handler.
+type Verification struct {
+	ImportPath string
+	Version    string // downloaded
+	Err        error
+}
+
+// OK reports whether the module was downloaded.
+func (v Verification) OK() bool {
+	return v.Err == nil
+}
+
+func (v Verification) String() string {
+	if v.Err != nil {
+		return fmt.Sprintf("%s: %v", v.ImportPath, v.Err)
+	}
+	return v.ImportPath + ": ok (" + v.Version + ")"
+}
+
+// VerifyImportPaths serves h on a local port and runs go mod download for the
+// latest version of the import path of every configured path, just as users
+// go get it, except that the go command reaches the configured hosts through
+// the local port.  It proves that the meta tags lead the go command to the
+// repositories, that it can fetch them, and that they hold the modules.  The
+// go command must be installed, and the configuration must set the host if it
+// has paths outside of hosts.
+//
+// To reach the handler, the go command is told to treat the configured hosts
+// as insecure and to use the local port as its HTTP proxy, which serves the
+// configured hosts over plain HTTP and forwards everything else.
+func (h *Handler) VerifyImportPaths(ctx context.Context) ([]Verification, error) {
+	var importPaths []string
+	hosts := make(map[string]bool)
+	for _, hh := range h.handlers() {
+		paths := hh.pathSet()
+		if len(paths) == 0 {
+			continue
+		}
+		if hh.host == "" {
+			return nil, errors.New("configuration must set host")
+		}
+		hosts[strings.ToLower(hh.host)] = true
+		for _, pc := range paths.configured() {
+			if !pc.retired {
+				importPaths = append(importPaths, hh.host+hh.prefix+pc.path)
+			}
+		}
+	}
+	if len(importPaths) == 0 {
+		return nil, nil
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: &verifyProxy{h: h, hosts: hosts}}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "govanityurls-verify")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	var insecure []string
+	for host := range hosts {
+		insecure = append(insecure, host)
+	}
+	sort.Strings(insecure)
+	proxy := "http://" + l.Addr().String()
+	env := append(os.Environ(),
+		"GO111MODULE=on",
+		"GOENV=off",
+		"GOWORK=off",
+		"GOTOOLCHAIN=local",
+		"GOFLAGS=-modcacherw",
+		"GOMODCACHE="+dir,
+		"GOPROXY=direct",
+		"GOSUMDB=off",
+		"GOINSECURE="+strings.Join(insecure, ","),
+		"GIT_TERMINAL_PROMPT=0",
+		"HTTP_PROXY="+proxy, "http_proxy="+proxy,
+		"HTTPS_PROXY="+proxy, "https_proxy="+proxy,
+		"NO_PROXY=", "no_proxy=",
+	)
+	var results []Verification
+	for _, p := range importPaths {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		results = append(results, verifyImportPath(ctx, goCmd, dir, env, p))
+	}
+	return results, nil
+}
+
+// verifyImportPath runs go mod download for the latest version of
+// importPath.
+func verifyImportPath(ctx context.Context, goCmd, dir string, env []string, importPath string) Verification {
+	v := Verification{ImportPath: importPath}
+	cmd := exec.CommandContext(ctx, goCmd, "mod", "download", "-json", importPath+"@latest")
+	cmd.Dir, cmd.Env = dir, env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var mod struct {
+		Version string
+		Error   string
+	}
+	if jerr := json.Unmarshal(out, &mod); jerr == nil && mod.Error != "" {
+		v.Err = errors.New(mod.Error)
+		return v
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		v.Err = err
+		return v
+	}
+	v.Version = mod.Version
+	return v
+}
+
+// verifyProxy is the HTTP proxy of the go command during
+// VerifyImportPaths.  It serves the configured hosts from the handler and
+// forwards requests for other hosts.  Tunnels to the configured hosts are
+// refused, so that the go command falls back to plain HTTP for them.
+type verifyProxy struct {
+	h     *Handler
+	hosts map[string]bool
+}
+
+func (p *verifyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	switch {
+	case r.Method == http.MethodConnect && p.hosts[host]:
+		http.Error(w, "served over plain HTTP", http.StatusBadGateway)
+	case r.Method == http.MethodConnect:
+		tunnel(w, r)
+	case p.hosts[host] || !r.URL.IsAbs():
+		p.h.ServeHTTP(w, r)
+	default:
+		(&httputil.ReverseProxy{Director: func(*http.Request) {}}).ServeHTTP(w, r)
+	}
+}
+
+// tunnel connects the client to the host asked for by a CONNECT request.
+func tunnel(w http.ResponseWriter, r *http.Request) {
+	dst, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		dst.Close()
+		http.Error(w, "cannot tunnel", http.StatusInternalServerError)
+		return
+	}
+	src, buf, err := hj.Hijack()
+	if err != nil {
+		dst.Close()
+		return
+	}
+	defer src.Close()
+	defer dst.Close()
+	io.WriteString(src, "HTTP/1.1 200 Connection established\r\n\r\n")
+	done := make(chan struct{})
+	go func() {
+		io.Copy(dst, buf)
+		dst.Close()
+		close(done)
+	}()
+	io.Copy(src, dst)
+	src.Close()
+	<-done
+}
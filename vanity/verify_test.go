@@ -0,0 +1,104 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyImportPaths(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the go command")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not installed")
+	}
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	os.Mkdir(repo, 0755)
+	gitRepo(t, repo, []string{"v1.0.0"}, map[string]map[string]string{
+		"v1.0.0": {
+			"go.mod": "module example.com/mod\n",
+			"mod.go": "package mod\n",
+		},
+	})
+	// Serve the repository over Git's dumb HTTP protocol.
+	bare := filepath.Join(dir, "mod.git")
+	for _, args := range [][]string{
+		{"clone", "--quiet", "--bare", repo, bare},
+		{"-C", bare, "update-server-info"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+	git := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer git.Close()
+
+	h, err := NewHandler([]byte("host: example.com\n" +
+		"paths:\n" +
+		"  /mod:\n" +
+		"    repo: " + git.URL + "/mod.git\n" +
+		"    vcs: git\n" +
+		"    allow_insecure: true\n" +
+		"  /missing:\n" +
+		"    repo: " + git.URL + "/missing.git\n" +
+		"    vcs: git\n" +
+		"    allow_insecure: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := h.VerifyImportPaths(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results; want 2", len(results))
+	}
+	for _, v := range results {
+		switch v.ImportPath {
+		case "example.com/mod":
+			if !v.OK() || v.Version != "v1.0.0" {
+				t.Errorf("%v; want ok (v1.0.0)", v)
+			}
+		case "example.com/missing":
+			if v.OK() || !strings.Contains(v.Err.Error(), "missing") {
+				t.Errorf("%v; want error", v)
+			}
+		default:
+			t.Errorf("unexpected result %v", v)
+		}
+	}
+
+	h, err = NewHandler([]byte("paths:\n  /mod:\n    repo: https://github.com/example/mod\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.VerifyImportPaths(context.Background()); err == nil {
+		t.Error("VerifyImportPaths without a host succeeded; want error")
+	}
+}
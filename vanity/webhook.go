@@ -0,0 +1,158 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout     = 2 * time.Second
+	defaultWebhookMaxFailures = 5
+	defaultWebhookCooldown    = 30 * time.Second
+)
+
+var errCircuitOpen = errors.New("vanity: resolver webhook failing, not calling it")
+
+// WebhookResolver resolves paths by asking an HTTP endpoint.  It POSTs
+//
+//	{"path": "/foo/bar"}
+//
+// and expects either 404 Not Found for unknown paths or 200 OK with
+//
+//	{"path": "/foo", "repo": "https://...", "vcs": "git", "display": "..."}
+//
+// where all fields but repo are optional, as in Resolution.  After
+// MaxFailures consecutive failed calls the endpoint is not called again for
+// Cooldown, so that an outage does not slow down every request.
+type WebhookResolver struct {
+	URL string
+
+	// Client is used to call the endpoint.  If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	// Timeout bounds each call.  Defaults to 2 seconds.
+	Timeout time.Duration
+
+	// MaxFailures and Cooldown configure the circuit breaker.  They default
+	// to 5 and 30 seconds.
+	MaxFailures int
+	Cooldown    time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// Resolve implements Resolver.
+func (wr *WebhookResolver) Resolve(ctx context.Context, path string) (*Resolution, error) {
+	if !wr.allow() {
+		return nil, errCircuitOpen
+	}
+	res, err := wr.call(ctx, path)
+	wr.record(err == nil)
+	return res, err
+}
+
+func (wr *WebhookResolver) call(ctx context.Context, path string) (*Resolution, error) {
+	timeout := wr.Timeout
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	body, err := json.Marshal(struct {
+		Path string `json:"path"`
+	}{path})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", wr.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := wr.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("resolver webhook %s: %s", wr.URL, resp.Status)
+	}
+	var parsed struct {
+		Path    string `json:"path"`
+		Repo    string `json:"repo"`
+		VCS     string `json:"vcs"`
+		Display string `json:"display"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("resolver webhook %s: %v", wr.URL, err)
+	}
+	if parsed.Repo == "" {
+		return nil, fmt.Errorf("resolver webhook %s: no repo for %s", wr.URL, path)
+	}
+	return &Resolution{
+		Path:    parsed.Path,
+		Repo:    parsed.Repo,
+		VCS:     parsed.VCS,
+		Display: parsed.Display,
+	}, nil
+}
+
+// allow reports whether the circuit breaker lets a call through.
+func (wr *WebhookResolver) allow() bool {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return time.Now().After(wr.openUntil)
+}
+
+func (wr *WebhookResolver) record(ok bool) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if ok {
+		wr.failures = 0
+		return
+	}
+	wr.failures++
+	max := wr.MaxFailures
+	if max == 0 {
+		max = defaultWebhookMaxFailures
+	}
+	if wr.failures >= max {
+		cooldown := wr.Cooldown
+		if cooldown == 0 {
+			cooldown = defaultWebhookCooldown
+		}
+		wr.openUntil = time.Now().Add(cooldown)
+		wr.failures = 0
+	}
+}
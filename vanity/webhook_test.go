@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookResolver(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req struct {
+			Path string `json:"path"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch req.Path {
+		case "/svc/pkg":
+			io.WriteString(w, `{"path":"/svc","repo":"https://github.com/example/svc"}`)
+		case "/fail":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	wr := &WebhookResolver{URL: srv.URL, MaxFailures: 2, Cooldown: time.Hour}
+	ctx := context.Background()
+
+	res, err := wr.Resolve(ctx, "/svc/pkg")
+	if err != nil || res == nil || res.Path != "/svc" || res.Repo != "https://github.com/example/svc" {
+		t.Errorf("Resolve(/svc/pkg) = %+v, %v", res, err)
+	}
+	if res, err := wr.Resolve(ctx, "/unknown"); res != nil || err != nil {
+		t.Errorf("Resolve(/unknown) = %+v, %v; want nil, nil", res, err)
+	}
+
+	// Two failures open the circuit; the next call doesn't reach the server.
+	for i := 0; i < 2; i++ {
+		if _, err := wr.Resolve(ctx, "/fail"); err == nil {
+			t.Error("Resolve(/fail) succeeded")
+		}
+	}
+	before := calls
+	if _, err := wr.Resolve(ctx, "/svc/pkg"); err != errCircuitOpen {
+		t.Errorf("Resolve with open circuit = %v; want errCircuitOpen", err)
+	}
+	if calls != before {
+		t.Errorf("webhook called with open circuit")
+	}
+}